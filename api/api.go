@@ -3,33 +3,87 @@ package api
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/saisai/gindemo/api/controllers"
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/tracing"
 
 	//	"github.com/dchest/captcha"
 )
 
 var (
-	engine *gin.Engine
+	engine        *gin.Engine
+	privateEngine *gin.Engine
 )
 
-func init() {
-	engine = gin.Default()
-	setupRoutersV1()
+// Init 创建路由。separatePrivate 为 true 时内部接口 /private/api/v1 只在 PrivateEngine 上提供，
+// 否则与其他接口共用 Engine
+func Init(separatePrivate bool) {
+	engine = newEngine()
+	setupRoutersV1(engine)
+
+	if separatePrivate {
+		privateEngine = newEngine()
+		setupPrivateRouters(privateEngine)
+		return
+	}
+	setupPrivateRouters(engine)
+}
+
+func newEngine() *gin.Engine {
+	e := gin.New()
+	e.Use(requestID())
+	e.Use(tracing.Middleware())
+	e.Use(accessLog())
+	e.Use(recovery())
+	e.Use(metrics.Middleware())
+	e.Use(showBody())
+	return e
 }
 
 func Engine() *gin.Engine {
 	return engine
 }
 
-func setupRoutersV1() {
+// PrivateEngine 内部接口单独监听时的路由，未单独监听时为nil
+func PrivateEngine() *gin.Engine {
+	return privateEngine
+}
+
+func setupRoutersV1(engine *gin.Engine) {
+	engine.GET("/healthz", controllers.Healthz)
+	engine.GET("/readyz", controllers.Readyz)
+
 	v1 := engine.Group("/usersystem/api/v1")
-	v1.POST("/register", controllers.Register)
-	v1.POST("/login", controllers.Login)
+	v1.Use(rateLimit(RATELIMIT_API))
+	v1.POST("/register", rateLimit(RATELIMIT_REGISTER), controllers.Register)
+	v1.POST("/login", rateLimit(RATELIMIT_LOGIN), controllers.Login)
 	v1.POST("/logout", controllers.Logout)
 	v1.GET("/info", controllers.Info)
 	v1.POST("/add_identify_type", controllers.AddIdentifyType)
 	v1.POST("/authentication", controllers.Authentication)
+}
 
+// setupPrivateRouters 供其他后端服务调用的内部接口
+func setupPrivateRouters(engine *gin.Engine) {
 	private := engine.Group("/private/api/v1")
-	private.POST("/private_register", controllers.Private_Register)
+	private.Use(rateLimit(RATELIMIT_PRIVATE))
+	private.Use(privateAuth())
+	private.POST("/users/bulk", controllers.PrivateBulkRegister)
+	private.GET("/users/lookup", controllers.PrivateLookup)
+	private.POST("/users/batch_info", controllers.PrivateBatchInfo)
+	private.POST("/tokens/validate", controllers.PrivateValidateToken)
+	private.GET("/provision/status", controllers.ProvisionStatus)
+	private.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	private.POST("/webhooks", controllers.CreateWebhook)
+	private.GET("/webhooks", controllers.ListWebhooks)
+	private.GET("/webhooks/:id", controllers.GetWebhook)
+	private.PUT("/webhooks/:id", controllers.UpdateWebhook)
+	private.DELETE("/webhooks/:id", controllers.DeleteWebhook)
+	private.GET("/webhooks/:id/deliveries", controllers.ListWebhookDeliveries)
+	private.POST("/webhook_deliveries/:id/redeliver", controllers.RedeliverWebhook)
 
+	private.GET("/jobs/queues", controllers.ListJobQueues)
+	private.GET("/jobs/queues/:queue/dead", controllers.ListDeadJobs)
+	private.POST("/jobs/queues/:queue/dead/:id/requeue", controllers.RequeueDeadJob)
+	private.DELETE("/jobs/queues/:queue/dead/:id", controllers.DeleteDeadJob)
 }
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/saisai/gindemo/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Healthz 存活检查，进程可以处理请求即返回200
+func Healthz(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, health.Liveness())
+}
+
+// Readyz 就绪检查，所有依赖可用返回200，否则返回503，body为各依赖的检查结果
+func Readyz(ctx *gin.Context) {
+	report := health.Readiness()
+	if report.Status != health.STATUS_UP {
+		ctx.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/saisai/gindemo/api/msg"
+	"github.com/saisai/gindemo/jobs"
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 后台任务队列的管理接口，属于内部接口
+
+const (
+	DEFAULT_DEAD_JOB_LIMIT = 50
+	MAX_DEAD_JOB_LIMIT     = 500
+)
+
+// jobStatus 队列或任务不存在时返回404，任务队列未启用时返回503
+func jobStatus(code int) int {
+	switch code {
+	case msg.OK:
+		return http.StatusOK
+	case msg.ErrJobNotExist, msg.ErrQueueNotExist:
+		return http.StatusNotFound
+	case msg.ErrJobsDisabled:
+		return http.StatusServiceUnavailable
+	case msg.ErrServerInternalError:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// jobError 把任务队列的错误转换为错误码
+func jobError(err error) int {
+	switch err {
+	case jobs.ErrDisabled:
+		return msg.ErrJobsDisabled
+	case jobs.ErrUnknownQueue:
+		return msg.ErrQueueNotExist
+	}
+	return msg.ErrServerInternalError
+}
+
+// ListJobQueues 返回各队列等待、执行中、延迟和死信的任务数
+func ListJobQueues(ctx *gin.Context) {
+	rsp := new(msg.JobQueueListRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(jobStatus(rsp.Error_code), rsp)
+	}()
+
+	stats, err := jobs.QueueStats(ctx.Request.Context())
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "get job queue stats failed", "error", err)
+		rsp.Error_code = jobError(err)
+		return
+	}
+	rsp.Queues = make([]msg.JobQueue, 0, len(stats))
+	for _, s := range stats {
+		rsp.Queues = append(rsp.Queues, msg.JobQueue(s))
+	}
+}
+
+// ListDeadJobs 返回死信队列中最近的任务，limit 默认50
+func ListDeadJobs(ctx *gin.Context) {
+	rsp := new(msg.JobListRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(jobStatus(rsp.Error_code), rsp)
+	}()
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(DEFAULT_DEAD_JOB_LIMIT)))
+	if err != nil || limit <= 0 || limit > MAX_DEAD_JOB_LIMIT {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	list, err := jobs.DeadJobs(ctx.Request.Context(), ctx.Param("queue"), limit)
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "list dead jobs failed", "queue", ctx.Param("queue"), "error", err)
+		rsp.Error_code = jobError(err)
+		return
+	}
+	rsp.Jobs = make([]msg.Job, 0, len(list))
+	for _, j := range list {
+		rsp.Jobs = append(rsp.Jobs, msg.Job{
+			Id:          j.Id,
+			Queue:       j.Queue,
+			Type:        j.Type,
+			Payload:     j.Payload,
+			Attempts:    j.Attempts,
+			MaxAttempts: j.MaxAttempts,
+			LastError:   j.LastError,
+			CreatedAt:   j.CreatedAt,
+			FailedAt:    j.FailedAt,
+		})
+	}
+}
+
+// RequeueDeadJob 把死信队列中的任务重置执行次数后重新排队
+func RequeueDeadJob(ctx *gin.Context) {
+	rsp := new(msg.BaseRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(jobStatus(rsp.Error_code), rsp)
+	}()
+
+	ok, err := jobs.Requeue(ctx.Request.Context(), ctx.Param("queue"), ctx.Param("id"))
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "requeue job failed", "queue", ctx.Param("queue"), "job_id", ctx.Param("id"), "error", err)
+		rsp.Error_code = jobError(err)
+		return
+	}
+	if !ok {
+		rsp.Error_code = msg.ErrJobNotExist
+		return
+	}
+	log.InfoCtx(ctx.Request.Context(), "[jobs] dead job requeued", "queue", ctx.Param("queue"), "job_id", ctx.Param("id"),
+		"client", ctx.GetString(KEY_PRIVATE_CLIENT))
+}
+
+// DeleteDeadJob 从死信队列中删除任务
+func DeleteDeadJob(ctx *gin.Context) {
+	rsp := new(msg.BaseRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(jobStatus(rsp.Error_code), rsp)
+	}()
+
+	ok, err := jobs.DeleteDead(ctx.Request.Context(), ctx.Param("queue"), ctx.Param("id"))
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "delete dead job failed", "queue", ctx.Param("queue"), "job_id", ctx.Param("id"), "error", err)
+		rsp.Error_code = jobError(err)
+		return
+	}
+	if !ok {
+		rsp.Error_code = msg.ErrJobNotExist
+		return
+	}
+	log.InfoCtx(ctx.Request.Context(), "[jobs] dead job deleted", "queue", ctx.Param("queue"), "job_id", ctx.Param("id"),
+		"client", ctx.GetString(KEY_PRIVATE_CLIENT))
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/saisai/gindemo/api/msg"
+	"github.com/saisai/gindemo/common"
+	"github.com/saisai/gindemo/config"
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/models"
+	"github.com/saisai/gindemo/utils/cache"
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 内部接口，调用方已通过 mTLS 或 HMAC 签名认证，不检查 time-zone 等用户请求头
+
+// KEY_PRIVATE_CLIENT gin.Context 中保存内部接口调用方的key，值为 mtls:<CN> 或 hmac:<key_id>
+const KEY_PRIVATE_CLIENT = "private_client"
+
+// PrivateBulkRegister 批量注册，每个用户单独注册，结果与请求中的 users 一一对应
+func PrivateBulkRegister(ctx *gin.Context) {
+	req := new(msg.BulkRegisterReq)
+	rsp := new(msg.BulkRegisterRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		if rsp.Error_code != msg.OK {
+			ctx.JSON(http.StatusBadRequest, rsp)
+			return
+		}
+		ctx.JSON(http.StatusOK, rsp)
+	}()
+
+	err := bindBody(ctx, req)
+	if err != nil || len(req.Users) == 0 || len(req.Users) > config.Get().Private.MaxBatch {
+		log.WarnCtx(ctx.Request.Context(), "invalid bulk register body", "count", len(req.Users), "error", err)
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	rsp.Results = make([]msg.BulkRegisterResult, len(req.Users))
+	for i := range req.Users {
+		userId, errCode := models.Register(ctx.Request.Context(), &req.Users[i])
+		rsp.Results[i] = msg.BulkRegisterResult{Error_code: errCode, User_id: userId}
+	}
+	log.InfoCtx(ctx.Request.Context(), "[private] bulk register", "client", ctx.GetString(KEY_PRIVATE_CLIENT),
+		"count", len(req.Users))
+}
+
+// PrivateLookup 按账号查询用户信息，参数 identify_type 和 identifier
+func PrivateLookup(ctx *gin.Context) {
+	rsp := new(msg.InfoRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		switch rsp.Error_code {
+		case msg.OK:
+			ctx.JSON(http.StatusOK, rsp)
+		case msg.ErrAccountNotExist:
+			ctx.JSON(http.StatusNotFound, rsp)
+		default:
+			ctx.JSON(http.StatusBadRequest, rsp)
+		}
+	}()
+
+	identifyType, identifier := ctx.Query("identify_type"), ctx.Query("identifier")
+	if identifyType == "" || identifier == "" {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	userId, err := models.FindUserId(ctx.Request.Context(), identifyType, identifier)
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "lookup user failed", "identify_type", identifyType, "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	if userId == "" {
+		rsp.Error_code = msg.ErrAccountNotExist
+		return
+	}
+
+	if err := models.UserInfo(ctx.Request.Context(), userId, rsp); err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "get user info failed", "user_id", userId, "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+}
+
+// PrivateBatchInfo 批量查询用户信息，不存在的用户不在结果中
+func PrivateBatchInfo(ctx *gin.Context) {
+	req := new(msg.BatchInfoReq)
+	rsp := new(msg.BatchInfoRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		if rsp.Error_code != msg.OK {
+			ctx.JSON(http.StatusBadRequest, rsp)
+			return
+		}
+		ctx.JSON(http.StatusOK, rsp)
+	}()
+
+	err := bindBody(ctx, req)
+	if err != nil || len(req.User_ids) == 0 || len(req.User_ids) > config.Get().Private.MaxBatch {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	users, err := models.BatchUserInfo(ctx.Request.Context(), req.User_ids)
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "batch user info failed", "count", len(req.User_ids), "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	rsp.Users = users
+}
+
+// PrivateValidateToken 校验用户token，token无效时 valid 为false；不会延长token的有效期
+func PrivateValidateToken(ctx *gin.Context) {
+	req := new(msg.ValidateTokenReq)
+	rsp := new(msg.ValidateTokenRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		if rsp.Error_code != msg.OK {
+			ctx.JSON(http.StatusBadRequest, rsp)
+			return
+		}
+		ctx.JSON(http.StatusOK, rsp)
+	}()
+
+	err := bindBody(ctx, req)
+	if err != nil || req.Token == "" {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	ret := models.Authentication(ctx.Request.Context(), &msg.AuthenticationReq{Token: req.Token})
+	metrics.TokenValidation("private", ret == msg.OK)
+	if ret != msg.OK {
+		return
+	}
+
+	userId, _, _ := models.ParseToken(req.Token)
+	rsp.Valid = true
+	rsp.User_id = userId
+	rsp.ExpiresIn = cache.DoTTLCtx(ctx.Request.Context(), userId+common.KEY_TOKEN)
+}
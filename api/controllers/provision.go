@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/saisai/gindemo/provision"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProvisionStatus 各下游开通钩子的执行情况
+func ProvisionStatus(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, provision.Statuses())
+}
@@ -1,20 +1,25 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
-	"math"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/saisai/gindemo/utils/cache"
 
 	"github.com/saisai/gindemo/api/msg"
 	"github.com/saisai/gindemo/common"
+	"github.com/saisai/gindemo/config"
+	"github.com/saisai/gindemo/events"
+	"github.com/saisai/gindemo/idgen"
+	"github.com/saisai/gindemo/metrics"
 	"github.com/saisai/gindemo/models"
 	"github.com/saisai/gindemo/service"
+	"github.com/saisai/gindemo/utils/log"
+	"github.com/saisai/gindemo/utils/timeutil"
 
 	"github.com/gin-gonic/gin"
 )
@@ -28,7 +33,7 @@ func getHeaders(ctx *gin.Context) map[string]interface{} {
 		head["time-zone"] = timeZone
 	}
 	acceptLanguage := ctx.Request.Header.Get("accept-language")
-	if timeZone != "" {
+	if acceptLanguage != "" {
 		head["accept-language"] = acceptLanguage
 	}
 	authtype := ctx.Request.Header.Get("x-us-authtype")
@@ -44,20 +49,15 @@ func getHeaders(ctx *gin.Context) map[string]interface{} {
 
 func headCheck(head map[string]interface{}) *service.Error {
 
-	fmt.Println(head)
-
-	timeZone, err := strconv.Atoi(head["time-zone"].(string))
-	if err != nil {
-		fmt.Println(err.Error())
+	timeZone, _ := head["time-zone"].(string)
+	if _, err := timeutil.ParseZone(timeZone); err != nil {
+		log.Warn("invalid time-zone header", "time-zone", timeZone, "error", err)
+		return service.ErrInvalidParam
 	}
 
-	language := head["accept-language"].(string)
+	language, _ := head["accept-language"].(string)
 	//	authtype := head["x-us-authtype"].(int)
 
-	if math.Abs(float64(timeZone)) > 12 {
-		return service.ErrInvalidParam
-	}
-
 	if strings.ToUpper(language) != "EN" &&
 		strings.ToUpper(language) != "ZH" {
 		return service.ErrInvalidParam
@@ -65,6 +65,15 @@ func headCheck(head map[string]interface{}) *service.Error {
 	return nil
 }
 
+// callerZone 返回 time-zone 请求头指定的时区，没有或无效时为UTC，响应中的时间按这个时区输出
+func callerZone(ctx *gin.Context) *time.Location {
+	loc, err := timeutil.ParseZone(ctx.GetHeader("time-zone"))
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
 func authCheck(ctx *gin.Context) *service.Error {
 	head := getHeaders(ctx)
 	err := headCheck(head)
@@ -76,11 +85,11 @@ func authCheck(ctx *gin.Context) *service.Error {
 		return service.ErrUnauthorized
 	}
 
-	b, _ := cache.DoStrGet(token.(string))
+	b, _ := cache.DoStrGetCtx(ctx.Request.Context(), token.(string))
 	if !b {
 		return service.ErrUnauthorized
 	}
-	b = cache.DoExpire(token.(string), service.Redis_key_token_expire)
+	b = cache.DoExpireCtx(ctx.Request.Context(), token.(string), service.Redis_key_token_expire)
 	if !b {
 		return service.ErrUnauthorized
 	}
@@ -114,25 +123,32 @@ func Register(ctx *gin.Context) {
 	head := getHeaders(ctx)
 	err := headCheck(head)
 	if err != nil {
-		fmt.Println(err.Error())
+		log.WarnCtx(ctx.Request.Context(), "invalid headers", "error", err)
 		rsp.Error_code = msg.ErrInvalidParam
 		return
 	}
 
 	err2 := bindBody(ctx, req)
 	if err2 != nil {
-		fmt.Println(err2.Error())
+		log.WarnCtx(ctx.Request.Context(), "invalid body", "error", err2)
 		rsp.Error_code = msg.ErrInvalidParam
 		return
 	}
 
-	_, errCode := models.Register(req)
+	_, errCode := models.Register(ctx.Request.Context(), req)
 	if errCode != 0 {
 		rsp.Error_code = errCode
+		return
 	}
 }
 
-func checkToken(head map[string]interface{}) int {
+func checkToken(ctx context.Context, head map[string]interface{}) int {
+	ret := verifyToken(ctx, head)
+	metrics.TokenValidation("api", ret == msg.OK)
+	return ret
+}
+
+func verifyToken(ctx context.Context, head map[string]interface{}) int {
 
 	token := head["x-us-token"]
 
@@ -140,23 +156,27 @@ func checkToken(head map[string]interface{}) int {
 		return msg.ErrUnauthorized
 	}
 
-	str := strings.Split(token.(string), common.SPLIT)
-	if len(str) != 2 {
+	userId, _, ok := models.ParseToken(token.(string))
+	if !ok {
 		return msg.ErrUnauthorized
 	}
 
-	key := str[0] + common.KEY_TOKEN
+	key := userId + common.KEY_TOKEN
 
-	has, value := cache.DoStrGet(key)
-	if !has {
+	value, err := cache.GetString(ctx, key)
+	if err == cache.ErrNotFound {
 		return msg.ErrUnauthorized
 	}
+	if err != nil {
+		log.ErrorCtx(ctx, "get token failed", "error", err)
+		return msg.ErrServerInternalError
+	}
 
 	if value != token {
 		return msg.ErrUnauthorized
 	}
 
-	cache.DoExpire(key, common.ONE_MINUTE)
+	cache.DoExpireCtx(ctx, key, config.Get().Token.RefreshTTL)
 
 	return msg.OK
 }
@@ -188,23 +208,7 @@ func Login(ctx *gin.Context) {
 		return
 	}
 
-	models.Login(req, rsp)
-
-	//	token := head["x-us-token"]
-	//	str := strings.Split(token.(string), common.SPLIT)
-
-	//	b, err2 := models.LoginCydexManager(str[0], "token", rsp.Token)
-	//	if err2 != nil {
-	//		rsp.Error_code = msg.ErrCydexManagerAuthError
-	//		return
-	//	}
-	//	if !b {
-	//		rsp.Error_code = msg.ErrCydexManagerAuthError
-	//		return
-	//	}
-
-	fmt.Println(rsp)
-
+	models.Login(ctx.Request.Context(), req, rsp)
 }
 
 func Logout(ctx *gin.Context) {
@@ -226,7 +230,7 @@ func Logout(ctx *gin.Context) {
 		return
 	}
 
-	ret := checkToken(head)
+	ret := checkToken(ctx.Request.Context(), head)
 
 	if ret != msg.OK {
 		rsp.Error_code = ret
@@ -239,14 +243,12 @@ func Logout(ctx *gin.Context) {
 	//		return
 	//	}
 
-	token := head["x-us-token"]
-	str := strings.Split(token.(string), common.SPLIT)
-
-	key := str[0]
-	has := cache.DoDel(key)
+	userId, sessionId, _ := models.ParseToken(head["x-us-token"].(string))
+	has := cache.DoDelCtx(ctx.Request.Context(), userId+common.KEY_TOKEN)
 	if !has {
-		fmt.Println("clear cache error")
+		log.ErrorCtx(ctx.Request.Context(), "clear token cache failed", "user_id", userId)
 	}
+	events.Publish(ctx.Request.Context(), events.LoggedOut{UserId: userId, SessionId: sessionId, At: time.Now()})
 }
 
 func Info(ctx *gin.Context) {
@@ -264,27 +266,28 @@ func Info(ctx *gin.Context) {
 	head := getHeaders(ctx)
 	err := headCheck(head)
 	if err != nil {
-		fmt.Println(err.Error())
+		log.WarnCtx(ctx.Request.Context(), "invalid headers", "error", err)
 		rsp.Error_code = msg.ErrInvalidParam
 		return
 	}
 
-	ret := checkToken(head)
+	ret := checkToken(ctx.Request.Context(), head)
 
 	if ret != msg.OK {
 		rsp.Error_code = ret
 		return
 	}
 
-	token := head["x-us-token"]
-	str := strings.Split(token.(string), common.SPLIT)
+	userId, _, _ := models.ParseToken(head["x-us-token"].(string))
 
-	err2 := models.UserInfo(str[0], rsp)
+	err2 := models.UserInfo(ctx.Request.Context(), userId, rsp)
 	if err2 != nil {
-		fmt.Println(err2.Error())
+		log.ErrorCtx(ctx.Request.Context(), "get user info failed", "user_id", userId, "error", err2)
 		rsp.Error_code = msg.ErrServerInternalError
 		return
 	}
+	rsp.Id = idgen.Public(rsp.Id)
+	rsp.UserInfo.In(callerZone(ctx))
 
 }
 
@@ -309,7 +312,7 @@ func AddIdentifyType(ctx *gin.Context) {
 		return
 	}
 
-	ret := checkToken(head)
+	ret := checkToken(ctx.Request.Context(), head)
 
 	if ret != msg.OK {
 		rsp.Error_code = ret
@@ -321,10 +324,22 @@ func AddIdentifyType(ctx *gin.Context) {
 		rsp.Error_code = msg.ErrInvalidParam
 		return
 	}
+	// 账号只能绑定到token所属的用户，body 中的 user_id 可以省略，填写时必须是同一个用户
+	userId, _, _ := models.ParseToken(head["x-us-token"].(string))
+	if req.User_id != "" {
+		bodyId, err := idgen.Internal(req.User_id)
+		if err != nil {
+			rsp.Error_code = msg.ErrInvalidParam
+			return
+		}
+		if bodyId != userId {
+			rsp.Error_code = msg.ErrNotAllowed
+			return
+		}
+	}
+	req.User_id = userId
 
-	fmt.Println(req)
-
-	rsp.Error_code = models.AddIdentifyType(req)
+	rsp.Error_code = models.AddIdentifyType(ctx.Request.Context(), req)
 }
 
 func Authentication(ctx *gin.Context) {
@@ -342,22 +357,26 @@ func Authentication(ctx *gin.Context) {
 
 	err := bindBody(ctx, req)
 	if err != nil {
-		fmt.Println(err.Error())
+		log.WarnCtx(ctx.Request.Context(), "invalid body", "error", err)
 		rsp.Error_code = msg.ErrInvalidParam
 		return
 	}
 
-	if ret := models.Authentication(req); ret != msg.OK {
-		fmt.Println("models.Authentication error")
+	ret := models.Authentication(ctx.Request.Context(), req)
+	metrics.TokenValidation("authentication", ret == msg.OK)
+	if ret != msg.OK {
+		log.InfoCtx(ctx.Request.Context(), "authentication failed", "error_code", ret)
 		rsp.Error_code = ret
 		return
 	}
 
-	code := models.GetUerInfo(req.Token, rsp)
+	code := models.GetUerInfo(ctx.Request.Context(), req.Token, rsp)
 	if code != msg.OK {
-		fmt.Println("models.GetUerInfo error")
+		log.ErrorCtx(ctx.Request.Context(), "get user info failed", "error_code", code)
 		rsp.Error_code = code
 		return
 	}
+	rsp.Id = idgen.Public(rsp.Id)
+	rsp.UserInfo.In(callerZone(ctx))
 
 }
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/saisai/gindemo/api/msg"
+	"github.com/saisai/gindemo/idgen"
+	"github.com/saisai/gindemo/models"
+	"github.com/saisai/gindemo/utils"
+	"github.com/saisai/gindemo/utils/log"
+	"github.com/saisai/gindemo/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webhook 订阅管理，属于内部接口
+
+const (
+	DEFAULT_DELIVERY_LIMIT = 50
+	MAX_DELIVERY_LIMIT     = 200
+)
+
+// webhookStatus 订阅或投递记录不存在时返回404
+func webhookStatus(code int) int {
+	switch code {
+	case msg.OK:
+		return http.StatusOK
+	case msg.ErrWebhookNotExist, msg.ErrDeliveryNotExist:
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+func webhookView(s *models.WebhookSubscription) *msg.Webhook {
+	return &msg.Webhook{
+		Id:        s.Id,
+		Name:      s.Name,
+		Url:       s.URL,
+		Events:    strings.Split(s.Events, ","),
+		Active:    s.Active,
+		Owner:     s.Owner,
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+	}
+}
+
+func deliveryView(d *models.WebhookDelivery) *msg.WebhookDelivery {
+	return &msg.WebhookDelivery{
+		Id:             d.Id,
+		SubscriptionId: d.SubscriptionId,
+		EventId:        d.EventId,
+		Event:          d.Event,
+		State:          d.State,
+		Attempts:       d.Attempts,
+		NextAttemptAt:  d.NextAttemptAt,
+		ResponseCode:   d.ResponseCode,
+		LastError:      d.LastError,
+		DurationMs:     d.DurationMs,
+		RedeliveryOf:   d.RedeliveryOf,
+		CreatedAt:      d.CreatedAt,
+		UpdatedAt:      d.UpdatedAt,
+	}
+}
+
+// bindWebhook 解析并校验订阅，把请求的内容写入 s
+func bindWebhook(ctx *gin.Context, s *models.WebhookSubscription) bool {
+	req := new(msg.WebhookReq)
+	if err := bindBody(ctx, req); err != nil {
+		log.WarnCtx(ctx.Request.Context(), "invalid webhook body", "error", err)
+		return false
+	}
+	if err := webhook.ValidURL(ctx.Request.Context(), req.Url); err != nil {
+		log.WarnCtx(ctx.Request.Context(), "invalid webhook", "error", err)
+		return false
+	}
+	if err := webhook.ValidEvents(req.Events); err != nil {
+		log.WarnCtx(ctx.Request.Context(), "invalid webhook", "error", err)
+		return false
+	}
+	if len(req.Name) > 100 || len(req.Url) > 500 || len(req.Secret) > 100 || len(strings.Join(req.Events, ",")) > 500 {
+		return false
+	}
+
+	s.Name = req.Name
+	s.URL = req.Url
+	s.Events = strings.Join(req.Events, ",")
+	if req.Secret != "" {
+		s.Secret = req.Secret
+	}
+	if req.Active != nil {
+		s.Active = *req.Active
+	}
+	return true
+}
+
+// CreateWebhook 创建订阅，未指定secret时生成一个，secret 只在创建时返回
+func CreateWebhook(ctx *gin.Context) {
+	rsp := new(msg.WebhookRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(webhookStatus(rsp.Error_code), rsp)
+	}()
+
+	s := &models.WebhookSubscription{
+		Id:     idgen.New(),
+		Secret: utils.GetToken(),
+		Active: true,
+		Owner:  ctx.GetString(KEY_PRIVATE_CLIENT),
+	}
+	if !bindWebhook(ctx, s) {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	if err := models.CreateWebhook(ctx.Request.Context(), s); err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "create webhook failed", "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	webhook.Invalidate()
+	log.InfoCtx(ctx.Request.Context(), "[webhook] subscription created", "id", s.Id, "owner", s.Owner,
+		"url", log.RedactURL(s.URL), "events", s.Events)
+
+	rsp.Webhook = webhookView(s)
+	rsp.Secret = s.Secret
+}
+
+func ListWebhooks(ctx *gin.Context) {
+	rsp := new(msg.WebhookListRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(webhookStatus(rsp.Error_code), rsp)
+	}()
+
+	list, err := models.ListWebhooks(ctx.Request.Context())
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "list webhooks failed", "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	rsp.Webhooks = make([]msg.Webhook, 0, len(list))
+	for i := range list {
+		rsp.Webhooks = append(rsp.Webhooks, *webhookView(&list[i]))
+	}
+}
+
+func GetWebhook(ctx *gin.Context) {
+	rsp := new(msg.WebhookRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(webhookStatus(rsp.Error_code), rsp)
+	}()
+
+	s, err := models.GetWebhook(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "get webhook failed", "id", ctx.Param("id"), "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	if s == nil {
+		rsp.Error_code = msg.ErrWebhookNotExist
+		return
+	}
+	rsp.Webhook = webhookView(s)
+}
+
+// UpdateWebhook 修改订阅，secret 为空时保持不变
+func UpdateWebhook(ctx *gin.Context) {
+	rsp := new(msg.WebhookRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(webhookStatus(rsp.Error_code), rsp)
+	}()
+
+	s, err := models.GetWebhook(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "get webhook failed", "id", ctx.Param("id"), "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	if s == nil {
+		rsp.Error_code = msg.ErrWebhookNotExist
+		return
+	}
+	if !bindWebhook(ctx, s) {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	ok, err := models.UpdateWebhook(ctx.Request.Context(), s)
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "update webhook failed", "id", s.Id, "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	if !ok {
+		rsp.Error_code = msg.ErrWebhookNotExist
+		return
+	}
+	webhook.Invalidate()
+	rsp.Webhook = webhookView(s)
+}
+
+// DeleteWebhook 删除订阅及其投递记录
+func DeleteWebhook(ctx *gin.Context) {
+	rsp := new(msg.BaseRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(webhookStatus(rsp.Error_code), rsp)
+	}()
+
+	ok, err := models.DeleteWebhook(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "delete webhook failed", "id", ctx.Param("id"), "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	if !ok {
+		rsp.Error_code = msg.ErrWebhookNotExist
+		return
+	}
+	webhook.Invalidate()
+	log.InfoCtx(ctx.Request.Context(), "[webhook] subscription deleted", "id", ctx.Param("id"),
+		"client", ctx.GetString(KEY_PRIVATE_CLIENT))
+}
+
+// ListWebhookDeliveries 按ID倒序返回订阅的投递记录，参数 before 为上一页最后一条记录的ID，limit 默认50
+func ListWebhookDeliveries(ctx *gin.Context) {
+	rsp := new(msg.WebhookDeliveryListRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(webhookStatus(rsp.Error_code), rsp)
+	}()
+
+	before, err := strconv.ParseInt(ctx.DefaultQuery("before", "0"), 10, 64)
+	if err != nil {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(DEFAULT_DELIVERY_LIMIT)))
+	if err != nil || limit <= 0 || limit > MAX_DELIVERY_LIMIT {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	s, err := models.GetWebhook(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "get webhook failed", "id", ctx.Param("id"), "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	if s == nil {
+		rsp.Error_code = msg.ErrWebhookNotExist
+		return
+	}
+
+	list, err := models.ListDeliveries(ctx.Request.Context(), s.Id, before, limit)
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "list webhook deliveries failed", "id", s.Id, "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	rsp.Deliveries = make([]msg.WebhookDelivery, 0, len(list))
+	for i := range list {
+		rsp.Deliveries = append(rsp.Deliveries, *deliveryView(&list[i]))
+	}
+}
+
+// RedeliverWebhook 重新投递一条记录，返回新的投递记录
+func RedeliverWebhook(ctx *gin.Context) {
+	rsp := new(msg.WebhookDeliveryRsp)
+	rsp.Error_code = msg.OK
+
+	defer func() {
+		ctx.JSON(webhookStatus(rsp.Error_code), rsp)
+	}()
+
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		rsp.Error_code = msg.ErrInvalidParam
+		return
+	}
+
+	delivery, err := webhook.Redeliver(ctx.Request.Context(), id)
+	if err != nil {
+		log.ErrorCtx(ctx.Request.Context(), "redeliver webhook failed", "delivery_id", id, "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
+	}
+	if delivery == nil {
+		rsp.Error_code = msg.ErrDeliveryNotExist
+		return
+	}
+	log.InfoCtx(ctx.Request.Context(), "[webhook] redelivery queued", "delivery_id", id, "new_delivery_id", delivery.Id,
+		"client", ctx.GetString(KEY_PRIVATE_CLIENT))
+	rsp.Delivery = deliveryView(delivery)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/saisai/gindemo/config"
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+const HEADER_REQUEST_ID = "X-Request-ID"
+
+type bodyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID 使用请求头中的 X-Request-ID 作为请求ID，没有则生成一个，
+// 请求ID会写入响应头，并通过 request context 传递给后续的日志
+func requestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(HEADER_REQUEST_ID)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		ctx.Header(HEADER_REQUEST_ID, id)
+		ctx.Request = ctx.Request.WithContext(log.WithRequestID(ctx.Request.Context(), id))
+		ctx.Next()
+	}
+}
+
+// accessLog 每个请求记录一条访问日志
+func accessLog() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		log.InfoCtx(ctx.Request.Context(), "[access]",
+			"method", ctx.Request.Method,
+			"path", ctx.Request.URL.Path,
+			"status", ctx.Writer.Status(),
+			"latency", time.Since(start).String(),
+			"client_ip", ctx.ClientIP(),
+			"size", ctx.Writer.Size(),
+		)
+	}
+}
+
+// recovery 捕获处理函数中的panic，记录带请求ID的错误日志后返回500
+func recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(ctx *gin.Context, err interface{}) {
+		log.ErrorCtx(ctx.Request.Context(), "[panic]",
+			"method", ctx.Request.Method,
+			"path", ctx.Request.URL.Path,
+			"error", err,
+		)
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// showBody 按 api.show_req / api.show_rsp 配置在日志中打印请求和响应的body，凭证类字段会被脱敏
+func showBody() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		cfg := config.Get().API
+
+		if cfg.ShowReq && ctx.Request.Body != nil {
+			body, err := ioutil.ReadAll(ctx.Request.Body)
+			if err == nil {
+				ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+				log.InfoCtx(ctx.Request.Context(), "[req]",
+					"method", ctx.Request.Method,
+					"path", ctx.Request.URL.Path,
+					"body", log.RedactJSON(body),
+				)
+			}
+		}
+
+		var writer *bodyWriter
+		if cfg.ShowRsp {
+			writer = &bodyWriter{ResponseWriter: ctx.Writer}
+			ctx.Writer = writer
+		}
+
+		ctx.Next()
+
+		if writer != nil {
+			log.InfoCtx(ctx.Request.Context(), "[rsp]",
+				"method", ctx.Request.Method,
+				"path", ctx.Request.URL.Path,
+				"status", writer.Status(),
+				"body", log.RedactJSON(writer.body.Bytes()),
+			)
+		}
+	}
+}
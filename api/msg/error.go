@@ -13,4 +13,13 @@ const (
 	ErrServerInternalError   = 109
 	ErrIdentifyTypeExist     = 110
 	ErrCydexManagerAuthError = 111
+	ErrEmailIsExist          = 112
+	ErrPhoneIsExist          = 113
+	ErrIdentifierIsExist     = 114
+	ErrTooManyRequests       = 115
+	ErrWebhookNotExist       = 116
+	ErrDeliveryNotExist      = 117
+	ErrJobNotExist           = 118
+	ErrQueueNotExist         = 119
+	ErrJobsDisabled          = 120
 )
@@ -1,22 +1,35 @@
 package msg
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/saisai/gindemo/utils/timeutil"
+)
+
 type BaseRsp struct {
 	Error_code int `json:"error_code"`
 }
 
 type User struct {
-	Id         string `json:"id" xorm:"varchar(24) pk"`
-	Nickname   string `json:"nickname" xorm:"varchar(100)"`
-	Avatar     string `json:"avatar" xorm:"varchar(100)"`
-	Sex        int    `json:"sex" xorm:"int"`
-	CreateTime string `json:"createtime" xorm:"DateTime created"`
+	Id         string    `json:"id" xorm:"varchar(32) pk"`
+	Nickname   string    `json:"nickname" xorm:"varchar(100)"`
+	Avatar     string    `json:"avatar" xorm:"varchar(100)"`
+	Sex        int       `json:"sex" xorm:"int"`
+	CreateTime time.Time `json:"createtime" xorm:"DateTime created"`
 }
 
 type UserInfo struct {
 	User
-	UpdateTime string `json:"updatetime" xorm:"DateTime updated"`
-	Phone      string `json:"phone" xorm:"varchar(100)"`
-	Email      string `json:"email" xorm:"varchar(100)"`
+	UpdateTime time.Time `json:"updatetime" xorm:"DateTime updated"`
+	Phone      string    `json:"phone" xorm:"varchar(100)"`
+	Email      string    `json:"email" xorm:"varchar(100)"`
+}
+
+// In 把时间转换到调用方的时区 loc，JSON 中按 RFC 3339 输出该时区的时间
+func (u *UserInfo) In(loc *time.Location) {
+	u.CreateTime = timeutil.In(u.CreateTime, loc)
+	u.UpdateTime = timeutil.In(u.UpdateTime, loc)
 }
 
 type RegisterReq struct {
@@ -54,7 +67,7 @@ type InfoRsp struct {
 }
 
 type AddIdentifyTypeReq struct {
-	User_id       string `json:"user_id"`
+	User_id       string `json:"user_id"` // 可省略，以 x-us-token 中的用户为准
 	Identify_type string `json:"identify_type"`
 	Identifier    string `json:"identifier"`
 	Credential    string `json:"credential"`
@@ -73,12 +86,124 @@ type AuthenticationRsp struct {
 	UserInfo
 }
 
-type LoginCydexManagerReq struct {
-	AuthType string `json:"authtype"`
-	Auth     string `json:"auth"`
+type BulkRegisterReq struct {
+	Users []RegisterReq `json:"users"`
+}
+
+// BulkRegisterResult 单个用户的注册结果，与请求中的 users 一一对应
+type BulkRegisterResult struct {
+	Error_code int    `json:"error_code"`
+	User_id    string `json:"user_id,omitempty"`
 }
 
-type LoginCydexManagerRsp struct {
+type BulkRegisterRsp struct {
 	BaseRsp
+	Results []BulkRegisterResult `json:"results"`
+}
+
+type ValidateTokenReq struct {
 	Token string `json:"token"`
 }
+
+type ValidateTokenRsp struct {
+	BaseRsp
+	Valid     bool   `json:"valid"`
+	User_id   string `json:"user_id,omitempty"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+}
+
+type BatchInfoReq struct {
+	User_ids []string `json:"user_ids"`
+}
+
+type BatchInfoRsp struct {
+	BaseRsp
+	Users []UserInfo `json:"users"`
+}
+
+type WebhookReq struct {
+	Name   string   `json:"name"`
+	Url    string   `json:"url"`
+	Secret string   `json:"secret"` // 为空时生成一个，只在创建时返回
+	Events []string `json:"events"` // * 表示全部事件
+	Active *bool    `json:"active"` // 默认 true
+}
+
+type Webhook struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type WebhookRsp struct {
+	BaseRsp
+	Webhook *Webhook `json:"webhook,omitempty"`
+	Secret  string   `json:"secret,omitempty"`
+}
+
+type WebhookListRsp struct {
+	BaseRsp
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type WebhookDelivery struct {
+	Id             int64     `json:"id"`
+	SubscriptionId string    `json:"subscription_id"`
+	EventId        string    `json:"event_id"`
+	Event          string    `json:"event"`
+	State          string    `json:"state"`
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	ResponseCode   int       `json:"response_code"`
+	LastError      string    `json:"last_error,omitempty"`
+	DurationMs     int       `json:"duration_ms"`
+	RedeliveryOf   int64     `json:"redelivery_of,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type WebhookDeliveryRsp struct {
+	BaseRsp
+	Delivery *WebhookDelivery `json:"delivery,omitempty"`
+}
+
+type WebhookDeliveryListRsp struct {
+	BaseRsp
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+type JobQueue struct {
+	Queue      string `json:"queue"`
+	Workers    int    `json:"workers"`
+	Ready      int64  `json:"ready"`
+	Processing int64  `json:"processing"`
+	Delayed    int64  `json:"delayed"`
+	Dead       int64  `json:"dead"`
+}
+
+type JobQueueListRsp struct {
+	BaseRsp
+	Queues []JobQueue `json:"queues"`
+}
+
+type Job struct {
+	Id          string          `json:"id"`
+	Queue       string          `json:"queue"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FailedAt    time.Time       `json:"failed_at"`
+}
+
+type JobListRsp struct {
+	BaseRsp
+	Jobs []Job `json:"jobs"`
+}
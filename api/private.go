@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/saisai/gindemo/api/controllers"
+	"github.com/saisai/gindemo/api/msg"
+	"github.com/saisai/gindemo/config"
+	"github.com/saisai/gindemo/utils/cache"
+	"github.com/saisai/gindemo/utils/log"
+	"github.com/saisai/gindemo/utils/sign"
+
+	"github.com/gin-gonic/gin"
+)
+
+const KEY_PRIVATE_NONCE = "private_nonce:"
+
+// privateAuth 内部接口的认证：已校验的客户端证书（CN 在 private.client_names 中）或有效的 HMAC 签名，
+// 签名的随机数在时间偏差窗口内只能使用一次
+func privateAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		sec := config.Get().Private
+
+		if client, ok := verifiedClient(ctx.Request, sec.ClientNameList()); ok {
+			ctx.Set(controllers.KEY_PRIVATE_CLIENT, "mtls:"+client)
+			ctx.Next()
+			return
+		}
+
+		keys, _ := sec.Keys()
+		skew := time.Duration(sec.MaxClockSkew) * time.Second
+		// 签名头、密钥ID和时间戳都有效时才读取body，且读取的大小有上限
+		err := sign.Precheck(ctx.Request, keys, skew)
+		if err == nil {
+			var body []byte
+			if ctx.Request.Body != nil {
+				body, err = ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, int64(sec.MaxBodySize)))
+				if err != nil {
+					status := http.StatusBadRequest
+					var tooLarge *http.MaxBytesError
+					if errors.As(err, &tooLarge) {
+						status = http.StatusRequestEntityTooLarge
+					}
+					ctx.AbortWithStatusJSON(status, msg.BaseRsp{Error_code: msg.ErrInvalidParam})
+					return
+				}
+				ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+
+			var keyId, nonce string
+			keyId, nonce, err = sign.Verify(ctx.Request, body, keys, skew)
+			if err == nil && !cache.DoSetNxCtx(ctx.Request.Context(), KEY_PRIVATE_NONCE+keyId+":"+nonce, 2*sec.MaxClockSkew) {
+				err = sign.ErrReplayed
+			}
+			if err == nil {
+				ctx.Set(controllers.KEY_PRIVATE_CLIENT, "hmac:"+keyId)
+				ctx.Next()
+				return
+			}
+		}
+		if len(keys) > 0 {
+			log.WarnCtx(ctx.Request.Context(), "[private] signature rejected", "client_ip", ctx.ClientIP(), "error", err)
+		}
+
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, msg.BaseRsp{Error_code: msg.ErrUnauthorized})
+	}
+}
+
+// verifiedClient 返回已通过CA校验的客户端证书的CN，names 为空时接受所有已校验的证书
+func verifiedClient(req *http.Request, names []string) (string, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cn := req.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(names) == 0 {
+		return cn, true
+	}
+	for _, name := range names {
+		if name == cn {
+			return cn, true
+		}
+	}
+	return "", false
+}
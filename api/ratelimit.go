@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saisai/gindemo/api/msg"
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/models"
+	"github.com/saisai/gindemo/ratelimit"
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 限流的路由分组，对应配置文件 [ratelimit] 中的同名配置项
+const (
+	RATELIMIT_REGISTER = "register"
+	RATELIMIT_LOGIN    = "login"
+	RATELIMIT_API      = "api"
+	RATELIMIT_PRIVATE  = "private"
+)
+
+// rateLimit 按分组配置的规则限制请求频率，超过限制返回429。
+// 依次检查每条规则，响应头 X-RateLimit-* 取剩余额度最少的一条；限流器出错时放行。
+func rateLimit(group string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		rules := ratelimit.Rules(group)
+		if len(rules) == 0 {
+			ctx.Next()
+			return
+		}
+
+		var tightest *ratelimit.Result
+		for _, rule := range rules {
+			key := group + ":" + rateLimitKey(ctx, rule.Keys)
+			result, err := ratelimit.Allow(ctx.Request.Context(), key, rule)
+			if err != nil {
+				log.ErrorCtx(ctx.Request.Context(), "[ratelimit] check failed", "group", group, "error", err)
+				continue
+			}
+			if tightest == nil || !result.Allowed || (tightest.Allowed && result.Remaining < tightest.Remaining) {
+				tightest = result
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+		if tightest == nil {
+			ctx.Next()
+			return
+		}
+
+		header := ctx.Writer.Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(tightest.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+		header.Set("X-RateLimit-Reset", strconv.Itoa(seconds(tightest.Reset)))
+
+		if !tightest.Allowed {
+			header.Set("Retry-After", strconv.Itoa(seconds(tightest.RetryAfter)))
+			metrics.RateLimited(group)
+			log.InfoCtx(ctx.Request.Context(), "[ratelimit] rejected", "group", group, "client_ip", ctx.ClientIP())
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, msg.BaseRsp{Error_code: msg.ErrTooManyRequests})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// seconds 向上取整到秒，至少为1
+func seconds(d time.Duration) int {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 1 {
+		return 1
+	}
+	return s
+}
+
+// rateLimitKey 按限流维度拼出计数key
+func rateLimitKey(ctx *gin.Context, keys []string) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		switch key {
+		case ratelimit.KEY_IP:
+			parts = append(parts, ctx.ClientIP())
+		case ratelimit.KEY_USER:
+			parts = append(parts, rateLimitUser(ctx))
+		case ratelimit.KEY_IDENTIFIER:
+			parts = append(parts, rateLimitIdentifier(ctx))
+		}
+	}
+	return strings.Join(parts, "+")
+}
+
+// rateLimitUser 取 x-us-token 中的用户ID，token 校验通过（与缓存中的token一致）才按用户计数，
+// 否则按IP计数，伪造的token既不能占用其他用户的额度，也不能每次换一个用户ID绕过限流
+func rateLimitUser(ctx *gin.Context) string {
+	token := ctx.GetHeader("x-us-token")
+	if token != "" && models.Authentication(ctx.Request.Context(), &msg.AuthenticationReq{Token: token}) == msg.OK {
+		userId, _, _ := models.ParseToken(token)
+		return "user:" + userId
+	}
+	return "ip:" + ctx.ClientIP()
+}
+
+// rateLimitIdentifier 取请求body中的账号（identifier、email 或 phone），读取后恢复body
+func rateLimitIdentifier(ctx *gin.Context) string {
+	if ctx.Request.Body == nil {
+		return ""
+	}
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Identifier string `json:"identifier"`
+		Email      string `json:"email"`
+		Phone      string `json:"phone"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	switch {
+	case req.Identifier != "":
+		return strings.ToLower(req.Identifier)
+	case req.Email != "":
+		return strings.ToLower(req.Email)
+	}
+	return req.Phone
+}
@@ -1,20 +1,41 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
-	"syscall"
+	"io/ioutil"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/saisai/gindemo/api"
+	"github.com/saisai/gindemo/config"
+	"github.com/saisai/gindemo/events"
+	"github.com/saisai/gindemo/health"
+	"github.com/saisai/gindemo/idgen"
+	"github.com/saisai/gindemo/jobs"
+	"github.com/saisai/gindemo/lifecycle"
+	"github.com/saisai/gindemo/lock"
+	"github.com/saisai/gindemo/maintenance"
+	"github.com/saisai/gindemo/metrics"
 	"github.com/saisai/gindemo/models"
+	"github.com/saisai/gindemo/provision"
+	"github.com/saisai/gindemo/ratelimit"
+	"github.com/saisai/gindemo/tracing"
+	"github.com/saisai/gindemo/webhook"
 
 	"github.com/saisai/gindemo/utils/cache"
+	ss_http "github.com/saisai/gindemo/utils/http"
 
-	"caton/zh.jin/utils/log"
+	"github.com/saisai/gindemo/utils/log"
+	"github.com/saisai/gindemo/utils/timeutil"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-ini/ini"
 	"github.com/go-xorm/xorm"
+	"xorm.io/core"
 	//	"gopkg.in/redsync.v1"
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -24,39 +45,111 @@ const (
 )
 
 var (
-	config      *ini.File
-	apiAddr     string
-	ConfigFiles []interface{} = []interface{}{"/opt/saisai/profile.ini"}
+	configFile string
+	apiAddr    string
 )
 
 func initConfig() error {
-	var err error
-	profile, _ := ConfigFiles[0].(string)
-	if err = syscall.Access(profile, syscall.F_OK); err != nil {
-		return err
-	}
-	config, err = ini.LooseLoad(profile, ConfigFiles[0:]...)
+	cfg, err := config.Load(configFile)
 	if err != nil {
 		return err
 	}
+	config.Set(cfg)
 	return nil
 }
 
-func initRedis(cfg *ini.File) (err error) {
-	sec, err := cfg.GetSection("redis")
-	if err != nil {
+func initLog(cfg *config.Config) error {
+	sec := cfg.Log
+	return log.Init(log.Options{
+		Level:      sec.Level,
+		Format:     sec.Format,
+		Output:     sec.Output,
+		File:       sec.File,
+		MaxSize:    sec.MaxSize,
+		MaxBackups: sec.MaxBackups,
+		MaxAge:     sec.MaxAge,
+	})
+}
+
+// reloadLogLevel 配置热加载时只更新日志级别，输出位置和格式需要重启生效
+func reloadLogLevel(cfg *config.Config) {
+	if err := log.SetLevel(cfg.Log.Level); err != nil {
+		log.Error("reload log level failed", "error", err)
+	}
+}
+
+func initTracing(cfg *config.Config) error {
+	sec := cfg.Trace
+	log.Info("[init tracing]", "exporter", sec.Exporter, "endpoint", sec.Endpoint, "sample_ratio", sec.SampleRatio)
+	return tracing.Init(tracing.Options{
+		Exporter:    sec.Exporter,
+		Endpoint:    sec.Endpoint,
+		Insecure:    sec.Insecure,
+		ServiceName: sec.ServiceName,
+		Version:     Version,
+		SampleRatio: sec.SampleRatio,
+	})
+}
+
+// initID 选择ID生成器并设置公开ID的密钥
+func initID(cfg *config.Config) error {
+	sec := cfg.ID
+	log.Info("[init id]", "generator", sec.Generator, "worker_id", sec.WorkerId, "public_id", sec.PublicIdKey != "")
+	if err := idgen.Init(idgen.Options{Kind: sec.Generator, WorkerId: sec.WorkerId}); err != nil {
 		return err
 	}
-	url := sec.Key("url").String()
-	log.Infof("[init redis] url:'%s'", url)
-	//	auth := sec.Key("password").String()
-	//	log.Infof("[init redis] pwd:'%s'", auth)
+	return idgen.SetPublicKey(sec.PublicIdKey)
+}
+
+func initCache(cfg *config.Config) (err error) {
+	backend := cfg.Cache.Backend
+	log.Info("[init cache]", "backend", backend)
+
+	if backend == cache.BACKEND_MEMORY {
+		cache.InitMemory(cfg.Cache.CleanupInterval)
+		log.Info("[init memory cache success]")
+		return nil
+	}
+	return initRedis(cfg)
+}
 
-	cache.Init(url, "", 20, 20, 10)
+func initRedis(cfg *config.Config) (err error) {
+	sec := cfg.Redis
+	log.Info("[init redis]", "mode", sec.Mode, "url", log.RedactURL(sec.URL), "addrs", sec.Addrs,
+		"master_name", sec.MasterName, "key_prefix", sec.KeyPrefix, "tls", sec.TLS, "max_idle", sec.MaxIdle,
+		"max_active", sec.MaxActive)
+
+	tlsConfig, err := redisTLSConfig(sec)
+	if err != nil {
+		return err
+	}
+	err = cache.Init(cache.Options{
+		Mode:             sec.Mode,
+		URL:              sec.URL,
+		Addrs:            sec.AddrList(),
+		MasterName:       sec.MasterName,
+		SentinelPassword: sec.SentinelPassword,
+		Username:         sec.Username,
+		Password:         sec.Password,
+		KeyPrefix:        sec.KeyPrefix,
+		TLS:              sec.TLS,
+		TLSConfig:        tlsConfig,
+		MaxIdle:          sec.MaxIdle,
+		MaxActive:        sec.MaxActive,
+		Wait:             sec.Wait,
+		IdleTimeout:      time.Duration(sec.IdleTimeout) * time.Second,
+		ConnectTimeout:   time.Duration(sec.ConnectTimeout) * time.Second,
+		ReadTimeout:      time.Duration(sec.ReadTimeout) * time.Second,
+		WriteTimeout:     time.Duration(sec.WriteTimeout) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	metrics.RegisterCachePool(cache.PoolActiveCount, cache.PoolIdleCount)
 
 	log.Info("[init redis success]")
 
-	err = cache.Ping()
+	err = cache.PingCtx(context.Background())
 	if err != nil {
 		return err
 	}
@@ -64,91 +157,686 @@ func initRedis(cfg *ini.File) (err error) {
 	return
 }
 
-func initDB(cfg *ini.File) (err error) {
-	//create database
-	sec, err := cfg.GetSection("db")
+// redisTLSConfig 连接redis的TLS配置，没有设置证书相关的配置项时返回nil，使用系统根证书
+func redisTLSConfig(sec config.RedisConfig) (*tls.Config, error) {
+	if sec.TLSCAFile == "" && sec.TLSCertFile == "" && !sec.TLSSkipVerify {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: sec.TLSSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if sec.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(sec.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read redis ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", sec.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if sec.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(sec.TLSCertFile, sec.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load redis client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func initHTTPClient(cfg *config.Config) error {
+	sec := cfg.HTTPClient
+	log.Info("[init http client]", "ca_file", sec.CAFile, "mtls", sec.CertFile != "",
+		"insecure_skip_verify", sec.InsecureSkipVerify, "max_retries", sec.MaxRetries)
+	return ss_http.Init(httpClientOptions(cfg))
+}
+
+// httpClientOptions 按 [http_client] 生成出站http客户端的配置
+func httpClientOptions(cfg *config.Config) ss_http.Options {
+	sec := cfg.HTTPClient
+	return ss_http.Options{
+		Timeout:            time.Duration(sec.Timeout) * time.Second,
+		CAFile:             sec.CAFile,
+		CertFile:           sec.CertFile,
+		KeyFile:            sec.KeyFile,
+		InsecureSkipVerify: sec.InsecureSkipVerify,
+		MaxRetries:         sec.MaxRetries,
+		RetryBackoff:       time.Duration(sec.RetryBackoff) * time.Millisecond,
+		RetryMaxBackoff:    time.Duration(sec.RetryMaxBackoff) * time.Millisecond,
+		BreakerFailures:    sec.BreakerFailures,
+		BreakerCooldown:    time.Duration(sec.BreakerCooldown) * time.Second,
+	}
+}
+
+// initProvision 创建下游开通钩子的执行队列，未启用时不执行任何钩子
+func initProvision(cfg *config.Config) error {
+	sec := cfg.Provision
+	log.Info("[init provision]", "enabled", sec.Enabled, "workers", sec.Workers, "targets", len(sec.Targets))
+	if !sec.Enabled {
+		return nil
+	}
+
+	targets := make([]provision.Target, 0, len(sec.Targets))
+	for i := range sec.Targets {
+		t := &sec.Targets[i]
+		header, err := t.Header()
+		if err != nil {
+			return fmt.Errorf("provision.%s: %v", t.Name, err)
+		}
+		targets = append(targets, provision.Target{
+			Name:     t.Name,
+			URL:      t.URL,
+			Events:   t.EventList(),
+			Timeout:  time.Duration(t.Timeout) * time.Second,
+			CacheKey: t.CacheKey,
+			CacheTTL: t.CacheTTL,
+			Header:   header,
+		})
+		log.Info("[init provision] target", "name", t.Name, "url", log.RedactURL(t.URL), "events", t.Events)
+	}
+
+	provision.Init(provision.Options{
+		Workers:      sec.Workers,
+		QueueSize:    sec.QueueSize,
+		MaxRetries:   sec.MaxRetries,
+		RetryBackoff: time.Duration(sec.RetryBackoff) * time.Millisecond,
+		Targets:      targets,
+	})
+	return nil
+}
+
+// initWebhook 创建webhook投递队列，投递记录保存在数据库中。
+// 投递使用单独的http客户端，只能连接 AllowedAddr 允许的地址
+func initWebhook(cfg *config.Config) error {
+	sec := cfg.Webhook
+	log.Info("[init webhook]", "enabled", sec.Enabled, "workers", sec.Workers, "max_attempts", sec.MaxAttempts,
+		"allow_nets", sec.AllowNets)
+	// 未启用投递时订阅接口仍然可用，同样需要检查地址
+	if err := webhook.SetAllowedNets(sec.AllowNets); err != nil {
+		return err
+	}
+	if !sec.Enabled {
+		return nil
+	}
+
+	opts := httpClientOptions(cfg)
+	opts.AllowAddr = webhook.AllowedAddr
+	client, err := ss_http.NewClient(opts)
 	if err != nil {
 		return err
 	}
+	webhook.Init(webhook.Options{
+		Workers:         sec.Workers,
+		PollInterval:    time.Duration(sec.PollInterval) * time.Second,
+		BatchSize:       sec.BatchSize,
+		MaxAttempts:     sec.MaxAttempts,
+		RetryBackoff:    time.Duration(sec.RetryBackoff) * time.Second,
+		RetryMaxBackoff: time.Duration(sec.RetryMaxBackoff) * time.Second,
+		Timeout:         time.Duration(sec.Timeout) * time.Second,
+		Retention:       time.Duration(sec.RetentionDays) * 24 * time.Hour,
+	}, client)
+	return nil
+}
+
+// initLock 选择分布式锁的后端：配置了 Redlock 节点时使用 Redlock，否则使用缓存的redis，
+// 缓存为进程内后端时使用进程内锁
+func initLock(cfg *config.Config) {
+	sec := cfg.Lock
+	urls := sec.RedlockURLList()
+	switch {
+	case len(urls) > 0:
+		lock.Use(lock.NewRedlock(urls, time.Duration(sec.RedlockTimeout)*time.Millisecond))
+		log.Info("[init lock] redlock", "nodes", len(urls))
+	case cfg.Cache.Backend == cache.BACKEND_REDIS:
+		lock.Use(lock.NewRedis())
+		log.Info("[init lock] redis")
+	default:
+		log.Info("[init lock] memory")
+	}
+}
+
+// initJobs 创建后台任务队列，任务保存在redis中，缓存使用进程内后端时不启用
+func initJobs(cfg *config.Config) {
+	sec := cfg.Jobs
+	queues, _ := sec.QueueMap()
+	log.Info("[init jobs]", "enabled", sec.Enabled, "queues", sec.Queues, "visibility_timeout", sec.VisibilityTimeout)
+	if !sec.Enabled {
+		return
+	}
+	if cfg.Cache.Backend != cache.BACKEND_REDIS {
+		log.Warn("[init jobs] job queue requires cache.backend redis, disabled")
+		return
+	}
+	jobs.Init(jobs.Options{
+		Queues:            queues,
+		VisibilityTimeout: time.Duration(sec.VisibilityTimeout) * time.Second,
+		MaxAttempts:       sec.MaxAttempts,
+		RetryBackoff:      time.Duration(sec.RetryBackoff) * time.Second,
+		RetryMaxBackoff:   time.Duration(sec.RetryMaxBackoff) * time.Second,
+		PollInterval:      time.Duration(sec.PollInterval) * time.Second,
+		DeadRetention:     time.Duration(sec.DeadRetentionDays) * 24 * time.Hour,
+	})
+}
+
+// initUserCache 启用 /info 和 /authentication 的用户信息缓存，
+// events.transport 为 none 时其他实例修改的用户信息最多在 ttl 后才会更新
+func initUserCache(cfg *config.Config) {
+	sec := cfg.UserCache
+	log.Info("[init user cache]", "enabled", sec.Enabled, "ttl", sec.TTL, "negative_ttl", sec.NegativeTTL,
+		"max_entries", sec.MaxEntries)
+	if !sec.Enabled {
+		return
+	}
+	models.InitUserViewCache(time.Duration(sec.TTL)*time.Second, time.Duration(sec.NegativeTTL)*time.Second,
+		sec.MaxEntries)
+}
+
+// initEvents 创建领域事件总线并注册订阅者，需在开通钩子和webhook之后初始化
+func initEvents(cfg *config.Config) {
+	sec := cfg.Events
+	log.Info("[init events]", "workers", sec.Workers, "queue_size", sec.QueueSize, "transport", sec.Transport,
+		"channel", sec.Channel)
+
+	var transport events.Transport
+	if sec.Transport == "redis" {
+		transport = events.NewRedis(sec.Channel)
+	}
+	events.Init(events.Options{
+		Workers:   sec.Workers,
+		QueueSize: sec.QueueSize,
+		Transport: transport,
+	})
+	subscribeEvents()
+}
 
-	driver := sec.Key("driver").String()
-	source := sec.Key("source").String()
-	showSql := sec.Key("show_sql").MustBool()
-	utc := sec.Key("utc").MustBool(false)
-	useCache := sec.Key("use_cache").MustBool(false)
-	log.Infof("[init DB] url:'%s %s' show_sql:%t, utc:%t, cache:%t\n", driver, source, showSql, utc, useCache)
+// initRateLimit redis 后端下多个实例共享限流额度，redis 不可用时退回到进程内限流
+func initRateLimit(cfg *config.Config) error {
+	if cfg.Cache.Backend == cache.BACKEND_REDIS {
+		ratelimit.Use(ratelimit.NewRedis(ratelimit.NewMemory(time.Minute)))
+	}
+	return applyRateLimit(cfg)
+}
 
-	db, err := xorm.NewEngine(driver, source)
+// applyRateLimit 解析各路由分组的限流规则，配置热加载时也会调用
+func applyRateLimit(cfg *config.Config) error {
+	sec := cfg.RateLimit
+	groups := map[string]string{
+		api.RATELIMIT_REGISTER: sec.Register,
+		api.RATELIMIT_LOGIN:    sec.Login,
+		api.RATELIMIT_API:      sec.API,
+		api.RATELIMIT_PRIVATE:  sec.Private,
+	}
+
+	rules := make(map[string][]*ratelimit.Rule)
+	if sec.Enabled {
+		for group, value := range groups {
+			parsed, err := ratelimit.ParseRules(value)
+			if err != nil {
+				return fmt.Errorf("ratelimit.%s: %v", group, err)
+			}
+			rules[group] = parsed
+		}
+	}
+	ratelimit.SetRules(rules)
+	log.Info("[init ratelimit]", "enabled", sec.Enabled, "register", sec.Register, "login", sec.Login,
+		"api", sec.API, "private", sec.Private)
+	return nil
+}
+
+func reloadRateLimit(cfg *config.Config) {
+	if err := applyRateLimit(cfg); err != nil {
+		log.Error("reload ratelimit failed, keeping previous rules", "error", err)
+	}
+}
+
+func initDB(cfg *config.Config, autoMigrate bool) (err error) {
+	//create database
+	sec := cfg.DB
+	log.Info("[init DB]", "driver", sec.Driver, "show_sql", sec.ShowSQL, "utc", sec.UTC,
+		"legacy_time_zone", sec.LegacyTimeZone, "cache", sec.UseCache, "auto_migrate", autoMigrate, "max_open_conns", sec.MaxOpenConns,
+		"max_idle_conns", sec.MaxIdleConns, "conn_max_lifetime", sec.ConnMaxLifetime)
+
+	// 旧版本在 utc=false 时以本地时间写入，迁移 0007 按 legacy_time_zone 转换，未设置时有数据则中止迁移
+	if sec.UTC {
+		models.SetLegacyTimeZone(time.UTC)
+	} else if sec.LegacyTimeZone != "" {
+		loc, err := timeutil.ParseZone(sec.LegacyTimeZone)
+		if err != nil {
+			return err
+		}
+		models.SetLegacyTimeZone(loc)
+	}
+
+	// 使用带追踪的驱动，xorm 仍按原驱动解析DSN和选择方言
+	driverName, err := tracing.RegisterSQLDriver(sec.Driver)
+	if err != nil {
+		return
+	}
+	if parent := core.QueryDriver(sec.Driver); parent != nil && core.QueryDriver(driverName) == nil {
+		core.RegisterDriver(driverName, parent)
+	}
+
+	db, err := newEngine(driverName, sec.Source, sec)
 	if err != nil {
 		return
 	}
-	if utc {
-		db.TZLocation = time.UTC
+	if sec.UseCache {
+		db.SetDefaultCacher(xorm.NewLRUCacher(xorm.NewMemoryStore(), sec.CacheSize))
 	}
-	db.SetMaxOpenConns(200)
-	db.SetMaxIdleConns(20)
-	db.ShowSQL(showSql)
 
 	models.InitDB(db)
+	metrics.RegisterDB(db.DB().DB, "mysql")
+
+	if len(sec.Replicas) > 0 {
+		names := make([]string, 0, len(sec.Replicas))
+		engines := make([]*xorm.Engine, 0, len(sec.Replicas))
+		for _, r := range sec.Replicas {
+			// 从库不设置 xorm 缓存：从库查询的结果缓存在主库的 cacher 中，主库写入时失效
+			e, err := newEngine(driverName, r.Source, sec)
+			if err != nil {
+				return fmt.Errorf("db replica %s: %v", r.Name, err)
+			}
+			names = append(names, r.Name)
+			engines = append(engines, e)
+			metrics.RegisterDB(e.DB().DB, "mysql_replica_"+r.Name)
+		}
+		log.Info("[init DB] replicas", "replicas", names, "max_replica_lag", sec.MaxReplicaLag)
+		err = models.InitReplicas(names, engines, time.Duration(sec.MaxReplicaLag)*time.Second,
+			time.Duration(sec.ReplicaCheckInterval)*time.Second)
+		if err != nil {
+			return err
+		}
+	}
 
-	//sync table struct
-	if err := models.SyncTables(); err != nil {
-		return err
+	if autoMigrate {
+		n, err := models.MigrateUp()
+		if err != nil {
+			return err
+		}
+		log.Info("[init DB] migrations applied", "count", n)
 	}
 	return
 }
 
-func initApi(cfg *ini.File) error {
-	sec, err := cfg.GetSection("api")
+// newEngine 创建一个库的 xorm 引擎并按 [db] 设置连接池，主库和从库共用
+func newEngine(driverName string, source string, sec config.DBConfig) (*xorm.Engine, error) {
+	db, err := xorm.NewEngine(driverName, source)
 	if err != nil {
+		return nil, err
+	}
+	// 时间一律以UTC读写，接口返回时再转换到调用方的时区
+	db.TZLocation = time.UTC
+	db.DatabaseTZ = time.UTC
+	db.SetMaxOpenConns(sec.MaxOpenConns)
+	db.SetMaxIdleConns(sec.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(sec.ConnMaxLifetime) * time.Second)
+	db.DB().SetConnMaxIdleTime(time.Duration(sec.ConnMaxIdleTime) * time.Second)
+	db.ShowSQL(sec.ShowSQL)
+	return db, nil
+}
+
+// runMigrate 执行 migrate up|down|status 子命令
+func runMigrate(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s [--config file] migrate up|down|status", os.Args[0])
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := models.MigrateUp()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d migrations applied\n", n)
+	case "down":
+		m, err := models.MigrateDown()
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Println("no migration to roll back")
+		} else {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+	case "status":
+		states, err := models.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.Applied {
+				applied = "applied " + state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", state.Version, state.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up|down|status", args[0])
+	}
+	return nil
+}
+
+// runCache 执行 cache cleanup [--dry-run] 子命令
+func runCache(cfg *config.Config, args []string) error {
+	usage := fmt.Errorf("usage: %s [--config file] cache cleanup [--dry-run]", os.Args[0])
+	if len(args) == 0 || args[0] != "cleanup" {
+		return usage
+	}
+	fs := flag.NewFlagSet("cache cleanup", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only report the keys that would be deleted or expired")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 0 {
+		return usage
+	}
+	if cfg.Cache.Backend != cache.BACKEND_REDIS {
+		return fmt.Errorf("cache cleanup requires the redis backend, got %q", cfg.Cache.Backend)
+	}
+
+	if err := initRedis(cfg); err != nil {
+		return err
+	}
+	defer cache.Close()
+	if err := initDB(cfg, false); err != nil {
 		return err
 	}
 
-	apiAddr = sec.Key("addr").String()
-	debug := sec.Key("debug").MustBool(false)
+	stats, err := maintenance.CleanupCache(context.Background(), *dryRun)
+	for _, s := range stats {
+		fmt.Printf("%s\tscanned %d\tdeleted %d\texpired %d\n", s.Kind, s.Scanned, s.Deleted, s.Expired)
+	}
+	if *dryRun {
+		fmt.Println("dry run, nothing changed")
+	}
+	return err
+}
 
-	if !debug {
+func initApi(cfg *config.Config) error {
+	apiAddr = cfg.API.Addr
+
+	if !cfg.API.Debug {
 		gin.SetMode(gin.ReleaseMode)
 	}
+
+	sec := cfg.Private
+	keys, _ := sec.Keys()
+	log.Info("[init private api]", "addr", sec.Addr, "tls", sec.CertFile != "", "mtls", sec.ClientCAFile != "",
+		"hmac_keys", len(keys))
+	if sec.ClientCAFile == "" && len(keys) == 0 {
+		log.Warn("[init private api] neither private.client_ca_file nor private.hmac_keys is configured, /private/api/v1 rejects all requests")
+	}
+	api.Init(sec.Addr != "")
 	return nil
 }
 
+// privateTLSConfig 内部接口单独监听时的TLS配置，配置了 client_ca_file 时校验客户端证书；
+// 未携带证书的请求仍可以使用HMAC签名认证
+func privateTLSConfig(sec config.PrivateConfig) (*tls.Config, error) {
+	if sec.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(sec.CertFile, sec.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load private server certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if sec.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(sec.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read private client ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", sec.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
 func initApplication() error {
+	cfg := config.Get()
 
-	if err := initRedis(config); err != nil {
-		fmt.Println("initRedis err")
-		return err
+	if err := initLog(cfg); err != nil {
+		return fmt.Errorf("init log: %v", err)
 	}
+	config.OnReload(reloadLogLevel)
 
-	if err := initDB(config); err != nil {
-		fmt.Println("initDB err")
-		return err
+	if err := initTracing(cfg); err != nil {
+		return fmt.Errorf("init tracing: %v", err)
+	}
+
+	if err := initID(cfg); err != nil {
+		return fmt.Errorf("init id: %v", err)
+	}
+
+	if err := initCache(cfg); err != nil {
+		return fmt.Errorf("init cache: %v", err)
+	}
+
+	initLock(cfg)
+
+	if err := initHTTPClient(cfg); err != nil {
+		return fmt.Errorf("init http client: %v", err)
+	}
+
+	if err := initProvision(cfg); err != nil {
+		return fmt.Errorf("init provision: %v", err)
+	}
+
+	if err := initRateLimit(cfg); err != nil {
+		return fmt.Errorf("init ratelimit: %v", err)
+	}
+	config.OnReload(reloadRateLimit)
+
+	if err := initDB(cfg, cfg.DB.AutoMigrate); err != nil {
+		return fmt.Errorf("init db: %v", err)
+	}
+	if err := models.CheckLegacyTimes(); err != nil {
+		return fmt.Errorf("init db: %v", err)
+	}
+
+	initUserCache(cfg)
+	if err := initWebhook(cfg); err != nil {
+		return fmt.Errorf("init webhook: %v", err)
+	}
+	initJobs(cfg)
+	initEvents(cfg)
+
+	if err := initApi(cfg); err != nil {
+		return fmt.Errorf("init api: %v", err)
 	}
 
-	if err := initApi(config); err != nil {
+	initHealth(cfg)
+
+	return nil
+}
+
+// initHealth 注册就绪检查依赖
+func initHealth(cfg *config.Config) {
+	health.SetVersion(Version)
+	health.SetOptions(time.Duration(cfg.Health.Timeout)*time.Second, time.Duration(cfg.Health.CacheTTL)*time.Second)
+
+	health.Register("mysql", func(ctx context.Context) error {
+		return models.DB().PingContext(ctx)
+	})
+	health.Register("cache", func(ctx context.Context) error {
+		return cache.PingCtx(ctx)
+	})
+	health.Register("migrations", func(ctx context.Context) error {
+		pending, err := models.PendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d migrations pending", pending)
+		}
+		return nil
+	})
+}
 
+// initLifecycle 注册各子系统的停止函数，停止顺序与注册顺序相反：
+// 就绪检查 -> http服务 -> 后台任务 -> 事件总线 -> 任务队列 -> webhook -> 开通钩子 -> 锁 -> redis -> mysql -> tracing
+func initLifecycle(cfg *config.Config) error {
+	lifecycle.Append(lifecycle.Hook{
+		Name: "tracing",
+		Stop: tracing.Shutdown,
+	})
+
+	lifecycle.Append(lifecycle.Hook{
+		Name:  "mysql",
+		Start: models.StartReplicaCheck,
+		Stop: func(ctx context.Context) error {
+			if err := models.StopReplicaCheck(ctx); err != nil {
+				return err
+			}
+			return models.CloseDB()
+		},
+	})
+
+	lifecycle.Append(lifecycle.Hook{
+		Name: "cache",
+		Stop: func(ctx context.Context) error {
+			cache.Close()
+			return nil
+		},
+	})
+
+	lifecycle.Append(lifecycle.Hook{
+		Name: "lock",
+		Stop: lock.Close,
+	})
+
+	// 在redis之前停止，队列中剩余的钩子仍可以缓存下游返回的token
+	lifecycle.Append(lifecycle.Hook{
+		Name:  "provision",
+		Start: provision.Start,
+		Stop:  provision.Stop,
+	})
+
+	lifecycle.Append(lifecycle.Hook{
+		Name:  "webhook",
+		Start: webhook.Start,
+		Stop:  webhook.Stop,
+	})
+
+	lifecycle.Append(lifecycle.Hook{
+		Name:  "jobs",
+		Start: jobs.Start,
+		Stop:  jobs.Stop,
+	})
+
+	// 在开通钩子和webhook之前停止，异步处理函数仍可以把事件交给它们
+	lifecycle.Append(lifecycle.Hook{
+		Name:  "events",
+		Start: events.Start,
+		Stop:  events.Stop,
+	})
+
+	stopWatch := make(chan struct{})
+	lifecycle.Append(lifecycle.Hook{
+		Name: "config watcher",
+		Start: func() error {
+			config.Watch(configFile, stopWatch)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			close(stopWatch)
+			return nil
+		},
+	})
+
+	lifecycle.AppendServer("api", &http.Server{
+		Addr:         apiAddr,
+		Handler:      api.Engine(),
+		ReadTimeout:  time.Duration(cfg.API.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.API.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(cfg.API.IdleTimeout) * time.Second,
+	})
+
+	if cfg.Private.Addr != "" {
+		tlsConfig, err := privateTLSConfig(cfg.Private)
+		if err != nil {
+			return err
+		}
+		lifecycle.AppendServer("private api", &http.Server{
+			Addr:         cfg.Private.Addr,
+			Handler:      api.PrivateEngine(),
+			TLSConfig:    tlsConfig,
+			ReadTimeout:  time.Duration(cfg.API.ReadTimeout) * time.Second,
+			WriteTimeout: time.Duration(cfg.API.WriteTimeout) * time.Second,
+			IdleTimeout:  time.Duration(cfg.API.IdleTimeout) * time.Second,
+		})
 	}
 
+	// 最后注册、最先停止：停止http服务之前 /readyz 先返回 down
+	lifecycle.Append(lifecycle.Hook{
+		Name: "readiness",
+		Stop: func(ctx context.Context) error {
+			health.SetShuttingDown()
+			return nil
+		},
+	})
 	return nil
 }
 
-func run() {
-	log.Infof("http run %s\n", apiAddr)
-	err := api.Engine().Run(apiAddr)
-	log.Error(err)
+func run() error {
+	cfg := config.Get()
+	if err := initLifecycle(cfg); err != nil {
+		return err
+	}
+
+	log.Info("http run", "addr", apiAddr)
+	return lifecycle.Run(time.Duration(cfg.API.ShutdownTimeout) * time.Second)
 }
 
 func main() {
-	log.Infof("Start ...\n")
+	flag.StringVar(&configFile, "config", config.DEFAULT_CONFIG_FILE, "config file, .ini or .yaml")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [--config file] [migrate up|down|status | cache cleanup [--dry-run]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	log.Info("Start ...", "version", Version)
 	if err := initConfig(); err != nil {
 		fmt.Println(err.Error())
-		panic(err)
+		os.Exit(1)
 	}
 
-	if err := initApplication(); err != nil {
-		log.Fatal(err)
+	if flag.NArg() > 0 && flag.Arg(0) == "migrate" {
+		if err := initDB(config.Get(), false); err != nil {
+			log.Fatal("init db failed", "error", err)
+		}
+		if err := runMigrate(flag.Args()[1:]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	run()
+	if flag.NArg() > 0 && flag.Arg(0) == "cache" {
+		if err := runCache(config.Get(), flag.Args()[1:]); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 
+	if err := initApplication(); err != nil {
+		log.Fatal("init failed", "error", err)
+	}
+
+	if err := run(); err != nil {
+		log.Fatal("stopped with error", "error", err)
+	}
+	log.Info("Stopped")
+	log.Close()
 }
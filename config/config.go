@@ -0,0 +1,530 @@
+// Package config 服务配置，支持 ini / yaml 文件、环境变量覆盖以及 SIGHUP 热加载
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DEFAULT_CONFIG_FILE = "/opt/saisai/profile.ini"
+
+	// ENV_PREFIX 环境变量覆盖配置项，格式：USERSYSTEM_<SECTION>_<KEY>，如 USERSYSTEM_DB_SOURCE
+	ENV_PREFIX = "USERSYSTEM"
+)
+
+// Config 服务配置，每个字段对应配置文件中的一个section
+type Config struct {
+	DB     DBConfig     `ini:"db" yaml:"db"`
+	API    APIConfig    `ini:"api" yaml:"api"`
+	Cache  CacheConfig  `ini:"cache" yaml:"cache"`
+	Redis  RedisConfig  `ini:"redis" yaml:"redis"`
+	Log    LogConfig    `ini:"log" yaml:"log"`
+	Login  LoginConfig  `ini:"login" yaml:"login"`
+	Token  TokenConfig  `ini:"token" yaml:"token"`
+	Health HealthConfig `ini:"health" yaml:"health"`
+	Trace  TraceConfig  `ini:"trace" yaml:"trace"`
+
+	RateLimit  RateLimitConfig  `ini:"ratelimit" yaml:"ratelimit"`
+	HTTPClient HTTPClientConfig `ini:"http_client" yaml:"http_client"`
+	Provision  ProvisionConfig  `ini:"provision" yaml:"provision"`
+	Private    PrivateConfig    `ini:"private" yaml:"private"`
+	Webhook    WebhookConfig    `ini:"webhook" yaml:"webhook"`
+	Events     EventsConfig     `ini:"events" yaml:"events"`
+	Lock       LockConfig       `ini:"lock" yaml:"lock"`
+	Jobs       JobsConfig       `ini:"jobs" yaml:"jobs"`
+	UserCache  UserCacheConfig  `ini:"user_cache" yaml:"user_cache"`
+	ID         IDConfig         `ini:"id" yaml:"id"`
+}
+
+type DBConfig struct {
+	Driver      string `ini:"driver" yaml:"driver"`             // 默认 mysql
+	Source      string `ini:"source" yaml:"source"`             // 必填，DSN
+	ShowSQL     bool   `ini:"show_sql" yaml:"show_sql"`         // 默认 false
+	UTC         bool   `ini:"utc" yaml:"utc"`                   // 默认 false，已废弃，时间一律以UTC读写，只表示旧版本写入的数据是否为UTC
+	UseCache    bool   `ini:"use_cache" yaml:"use_cache"`       // 默认 false，启用xorm的LRU缓存
+	CacheSize   int    `ini:"cache_size" yaml:"cache_size"`     // 默认 1000，LRU缓存的记录数
+	AutoMigrate bool   `ini:"auto_migrate" yaml:"auto_migrate"` // 默认 true，启动时执行数据库迁移
+
+	LegacyTimeZone string `ini:"legacy_time_zone" yaml:"legacy_time_zone"` // utc=false 时旧数据的时区，迁移 0007 据此转换为UTC
+
+	MaxOpenConns    int `ini:"max_open_conns" yaml:"max_open_conns"`         // 默认 200，主库和每个从库各自的最大连接数
+	MaxIdleConns    int `ini:"max_idle_conns" yaml:"max_idle_conns"`         // 默认 20，不能大于 max_open_conns
+	ConnMaxLifetime int `ini:"conn_max_lifetime" yaml:"conn_max_lifetime"`   // 默认 300秒，连接的最长使用时间，应小于MySQL的 wait_timeout，0 不限制
+	ConnMaxIdleTime int `ini:"conn_max_idle_time" yaml:"conn_max_idle_time"` // 默认 0，空闲连接的关闭时间，不限制
+
+	MaxReplicaLag        int         `ini:"max_replica_lag" yaml:"max_replica_lag"`               // 默认 5秒，复制延迟超过后不再读该从库，0 只检查连通性
+	ReplicaCheckInterval int         `ini:"replica_check_interval" yaml:"replica_check_interval"` // 默认 5秒，检查从库的间隔
+	Replicas             []DBReplica `ini:"-" yaml:"replicas"`
+}
+
+// DBReplica 只读从库，ini 文件中每个从库是一个 [db.replica.<name>] section，yaml 中为 replicas 列表
+type DBReplica struct {
+	Name   string `ini:"-" yaml:"name"`        // 必填，ini 中取 section 名 db.replica.<name> 的后半部分
+	Source string `ini:"source" yaml:"source"` // 必填，DSN，账号需要 REPLICATION CLIENT 权限以查询复制延迟
+}
+
+type APIConfig struct {
+	Addr            string `ini:"addr" yaml:"addr"`                         // 默认 0.0.0.0:9007
+	ShowReq         bool   `ini:"show_req" yaml:"show_req"`                 // 默认 false，日志中打印请求body，可热加载
+	ShowRsp         bool   `ini:"show_rsp" yaml:"show_rsp"`                 // 默认 false，日志中打印响应body，可热加载
+	Debug           bool   `ini:"debug" yaml:"debug"`                       // 默认 false，gin debug模式
+	ReadTimeout     int    `ini:"read_timeout" yaml:"read_timeout"`         // 默认 10秒，读取整个请求的超时
+	WriteTimeout    int    `ini:"write_timeout" yaml:"write_timeout"`       // 默认 10秒，写响应的超时
+	IdleTimeout     int    `ini:"idle_timeout" yaml:"idle_timeout"`         // 默认 60秒，keep-alive 空闲连接的超时
+	ShutdownTimeout int    `ini:"shutdown_timeout" yaml:"shutdown_timeout"` // 默认 30秒，停止服务时等待处理中请求的时间
+}
+
+type CacheConfig struct {
+	Backend         string `ini:"backend" yaml:"backend"`                   // 默认 redis，可选 redis | memory
+	CleanupInterval int    `ini:"cleanup_interval" yaml:"cleanup_interval"` // 默认 60秒，memory后端清理过期key的间隔
+}
+
+type RedisConfig struct {
+	Mode             string `ini:"mode" yaml:"mode"`                           // 默认 standalone，可选 standalone | sentinel | cluster
+	URL              string `ini:"url" yaml:"url"`                             // mode=standalone 时必填，redis:// 或 rediss://（TLS）地址
+	Addrs            string `ini:"addrs" yaml:"addrs"`                         // mode=sentinel 为各sentinel的 host:port，mode=cluster 为部分节点的 host:port，用 , 分隔
+	MasterName       string `ini:"master_name" yaml:"master_name"`             // mode=sentinel 时必填
+	SentinelPassword string `ini:"sentinel_password" yaml:"sentinel_password"` // 默认为空，连接sentinel的密码
+	Username         string `ini:"username" yaml:"username"`                   // 默认为空，redis 6 的ACL用户名
+	Password         string `ini:"password" yaml:"password"`                   // 默认为空，设置后覆盖 url 中的密码
+	KeyPrefix        string `ini:"key_prefix" yaml:"key_prefix"`               // 默认为空，所有key的前缀，多个服务共用一个redis时用它区分，代替DB编号
+	TLS              bool   `ini:"tls" yaml:"tls"`                             // 默认 false，url 为 rediss:// 时也使用TLS
+	TLSCAFile        string `ini:"tls_ca_file" yaml:"tls_ca_file"`             // 默认为空，使用系统根证书
+	TLSCertFile      string `ini:"tls_cert_file" yaml:"tls_cert_file"`         // 默认为空，需要客户端证书时与 tls_key_file 一起设置
+	TLSKeyFile       string `ini:"tls_key_file" yaml:"tls_key_file"`           // 默认为空
+	TLSSkipVerify    bool   `ini:"tls_skip_verify" yaml:"tls_skip_verify"`     // 默认 false，不校验服务端证书，仅用于测试
+	MaxIdle          int    `ini:"max_idle" yaml:"max_idle"`                   // 默认 20，每个节点的最大空闲连接数
+	MaxActive        int    `ini:"max_active" yaml:"max_active"`               // 默认 0，每个节点的最大连接数，0 表示不限制
+	Wait             bool   `ini:"wait" yaml:"wait"`                           // 默认 true，连接数达到 max_active 时等待，否则立即返回错误
+	IdleTimeout      int    `ini:"idle_timeout" yaml:"idle_timeout"`           // 默认 20秒，空闲连接的关闭时间
+	ConnectTimeout   int    `ini:"connect_timeout" yaml:"connect_timeout"`     // 默认 5秒
+	ReadTimeout      int    `ini:"read_timeout" yaml:"read_timeout"`           // 默认 0，不超时，设置时至少 2秒（后台任务阻塞读取 1秒）
+	WriteTimeout     int    `ini:"write_timeout" yaml:"write_timeout"`         // 默认 0，不超时
+}
+
+// AddrList 返回 sentinel 或 cluster 节点地址列表
+func (r RedisConfig) AddrList() []string {
+	addrs := make([]string, 0)
+	for _, a := range strings.Split(r.Addrs, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+type LogConfig struct {
+	Level      string `ini:"level" yaml:"level"`             // 默认 info，可选 debug|info|warn|error，可热加载
+	Format     string `ini:"format" yaml:"format"`           // 默认 json，可选 json|text
+	Output     string `ini:"output" yaml:"output"`           // 默认 stdout，可选 stdout|file
+	File       string `ini:"file" yaml:"file"`               // output=file 时必填
+	MaxSize    int    `ini:"max_size" yaml:"max_size"`       // 默认 100MB，超过后滚动
+	MaxBackups int    `ini:"max_backups" yaml:"max_backups"` // 默认 10，保留的历史文件数
+	MaxAge     int    `ini:"max_age" yaml:"max_age"`         // 默认 30天，历史文件的保留时间
+}
+
+type LoginConfig struct {
+	MaxErrors   int `ini:"max_errors" yaml:"max_errors"`     // 默认 10，错误次数超过后拒绝登录，可热加载
+	ErrorWindow int `ini:"error_window" yaml:"error_window"` // 默认 300秒，错误计数的有效期，可热加载
+}
+
+type TokenConfig struct {
+	TTL        int `ini:"ttl" yaml:"ttl"`                 // 默认 300秒，登录后token的有效期，可热加载
+	RefreshTTL int `ini:"refresh_ttl" yaml:"refresh_ttl"` // 默认 60秒，每次使用token后重置的有效期，可热加载
+}
+
+type HealthConfig struct {
+	Timeout  int `ini:"timeout" yaml:"timeout"`     // 默认 2秒，单个依赖检查的超时
+	CacheTTL int `ini:"cache_ttl" yaml:"cache_ttl"` // 默认 5秒，就绪检查结果的缓存时间
+}
+
+type TraceConfig struct {
+	Exporter    string  `ini:"exporter" yaml:"exporter"`         // 默认 none，可选 none|stdout|otlp
+	Endpoint    string  `ini:"endpoint" yaml:"endpoint"`         // 默认 localhost:4318，OTLP/HTTP collector 地址
+	Insecure    bool    `ini:"insecure" yaml:"insecure"`         // 默认 false，使用 http 而不是 https 连接 collector
+	ServiceName string  `ini:"service_name" yaml:"service_name"` // 默认 usersystem
+	SampleRatio float64 `ini:"sample_ratio" yaml:"sample_ratio"` // 默认 1，无上游span时的采样比例，0~1
+}
+
+// RateLimitConfig 各路由分组的限流规则，可热加载。规则格式为 <algorithm>:<limit>/<period>:<key>[+<key>]，
+// algorithm 为 token_bucket|sliding_window，key 为 ip|user|identifier，多条规则用 , 分隔，为空表示不限流
+type RateLimitConfig struct {
+	Enabled  bool   `ini:"enabled" yaml:"enabled"`   // 默认 true
+	Register string `ini:"register" yaml:"register"` // 默认 sliding_window:10/1h:ip
+	Login    string `ini:"login" yaml:"login"`       // 默认 token_bucket:30/1m:ip,sliding_window:10/10m:identifier
+	API      string `ini:"api" yaml:"api"`           // 默认 token_bucket:300/1m:ip，作用于 /usersystem/api/v1 下的所有接口
+	Private  string `ini:"private" yaml:"private"`   // 默认为空，/private/api/v1 下的接口
+}
+
+// HTTPClientConfig 调用外部服务（如 Cydex manager）的http客户端
+type HTTPClientConfig struct {
+	Timeout            int    `ini:"timeout" yaml:"timeout"`                           // 默认 5秒，单次请求的超时
+	CAFile             string `ini:"ca_file" yaml:"ca_file"`                           // 默认为空，额外信任的CA证书（PEM）
+	CertFile           string `ini:"cert_file" yaml:"cert_file"`                       // 默认为空，mTLS 客户端证书，需同时配置 key_file
+	KeyFile            string `ini:"key_file" yaml:"key_file"`                         // 默认为空，mTLS 客户端私钥
+	InsecureSkipVerify bool   `ini:"insecure_skip_verify" yaml:"insecure_skip_verify"` // 默认 false，跳过服务端证书校验，仅用于测试环境
+	MaxRetries         int    `ini:"max_retries" yaml:"max_retries"`                   // 默认 2，幂等请求失败后的重试次数
+	RetryBackoff       int    `ini:"retry_backoff" yaml:"retry_backoff"`               // 默认 100毫秒，第一次重试前的等待时间，之后翻倍
+	RetryMaxBackoff    int    `ini:"retry_max_backoff" yaml:"retry_max_backoff"`       // 默认 2000毫秒，重试等待时间的上限
+	BreakerFailures    int    `ini:"breaker_failures" yaml:"breaker_failures"`         // 默认 5，同一主机连续失败后熔断，0 表示不熔断
+	BreakerCooldown    int    `ini:"breaker_cooldown" yaml:"breaker_cooldown"`         // 默认 30秒，熔断后多久放行探测请求
+}
+
+// PrivateConfig 供其他后端服务调用的内部接口 /private/api/v1，请求需通过 mTLS 客户端证书或 HMAC 签名认证，
+// 两者都未配置时拒绝所有内部接口请求
+type PrivateConfig struct {
+	Addr         string `ini:"addr" yaml:"addr"`                     // 默认为空，与 api.addr 共用端口；配置后内部接口只在该地址提供
+	CertFile     string `ini:"cert_file" yaml:"cert_file"`           // 默认为空，配置 addr 时启用TLS的服务端证书，需同时配置 key_file
+	KeyFile      string `ini:"key_file" yaml:"key_file"`             // 默认为空，服务端私钥
+	ClientCAFile string `ini:"client_ca_file" yaml:"client_ca_file"` // 默认为空，校验客户端证书（mTLS）的CA，需启用TLS
+	ClientNames  string `ini:"client_names" yaml:"client_names"`     // 默认为空，允许的客户端证书CN，用 , 分隔，为空表示CA签发的证书都允许，可热加载
+	HMACKeys     string `ini:"hmac_keys" yaml:"hmac_keys"`           // 默认为空，HMAC签名的密钥，格式 key_id=secret,key_id2=secret2，可热加载
+	MaxClockSkew int    `ini:"max_clock_skew" yaml:"max_clock_skew"` // 默认 300秒，签名时间戳与服务器时间允许的偏差
+	MaxBatch     int    `ini:"max_batch" yaml:"max_batch"`           // 默认 100，批量接口单次最多处理的条数
+	MaxBodySize  int    `ini:"max_body_size" yaml:"max_body_size"`   // 默认 1048576字节，HMAC签名校验时读取的请求body上限
+}
+
+// Keys 解析HMAC签名的密钥
+func (p *PrivateConfig) Keys() (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, item := range strings.Split(p.HMACKeys, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("hmac key %q must be key_id=secret", strings.TrimSpace(kv[0]))
+		}
+		id := strings.TrimSpace(kv[0])
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("duplicate hmac key id %q", id)
+		}
+		keys[id] = strings.TrimSpace(kv[1])
+	}
+	return keys, nil
+}
+
+// ClientNameList 返回允许的客户端证书CN
+func (p *PrivateConfig) ClientNameList() []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(p.ClientNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// WebhookConfig 用户生命周期事件的出站webhook，订阅通过内部接口管理，修改后需要重启
+type WebhookConfig struct {
+	Enabled         bool `ini:"enabled" yaml:"enabled"`                     // 默认 true
+	Workers         int  `ini:"workers" yaml:"workers"`                     // 默认 4，并发投递的goroutine数
+	PollInterval    int  `ini:"poll_interval" yaml:"poll_interval"`         // 默认 2秒，查询到期投递记录的间隔
+	BatchSize       int  `ini:"batch_size" yaml:"batch_size"`               // 默认 100，每次最多领取的投递记录数
+	MaxAttempts     int  `ini:"max_attempts" yaml:"max_attempts"`           // 默认 8，包括第一次投递在内的最大尝试次数
+	RetryBackoff    int  `ini:"retry_backoff" yaml:"retry_backoff"`         // 默认 10秒，第一次重试前的等待时间，之后翻倍
+	RetryMaxBackoff int  `ini:"retry_max_backoff" yaml:"retry_max_backoff"` // 默认 3600秒，重试等待时间的上限
+	Timeout         int  `ini:"timeout" yaml:"timeout"`                     // 默认 10秒，单次投递的超时
+	RetentionDays   int  `ini:"retention_days" yaml:"retention_days"`       // 默认 30天，已结束的投递记录的保留时间，0 表示不清理
+
+	// 默认为空，允许订阅和投递的内网地址段（CIDR），用 , 分隔。回环、链路本地、私有等地址默认不允许
+	AllowNets string `ini:"allow_nets" yaml:"allow_nets"`
+}
+
+// EventsConfig 进程内领域事件总线，transport 为 redis 时事件通过redis pub/sub广播给其他实例，修改后需要重启
+type EventsConfig struct {
+	Workers   int    `ini:"workers" yaml:"workers"`       // 默认 4，执行异步处理函数的goroutine数
+	QueueSize int    `ini:"queue_size" yaml:"queue_size"` // 默认 1000，队列满时丢弃异步处理的事件
+	Transport string `ini:"transport" yaml:"transport"`   // 默认 none，none | redis，redis 需要 cache.backend 为 redis
+	Channel   string `ini:"channel" yaml:"channel"`       // 默认 usersystem:events，redis pub/sub 频道
+}
+
+// LockConfig 分布式锁，默认使用缓存的redis，缓存为进程内后端时锁只在单个实例内互斥，修改后需要重启
+type LockConfig struct {
+	RedlockURLs    string `ini:"redlock_urls" yaml:"redlock_urls"`       // 默认为空，多个独立redis节点的 redis:// 地址，用 , 分隔，设置后使用 Redlock
+	RedlockTimeout int    `ini:"redlock_timeout" yaml:"redlock_timeout"` // 默认 200毫秒，Redlock 单个节点的连接和读写超时
+}
+
+// RedlockURLList 返回 Redlock 节点地址列表
+func (l LockConfig) RedlockURLList() []string {
+	urls := make([]string, 0)
+	for _, u := range strings.Split(l.RedlockURLs, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// JobsConfig 基于redis的后台任务队列，cache.backend 为 memory 时不启用，修改后需要重启
+type JobsConfig struct {
+	Enabled           bool   `ini:"enabled" yaml:"enabled"`                         // 默认 true
+	Queues            string `ini:"queues" yaml:"queues"`                           // 默认 default:4，队列名:worker数，用 , 分隔
+	VisibilityTimeout int    `ini:"visibility_timeout" yaml:"visibility_timeout"`   // 默认 300秒，任务执行超过这个时间视为失败并重新排队
+	MaxAttempts       int    `ini:"max_attempts" yaml:"max_attempts"`               // 默认 5，包括第一次在内的最大执行次数
+	RetryBackoff      int    `ini:"retry_backoff" yaml:"retry_backoff"`             // 默认 10秒，第一次重试前的等待时间，之后翻倍
+	RetryMaxBackoff   int    `ini:"retry_max_backoff" yaml:"retry_max_backoff"`     // 默认 3600秒，重试等待时间的上限
+	PollInterval      int    `ini:"poll_interval" yaml:"poll_interval"`             // 默认 1秒，检查到期的延迟任务和超时任务的间隔
+	DeadRetentionDays int    `ini:"dead_retention_days" yaml:"dead_retention_days"` // 默认 7天，死信队列中任务的保留时间，0 表示一直保留
+}
+
+// QueueMap 解析 queues，返回队列名到worker数的映射
+func (j *JobsConfig) QueueMap() (map[string]int, error) {
+	queues := make(map[string]int)
+	for _, item := range strings.Split(j.Queues, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" || strings.ContainsAny(name, "{}") {
+			return nil, fmt.Errorf("queue %q must be name:workers", item)
+		}
+		workers, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || workers <= 0 {
+			return nil, fmt.Errorf("queue %q: workers must be a positive integer", name)
+		}
+		if _, ok := queues[name]; ok {
+			return nil, fmt.Errorf("duplicate queue %q", name)
+		}
+		queues[name] = workers
+	}
+	return queues, nil
+}
+
+// UserCacheConfig /info 和 /authentication 使用的进程内用户信息缓存，修改后需要重启
+type UserCacheConfig struct {
+	Enabled     bool `ini:"enabled" yaml:"enabled"`           // 默认 true
+	TTL         int  `ini:"ttl" yaml:"ttl"`                   // 默认 60秒，用户信息的缓存时间，也是没有广播时其他实例修改后最长的不一致时间
+	NegativeTTL int  `ini:"negative_ttl" yaml:"negative_ttl"` // 默认 10秒，不存在的用户ID的缓存时间，0 表示不缓存
+	MaxEntries  int  `ini:"max_entries" yaml:"max_entries"`   // 默认 10000，超过后淘汰已过期的和任意的缓存项
+}
+
+// IDConfig 用户、账号、会话和事件ID的生成方式，修改后需要重启
+type IDConfig struct {
+	Generator   string `ini:"generator" yaml:"generator"`         // 默认 objectid，可选 objectid|ulid|snowflake
+	WorkerId    int    `ini:"worker_id" yaml:"worker_id"`         // 默认 -1，snowflake 的 worker ID（0-1023），-1 由机器码计算；同一台机器上的多个实例必须分别指定
+	PublicIdKey string `ini:"public_id_key" yaml:"public_id_key"` // 默认 空，公开接口中用户ID的加密密钥，为空时直接返回内部ID；修改后已发放的token全部失效
+}
+
+// ProvisionConfig 注册/登录后异步调用的下游开通钩子，修改后需要重启。
+// ini 文件中每个钩子是一个 [provision.<name>] section，yaml 中为 targets 列表
+type ProvisionConfig struct {
+	Enabled      bool              `ini:"enabled" yaml:"enabled"`             // 默认 true
+	Workers      int               `ini:"workers" yaml:"workers"`             // 默认 4，并发执行钩子的goroutine数
+	QueueSize    int               `ini:"queue_size" yaml:"queue_size"`       // 默认 1000，队列满时新的钩子直接记为失败
+	MaxRetries   int               `ini:"max_retries" yaml:"max_retries"`     // 默认 3，失败后的重试次数
+	RetryBackoff int               `ini:"retry_backoff" yaml:"retry_backoff"` // 默认 500毫秒，第一次重试前的等待时间，之后翻倍
+	Targets      []ProvisionTarget `ini:"-" yaml:"targets"`
+}
+
+// ProvisionTarget 一个下游服务
+type ProvisionTarget struct {
+	Name     string `ini:"-" yaml:"name"`              // 必填，ini 中取 section 名 provision.<name> 的后半部分
+	URL      string `ini:"url" yaml:"url"`             // 必填，http(s) 地址，以 POST 发送 JSON
+	Events   string `ini:"events" yaml:"events"`       // 默认 login，可选 register,login，用 , 分隔
+	Timeout  int    `ini:"timeout" yaml:"timeout"`     // 默认 5秒，单次调用的超时
+	CacheKey string `ini:"cache_key" yaml:"cache_key"` // 默认为空，下游返回的token缓存在 <user_id><cache_key> 下，如 _cydex_auth
+	CacheTTL int    `ini:"cache_ttl" yaml:"cache_ttl"` // 默认 300秒，缓存token的有效期
+	Headers  string `ini:"headers" yaml:"headers"`     // 默认为空，附加的请求头，格式 k=v,k2=v2
+}
+
+// EventList 返回钩子订阅的事件
+func (t *ProvisionTarget) EventList() []string {
+	events := make([]string, 0)
+	for _, e := range strings.Split(t.Events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Header 解析附加的请求头
+func (t *ProvisionTarget) Header() (http.Header, error) {
+	header := http.Header{}
+	for _, item := range strings.Split(t.Headers, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("header %q must be key=value", item)
+		}
+		header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return header, nil
+}
+
+// fillDefaults 为没有配置的钩子字段填上默认值
+func (t *ProvisionTarget) fillDefaults() {
+	if t.Events == "" {
+		t.Events = "login"
+	}
+	if t.Timeout == 0 {
+		t.Timeout = 5
+	}
+	if t.CacheTTL == 0 {
+		t.CacheTTL = 300
+	}
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		DB: DBConfig{
+			Driver:      "mysql",
+			CacheSize:   1000,
+			AutoMigrate: true,
+
+			MaxOpenConns:    200,
+			MaxIdleConns:    20,
+			ConnMaxLifetime: 300,
+
+			MaxReplicaLag:        5,
+			ReplicaCheckInterval: 5,
+		},
+		API: APIConfig{
+			Addr:            "0.0.0.0:9007",
+			ReadTimeout:     10,
+			WriteTimeout:    10,
+			IdleTimeout:     60,
+			ShutdownTimeout: 30,
+		},
+		Cache: CacheConfig{
+			Backend:         "redis",
+			CleanupInterval: 60,
+		},
+		Redis: RedisConfig{
+			Mode:           "standalone",
+			MaxIdle:        20,
+			Wait:           true,
+			IdleTimeout:    20,
+			ConnectTimeout: 5,
+		},
+		Log: LogConfig{
+			Level:      "info",
+			Format:     "json",
+			Output:     "stdout",
+			MaxSize:    100,
+			MaxBackups: 10,
+			MaxAge:     30,
+		},
+		Login: LoginConfig{
+			MaxErrors:   10,
+			ErrorWindow: 300,
+		},
+		Token: TokenConfig{
+			TTL:        300,
+			RefreshTTL: 60,
+		},
+		Health: HealthConfig{
+			Timeout:  2,
+			CacheTTL: 5,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:  true,
+			Register: "sliding_window:10/1h:ip",
+			Login:    "token_bucket:30/1m:ip,sliding_window:10/10m:identifier",
+			API:      "token_bucket:300/1m:ip",
+		},
+		HTTPClient: HTTPClientConfig{
+			Timeout:         5,
+			MaxRetries:      2,
+			RetryBackoff:    100,
+			RetryMaxBackoff: 2000,
+			BreakerFailures: 5,
+			BreakerCooldown: 30,
+		},
+		Provision: ProvisionConfig{
+			Enabled:      true,
+			Workers:      4,
+			QueueSize:    1000,
+			MaxRetries:   3,
+			RetryBackoff: 500,
+		},
+		Private: PrivateConfig{
+			MaxClockSkew: 300,
+			MaxBatch:     100,
+			MaxBodySize:  1 << 20,
+		},
+		Webhook: WebhookConfig{
+			Enabled:         true,
+			Workers:         4,
+			PollInterval:    2,
+			BatchSize:       100,
+			MaxAttempts:     8,
+			RetryBackoff:    10,
+			RetryMaxBackoff: 3600,
+			Timeout:         10,
+			RetentionDays:   30,
+		},
+		Events: EventsConfig{
+			Workers:   4,
+			QueueSize: 1000,
+			Transport: "none",
+			Channel:   "usersystem:events",
+		},
+		Lock: LockConfig{
+			RedlockTimeout: 200,
+		},
+		Jobs: JobsConfig{
+			Enabled:           true,
+			Queues:            "default:4",
+			VisibilityTimeout: 300,
+			MaxAttempts:       5,
+			RetryBackoff:      10,
+			RetryMaxBackoff:   3600,
+			PollInterval:      1,
+			DeadRetentionDays: 7,
+		},
+		UserCache: UserCacheConfig{
+			Enabled:     true,
+			TTL:         60,
+			NegativeTTL: 10,
+			MaxEntries:  10000,
+		},
+		ID: IDConfig{
+			Generator: "objectid",
+			WorkerId:  -1,
+		},
+		Trace: TraceConfig{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			ServiceName: "usersystem",
+			SampleRatio: 1,
+		},
+	}
+}
+
+var (
+	current   atomic.Value
+	listeners []func(*Config)
+	lock      sync.Mutex
+)
+
+func init() {
+	current.Store(Default())
+}
+
+// Get 返回当前配置，返回值只读
+func Get() *Config {
+	return current.Load().(*Config)
+}
+
+// Set 替换当前配置
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// OnReload 注册热加载回调，配置热加载成功后按注册顺序调用
+func OnReload(fn func(*Config)) {
+	lock.Lock()
+	defer lock.Unlock()
+	listeners = append(listeners, fn)
+}
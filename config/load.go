@@ -0,0 +1,328 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/saisai/gindemo/utils/log"
+	"github.com/saisai/gindemo/utils/timeutil"
+
+	"github.com/go-ini/ini"
+	"gopkg.in/yaml.v2"
+)
+
+// Load 读取配置文件（.yaml/.yml 按yaml解析，其余按ini解析），在默认配置之上
+// 依次应用配置文件和环境变量，并校验结果
+func Load(path string) (*Config, error) {
+	c := Default()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(content, c); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+	default:
+		file, err := ini.Load(path)
+		if err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+		if err := file.MapTo(c); err != nil {
+			return nil, fmt.Errorf("config %s: %v", path, err)
+		}
+		for _, sec := range file.Section("db.replica").ChildSections() {
+			replica := DBReplica{Name: strings.TrimPrefix(sec.Name(), "db.replica.")}
+			if err := sec.MapTo(&replica); err != nil {
+				return nil, fmt.Errorf("config %s: [%s]: %v", path, sec.Name(), err)
+			}
+			c.DB.Replicas = append(c.DB.Replicas, replica)
+		}
+		for _, sec := range file.Section("provision").ChildSections() {
+			target := ProvisionTarget{Name: strings.TrimPrefix(sec.Name(), "provision.")}
+			if err := sec.MapTo(&target); err != nil {
+				return nil, fmt.Errorf("config %s: [%s]: %v", path, sec.Name(), err)
+			}
+			c.Provision.Targets = append(c.Provision.Targets, target)
+		}
+	}
+	for i := range c.Provision.Targets {
+		c.Provision.Targets[i].fillDefaults()
+	}
+
+	if err := applyEnv(c); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// applyEnv 用 USERSYSTEM_<SECTION>_<KEY> 环境变量覆盖配置项
+func applyEnv(c *Config) error {
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Type().Field(i).Tag.Get("ini")
+		fields := sections.Field(i)
+		for j := 0; j < fields.NumField(); j++ {
+			key := fields.Type().Field(j).Tag.Get("ini")
+			if key == "-" {
+				continue
+			}
+			name := strings.ToUpper(ENV_PREFIX + "_" + section + "_" + key)
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if err := setField(fields.Field(j), value); err != nil {
+				return fmt.Errorf("env %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate 校验配置，一次返回所有不合法的配置项
+func (c *Config) Validate() error {
+	errs := make([]string, 0)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.DB.Driver != "", "db.driver is required")
+	check(c.DB.Source != "", "db.source is required")
+	check(c.DB.CacheSize > 0, "db.cache_size must be positive, got %d", c.DB.CacheSize)
+	check(c.DB.MaxOpenConns > 0, "db.max_open_conns must be positive, got %d", c.DB.MaxOpenConns)
+	check(c.DB.MaxIdleConns >= 0 && c.DB.MaxIdleConns <= c.DB.MaxOpenConns,
+		"db.max_idle_conns must be between 0 and max_open_conns, got %d", c.DB.MaxIdleConns)
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime must not be negative, got %d", c.DB.ConnMaxLifetime)
+	check(c.DB.ConnMaxIdleTime >= 0, "db.conn_max_idle_time must not be negative, got %d", c.DB.ConnMaxIdleTime)
+	check(c.DB.MaxReplicaLag >= 0, "db.max_replica_lag must not be negative, got %d", c.DB.MaxReplicaLag)
+	check(c.DB.ReplicaCheckInterval > 0, "db.replica_check_interval must be positive, got %d", c.DB.ReplicaCheckInterval)
+	if c.DB.LegacyTimeZone != "" {
+		_, err := timeutil.ParseZone(c.DB.LegacyTimeZone)
+		check(err == nil, "db.legacy_time_zone is not a valid time zone: %q", c.DB.LegacyTimeZone)
+		check(!c.DB.UTC, "db.legacy_time_zone requires db.utc=false")
+	}
+	replicaNames := make(map[string]bool)
+	for _, r := range c.DB.Replicas {
+		check(r.Name != "", "db replica name is required")
+		check(!replicaNames[r.Name], "duplicate db replica %q", r.Name)
+		check(r.Source != "", "db replica %q: source is required", r.Name)
+		replicaNames[r.Name] = true
+	}
+
+	_, _, err := net.SplitHostPort(c.API.Addr)
+	check(err == nil, "api.addr %q is not a valid host:port", c.API.Addr)
+	check(c.API.ReadTimeout > 0, "api.read_timeout must be positive, got %d", c.API.ReadTimeout)
+	check(c.API.WriteTimeout > 0, "api.write_timeout must be positive, got %d", c.API.WriteTimeout)
+	check(c.API.IdleTimeout > 0, "api.idle_timeout must be positive, got %d", c.API.IdleTimeout)
+	check(c.API.ShutdownTimeout > 0, "api.shutdown_timeout must be positive, got %d", c.API.ShutdownTimeout)
+
+	check(c.Cache.Backend == "redis" || c.Cache.Backend == "memory",
+		"cache.backend must be redis or memory, got %q", c.Cache.Backend)
+	check(c.Cache.CleanupInterval > 0, "cache.cleanup_interval must be positive, got %d", c.Cache.CleanupInterval)
+	if rc := c.Redis; c.Cache.Backend == "redis" {
+		switch rc.Mode {
+		case "standalone":
+			u, err := url.Parse(rc.URL)
+			check(rc.URL != "" && err == nil && (u.Scheme == "redis" || u.Scheme == "rediss"),
+				"redis.url %q must be a redis:// or rediss:// url when cache.backend is redis", log.RedactURL(rc.URL))
+		case "sentinel":
+			check(len(rc.AddrList()) > 0, "redis.addrs is required for sentinel mode")
+			check(rc.MasterName != "", "redis.master_name is required for sentinel mode")
+		case "cluster":
+			check(len(rc.AddrList()) > 0, "redis.addrs is required for cluster mode")
+		default:
+			check(false, "redis.mode %q is not one of standalone|sentinel|cluster", rc.Mode)
+		}
+		for _, addr := range rc.AddrList() {
+			_, _, err := net.SplitHostPort(addr)
+			check(err == nil, "redis.addrs: %q is not host:port", addr)
+		}
+		check(!strings.ContainsAny(rc.KeyPrefix, "{}"), "redis.key_prefix must not contain { or }, they are used as cluster hash tags")
+		check((rc.TLSCertFile == "") == (rc.TLSKeyFile == ""), "redis.tls_cert_file and redis.tls_key_file must be set together")
+		check(rc.MaxIdle >= 0, "redis.max_idle must not be negative, got %d", rc.MaxIdle)
+		check(rc.MaxActive >= 0, "redis.max_active must not be negative, got %d", rc.MaxActive)
+		check(rc.IdleTimeout >= 0, "redis.idle_timeout must not be negative, got %d", rc.IdleTimeout)
+		check(rc.ConnectTimeout > 0, "redis.connect_timeout must be positive, got %d", rc.ConnectTimeout)
+		check(rc.ReadTimeout == 0 || rc.ReadTimeout >= 2, "redis.read_timeout must be 0 or at least 2, got %d", rc.ReadTimeout)
+		check(rc.WriteTimeout >= 0, "redis.write_timeout must not be negative, got %d", rc.WriteTimeout)
+	}
+
+	check(validLevel(c.Log.Level), "log.level %q is not one of debug|info|warn|error", c.Log.Level)
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format %q is not one of json|text", c.Log.Format)
+	check(c.Log.Output == "stdout" || c.Log.Output == "file", "log.output %q is not one of stdout|file", c.Log.Output)
+	check(c.Log.Output != "file" || c.Log.File != "", "log.file is required when log.output is file")
+	check(c.Log.MaxSize > 0, "log.max_size must be positive, got %d", c.Log.MaxSize)
+
+	check(c.Login.MaxErrors > 0, "login.max_errors must be positive, got %d", c.Login.MaxErrors)
+	check(c.Login.ErrorWindow > 0, "login.error_window must be positive, got %d", c.Login.ErrorWindow)
+	check(c.Token.TTL > 0, "token.ttl must be positive, got %d", c.Token.TTL)
+	check(c.Token.RefreshTTL > 0, "token.refresh_ttl must be positive, got %d", c.Token.RefreshTTL)
+	check(c.Health.Timeout > 0, "health.timeout must be positive, got %d", c.Health.Timeout)
+	check(c.Health.CacheTTL >= 0, "health.cache_ttl must not be negative, got %d", c.Health.CacheTTL)
+
+	hc := c.HTTPClient
+	check(hc.Timeout > 0, "http_client.timeout must be positive, got %d", hc.Timeout)
+	check((hc.CertFile == "") == (hc.KeyFile == ""), "http_client.cert_file and http_client.key_file must be set together")
+	check(hc.MaxRetries >= 0, "http_client.max_retries must not be negative, got %d", hc.MaxRetries)
+	check(hc.RetryBackoff > 0 && hc.RetryBackoff <= hc.RetryMaxBackoff,
+		"http_client.retry_backoff must be positive and not exceed retry_max_backoff, got %d", hc.RetryBackoff)
+	check(hc.BreakerFailures >= 0, "http_client.breaker_failures must not be negative, got %d", hc.BreakerFailures)
+	check(hc.BreakerCooldown > 0, "http_client.breaker_cooldown must be positive, got %d", hc.BreakerCooldown)
+
+	pv := c.Private
+	if pv.Addr != "" {
+		_, _, err := net.SplitHostPort(pv.Addr)
+		check(err == nil, "private.addr %q is not a valid host:port", pv.Addr)
+		check(pv.Addr != c.API.Addr, "private.addr must differ from api.addr")
+	}
+	check((pv.CertFile == "") == (pv.KeyFile == ""), "private.cert_file and private.key_file must be set together")
+	check(pv.CertFile == "" || pv.Addr != "", "private.cert_file requires private.addr")
+	check(pv.ClientCAFile == "" || pv.CertFile != "", "private.client_ca_file requires private.cert_file")
+	_, err = pv.Keys()
+	check(err == nil, "private.hmac_keys: %v", err)
+	check(pv.MaxClockSkew > 0, "private.max_clock_skew must be positive, got %d", pv.MaxClockSkew)
+	check(pv.MaxBatch > 0, "private.max_batch must be positive, got %d", pv.MaxBatch)
+	check(pv.MaxBodySize > 0, "private.max_body_size must be positive, got %d", pv.MaxBodySize)
+
+	wc := c.Webhook
+	check(wc.Workers > 0, "webhook.workers must be positive, got %d", wc.Workers)
+	check(wc.PollInterval > 0, "webhook.poll_interval must be positive, got %d", wc.PollInterval)
+	check(wc.BatchSize > 0, "webhook.batch_size must be positive, got %d", wc.BatchSize)
+	check(wc.MaxAttempts > 0, "webhook.max_attempts must be positive, got %d", wc.MaxAttempts)
+	check(wc.RetryBackoff > 0 && wc.RetryBackoff <= wc.RetryMaxBackoff,
+		"webhook.retry_backoff must be positive and not exceed retry_max_backoff, got %d", wc.RetryBackoff)
+	check(wc.Timeout > 0, "webhook.timeout must be positive, got %d", wc.Timeout)
+	check(wc.RetentionDays >= 0, "webhook.retention_days must not be negative, got %d", wc.RetentionDays)
+	for _, cidr := range strings.Split(wc.AllowNets, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			_, _, err := net.ParseCIDR(cidr)
+			check(err == nil, "webhook.allow_nets: %q is not a valid CIDR", cidr)
+		}
+	}
+
+	ec := c.Events
+	check(ec.Workers > 0, "events.workers must be positive, got %d", ec.Workers)
+	check(ec.QueueSize > 0, "events.queue_size must be positive, got %d", ec.QueueSize)
+	check(ec.Transport == "none" || ec.Transport == "redis",
+		"events.transport %q is not one of none|redis", ec.Transport)
+	check(ec.Transport != "redis" || c.Cache.Backend == "redis", "events.transport redis requires cache.backend redis")
+	check(ec.Transport != "redis" || ec.Channel != "", "events.channel is required for redis transport")
+
+	lc := c.Lock
+	check(lc.RedlockTimeout > 0, "lock.redlock_timeout must be positive, got %d", lc.RedlockTimeout)
+	redlock := lc.RedlockURLList()
+	check(len(redlock) != 2, "lock.redlock_urls needs 1 or at least 3 nodes for a majority, got 2")
+	for _, addr := range redlock {
+		u, err := url.Parse(addr)
+		check(err == nil && u.Scheme == "redis" && u.Host != "", "lock.redlock_urls: %q is not a redis:// url", addr)
+	}
+
+	jc := c.Jobs
+	queues, err := jc.QueueMap()
+	check(err == nil, "jobs.queues: %v", err)
+	check(!jc.Enabled || err != nil || len(queues) > 0, "jobs.queues is required when jobs are enabled")
+	check(jc.VisibilityTimeout > 0, "jobs.visibility_timeout must be positive, got %d", jc.VisibilityTimeout)
+	check(jc.MaxAttempts > 0, "jobs.max_attempts must be positive, got %d", jc.MaxAttempts)
+	check(jc.RetryBackoff > 0 && jc.RetryBackoff <= jc.RetryMaxBackoff,
+		"jobs.retry_backoff must be positive and not exceed retry_max_backoff, got %d", jc.RetryBackoff)
+	check(jc.PollInterval > 0, "jobs.poll_interval must be positive, got %d", jc.PollInterval)
+	check(jc.DeadRetentionDays >= 0, "jobs.dead_retention_days must not be negative, got %d", jc.DeadRetentionDays)
+
+	uc := c.UserCache
+	check(uc.TTL > 0, "user_cache.ttl must be positive, got %d", uc.TTL)
+	check(uc.NegativeTTL >= 0, "user_cache.negative_ttl must not be negative, got %d", uc.NegativeTTL)
+	check(uc.MaxEntries > 0, "user_cache.max_entries must be positive, got %d", uc.MaxEntries)
+
+	ic := c.ID
+	check(ic.Generator == "objectid" || ic.Generator == "ulid" || ic.Generator == "snowflake",
+		"id.generator must be objectid|ulid|snowflake, got %q", ic.Generator)
+	check(ic.WorkerId >= -1 && ic.WorkerId <= 1023, "id.worker_id must be -1 or 0-1023, got %d", ic.WorkerId)
+	check(ic.PublicIdKey == "" || len(ic.PublicIdKey) >= 16, "id.public_id_key must be at least 16 characters")
+
+	pc := c.Provision
+	check(pc.Workers > 0, "provision.workers must be positive, got %d", pc.Workers)
+	check(pc.QueueSize > 0, "provision.queue_size must be positive, got %d", pc.QueueSize)
+	check(pc.MaxRetries >= 0, "provision.max_retries must not be negative, got %d", pc.MaxRetries)
+	check(pc.RetryBackoff > 0, "provision.retry_backoff must be positive, got %d", pc.RetryBackoff)
+	names := make(map[string]bool)
+	for i := range pc.Targets {
+		t := &pc.Targets[i]
+		check(t.Name != "", "provision target #%d: name is required", i+1)
+		check(!names[t.Name], "provision.%s: duplicate target name", t.Name)
+		names[t.Name] = true
+		u, err := url.Parse(t.URL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"provision.%s.url %q must be an http(s) url", t.Name, t.URL)
+		events := t.EventList()
+		check(len(events) > 0, "provision.%s.events is required", t.Name)
+		for _, e := range events {
+			check(e == "register" || e == "login", "provision.%s.events: %q is not one of register|login", t.Name, e)
+		}
+		check(t.Timeout > 0, "provision.%s.timeout must be positive, got %d", t.Name, t.Timeout)
+		check(t.CacheTTL > 0, "provision.%s.cache_ttl must be positive, got %d", t.Name, t.CacheTTL)
+		_, err = t.Header()
+		check(err == nil, "provision.%s.headers: %v", t.Name, err)
+	}
+
+	check(c.Trace.Exporter == "none" || c.Trace.Exporter == "stdout" || c.Trace.Exporter == "otlp",
+		"trace.exporter %q is not one of none|stdout|otlp", c.Trace.Exporter)
+	check(c.Trace.Exporter != "otlp" || c.Trace.Endpoint != "", "trace.endpoint is required when trace.exporter is otlp")
+	check(c.Trace.SampleRatio >= 0 && c.Trace.SampleRatio <= 1, "trace.sample_ratio must be between 0 and 1, got %v", c.Trace.SampleRatio)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func validLevel(level string) bool {
+	switch level {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"github.com/saisai/gindemo/utils/log"
+)
+
+// Watch 收到 SIGHUP 时重新读取配置文件，只有可热加载的配置项会生效，
+// 其余配置项的修改需要重启服务。stop 关闭后停止监听。
+func Watch(path string, stop <-chan struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-sigs:
+				if err := Reload(path); err != nil {
+					log.Errorf("[config reload] %s: %v", path, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// hotReload 可热加载的配置项，<section> 表示整个section，<section>.<key> 表示其中的一项，名称与配置文件一致
+var hotReload = []string{
+	"log.level",
+	"login",
+	"token",
+	"ratelimit",
+	"api.show_req",
+	"api.show_rsp",
+	"private.hmac_keys",
+	"private.client_names",
+}
+
+// merge 返回在 old 上应用了 loaded 中可热加载配置项的新配置，以及含有不可热加载修改的section
+func merge(old, loaded *Config) (*Config, []string) {
+	next := *old
+	nv, ov, lv := reflect.ValueOf(&next).Elem(), reflect.ValueOf(old).Elem(), reflect.ValueOf(loaded).Elem()
+
+	restart := make([]string, 0)
+	for i := 0; i < nv.NumField(); i++ {
+		section := nv.Type().Field(i).Tag.Get("ini")
+		whole, keys := hotKeys(section)
+		if whole {
+			nv.Field(i).Set(lv.Field(i))
+			continue
+		}
+
+		// 把 loaded 中可热加载的项换成旧值后与 old 比较，不同说明修改了需要重启的配置项
+		rest := reflect.New(lv.Field(i).Type()).Elem()
+		rest.Set(lv.Field(i))
+		for _, key := range keys {
+			fieldByTag(nv.Field(i), key).Set(fieldByTag(lv.Field(i), key))
+			fieldByTag(rest, key).Set(fieldByTag(ov.Field(i), key))
+		}
+		if !reflect.DeepEqual(rest.Interface(), ov.Field(i).Interface()) {
+			restart = append(restart, section)
+		}
+	}
+	return &next, restart
+}
+
+// hotKeys 返回 section 是否整个可热加载，以及其中可热加载的配置项
+func hotKeys(section string) (bool, []string) {
+	keys := make([]string, 0)
+	for _, name := range hotReload {
+		if name == section {
+			return true, nil
+		}
+		if key := strings.TrimPrefix(name, section+"."); key != name {
+			keys = append(keys, key)
+		}
+	}
+	return false, keys
+}
+
+// fieldByTag 返回结构体 v 中 ini 标签为 tag 的字段
+func fieldByTag(v reflect.Value, tag string) reflect.Value {
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("ini") == tag {
+			return v.Field(i)
+		}
+	}
+	panic("config: no field " + tag + " in " + v.Type().Name())
+}
+
+// Reload 重新读取配置文件并应用可热加载的配置项，新配置不合法时保持原配置不变
+func Reload(path string) error {
+	loaded, err := Load(path)
+	if err != nil {
+		return err
+	}
+
+	old := Get()
+	next, restart := merge(old, loaded)
+	if len(restart) > 0 {
+		log.Warn("[config reload] changes require a restart and are ignored until then", "sections", strings.Join(restart, ", "))
+	}
+
+	Set(next)
+	log.Infof("[config reload] %s reloaded", path)
+
+	lock.Lock()
+	fns := append([]func(*Config){}, listeners...)
+	lock.Unlock()
+	for _, fn := range fns {
+		fn(next)
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/idgen"
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/utils/log"
+)
+
+// Handler 事件处理函数，按事件的实际类型做类型断言，如 e.(events.LoginSucceeded)
+type Handler func(ctx context.Context, e Event)
+
+// Options 总线配置
+type Options struct {
+	Workers   int // 异步处理函数的worker数
+	QueueSize int // 异步处理队列长度，队列满时丢弃事件
+	Transport Transport
+}
+
+// Envelope 广播给其他实例的事件
+type Envelope struct {
+	Id        string          `json:"id,omitempty"`
+	Name      string          `json:"name"`
+	Instance  string          `json:"instance"`
+	RequestId string          `json:"request_id,omitempty"`
+	At        time.Time       `json:"at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type subscription struct {
+	handler Handler
+	async   bool
+	cluster bool // 同时接收其他实例广播的事件
+}
+
+type job struct {
+	ctx  context.Context
+	name string // 用于日志和指标
+	run  func(ctx context.Context)
+}
+
+// Bus 事件总线
+type Bus struct {
+	opts     Options
+	instance string
+
+	mu       sync.RWMutex
+	handlers map[string][]subscription
+
+	queue  chan job
+	closed bool
+	qmu    sync.RWMutex
+	wg     sync.WaitGroup
+}
+
+var bus *Bus
+
+type eventIdKey struct{}
+
+func withEventId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, eventIdKey{}, id)
+}
+
+// EventId 返回处理函数正在处理的事件的ID，发布时生成，广播给其他实例时保持不变
+func EventId(ctx context.Context) string {
+	id, _ := ctx.Value(eventIdKey{}).(string)
+	return id
+}
+
+// New 创建事件总线
+func New(opts Options) *Bus {
+	return &Bus{
+		opts:     opts,
+		instance: idgen.New(),
+		handlers: make(map[string][]subscription),
+		queue:    make(chan job, opts.QueueSize),
+	}
+}
+
+// Init 创建默认事件总线，订阅需在 Init 之后进行
+func Init(opts Options) {
+	bus = New(opts)
+}
+
+// Start 启动默认事件总线
+func Start() error {
+	if bus == nil {
+		return nil
+	}
+	return bus.Start()
+}
+
+// Stop 停止默认事件总线，等待队列中的异步处理函数执行完成
+func Stop(ctx context.Context) error {
+	if bus == nil {
+		return nil
+	}
+	return bus.Stop(ctx)
+}
+
+// Subscribe 在默认事件总线上注册同步处理函数，见 Bus.Subscribe
+func Subscribe(name string, h Handler) {
+	if bus == nil {
+		return
+	}
+	bus.Subscribe(name, h)
+}
+
+// SubscribeAsync 在默认事件总线上注册异步处理函数，见 Bus.SubscribeAsync
+func SubscribeAsync(name string, h Handler) {
+	if bus == nil {
+		return
+	}
+	bus.SubscribeAsync(name, h)
+}
+
+// SubscribeCluster 在默认事件总线上注册接收所有实例事件的处理函数，见 Bus.SubscribeCluster
+func SubscribeCluster(name string, h Handler) {
+	if bus == nil {
+		return
+	}
+	bus.SubscribeCluster(name, h)
+}
+
+// Publish 在默认事件总线上发布事件，未初始化时忽略
+func Publish(ctx context.Context, e Event) {
+	if bus == nil {
+		return
+	}
+	bus.Publish(ctx, e)
+}
+
+func (b *Bus) Start() error {
+	for i := 0; i < b.opts.Workers; i++ {
+		b.wg.Add(1)
+		go b.worker()
+	}
+	if b.opts.Transport != nil {
+		if err := b.opts.Transport.Start(b.receive); err != nil {
+			return fmt.Errorf("events: start transport: %v", err)
+		}
+	}
+	return nil
+}
+
+func (b *Bus) Stop(ctx context.Context) error {
+	if b.opts.Transport != nil {
+		if err := b.opts.Transport.Close(); err != nil {
+			log.Warn("[events] close transport failed", "error", err)
+		}
+	}
+
+	b.qmu.Lock()
+	b.closed = true
+	close(b.queue)
+	b.qmu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("events: %v, %d pending handlers dropped", ctx.Err(), len(b.queue))
+	}
+}
+
+// Subscribe 注册同步处理函数，在 Publish 的调用方中按注册顺序执行，只接收本实例发布的事件。
+// 同步处理函数应当很快返回，耗时的操作应使用 SubscribeAsync。
+func (b *Bus) Subscribe(name string, h Handler) {
+	b.add(name, subscription{handler: h})
+}
+
+// SubscribeAsync 注册异步处理函数，由worker在请求结束后执行，只接收本实例发布的事件
+func (b *Bus) SubscribeAsync(name string, h Handler) {
+	b.add(name, subscription{handler: h, async: true})
+}
+
+// SubscribeCluster 注册异步处理函数，接收本实例发布的和其他实例广播的事件，未配置传输时与 SubscribeAsync 相同
+func (b *Bus) SubscribeCluster(name string, h Handler) {
+	b.add(name, subscription{handler: h, async: true, cluster: true})
+}
+
+func (b *Bus) add(name string, s subscription) {
+	if _, ok := decoders[name]; !ok {
+		panic("events: unknown event " + name)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[name] = append(b.handlers[name], s)
+}
+
+func (b *Bus) subscriptions(name string) []subscription {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.handlers[name]
+}
+
+// Publish 发布事件：同步处理函数立即执行，异步处理函数进入队列，配置了传输时同时广播给其他实例。
+// 异步处理只沿用请求的trace和请求ID，不受请求取消的影响。
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	name := e.Name()
+	id := idgen.New()
+	ctx = withEventId(ctx, id)
+	detached := withEventId(log.Detach(ctx), id)
+
+	for _, s := range b.subscriptions(name) {
+		if !s.async {
+			b.call(ctx, name, s.handler, e)
+			continue
+		}
+		h := s.handler
+		b.enqueue(job{ctx: detached, name: name, run: func(ctx context.Context) { b.call(ctx, name, h, e) }})
+	}
+
+	if b.opts.Transport != nil {
+		b.enqueue(job{ctx: detached, name: name, run: func(ctx context.Context) { b.broadcast(ctx, e) }})
+	}
+}
+
+func (b *Bus) enqueue(j job) {
+	b.qmu.RLock()
+	defer b.qmu.RUnlock()
+
+	if b.closed {
+		log.WarnCtx(j.ctx, "[events] bus is stopped, event dropped", "event", j.name)
+		metrics.EventDropped(j.name)
+		return
+	}
+	select {
+	case b.queue <- j:
+	default:
+		log.WarnCtx(j.ctx, "[events] queue is full, event dropped", "event", j.name)
+		metrics.EventDropped(j.name)
+	}
+}
+
+func (b *Bus) worker() {
+	defer b.wg.Done()
+	for j := range b.queue {
+		j.run(j.ctx)
+	}
+}
+
+// call 执行处理函数，处理函数的panic不影响发布方和其他处理函数
+func (b *Bus) call(ctx context.Context, name string, h Handler, e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.ErrorCtx(ctx, "[events] handler panic", "event", name, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+	h(ctx, e)
+}
+
+func (b *Bus) broadcast(ctx context.Context, e Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.ErrorCtx(ctx, "[events] marshal event failed", "event", e.Name(), "error", err)
+		return
+	}
+	raw, err := json.Marshal(&Envelope{
+		Id:        EventId(ctx),
+		Name:      e.Name(),
+		Instance:  b.instance,
+		RequestId: log.RequestID(ctx),
+		At:        time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		log.ErrorCtx(ctx, "[events] marshal envelope failed", "event", e.Name(), "error", err)
+		return
+	}
+	if err := b.opts.Transport.Publish(ctx, raw); err != nil {
+		log.WarnCtx(ctx, "[events] broadcast failed", "event", e.Name(), "error", err)
+	}
+}
+
+// receive 处理其他实例广播的事件，只交给 SubscribeCluster 注册的处理函数
+func (b *Bus) receive(raw []byte) {
+	env := new(Envelope)
+	if err := json.Unmarshal(raw, env); err != nil {
+		log.Warn("[events] invalid envelope", "error", err)
+		return
+	}
+	if env.Instance == b.instance {
+		return
+	}
+	decode, ok := decoders[env.Name]
+	if !ok {
+		log.Debug("[events] unknown remote event", "event", env.Name, "instance", env.Instance)
+		return
+	}
+	e, err := decode(env.Data)
+	if err != nil {
+		log.Warn("[events] invalid remote event", "event", env.Name, "instance", env.Instance, "error", err)
+		return
+	}
+
+	ctx := withEventId(context.Background(), env.Id)
+	if env.RequestId != "" {
+		ctx = log.WithRequestID(ctx, env.RequestId)
+	}
+	for _, s := range b.subscriptions(env.Name) {
+		if !s.cluster {
+			continue
+		}
+		h := s.handler
+		b.enqueue(job{ctx: ctx, name: env.Name, run: func(ctx context.Context) { b.call(ctx, env.Name, h, e) }})
+	}
+}
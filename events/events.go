@@ -0,0 +1,90 @@
+// Package events 进程内的领域事件总线：models 在用户注册、登录等操作完成后发布事件，
+// 指标、下游开通钩子、webhook、审计日志等副作用作为订阅者处理，不再写在业务代码中。
+// 配置 redis 传输时，事件同时广播给其他实例，通过 SubscribeCluster 订阅的处理函数可以收到所有实例的事件。
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	USER_REGISTERED = "user.registered"
+	LOGIN_SUCCEEDED = "user.login_succeeded"
+	LOGIN_FAILED    = "user.login_failed"
+	IDENTITY_LINKED = "user.identity_linked"
+	LOGGED_OUT      = "user.logged_out"
+)
+
+// 登录失败原因
+const (
+	REASON_INVALID_PARAM     = "invalid_param"
+	REASON_ACCOUNT_NOT_EXIST = "account_not_exist"
+	REASON_PASSWORD_ERROR    = "password_error"
+	REASON_CAPTCHA_ERROR     = "captcha_error"
+	REASON_LOCKED_OUT        = "locked_out"
+	REASON_INTERNAL_ERROR    = "internal_error"
+)
+
+// Event 领域事件，Name 返回事件名，同一事件名对应同一种类型
+type Event interface {
+	Name() string
+}
+
+type UserRegistered struct {
+	UserId        string    `json:"user_id"`
+	Nickname      string    `json:"nickname"`
+	IdentifyTypes []string  `json:"identify_types"`
+	At            time.Time `json:"at"`
+}
+
+type LoginSucceeded struct {
+	UserId       string    `json:"user_id"`
+	IdentifyType string    `json:"identify_type"`
+	SessionId    string    `json:"session_id"`
+	Token        string    `json:"-"` // 只在本实例内传递，不会广播给其他实例
+	At           time.Time `json:"at"`
+}
+
+type LoginFailed struct {
+	UserId       string    `json:"user_id,omitempty"` // 账号不存在或参数错误时为空
+	IdentifyType string    `json:"identify_type"`
+	Reason       string    `json:"reason"`
+	ErrCount     int       `json:"err_count,omitempty"` // 密码错误时为累计的错误次数
+	At           time.Time `json:"at"`
+}
+
+type IdentityLinked struct {
+	UserId       string    `json:"user_id"`
+	IdentifyType string    `json:"identify_type"`
+	At           time.Time `json:"at"`
+}
+
+type LoggedOut struct {
+	UserId    string    `json:"user_id"`
+	SessionId string    `json:"session_id,omitempty"` // 旧格式的token没有会话ID
+	At        time.Time `json:"at"`
+}
+
+func (UserRegistered) Name() string { return USER_REGISTERED }
+func (LoginSucceeded) Name() string { return LOGIN_SUCCEEDED }
+func (LoginFailed) Name() string    { return LOGIN_FAILED }
+func (IdentityLinked) Name() string { return IDENTITY_LINKED }
+func (LoggedOut) Name() string      { return LOGGED_OUT }
+
+// decoders 按事件名解码其他实例广播的事件
+var decoders = map[string]func([]byte) (Event, error){
+	USER_REGISTERED: decoder[UserRegistered](),
+	LOGIN_SUCCEEDED: decoder[LoginSucceeded](),
+	LOGIN_FAILED:    decoder[LoginFailed](),
+	IDENTITY_LINKED: decoder[IdentityLinked](),
+	LOGGED_OUT:      decoder[LoggedOut](),
+}
+
+func decoder[T Event]() func([]byte) (Event, error) {
+	return func(data []byte) (Event, error) {
+		var e T
+		err := json.Unmarshal(data, &e)
+		return e, err
+	}
+}
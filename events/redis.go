@@ -0,0 +1,158 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/utils/cache"
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Transport 在实例之间广播事件
+type Transport interface {
+	Publish(ctx context.Context, data []byte) error
+	// Start 开始接收其他实例广播的事件，receive 在传输内部的goroutine中调用
+	Start(receive func(data []byte)) error
+	Close() error
+}
+
+const (
+	redisRetryMin = time.Second
+	redisRetryMax = 30 * time.Second
+)
+
+// redisTransport 通过redis pub/sub广播事件，发布使用 cache 的连接池，订阅使用 cache.Dial 建立的没有读超时的连接。
+// 订阅连接断开后按退避重连，断开期间其他实例广播的事件会丢失。
+type redisTransport struct {
+	channel string
+
+	mu     sync.Mutex
+	psc    *redis.PubSubConn
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewRedis 创建redis传输，需要先初始化 cache 的redis连接池
+func NewRedis(channel string) Transport {
+	return &redisTransport{channel: channel, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (r *redisTransport) Publish(ctx context.Context, data []byte) error {
+	conn := cache.Get()
+	defer conn.Close()
+	_, err := conn.Do("PUBLISH", r.channel, data)
+	return err
+}
+
+func (r *redisTransport) Start(receive func(data []byte)) error {
+	psc, err := r.subscribe()
+	if err != nil {
+		return err
+	}
+	go r.loop(psc, receive)
+	return nil
+}
+
+// subscribe 建立连接订阅频道，Close 之后返回nil
+func (r *redisTransport) subscribe() (*redis.PubSubConn, error) {
+	conn, err := cache.Dial()
+	if err != nil {
+		return nil, err
+	}
+	psc := &redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(r.channel); err != nil {
+		psc.Close()
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		psc.Close()
+		return nil, nil
+	}
+	r.psc = psc
+	return psc, nil
+}
+
+func (r *redisTransport) loop(psc *redis.PubSubConn, receive func(data []byte)) {
+	defer close(r.done)
+
+	wait := redisRetryMin
+	for psc != nil {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			receive(v.Data)
+			continue
+		case redis.Subscription:
+			if v.Kind == "subscribe" {
+				log.Info("[events] subscribed", "channel", v.Channel)
+				wait = redisRetryMin
+				continue
+			}
+			if v.Count > 0 {
+				continue
+			}
+		case error:
+			if !r.isClosed() {
+				log.Warn("[events] subscription broken", "channel", r.channel, "error", v)
+			}
+		}
+
+		// 主动退订或连接断开
+		psc.Close()
+		psc = nil
+		for !r.isClosed() {
+			var err error
+			if psc, err = r.subscribe(); err == nil {
+				break
+			}
+			log.Warn("[events] resubscribe failed", "channel", r.channel, "wait", wait.String(), "error", err)
+			select {
+			case <-time.After(wait):
+			case <-r.stop:
+			}
+			if wait *= 2; wait > redisRetryMax {
+				wait = redisRetryMax
+			}
+		}
+	}
+}
+
+func (r *redisTransport) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// Close 退订频道并等待接收goroutine退出
+func (r *redisTransport) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.stop)
+	psc := r.psc
+	r.mu.Unlock()
+
+	if psc == nil {
+		return nil
+	}
+	if err := psc.Unsubscribe(r.channel); err != nil {
+		// 连接已不可用，直接关闭让接收goroutine退出
+		psc.Close()
+	}
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		psc.Close()
+		<-r.done
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Package health 存活和就绪检查，就绪检查会并发执行各依赖的检查函数并缓存结果
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	STATUS_UP   = "up"
+	STATUS_DOWN = "down"
+)
+
+// Checker 依赖检查函数，返回nil表示依赖可用
+type Checker func(ctx context.Context) error
+
+type Result struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type Report struct {
+	Status    string            `json:"status"`
+	Version   string            `json:"version"`
+	StartedAt time.Time         `json:"started_at"`
+	Uptime    string            `json:"uptime"`
+	Checks    map[string]Result `json:"checks,omitempty"`
+}
+
+type check struct {
+	name string
+	fn   Checker
+}
+
+var (
+	version   string
+	startedAt = time.Now()
+
+	timeout  = 2 * time.Second
+	cacheTTL = 5 * time.Second
+
+	mu       sync.Mutex
+	checks   []check
+	cached   map[string]Result
+	cachedAt time.Time
+	running  chan struct{} // 正在执行的检查，完成后关闭
+
+	shuttingDown atomic.Bool
+)
+
+// SetShuttingDown 服务开始停止，之后的就绪检查都返回 down，负载均衡不再把请求转发到本实例
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// SetVersion 设置报告中的版本号
+func SetVersion(v string) {
+	version = v
+}
+
+// SetOptions 设置单个检查的超时时间和检查结果的缓存时间
+func SetOptions(checkTimeout, resultTTL time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	timeout = checkTimeout
+	cacheTTL = resultTTL
+}
+
+// Register 注册依赖检查，就绪检查要求所有依赖都可用
+func Register(name string, fn Checker) {
+	mu.Lock()
+	defer mu.Unlock()
+	checks = append(checks, check{name: name, fn: fn})
+	cached = nil
+}
+
+// Liveness 存活检查，只要进程能处理请求即为up
+func Liveness() Report {
+	return newReport(STATUS_UP, nil)
+}
+
+// Readiness 就绪检查，cacheTTL内重复调用直接返回缓存的结果。
+// 检查在锁外执行，使用独立的ctx和配置的超时，不受某个请求被取消的影响；同时只有一次检查在执行，其余调用等待它的结果
+func Readiness() Report {
+	if shuttingDown.Load() {
+		return newReport(STATUS_DOWN, map[string]Result{
+			"shutdown": {Status: STATUS_DOWN, Error: "shutting down", Duration: "0s", CheckedAt: time.Now()},
+		})
+	}
+	results := readinessResults()
+
+	status := STATUS_UP
+	for _, result := range results {
+		if result.Status != STATUS_UP {
+			status = STATUS_DOWN
+		}
+	}
+	return newReport(status, results)
+}
+
+func readinessResults() map[string]Result {
+	mu.Lock()
+	if cached != nil && time.Since(cachedAt) <= cacheTTL {
+		results := cached
+		mu.Unlock()
+		return results
+	}
+	if running == nil {
+		running = make(chan struct{})
+		go refresh(checks, timeout, running)
+	}
+	done := running
+	mu.Unlock()
+
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	return cached
+}
+
+// refresh 执行所有检查并更新缓存，完成后关闭 done
+func refresh(checks []check, timeout time.Duration, done chan struct{}) {
+	results := runChecks(context.Background(), checks, timeout)
+
+	mu.Lock()
+	cached, cachedAt, running = results, time.Now(), nil
+	mu.Unlock()
+	close(done)
+}
+
+func newReport(status string, results map[string]Result) Report {
+	return Report{
+		Status:    status,
+		Version:   version,
+		StartedAt: startedAt,
+		Uptime:    time.Since(startedAt).Round(time.Second).String(),
+		Checks:    results,
+	}
+}
+
+func runChecks(ctx context.Context, checks []check, timeout time.Duration) map[string]Result {
+	results := make(map[string]Result, len(checks))
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c check) {
+			defer wg.Done()
+			result := runCheck(ctx, c.fn, timeout)
+			lock.Lock()
+			results[c.name] = result
+			lock.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	return results
+}
+
+// runCheck 执行单个检查，检查函数不响应ctx时也会在超时后返回
+func runCheck(ctx context.Context, fn Checker, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Status:    STATUS_UP,
+		Duration:  time.Since(start).String(),
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Status = STATUS_DOWN
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+// Package idgen 生成用户、账号、会话、事件等的ID。生成器可选 ObjectID（默认，与原先的ID格式相同）、
+// ULID 和 Snowflake，三种ID都按生成时间排序。多个实例使用 Snowflake 时每个实例的 worker ID 必须不同。
+// 对外的接口使用 Public 转换后的不透明ID，不暴露内部ID，见 public.go
+package idgen
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/saisai/gindemo/utils"
+	"github.com/saisai/gindemo/utils/log"
+)
+
+const (
+	KIND_OBJECTID  = "objectid"
+	KIND_ULID      = "ulid"
+	KIND_SNOWFLAKE = "snowflake"
+
+	// MAX_LEN 各种ID的最大长度，数据库中的ID列至少要这么宽
+	MAX_LEN = 26
+)
+
+// Generator ID生成器，New 需要并发安全
+type Generator interface {
+	New() string
+	// Valid 判断 id 是否为该生成器的格式
+	Valid(id string) bool
+}
+
+// Options 生成器配置
+type Options struct {
+	Kind     string // objectid、ulid 或 snowflake
+	WorkerId int    // snowflake 的 worker ID，0-1023，< 0 时由机器码计算
+}
+
+var (
+	gen Generator = NewObjectID()
+
+	// builtin 用于 Valid，切换生成器后旧格式的ID仍然有效
+	builtin = []Generator{gen, NewULID(), &Snowflake{}}
+)
+
+// Init 按配置创建默认生成器
+func Init(opts Options) error {
+	switch opts.Kind {
+	case KIND_OBJECTID, "":
+		gen = NewObjectID()
+	case KIND_ULID:
+		gen = NewULID()
+	case KIND_SNOWFLAKE:
+		workerId := opts.WorkerId
+		if workerId < 0 {
+			workerId = machineWorkerId()
+		}
+		s, err := NewSnowflake(workerId)
+		if err != nil {
+			return err
+		}
+		gen = s
+	default:
+		return fmt.Errorf("idgen: unknown generator %q", opts.Kind)
+	}
+	return nil
+}
+
+// Use 设置默认生成器
+func Use(g Generator) {
+	gen = g
+}
+
+// New 使用默认生成器生成ID
+func New() string {
+	return gen.New()
+}
+
+// Valid 判断 id 是否为任意一种内置生成器的格式
+func Valid(id string) bool {
+	for _, g := range builtin {
+		if g.Valid(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// machineWorkerId 由机器码计算 worker ID，取不到机器码时随机选择。
+// 同一台机器上运行多个实例时计算结果相同，需要在配置中分别指定
+func machineWorkerId() int {
+	id := utils.GetMachineId()
+	if id == "" {
+		workerId := rand.Intn(SNOWFLAKE_MAX_WORKER + 1)
+		log.Warn("[idgen] machine id unavailable, using random worker id", "worker_id", workerId)
+		return workerId
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % (SNOWFLAKE_MAX_WORKER + 1))
+}
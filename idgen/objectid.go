@@ -0,0 +1,47 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+)
+
+// ObjectID 与 MongoDB ObjectId 格式相同的24位十六进制ID：4字节秒级时间戳、5字节进程随机数、3字节计数器
+type ObjectID struct {
+	process [5]byte
+	counter atomic.Uint32
+}
+
+// NewObjectID 创建 ObjectID 生成器，进程随机数和计数器初值随机选择
+func NewObjectID() *ObjectID {
+	g := new(ObjectID)
+	var b [4]byte
+	rand.Read(g.process[:])
+	rand.Read(b[:])
+	g.counter.Store(binary.BigEndian.Uint32(b[:]))
+	return g
+}
+
+func (g *ObjectID) New() string {
+	var b [12]byte
+	binary.BigEndian.PutUint32(b[0:4], uint32(time.Now().Unix()))
+	copy(b[4:9], g.process[:])
+	n := g.counter.Add(1)
+	b[9], b[10], b[11] = byte(n>>16), byte(n>>8), byte(n)
+	return hex.EncodeToString(b[:])
+}
+
+func (g *ObjectID) Valid(id string) bool {
+	if len(id) != 24 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
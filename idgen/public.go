@@ -0,0 +1,93 @@
+package idgen
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+// 对外的不透明ID：用密钥加密内部ID，客户端看不出ID的生成时间、顺序和格式，也无法构造其他用户的ID。
+// 同一个内部ID总是得到同一个公开ID（以内部ID的HMAC作为CTR的IV，即SIV结构），解密后重新计算HMAC校验。
+// 没有设置密钥时公开ID就是内部ID。更换密钥后旧的公开ID全部失效。
+// 编码使用小写的 base32，不含 token 的分隔符 _，可以直接放在URL中
+
+// publicIVLen 公开ID中HMAC的字节数，补0到16字节作为CTR的IV
+const publicIVLen = 12
+
+var publicEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrInvalidPublicId 公开ID格式错误或不是用当前密钥生成的
+var ErrInvalidPublicId = errors.New("idgen: invalid public id")
+
+var public struct {
+	block  cipher.Block
+	macKey []byte
+}
+
+// SetPublicKey 设置公开ID的密钥，为空时公开ID与内部ID相同
+func SetPublicKey(key string) error {
+	if key == "" {
+		public.block, public.macKey = nil, nil
+		return nil
+	}
+	block, err := aes.NewCipher(derive(key, "idgen public id encryption"))
+	if err != nil {
+		return err
+	}
+	public.block, public.macKey = block, derive(key, "idgen public id mac")
+	return nil
+}
+
+// derive 从配置的密钥派生出不同用途的32字节密钥
+func derive(key, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func publicMAC(id []byte) []byte {
+	mac := hmac.New(sha256.New, public.macKey)
+	mac.Write(id)
+	return mac.Sum(nil)[:publicIVLen]
+}
+
+// xorID 用 AES-CTR 加密或解密，IV 为 sum 补0到16字节
+func xorID(dst, src, sum []byte) {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, sum)
+	cipher.NewCTR(public.block, iv).XORKeyStream(dst, src)
+}
+
+// Public 返回内部ID对应的公开ID
+func Public(id string) string {
+	if public.block == nil || id == "" {
+		return id
+	}
+	sum := publicMAC([]byte(id))
+	out := make([]byte, publicIVLen+len(id))
+	copy(out, sum)
+	xorID(out[publicIVLen:], []byte(id), sum)
+	return strings.ToLower(publicEncoding.EncodeToString(out))
+}
+
+// Internal 返回公开ID对应的内部ID
+func Internal(publicId string) (string, error) {
+	if public.block == nil {
+		return publicId, nil
+	}
+	raw, err := publicEncoding.DecodeString(strings.ToUpper(publicId))
+	if err != nil || len(raw) <= publicIVLen {
+		return "", ErrInvalidPublicId
+	}
+	sum := raw[:publicIVLen]
+	id := make([]byte, len(raw)-publicIVLen)
+	xorID(id, raw[publicIVLen:], sum)
+	if !hmac.Equal(sum, publicMAC(id)) {
+		return "", ErrInvalidPublicId
+	}
+	return string(id), nil
+}
@@ -0,0 +1,99 @@
+package idgen
+
+import (
+	"strings"
+	"testing"
+)
+
+func setTestKey(t *testing.T, key string) {
+	if err := SetPublicKey(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetPublicKey("") })
+}
+
+func TestPublicRoundTrip(t *testing.T) {
+	setTestKey(t, "test-key")
+
+	ids := []string{
+		"1",
+		"5f0c1a2b3c4d5e6f7a8b9c0d",   // objectid
+		"01ARZ3NDEKTSV4RRFFQ69G5FAV", // ulid
+		"1234567890123456789",        // snowflake
+		"id_with_separator",
+	}
+	for _, id := range ids {
+		pub := Public(id)
+		if pub == id {
+			t.Errorf("Public(%q) returned the internal id", id)
+		}
+		if pub != strings.ToLower(pub) || strings.Contains(pub, "_") {
+			t.Errorf("Public(%q) = %q, want lowercase without _", id, pub)
+		}
+		if again := Public(id); again != pub {
+			t.Errorf("Public(%q) not deterministic: %q != %q", id, again, pub)
+		}
+		got, err := Internal(pub)
+		if err != nil || got != id {
+			t.Errorf("Internal(Public(%q)) = %q, %v", id, got, err)
+		}
+		// base32 不区分大小写
+		if got, err := Internal(strings.ToUpper(pub)); err != nil || got != id {
+			t.Errorf("Internal(upper(Public(%q))) = %q, %v", id, got, err)
+		}
+	}
+	if Public("") != "" {
+		t.Error("Public of empty id should be empty")
+	}
+}
+
+func TestPublicTamper(t *testing.T) {
+	setTestKey(t, "test-key")
+	pub := Public("5f0c1a2b3c4d5e6f7a8b9c0d")
+
+	// flip 把第 i 个字符换成另一个合法的 base32 字符
+	flip := func(i int) string {
+		c := byte('a')
+		if pub[i] == 'a' {
+			c = 'b'
+		}
+		return pub[:i] + string(c) + pub[i+1:]
+	}
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"empty", ""},
+		{"not base32", "not-base32!"},
+		{"iv only", pub[:20]},
+		{"truncated", pub[:len(pub)-8]},
+		{"flipped mac", flip(0)},
+		{"flipped body", flip(len(pub) - 2)},
+		{"internal id", "5f0c1a2b3c4d5e6f7a8b9c0d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id, err := Internal(tt.id); err != ErrInvalidPublicId {
+				t.Errorf("Internal(%q) = %q, %v, want ErrInvalidPublicId", tt.id, id, err)
+			}
+		})
+	}
+
+	setTestKey(t, "other-key")
+	if id, err := Internal(pub); err != ErrInvalidPublicId {
+		t.Errorf("Internal with another key = %q, %v, want ErrInvalidPublicId", id, err)
+	}
+}
+
+func TestPublicNoKey(t *testing.T) {
+	setTestKey(t, "")
+	for _, id := range []string{"", "5f0c1a2b3c4d5e6f7a8b9c0d", "anything_goes"} {
+		if pub := Public(id); pub != id {
+			t.Errorf("Public(%q) = %q without key, want the same id", id, pub)
+		}
+		if got, err := Internal(id); err != nil || got != id {
+			t.Errorf("Internal(%q) = %q, %v without key, want the same id", id, got, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package idgen
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/utils/log"
+)
+
+const (
+	// SNOWFLAKE_EPOCH Snowflake 时间戳的起点，2020-01-01 00:00:00 UTC 的毫秒数
+	SNOWFLAKE_EPOCH = 1577836800000
+	// SNOWFLAKE_MAX_WORKER worker ID 的最大值，占10位
+	SNOWFLAKE_MAX_WORKER = 1<<10 - 1
+
+	snowflakeSeqBits = 12
+	snowflakeMaxSeq  = 1<<snowflakeSeqBits - 1
+)
+
+// Snowflake 十进制的63位ID：41位毫秒时间戳、10位 worker ID、12位序号，每个 worker 每毫秒最多4096个。
+// 时钟回拨时等待时钟追上上一次的时间，不会生成重复的ID
+type Snowflake struct {
+	workerId int64
+
+	mu     sync.Mutex
+	lastMs int64
+	seq    int64
+}
+
+// NewSnowflake 创建 worker ID 为 workerId 的生成器
+func NewSnowflake(workerId int) (*Snowflake, error) {
+	if workerId < 0 || workerId > SNOWFLAKE_MAX_WORKER {
+		return nil, fmt.Errorf("idgen: snowflake worker id must be 0-%d, got %d", SNOWFLAKE_MAX_WORKER, workerId)
+	}
+	return &Snowflake{workerId: int64(workerId)}, nil
+}
+
+func (g *Snowflake) New() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := time.Now().UnixMilli() - SNOWFLAKE_EPOCH
+	if ms < g.lastMs {
+		log.Warn("[idgen] clock moved backwards, waiting", "behind", time.Duration(g.lastMs-ms)*time.Millisecond)
+		ms = g.waitAfter(g.lastMs - 1)
+	}
+	if ms == g.lastMs {
+		g.seq = (g.seq + 1) & snowflakeMaxSeq
+		if g.seq == 0 {
+			ms = g.waitAfter(g.lastMs)
+		}
+	} else {
+		g.seq = 0
+	}
+	g.lastMs = ms
+
+	id := ms<<(10+snowflakeSeqBits) | g.workerId<<snowflakeSeqBits | g.seq
+	return strconv.FormatInt(id, 10)
+}
+
+// waitAfter 等到时间戳大于 ms
+func (g *Snowflake) waitAfter(ms int64) int64 {
+	for {
+		now := time.Now().UnixMilli() - SNOWFLAKE_EPOCH
+		if now > ms {
+			return now
+		}
+		time.Sleep(time.Duration(ms-now+1) * time.Millisecond)
+	}
+}
+
+func (g *Snowflake) Valid(id string) bool {
+	if len(id) == 0 || len(id) > 19 || id[0] == '0' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	return err == nil && n > 0
+}
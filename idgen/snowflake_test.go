@@ -0,0 +1,110 @@
+package idgen
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestSnowflakeWorkerId(t *testing.T) {
+	tests := []struct {
+		workerId int
+		ok       bool
+	}{
+		{-1, false},
+		{0, true},
+		{1, true},
+		{SNOWFLAKE_MAX_WORKER, true},
+		{SNOWFLAKE_MAX_WORKER + 1, false},
+	}
+	for _, tt := range tests {
+		g, err := NewSnowflake(tt.workerId)
+		if (err == nil) != tt.ok {
+			t.Errorf("NewSnowflake(%d) error = %v, want ok=%v", tt.workerId, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(g.New(), 10, 64)
+		if got := int(n >> snowflakeSeqBits & SNOWFLAKE_MAX_WORKER); got != tt.workerId {
+			t.Errorf("worker id in id = %d, want %d", got, tt.workerId)
+		}
+	}
+}
+
+func TestSnowflakeOrdering(t *testing.T) {
+	g, err := NewSnowflake(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过每毫秒的序号上限，覆盖序号用完后等待下一毫秒的情况
+	var last int64
+	for i := 0; i < 3*(snowflakeMaxSeq+1); i++ {
+		id := g.New()
+		if !g.Valid(id) {
+			t.Fatalf("Valid(%q) = false", id)
+		}
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n <= last {
+			t.Fatalf("id %d after %d, want strictly increasing", n, last)
+		}
+		last = n
+	}
+}
+
+func TestSnowflakeConcurrentUnique(t *testing.T) {
+	g, _ := NewSnowflake(1)
+	const workers, each = 8, 2000
+
+	var mu sync.Mutex
+	seen := make(map[string]bool, workers*each)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids := make([]string, each)
+			for i := range ids {
+				ids[i] = g.New()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, id := range ids {
+				if seen[id] {
+					t.Errorf("duplicate id %s", id)
+				}
+				seen[id] = true
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSnowflakeValid(t *testing.T) {
+	g := &Snowflake{}
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"1", true},
+		{"1234567890123456789", true},
+		{"9223372036854775807", true},
+		{"9223372036854775808", false},
+		{"12345678901234567890", false},
+		{"", false},
+		{"0", false},
+		{"0123", false},
+		{"-1", false},
+		{"12a4", false},
+		{"01ARZ3NDEKTSV4RRFFQ69G5FAV", false},
+	}
+	for _, tt := range tests {
+		if got := g.Valid(tt.id); got != tt.valid {
+			t.Errorf("Valid(%q) = %v, want %v", tt.id, got, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// crockford ULID 使用的 Crockford Base32 字母表，不含 I、L、O、U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID 26位的 ULID：48位毫秒时间戳和80位随机数，按字符串排序即按生成时间排序。
+// 同一毫秒内（或时钟回拨时）在上一个ID的随机部分上加1，保证本进程生成的ID严格递增
+type ULID struct {
+	mu      sync.Mutex
+	lastMs  uint64
+	lastRnd [10]byte
+}
+
+func NewULID() *ULID {
+	return new(ULID)
+}
+
+func (g *ULID) New() string {
+	ms := uint64(time.Now().UnixMilli())
+
+	g.mu.Lock()
+	if ms <= g.lastMs {
+		ms = g.lastMs
+		if !increment(g.lastRnd[:]) {
+			// 同一毫秒内的随机部分用完，借用下一毫秒
+			ms++
+			rand.Read(g.lastRnd[:])
+		}
+	} else {
+		rand.Read(g.lastRnd[:])
+	}
+	g.lastMs = ms
+	var b [16]byte
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+	copy(b[6:], g.lastRnd[:])
+	g.mu.Unlock()
+
+	return encodeULID(b)
+}
+
+func (g *ULID) Valid(id string) bool {
+	if len(id) != 26 || id[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if decodeCrockford(id[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// increment 把 b 作为大端整数加1，溢出时返回 false
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeULID 把128位按每5位一个字符编码，第一个字符只有3位
+func encodeULID(b [16]byte) string {
+	var hi, lo uint64
+	for i := 0; i < 8; i++ {
+		hi = hi<<8 | uint64(b[i])
+		lo = lo<<8 | uint64(b[i+8])
+	}
+	var out [26]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// decodeCrockford 返回字符的值，不区分大小写，不是合法字符时返回 -1
+func decodeCrockford(c byte) int {
+	if 'a' <= c && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,424 @@
+// Package jobs 基于redis的后台任务队列：任务按名称分队列，由按类型注册的处理函数执行。
+// worker 用 BRPOPLPUSH 把任务移入处理中列表并设置可见性超时，处理完成才删除，
+// worker 崩溃或超时的任务会重新排队，因此每个任务至少执行一次，处理函数需要是幂等的。
+// 失败的任务按指数退避放入延迟队列重试，达到最大次数后进入死信队列，可以通过内部接口查看和重新排队。
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/idgen"
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/utils"
+	"github.com/saisai/gindemo/utils/log"
+)
+
+const DEFAULT_QUEUE = "default"
+
+var (
+	// ErrDisabled 任务队列没有启用，如缓存使用进程内后端时
+	ErrDisabled = errors.New("jobs: job queue is not enabled")
+	// ErrUnknownQueue 队列没有配置
+	ErrUnknownQueue = errors.New("jobs: unknown queue")
+)
+
+// Job 一个任务
+type Job struct {
+	Id          string          `json:"id"`
+	Queue       string          `json:"queue"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"` // 已开始执行的次数，包括当前这次
+	MaxAttempts int             `json:"max_attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	FailedAt    time.Time       `json:"failed_at"` // 进入死信队列的时间，未进入时为零值
+}
+
+// Handler 任务处理函数，返回错误时按退避重试，ctx 在可见性超时或服务停止时取消
+type Handler func(ctx context.Context, job *Job) error
+
+// HandleFunc 把处理 T 类型参数的函数包装为 Handler，参数无法解析时任务直接进入死信队列
+func HandleFunc[T any](fn func(ctx context.Context, payload T) error) Handler {
+	return func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return Permanent(fmt.Errorf("invalid payload: %v", err))
+		}
+		return fn(ctx, payload)
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent 标记不需要重试的错误，任务直接进入死信队列
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Options 队列配置
+type Options struct {
+	Queues            map[string]int // 队列名 -> worker数
+	VisibilityTimeout time.Duration  // 任务开始执行后多久没有完成视为失败并重新排队
+	MaxAttempts       int            // 包括第一次在内的最大执行次数，入队时可以单独指定
+	RetryBackoff      time.Duration  // 第一次重试前的等待时间，之后每次翻倍
+	RetryMaxBackoff   time.Duration
+	PollInterval      time.Duration // 把到期的延迟任务和超时的任务放回队列的间隔
+	DeadRetention     time.Duration // 死信队列中任务的保留时间，0 表示一直保留
+}
+
+// EnqueueOptions 入队参数，nil 表示放入 default 队列立即执行
+type EnqueueOptions struct {
+	Queue       string
+	Delay       time.Duration // 延迟执行
+	At          time.Time     // 定时执行，与 Delay 同时设置时取较晚的时间
+	MaxAttempts int           // 0 表示使用队列的配置
+}
+
+// Stats 一个队列的任务数
+type Stats struct {
+	Queue      string `json:"queue"`
+	Workers    int    `json:"workers"`
+	Ready      int64  `json:"ready"`
+	Processing int64  `json:"processing"`
+	Delayed    int64  `json:"delayed"` // 包括等待重试的任务
+	Dead       int64  `json:"dead"`
+}
+
+// Manager 管理各队列的worker
+type Manager struct {
+	opts  Options
+	store *store
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	ctx    context.Context // 处理函数的父context，停止超时后取消
+	cancel context.CancelFunc
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+var manager *Manager
+
+// NewManager 创建任务队列，使用 utils/cache 的redis连接池
+func NewManager(opts Options) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		opts:     opts,
+		store:    &store{},
+		handlers: make(map[string]Handler),
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Init 创建默认任务队列，处理函数需要在 Start 之前注册
+func Init(opts Options) {
+	manager = NewManager(opts)
+}
+
+// Start 启动默认任务队列的worker
+func Start() error {
+	if manager == nil {
+		return nil
+	}
+	manager.Start()
+	return nil
+}
+
+// Stop 停止默认任务队列，见 Manager.Stop
+func Stop(ctx context.Context) error {
+	if manager == nil {
+		return nil
+	}
+	return manager.Stop(ctx)
+}
+
+// Handle 在默认任务队列上注册任务类型的处理函数，未启用时忽略
+func Handle(typ string, h Handler) {
+	if manager == nil {
+		return
+	}
+	manager.Handle(typ, h)
+}
+
+// Enqueue 把任务放入默认任务队列，返回任务ID
+func Enqueue(ctx context.Context, typ string, payload interface{}, opts *EnqueueOptions) (string, error) {
+	if manager == nil {
+		return "", ErrDisabled
+	}
+	return manager.Enqueue(ctx, typ, payload, opts)
+}
+
+// QueueStats 返回默认任务队列各队列的任务数
+func QueueStats(ctx context.Context) ([]Stats, error) {
+	if manager == nil {
+		return nil, ErrDisabled
+	}
+	return manager.Stats(ctx)
+}
+
+// DeadJobs 返回默认任务队列中死信队列最近的 limit 个任务
+func DeadJobs(ctx context.Context, queue string, limit int) ([]Job, error) {
+	if manager == nil {
+		return nil, ErrDisabled
+	}
+	return manager.DeadJobs(ctx, queue, limit)
+}
+
+// Requeue 把死信队列中的任务重新排队，任务不存在时返回false
+func Requeue(ctx context.Context, queue string, id string) (bool, error) {
+	if manager == nil {
+		return false, ErrDisabled
+	}
+	return manager.Requeue(ctx, queue, id)
+}
+
+// DeleteDead 删除死信队列中的任务，任务不存在时返回false
+func DeleteDead(ctx context.Context, queue string, id string) (bool, error) {
+	if manager == nil {
+		return false, ErrDisabled
+	}
+	return manager.DeleteDead(ctx, queue, id)
+}
+
+func (m *Manager) Start() {
+	m.wg.Add(1)
+	go m.scheduler()
+	for queue, workers := range m.opts.Queues {
+		for i := 0; i < workers; i++ {
+			m.wg.Add(1)
+			go m.worker(queue)
+		}
+	}
+}
+
+// Stop 停止领取新任务并等待执行中的任务完成；ctx 超时后取消执行中的任务，
+// 这些任务在可见性超时后会重新排队
+func (m *Manager) Stop(ctx context.Context) error {
+	close(m.stop)
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		m.cancel()
+		return nil
+	case <-ctx.Done():
+		m.cancel()
+		<-done
+		return fmt.Errorf("jobs: %v, running jobs cancelled and will be retried after the visibility timeout", ctx.Err())
+	}
+}
+
+func (m *Manager) Handle(typ string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[typ] = h
+}
+
+func (m *Manager) handler(typ string) Handler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.handlers[typ]
+}
+
+func (m *Manager) Enqueue(ctx context.Context, typ string, payload interface{}, opts *EnqueueOptions) (string, error) {
+	if opts == nil {
+		opts = &EnqueueOptions{}
+	}
+	queue := opts.Queue
+	if queue == "" {
+		queue = DEFAULT_QUEUE
+	}
+	if _, ok := m.opts.Queues[queue]; !ok {
+		return "", ErrUnknownQueue
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	job := &Job{
+		Id:          idgen.New(),
+		Queue:       queue,
+		Type:        typ,
+		Payload:     data,
+		MaxAttempts: opts.MaxAttempts,
+		CreatedAt:   time.Now(),
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = m.opts.MaxAttempts
+	}
+
+	var runAt time.Time
+	if opts.Delay > 0 {
+		runAt = job.CreatedAt.Add(opts.Delay)
+	}
+	if opts.At.After(runAt) {
+		runAt = opts.At
+	}
+	if err := m.store.enqueue(job, runAt); err != nil {
+		return "", err
+	}
+	log.DebugCtx(ctx, "[jobs] enqueued", "queue", queue, "type", typ, "job_id", job.Id, "run_at", runAt)
+	return job.Id, nil
+}
+
+func (m *Manager) Stats(ctx context.Context) ([]Stats, error) {
+	queues := make([]string, 0, len(m.opts.Queues))
+	for queue := range m.opts.Queues {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+
+	list := make([]Stats, 0, len(queues))
+	for _, queue := range queues {
+		s, err := m.store.stats(queue)
+		if err != nil {
+			return nil, err
+		}
+		s.Workers = m.opts.Queues[queue]
+		list = append(list, *s)
+	}
+	return list, nil
+}
+
+func (m *Manager) DeadJobs(ctx context.Context, queue string, limit int) ([]Job, error) {
+	if _, ok := m.opts.Queues[queue]; !ok {
+		return nil, ErrUnknownQueue
+	}
+	return m.store.dead(queue, limit)
+}
+
+func (m *Manager) Requeue(ctx context.Context, queue string, id string) (bool, error) {
+	if _, ok := m.opts.Queues[queue]; !ok {
+		return false, ErrUnknownQueue
+	}
+	return m.store.requeue(queue, id)
+}
+
+func (m *Manager) DeleteDead(ctx context.Context, queue string, id string) (bool, error) {
+	if _, ok := m.opts.Queues[queue]; !ok {
+		return false, ErrUnknownQueue
+	}
+	return m.store.deleteDead(queue, id)
+}
+
+// scheduler 定期把到期的延迟任务放入队列，把可见性超时的任务重新排队
+func (m *Manager) scheduler() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for queue := range m.opts.Queues {
+			if _, err := m.store.promote(queue, time.Now()); err != nil {
+				log.Error("[jobs] promote delayed jobs failed", "queue", queue, "error", err)
+			}
+			n, err := m.store.reap(queue, time.Now(), m.opts.VisibilityTimeout, m.opts.DeadRetention)
+			if err != nil {
+				log.Error("[jobs] requeue timed out jobs failed", "queue", queue, "error", err)
+			}
+			if n > 0 {
+				log.Warn("[jobs] jobs timed out", "queue", queue, "count", n)
+			}
+		}
+	}
+}
+
+func (m *Manager) worker(queue string) {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		job, err := m.store.take(queue, time.Now().Add(m.opts.VisibilityTimeout))
+		if err != nil {
+			log.Error("[jobs] take job failed", "queue", queue, "error", err)
+			select {
+			case <-time.After(m.opts.PollInterval):
+			case <-m.stop:
+				return
+			}
+			continue
+		}
+		if job != nil {
+			m.run(job)
+		}
+	}
+}
+
+// run 执行任务并记录结果：成功时删除任务，失败时按退避重试或放入死信队列
+func (m *Manager) run(job *Job) {
+	ctx, cancel := context.WithTimeout(m.ctx, m.opts.VisibilityTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := m.call(ctx, job)
+	if err == nil {
+		if _, err := m.store.ack(job); err != nil {
+			log.Error("[jobs] ack job failed", "queue", job.Queue, "type", job.Type, "job_id", job.Id, "error", err)
+		}
+		metrics.Job(job.Queue, job.Type, "success")
+		log.Debug("[jobs] done", "queue", job.Queue, "type", job.Type, "job_id", job.Id,
+			"duration", time.Since(start).String())
+		return
+	}
+
+	var retryAt time.Time
+	result := "dead"
+	var permanent permanentError
+	if !errors.As(err, &permanent) && job.Attempts < job.MaxAttempts {
+		retryAt = time.Now().Add(utils.Backoff(job.Attempts, m.opts.RetryBackoff, m.opts.RetryMaxBackoff))
+		result = "retry"
+	}
+	log.Warn("[jobs] job failed", "queue", job.Queue, "type", job.Type, "job_id", job.Id,
+		"attempts", job.Attempts, "result", result, "error", err)
+	if _, err := m.store.fail(job, utils.Truncate(err.Error(), 500), retryAt, m.opts.DeadRetention); err != nil {
+		log.Error("[jobs] save job failure failed", "queue", job.Queue, "job_id", job.Id, "error", err)
+	}
+	metrics.Job(job.Queue, job.Type, result)
+}
+
+func (m *Manager) call(ctx context.Context, job *Job) (err error) {
+	h := m.handler(job.Type)
+	if h == nil {
+		return Permanent(fmt.Errorf("no handler for job type %q", job.Type))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("[jobs] handler panic", "type", job.Type, "job_id", job.Id, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
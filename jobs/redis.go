@@ -0,0 +1,392 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/saisai/gindemo/utils/cache"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// 一个队列的key使用同一个hash tag，在redis cluster中位于同一个slot：
+//
+//	jobs:{<queue>}:ready       LIST 等待执行的任务ID，LPUSH 入队，BRPOPLPUSH 出队
+//	jobs:{<queue>}:processing  LIST 执行中的任务ID
+//	jobs:{<queue>}:leases      ZSET 执行中任务的可见性超时时间（毫秒）
+//	jobs:{<queue>}:delayed     ZSET 延迟执行和等待重试的任务，score 为执行时间（毫秒）
+//	jobs:{<queue>}:dead        LIST 死信队列
+//	jobs:{<queue>}:job:<id>    HASH 任务内容
+const KEY_PREFIX = "jobs:"
+
+// reapLimit 每次最多重新排队的超时任务数
+const reapLimit = 1000
+
+// takeTimeout BRPOPLPUSH 的阻塞时间，也是停止时worker退出的最长等待时间
+const takeTimeout = 1
+
+func queueKey(queue string, kind string) string {
+	return cache.Key(KEY_PREFIX + "{" + queue + "}:" + kind)
+}
+
+func jobPrefix(queue string) string {
+	return cache.Key(KEY_PREFIX + "{" + queue + "}:job:")
+}
+
+// enqueueScript KEYS: job, ready, delayed
+// ARGV: id, queue, type, payload, max_attempts, created_at(ms), run_at(ms，0 表示立即执行)
+var enqueueScript = redis.NewScript(3, `
+redis.call('HMSET', KEYS[1], 'id', ARGV[1], 'queue', ARGV[2], 'type', ARGV[3], 'payload', ARGV[4],
+	'attempts', 0, 'max_attempts', ARGV[5], 'created_at', ARGV[6])
+if tonumber(ARGV[7]) > 0 then
+	redis.call('ZADD', KEYS[3], ARGV[7], ARGV[1])
+else
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+end
+return 1
+`)
+
+// claimScript 出队后增加执行次数并设置可见性超时，任务已被删除时从处理中列表移除
+// KEYS: job, processing, leases  ARGV: id, deadline(ms)
+// 返回: 任务的 HGETALL，任务不存在时为空
+var claimScript = redis.NewScript(3, `
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('LREM', KEYS[2], 1, ARGV[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	return {}
+end
+redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+redis.call('ZADD', KEYS[3], ARGV[2], ARGV[1])
+return redis.call('HGETALL', KEYS[1])
+`)
+
+// ackScript 执行次数与领取时一致才删除任务，超时后已被重新领取的任务不受影响
+// KEYS: job, processing, leases  ARGV: id, attempts
+var ackScript = redis.NewScript(3, `
+if tonumber(redis.call('HGET', KEYS[1], 'attempts')) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('LREM', KEYS[2], 1, ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// failScript 记录失败原因，retry_at 大于0时放入延迟队列，否则放入死信队列
+// KEYS: job, processing, leases, delayed, dead
+// ARGV: id, attempts, error, retry_at(ms), now(ms), dead_ttl(秒，0 表示不过期)
+var failScript = redis.NewScript(5, `
+if tonumber(redis.call('HGET', KEYS[1], 'attempts')) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('LREM', KEYS[2], 1, ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HSET', KEYS[1], 'last_error', ARGV[3])
+if tonumber(ARGV[4]) > 0 then
+	redis.call('ZADD', KEYS[4], ARGV[4], ARGV[1])
+else
+	redis.call('HSET', KEYS[1], 'failed_at', ARGV[5])
+	redis.call('LPUSH', KEYS[5], ARGV[1])
+	if tonumber(ARGV[6]) > 0 then
+		redis.call('EXPIRE', KEYS[1], ARGV[6])
+	end
+end
+return 1
+`)
+
+// promoteScript 把到期的延迟任务放入等待队列
+// KEYS: delayed, ready  ARGV: now(ms), limit
+var promoteScript = redis.NewScript(2, `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('LPUSH', KEYS[2], id)
+end
+return #ids
+`)
+
+// reapScript 把一个可见性超时的任务重新排队，执行次数已用完的放入死信队列。
+// 任务由调用方从 leases 中查出，每个任务单独执行一次，脚本只访问声明的key，可以在 redis cluster 中运行
+// KEYS: leases, processing, ready, dead, job
+// ARGV: id, now(ms), dead_ttl(秒)
+var reapScript = redis.NewScript(5, `
+local deadline = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not deadline or tonumber(deadline) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+if redis.call('LREM', KEYS[2], 1, ARGV[1]) == 0 or redis.call('EXISTS', KEYS[5]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[5], 'last_error', 'visibility timeout')
+local attempts = tonumber(redis.call('HGET', KEYS[5], 'attempts')) or 0
+local max = tonumber(redis.call('HGET', KEYS[5], 'max_attempts')) or 0
+if attempts >= max then
+	redis.call('HSET', KEYS[5], 'failed_at', ARGV[2])
+	redis.call('LPUSH', KEYS[4], ARGV[1])
+	if tonumber(ARGV[3]) > 0 then
+		redis.call('EXPIRE', KEYS[5], ARGV[3])
+	end
+else
+	redis.call('LPUSH', KEYS[3], ARGV[1])
+end
+return 1
+`)
+
+// orphanScript 出队后还没来得及设置超时的任务（worker在两步之间崩溃）补上超时时间，到期后由 reapScript 重新排队
+// KEYS: leases, processing  ARGV: orphan_deadline(ms)
+var orphanScript = redis.NewScript(2, `
+local n = 0
+for _, id in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+	if not redis.call('ZSCORE', KEYS[1], id) then
+		redis.call('ZADD', KEYS[1], ARGV[1], id)
+		n = n + 1
+	end
+end
+return n
+`)
+
+// requeueScript 把死信队列中的任务重置执行次数后放入等待队列
+// KEYS: dead, ready, job  ARGV: id
+var requeueScript = redis.NewScript(3, `
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 or redis.call('EXISTS', KEYS[3]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[3], 'attempts', 0)
+redis.call('HDEL', KEYS[3], 'failed_at')
+redis.call('PERSIST', KEYS[3])
+redis.call('LPUSH', KEYS[2], ARGV[1])
+return 1
+`)
+
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMilliseconds(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// store 任务在redis中的存储，使用 utils/cache 的连接池
+type store struct{}
+
+func (s *store) conn() (redis.Conn, error) {
+	conn := cache.Get()
+	if conn == nil {
+		return nil, fmt.Errorf("redis pool is not initialized")
+	}
+	return conn, nil
+}
+
+func (s *store) enqueue(job *Job, runAt time.Time) error {
+	conn, err := s.conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var at int64
+	if !runAt.IsZero() {
+		at = milliseconds(runAt)
+	}
+	_, err = enqueueScript.Do(conn, jobPrefix(job.Queue)+job.Id, queueKey(job.Queue, "ready"), queueKey(job.Queue, "delayed"),
+		job.Id, job.Queue, job.Type, []byte(job.Payload), job.MaxAttempts, milliseconds(job.CreatedAt), at)
+	return err
+}
+
+// take 阻塞最多 takeTimeout 秒等待一个任务，没有任务时返回nil
+func (s *store) take(queue string, deadline time.Time) (*Job, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	id, err := redis.String(conn.Do("BRPOPLPUSH", queueKey(queue, "ready"), queueKey(queue, "processing"), takeTimeout))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := redis.StringMap(claimScript.Do(conn, jobPrefix(queue)+id, queueKey(queue, "processing"),
+		queueKey(queue, "leases"), id, milliseconds(deadline)))
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	return decodeJob(fields), nil
+}
+
+func decodeJob(fields map[string]string) *Job {
+	job := &Job{
+		Id:        fields["id"],
+		Queue:     fields["queue"],
+		Type:      fields["type"],
+		Payload:   []byte(fields["payload"]),
+		LastError: fields["last_error"],
+	}
+	job.Attempts, _ = strconv.Atoi(fields["attempts"])
+	job.MaxAttempts, _ = strconv.Atoi(fields["max_attempts"])
+	created, _ := strconv.ParseInt(fields["created_at"], 10, 64)
+	job.CreatedAt = fromMilliseconds(created)
+	failed, _ := strconv.ParseInt(fields["failed_at"], 10, 64)
+	job.FailedAt = fromMilliseconds(failed)
+	return job
+}
+
+func (s *store) ack(job *Job) (bool, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	return redis.Bool(ackScript.Do(conn, jobPrefix(job.Queue)+job.Id, queueKey(job.Queue, "processing"),
+		queueKey(job.Queue, "leases"), job.Id, job.Attempts))
+}
+
+// fail retryAt 为零值时放入死信队列
+func (s *store) fail(job *Job, reason string, retryAt time.Time, deadTTL time.Duration) (bool, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var at int64
+	if !retryAt.IsZero() {
+		at = milliseconds(retryAt)
+	}
+	return redis.Bool(failScript.Do(conn, jobPrefix(job.Queue)+job.Id, queueKey(job.Queue, "processing"),
+		queueKey(job.Queue, "leases"), queueKey(job.Queue, "delayed"), queueKey(job.Queue, "dead"),
+		job.Id, job.Attempts, reason, at, milliseconds(time.Now()), int64(deadTTL/time.Second)))
+}
+
+func (s *store) promote(queue string, now time.Time) (int, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return redis.Int(promoteScript.Do(conn, queueKey(queue, "delayed"), queueKey(queue, "ready"), milliseconds(now), 1000))
+}
+
+func (s *store) reap(queue string, now time.Time, visibility time.Duration, deadTTL time.Duration) (int, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	leases, processing := queueKey(queue, "leases"), queueKey(queue, "processing")
+	ids, err := redis.Strings(conn.Do("ZRANGEBYSCORE", leases, "-inf", milliseconds(now), "LIMIT", 0, reapLimit))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		ok, err := redis.Bool(reapScript.Do(conn, leases, processing, queueKey(queue, "ready"), queueKey(queue, "dead"),
+			jobPrefix(queue)+id, id, milliseconds(now), int64(deadTTL/time.Second)))
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+
+	_, err = orphanScript.Do(conn, leases, processing, milliseconds(now.Add(visibility)))
+	return n, err
+}
+
+func (s *store) stats(queue string) (*Stats, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.Send("LLEN", queueKey(queue, "ready"))
+	conn.Send("LLEN", queueKey(queue, "processing"))
+	conn.Send("ZCARD", queueKey(queue, "delayed"))
+	conn.Send("LLEN", queueKey(queue, "dead"))
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	counts := make([]int64, 4)
+	for i := range counts {
+		if counts[i], err = redis.Int64(conn.Receive()); err != nil {
+			return nil, err
+		}
+	}
+	return &Stats{Queue: queue, Ready: counts[0], Processing: counts[1], Delayed: counts[2], Dead: counts[3]}, nil
+}
+
+// dead 返回最近进入死信队列的 limit 个任务，顺带移除已过期的任务ID
+func (s *store) dead(queue string, limit int) ([]Job, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("LRANGE", queueKey(queue, "dead"), 0, limit-1))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		conn.Send("HGETALL", jobPrefix(queue)+id)
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	list := make([]Job, 0, len(ids))
+	expired := make([]string, 0)
+	for _, id := range ids {
+		fields, err := redis.StringMap(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			expired = append(expired, id)
+			continue
+		}
+		list = append(list, *decodeJob(fields))
+	}
+	for _, id := range expired {
+		conn.Do("LREM", queueKey(queue, "dead"), 1, id)
+	}
+	return list, nil
+}
+
+func (s *store) requeue(queue string, id string) (bool, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	return redis.Bool(requeueScript.Do(conn, queueKey(queue, "dead"), queueKey(queue, "ready"), jobPrefix(queue)+id, id))
+}
+
+func (s *store) deleteDead(queue string, id string) (bool, error) {
+	conn, err := s.conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("LREM", queueKey(queue, "dead"), 1, id))
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = conn.Do("DEL", jobPrefix(queue)+id)
+	return true, err
+}
@@ -0,0 +1,165 @@
+// Package lifecycle 管理各子系统的启动和停止顺序，收到 SIGINT/SIGTERM 后按注册的逆序停止
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/saisai/gindemo/utils/log"
+)
+
+// Hook 子系统的启动和停止函数，Start 不能阻塞，Stop 需在 ctx 超时前返回，二者都可以为空
+type Hook struct {
+	Name  string
+	Start func() error
+	Stop  func(ctx context.Context) error
+}
+
+type Manager struct {
+	mu      sync.Mutex
+	hooks   []Hook
+	started int
+	errs    chan error
+}
+
+func NewManager() *Manager {
+	return &Manager{errs: make(chan error, 1)}
+}
+
+var defaultManager = NewManager()
+
+// Append 向默认Manager注册子系统
+func Append(h Hook) {
+	defaultManager.Append(h)
+}
+
+// AppendServer 向默认Manager注册http服务
+func AppendServer(name string, srv *http.Server) {
+	defaultManager.AppendServer(name, srv)
+}
+
+// Run 启动默认Manager
+func Run(shutdownTimeout time.Duration) error {
+	return defaultManager.Run(shutdownTimeout)
+}
+
+// Append 注册子系统，启动按注册顺序，停止按注册的逆序
+func (m *Manager) Append(h Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = append(m.hooks, h)
+}
+
+// AppendServer 注册http服务，启动时同步监听端口，端口被占用等错误由 Run 返回；
+// 停止时不再接受新连接，并等待处理中的请求完成。srv.TLSConfig 中配置了证书时以https提供服务
+func (m *Manager) AppendServer(name string, srv *http.Server) {
+	m.Append(Hook{
+		Name: name,
+		Start: func() error {
+			addr := srv.Addr
+			if addr == "" {
+				addr = ":http"
+			}
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+			log.Infof("[lifecycle] %s listen on %s", name, ln.Addr())
+			go func() {
+				var err error
+				if srv.TLSConfig != nil && len(srv.TLSConfig.Certificates) > 0 {
+					err = srv.ServeTLS(ln, "", "")
+				} else {
+					err = srv.Serve(ln)
+				}
+				if err != nil && err != http.ErrServerClosed {
+					m.fail(fmt.Errorf("%s: %v", name, err))
+				}
+			}()
+			return nil
+		},
+		Stop: srv.Shutdown,
+	})
+}
+
+// fail 通知Run有子系统异常退出，需要停止服务
+func (m *Manager) fail(err error) {
+	select {
+	case m.errs <- err:
+	default:
+	}
+}
+
+// Run 依次启动所有子系统，阻塞直到收到 SIGINT/SIGTERM 或有子系统异常退出，
+// 然后在 shutdownTimeout 内依次停止已启动的子系统
+func (m *Manager) Run(shutdownTimeout time.Duration) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	var runErr error
+	if err := m.start(); err != nil {
+		runErr = err
+	} else {
+		select {
+		case sig := <-sigs:
+			log.Infof("[lifecycle] received %s, shutting down", sig)
+		case runErr = <-m.errs:
+			log.Errorf("[lifecycle] %v, shutting down", runErr)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := m.stop(ctx); err != nil && runErr == nil {
+		runErr = err
+	}
+	return runErr
+}
+
+func (m *Manager) start() error {
+	m.mu.Lock()
+	hooks := append([]Hook{}, m.hooks...)
+	m.mu.Unlock()
+
+	for _, h := range hooks {
+		if h.Start != nil {
+			if err := h.Start(); err != nil {
+				return fmt.Errorf("start %s: %v", h.Name, err)
+			}
+		}
+		m.started++
+		log.Infof("[lifecycle] %s started", h.Name)
+	}
+	return nil
+}
+
+func (m *Manager) stop(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]Hook{}, m.hooks[:m.started]...)
+	m.mu.Unlock()
+
+	var firstErr error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		if h.Stop == nil {
+			continue
+		}
+		if err := h.Stop(ctx); err != nil {
+			log.Errorf("[lifecycle] stop %s: %v", h.Name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("stop %s: %v", h.Name, err)
+			}
+			continue
+		}
+		log.Infof("[lifecycle] %s stopped", h.Name)
+	}
+	return firstErr
+}
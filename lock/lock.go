@@ -0,0 +1,230 @@
+// Package lock 分布式锁：获取时写入随机的持有者token，只有持有者能释放和续期，
+// 持有期间由后台goroutine自动续期，每次获取返回单调递增的fencing token。
+// redis 后端通过 SET NX PX 和 Lua 脚本保证原子性，也可以使用多个独立redis节点的 Redlock，
+// 缓存使用进程内后端时退回到进程内锁，只在单个实例内互斥。
+package lock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/utils"
+	"github.com/saisai/gindemo/utils/log"
+)
+
+const KEY_PREFIX = "lock:"
+
+var (
+	// ErrNotAcquired 在等待时间内没有获取到锁
+	ErrNotAcquired = errors.New("lock: not acquired")
+	// ErrNotHeld 锁已过期或已被其他持有者获取
+	ErrNotHeld = errors.New("lock: not held")
+)
+
+// Backend 锁的存储，key 不含 KEY_PREFIX
+type Backend interface {
+	// Acquire key 不存在时写入 token 并设置 ttl，成功时返回递增的fencing token
+	Acquire(ctx context.Context, key string, token string, ttl time.Duration) (fence int64, ok bool, err error)
+	// Release key 的值为 token 时删除 key
+	Release(ctx context.Context, key string, token string) (bool, error)
+	// Extend key 的值为 token 时把过期时间重置为 ttl
+	Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error)
+}
+
+// Options 获取锁的参数
+type Options struct {
+	TTL        time.Duration // 锁的过期时间，持有者崩溃后最多这么久锁自动释放，默认 30秒
+	Wait       time.Duration // 获取不到时的最长等待时间，0 表示只尝试一次
+	RetryDelay time.Duration // 两次尝试之间的间隔，会加入随机抖动，默认 100毫秒
+	NoRenew    bool          // 不自动续期，持有时间超过 TTL 后锁会被其他人获取
+}
+
+var backend Backend = NewMemory()
+
+// Use 设置使用的锁后端
+func Use(b Backend) {
+	backend = b
+}
+
+// Close 关闭默认后端自己创建的连接，如 Redlock 各节点的连接池
+func Close(ctx context.Context) error {
+	if c, ok := backend.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Lock 一次成功获取的锁
+type Lock struct {
+	backend Backend
+	key     string
+	token   string
+	fence   int64
+	ttl     time.Duration
+
+	lost   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	closed bool
+}
+
+// Acquire 使用默认后端获取锁，见 AcquireWith
+func Acquire(ctx context.Context, key string, opts Options) (*Lock, error) {
+	return AcquireWith(ctx, backend, key, opts)
+}
+
+// AcquireWith 获取锁，在 opts.Wait 内获取不到时返回 ErrNotAcquired。
+// 自动续期持续到 Release 或 ctx 结束；ctx 结束后不再续期，锁在 TTL 后过期，持有者仍应调用 Release。
+func AcquireWith(ctx context.Context, b Backend, key string, opts Options) (*Lock, error) {
+	if opts.TTL <= 0 {
+		opts.TTL = 30 * time.Second
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 100 * time.Millisecond
+	}
+
+	token := utils.GetToken()
+	deadline := time.Now().Add(opts.Wait)
+	for {
+		fence, ok, err := b.Acquire(ctx, key, token, opts.TTL)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			l := &Lock{
+				backend: b,
+				key:     key,
+				token:   token,
+				fence:   fence,
+				ttl:     opts.TTL,
+				lost:    make(chan struct{}),
+				stop:    make(chan struct{}),
+				done:    make(chan struct{}),
+			}
+			if opts.NoRenew {
+				close(l.done)
+			} else {
+				go l.renew(log.Detach(ctx), ctx.Done())
+			}
+			return l, nil
+		}
+
+		wait := opts.RetryDelay/2 + time.Duration(rand.Int63n(int64(opts.RetryDelay)))
+		if time.Now().Add(wait).After(deadline) {
+			return nil, ErrNotAcquired
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Key 锁的key
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Fence 获取锁时分配的fencing token，每次获取同一个key都比上一次大。
+// 写共享资源时带上它，资源方拒绝比已见过的更小的值，可以防止锁过期后旧持有者的写入。
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Lost 续期失败、确认锁已不再持有时关闭，持有者应停止对共享资源的操作
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Extend 手动把过期时间重置为 ttl，之后的自动续期也使用这个 ttl
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	ok, err := l.backend.Extend(ctx, l.key, l.token, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		l.markLost()
+		return ErrNotHeld
+	}
+	l.mu.Lock()
+	l.ttl = ttl
+	l.mu.Unlock()
+	return nil
+}
+
+// Release 停止续期并释放锁，锁已过期或被其他人获取时返回 ErrNotHeld
+func (l *Lock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.stop)
+	}
+	l.mu.Unlock()
+	<-l.done
+
+	ok, err := l.backend.Release(ctx, l.key, l.token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+func (l *Lock) markLost() {
+	l.once.Do(func() { close(l.lost) })
+}
+
+// renew 每隔 TTL/3 续期一次；续期出错时继续重试，直到锁按上次成功续期的时间已经过期
+func (l *Lock) renew(ctx context.Context, cancel <-chan struct{}) {
+	defer close(l.done)
+
+	l.mu.Lock()
+	ttl := l.ttl
+	l.mu.Unlock()
+	expires := time.Now().Add(ttl)
+
+	for {
+		timer := time.NewTimer(ttl / 3)
+		select {
+		case <-timer.C:
+		case <-l.stop:
+			timer.Stop()
+			return
+		case <-cancel:
+			timer.Stop()
+			return
+		}
+
+		l.mu.Lock()
+		ttl = l.ttl
+		l.mu.Unlock()
+
+		start := time.Now()
+		ok, err := l.backend.Extend(ctx, l.key, l.token, ttl)
+		switch {
+		case err == nil && ok:
+			expires = start.Add(ttl)
+		case err == nil:
+			log.WarnCtx(ctx, "[lock] lost", "key", l.key, "fence", l.fence)
+			l.markLost()
+			return
+		case time.Now().After(expires):
+			log.WarnCtx(ctx, "[lock] renew failed, lock expired", "key", l.key, "fence", l.fence, "error", err)
+			l.markLost()
+			return
+		default:
+			log.WarnCtx(ctx, "[lock] renew failed, retrying", "key", l.key, "error", err)
+		}
+	}
+}
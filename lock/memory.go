@@ -0,0 +1,73 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	token   string
+	expires time.Time
+}
+
+// memoryBackend 进程内锁，仅在当前实例内互斥，用于缓存使用进程内后端时
+type memoryBackend struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	fences  map[string]int64
+}
+
+// NewMemory 创建进程内锁后端
+func NewMemory() Backend {
+	return &memoryBackend{
+		entries: make(map[string]*memoryEntry),
+		fences:  make(map[string]int64),
+	}
+}
+
+// held 返回未过期的锁，已过期的锁会被删除
+func (m *memoryBackend) held(key string) *memoryEntry {
+	e := m.entries[key]
+	if e != nil && time.Now().After(e.expires) {
+		delete(m.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (m *memoryBackend) Acquire(ctx context.Context, key string, token string, ttl time.Duration) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.held(key) != nil {
+		return 0, false, nil
+	}
+	m.entries[key] = &memoryEntry{token: token, expires: time.Now().Add(ttl)}
+	m.fences[key]++
+	return m.fences[key], true, nil
+}
+
+func (m *memoryBackend) Release(ctx context.Context, key string, token string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.held(key)
+	if e == nil || e.token != token {
+		return false, nil
+	}
+	delete(m.entries, key)
+	return true, nil
+}
+
+func (m *memoryBackend) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.held(key)
+	if e == nil || e.token != token {
+		return false, nil
+	}
+	e.expires = time.Now().Add(ttl)
+	return true, nil
+}
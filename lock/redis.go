@@ -0,0 +1,242 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/saisai/gindemo/utils/cache"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// acquireScript 锁不存在时写入token，并递增fencing计数
+// KEYS: lock, fence  ARGV: token, ttl(ms)
+// 返回: fencing token，未获取到返回0
+var acquireScript = redis.NewScript(2, `
+if redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2], 'NX') then
+	return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+// releaseScript 锁的值为token时删除
+// KEYS: lock  ARGV: token
+var releaseScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// extendScript 锁的值为token时重置过期时间
+// KEYS: lock  ARGV: token, ttl(ms)
+var extendScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// lockKey 锁和fencing计数使用同一个hash tag，在redis cluster中位于同一个slot
+func lockKey(key string) string {
+	return cache.Key(KEY_PREFIX + "{" + key + "}")
+}
+
+// fenceKey fencing计数不过期
+func fenceKey(key string) string {
+	return cache.Key(KEY_PREFIX + "{" + key + "}:fence")
+}
+
+func milliseconds(d time.Duration) int64 {
+	ms := int64(d / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	return ms
+}
+
+func acquireOn(conn redis.Conn, key string, token string, ttl time.Duration) (int64, bool, error) {
+	fence, err := redis.Int64(acquireScript.Do(conn, lockKey(key), fenceKey(key), token, milliseconds(ttl)))
+	if err != nil {
+		return 0, false, err
+	}
+	return fence, fence > 0, nil
+}
+
+func releaseOn(conn redis.Conn, key string, token string) (bool, error) {
+	return redis.Bool(releaseScript.Do(conn, lockKey(key), token))
+}
+
+func extendOn(conn redis.Conn, key string, token string, ttl time.Duration) (bool, error) {
+	return redis.Bool(extendScript.Do(conn, lockKey(key), token, milliseconds(ttl)))
+}
+
+// redisBackend 单个redis的锁，使用 utils/cache 的连接池
+type redisBackend struct{}
+
+// NewRedis 创建使用 utils/cache 连接池的锁后端
+func NewRedis() Backend {
+	return redisBackend{}
+}
+
+func (redisBackend) conn() (redis.Conn, error) {
+	conn := cache.Get()
+	if conn == nil {
+		return nil, fmt.Errorf("redis pool is not initialized")
+	}
+	return conn, nil
+}
+
+func (r redisBackend) Acquire(ctx context.Context, key string, token string, ttl time.Duration) (int64, bool, error) {
+	conn, err := r.conn()
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+	return acquireOn(conn, key, token, ttl)
+}
+
+func (r redisBackend) Release(ctx context.Context, key string, token string) (bool, error) {
+	conn, err := r.conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	return releaseOn(conn, key, token)
+}
+
+func (r redisBackend) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	conn, err := r.conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	return extendOn(conn, key, token, ttl)
+}
+
+// redlock 在多个独立的redis节点上加锁，多数节点成功且耗时小于有效期时才算获取成功，
+// 单个节点故障不影响锁的可用性。fencing token 取各节点计数的最大值，节点故障恢复后可能不再严格递增。
+type redlock struct {
+	pools []*redis.Pool
+}
+
+// NewRedlock 创建使用多个独立redis节点的锁后端，urls 为各节点的 redis:// 地址，timeout 为单个节点的读写超时
+func NewRedlock(urls []string, timeout time.Duration) Backend {
+	r := &redlock{pools: make([]*redis.Pool, 0, len(urls))}
+	for _, u := range urls {
+		u := u
+		r.pools = append(r.pools, &redis.Pool{
+			MaxIdle:     4,
+			IdleTimeout: time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(u,
+					redis.DialConnectTimeout(timeout),
+					redis.DialReadTimeout(timeout),
+					redis.DialWriteTimeout(timeout))
+			},
+		})
+	}
+	return r
+}
+
+func (r *redlock) quorum() int {
+	return len(r.pools)/2 + 1
+}
+
+// each 在所有节点上并发执行 fn，返回成功的节点数、出错的节点数和最后一个错误
+func (r *redlock) each(fn func(conn redis.Conn) (bool, error)) (int, int, error) {
+	type result struct {
+		ok  bool
+		err error
+	}
+	results := make(chan result, len(r.pools))
+	for _, pool := range r.pools {
+		go func(pool *redis.Pool) {
+			conn := pool.Get()
+			defer conn.Close()
+			ok, err := fn(conn)
+			results <- result{ok, err}
+		}(pool)
+	}
+
+	n, failed := 0, 0
+	var lastErr error
+	for range r.pools {
+		res := <-results
+		if res.err != nil {
+			failed++
+			lastErr = res.err
+		} else if res.ok {
+			n++
+		}
+	}
+	return n, failed, lastErr
+}
+
+// unavailable 出错的节点多到不可能达到多数时，结果应视为错误而不是锁被占用
+func (r *redlock) unavailable(failed int) bool {
+	return failed > len(r.pools)-r.quorum()
+}
+
+func (r *redlock) Acquire(ctx context.Context, key string, token string, ttl time.Duration) (int64, bool, error) {
+	start := time.Now()
+	fences := make(chan int64, len(r.pools))
+	n, failed, err := r.each(func(conn redis.Conn) (bool, error) {
+		fence, ok, err := acquireOn(conn, key, token, ttl)
+		if ok {
+			fences <- fence
+		}
+		return ok, err
+	})
+	close(fences)
+
+	// 时钟漂移按有效期的1%加2毫秒估算
+	drift := ttl/100 + 2*time.Millisecond
+	if n >= r.quorum() && time.Since(start)+drift < ttl {
+		var max int64
+		for fence := range fences {
+			if fence > max {
+				max = fence
+			}
+		}
+		return max, true, nil
+	}
+
+	// 没有获取到多数节点，释放已经加上的锁
+	r.each(func(conn redis.Conn) (bool, error) {
+		return releaseOn(conn, key, token)
+	})
+	if r.unavailable(failed) {
+		return 0, false, err
+	}
+	return 0, false, nil
+}
+
+func (r *redlock) Release(ctx context.Context, key string, token string) (bool, error) {
+	n, failed, err := r.each(func(conn redis.Conn) (bool, error) {
+		return releaseOn(conn, key, token)
+	})
+	if n < r.quorum() && r.unavailable(failed) {
+		return false, err
+	}
+	return n >= r.quorum(), nil
+}
+
+func (r *redlock) Extend(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	n, failed, err := r.each(func(conn redis.Conn) (bool, error) {
+		return extendOn(conn, key, token, ttl)
+	})
+	if n < r.quorum() && r.unavailable(failed) {
+		return false, err
+	}
+	return n >= r.quorum(), nil
+}
+
+// Close 关闭各节点的连接池
+func (r *redlock) Close() error {
+	for _, pool := range r.pools {
+		pool.Close()
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package maintenance
+
+import (
+	"context"
+	"strings"
+
+	"github.com/saisai/gindemo/common"
+	"github.com/saisai/gindemo/config"
+	"github.com/saisai/gindemo/idgen"
+	"github.com/saisai/gindemo/models"
+	"github.com/saisai/gindemo/utils/cache"
+	"github.com/saisai/gindemo/utils/captcha"
+	"github.com/saisai/gindemo/utils/log"
+)
+
+// 清理缓存中残留的本服务key：已删除用户的token和登录错误计数，以及没有过期时间的key。
+// 只用 SCAN 遍历配置的 key_prefix 之下、格式符合本服务约定的key，不会影响共用同一个redis的其他服务。
+
+// CleanupStats 一类key的清理结果，DryRun 时 Deleted、Expired 为将要处理的数量
+type CleanupStats struct {
+	Kind    string
+	Scanned int64 // 符合格式的key数量
+	Deleted int64 // 用户已不存在或无法补上过期时间而删除的数量
+	Expired int64 // 没有过期时间而补上过期时间的数量
+}
+
+// cleanupRule 一类key的清理规则
+type cleanupRule struct {
+	kind  string
+	match string
+	// userId 从key中取出用户ID，返回空表示不是本服务的key；为 nil 时不检查用户
+	userId func(key string) string
+	// ttl 没有过期时间的key补上的过期时间（秒），0 表示直接删除
+	ttl int
+}
+
+// userIdBefore 取出 <userId><suffix> 格式key中的用户ID
+func userIdBefore(suffix string) func(key string) string {
+	return func(key string) string {
+		id := strings.TrimSuffix(key, suffix)
+		if !idgen.Valid(id) {
+			return ""
+		}
+		return id
+	}
+}
+
+func cleanupRules(cfg *config.Config) []cleanupRule {
+	return []cleanupRule{
+		{kind: "token", match: "*" + common.KEY_TOKEN, userId: userIdBefore(common.KEY_TOKEN), ttl: cfg.Token.TTL},
+		{kind: "login_error_count", match: "*" + common.KEY_LOGIN_ERROR_COUNT, userId: userIdBefore(common.KEY_LOGIN_ERROR_COUNT), ttl: cfg.Login.ErrorWindow},
+		{kind: "captcha", match: captcha.KEY_PREFIX + "*"},
+	}
+}
+
+// CleanupCache 依次清理每类key，dryRun 为 true 时只统计不修改
+func CleanupCache(ctx context.Context, dryRun bool) ([]CleanupStats, error) {
+	rules := cleanupRules(config.Get())
+	result := make([]CleanupStats, 0, len(rules))
+	for _, rule := range rules {
+		stats := CleanupStats{Kind: rule.kind}
+		err := cache.Scan(ctx, cache.ScanOptions{Match: rule.match, Type: cache.TYPE_STRING}, func(keys []string) error {
+			return rule.cleanup(ctx, keys, dryRun, &stats)
+		})
+		log.Info("[cache cleanup]", "kind", stats.Kind, "scanned", stats.Scanned,
+			"deleted", stats.Deleted, "expired", stats.Expired, "dry_run", dryRun)
+		result = append(result, stats)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// cleanup 处理一批key：用户已不存在的删除，没有过期时间的补上过期时间或删除
+func (rule cleanupRule) cleanup(ctx context.Context, keys []string, dryRun bool, stats *CleanupStats) error {
+	owners := make(map[string]string, len(keys))
+	if rule.userId != nil {
+		ids := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if id := rule.userId(key); id != "" {
+				owners[key] = id
+				ids = append(ids, id)
+			}
+		}
+		existing, err := models.ExistingUserIds(ctx, ids)
+		if err != nil {
+			return err
+		}
+		kept := keys[:0]
+		for _, key := range keys {
+			if _, ok := owners[key]; ok {
+				kept = append(kept, key)
+			}
+		}
+		keys = kept
+		for key, id := range owners {
+			if existing[id] {
+				delete(owners, key)
+			}
+		}
+	}
+	stats.Scanned += int64(len(keys))
+
+	// owners 中剩下的是用户已不存在的key
+	var unlink, expire []string
+	for _, key := range keys {
+		if _, ok := owners[key]; ok {
+			unlink = append(unlink, key)
+			continue
+		}
+		ttl, err := cache.Raw.TTL(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ttl != -1 {
+			continue
+		}
+		if rule.ttl > 0 {
+			expire = append(expire, key)
+		} else {
+			unlink = append(unlink, key)
+		}
+	}
+
+	if dryRun {
+		stats.Deleted += int64(len(unlink))
+		stats.Expired += int64(len(expire))
+		return nil
+	}
+	n, err := cache.UnlinkKeys(ctx, unlink)
+	stats.Deleted += n
+	if err != nil {
+		return err
+	}
+	n, err = cache.ExpireKeys(ctx, expire, rule.ttl)
+	stats.Expired += n
+	return err
+}
@@ -0,0 +1,245 @@
+// Package metrics prometheus 指标，由内部接口 /private/api/v1/metrics 暴露
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "usersystem"
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	loginTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "login_total",
+		Help:      "Login attempts by identify type, result and failure reason.",
+	}, []string{"identify_type", "result", "reason"})
+
+	tokenValidationTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "token_validation_total",
+		Help:      "Token validations by caller and result.",
+	}, []string{"source", "result"})
+
+	captchaIssuedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "captcha_issued_total",
+		Help:      "Captchas issued.",
+	})
+
+	rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by the rate limiter by route group.",
+	}, []string{"group"})
+
+	provisionTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "provision_total",
+		Help:      "Downstream provisioning hook runs by hook, event and result.",
+	}, []string{"hook", "event", "result"})
+
+	webhookDeliveryTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by event and resulting state.",
+	}, []string{"event", "state"})
+
+	eventDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "events_dropped_total",
+		Help:      "Domain events not handled asynchronously because the bus queue was full or stopped.",
+	}, []string{"event"})
+
+	jobTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "jobs_total",
+		Help:      "Background job runs by queue, job type and result.",
+	}, []string{"queue", "type", "result"})
+
+	userCacheTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "user_cache_total",
+		Help:      "User info cache lookups by result: hit, negative_hit or miss.",
+	}, []string{"result"})
+
+	cacheCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "cache_command_duration_seconds",
+		Help:      "Redis command latency by command and result.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command", "result"})
+
+	dbReadTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "db_reads_total",
+		Help:      "Read-only queries routed by target: a replica name, primary (no replica available) or fallback (not found on a replica, retried on the primary).",
+	}, []string{"target"})
+
+	dbReplicaLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "db_replica_lag_seconds",
+		Help:      "Replication lag of each replica at the last check, -1 when unknown.",
+	}, []string{"replica"})
+
+	dbReplicaUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "db_replica_up",
+		Help:      "Whether the replica is used for reads (reachable and within the allowed lag).",
+	}, []string{"replica"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		httpRequestDuration,
+		loginTotal,
+		tokenValidationTotal,
+		captchaIssuedTotal,
+		rateLimitedTotal,
+		provisionTotal,
+		webhookDeliveryTotal,
+		eventDroppedTotal,
+		jobTotal,
+		userCacheTotal,
+		cacheCommandDuration,
+		dbReadTotal,
+		dbReplicaLag,
+		dbReplicaUp,
+	)
+}
+
+// Handler 返回 /private/api/v1/metrics 的处理函数
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware 统计每个请求的耗时，route 使用注册的路由模板，未匹配的路由统一记为 unmatched
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.WithLabelValues(ctx.Request.Method, route, strconv.Itoa(ctx.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Login 记录一次登录，reason 为空表示登录成功
+func Login(identifyType string, reason string) {
+	result := "success"
+	if reason != "" {
+		result = "failure"
+	}
+	loginTotal.WithLabelValues(identifyType, result, reason).Inc()
+}
+
+// TokenValidation 记录一次token校验，source 为校验来源，如 api、authentication
+func TokenValidation(source string, ok bool) {
+	result := "valid"
+	if !ok {
+		result = "invalid"
+	}
+	tokenValidationTotal.WithLabelValues(source, result).Inc()
+}
+
+// CaptchaIssued 记录一次验证码发放
+func CaptchaIssued() {
+	captchaIssuedTotal.Inc()
+}
+
+// RateLimited 记录一次被限流拒绝的请求
+func RateLimited(group string) {
+	rateLimitedTotal.WithLabelValues(group).Inc()
+}
+
+// Provision 记录一次下游开通钩子的执行结果，包括重试
+func Provision(hook string, event string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	provisionTotal.WithLabelValues(hook, event, result).Inc()
+}
+
+// WebhookDelivery 记录一次webhook投递，state 为投递后的状态：succeeded、pending（等待重试）或 failed
+func WebhookDelivery(event string, state string) {
+	webhookDeliveryTotal.WithLabelValues(event, state).Inc()
+}
+
+// EventDropped 记录一次因队列已满或总线已停止而丢弃的异步事件处理
+func EventDropped(event string) {
+	eventDroppedTotal.WithLabelValues(event).Inc()
+}
+
+// Job 记录一次后台任务的执行结果：success、retry（等待重试）或 dead（进入死信队列）
+func Job(queue string, typ string, result string) {
+	jobTotal.WithLabelValues(queue, typ, result).Inc()
+}
+
+// UserCache 记录一次用户信息缓存的查询：hit、negative_hit（缓存的不存在）或 miss
+func UserCache(result string) {
+	userCacheTotal.WithLabelValues(result).Inc()
+}
+
+// CacheCommand 记录一次redis命令的耗时
+func CacheCommand(command string, d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	cacheCommandDuration.WithLabelValues(command, result).Observe(d.Seconds())
+}
+
+// RegisterCachePool 注册redis连接池的活跃连接数和空闲连接数
+func RegisterCachePool(active func() int, idle func() int) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: NAMESPACE,
+			Name:      "cache_pool_active_connections",
+			Help:      "Active connections in the redis pool.",
+		}, func() float64 { return float64(active()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: NAMESPACE,
+			Name:      "cache_pool_idle_connections",
+			Help:      "Idle connections in the redis pool.",
+		}, func() float64 { return float64(idle()) }),
+	)
+}
+
+// DBRead 记录一次只读查询发往的库：从库名、primary（没有可用从库）或 fallback（从库没有查到，到主库重查）
+func DBRead(target string) {
+	dbReadTotal.WithLabelValues(target).Inc()
+}
+
+// DBReplica 记录从库最近一次检查的复制延迟（秒，未知为 -1）和是否可用
+func DBReplica(name string, lag float64, up bool) {
+	dbReplicaLag.WithLabelValues(name).Set(lag)
+	v := 0.0
+	if up {
+		v = 1
+	}
+	dbReplicaUp.WithLabelValues(name).Set(v)
+}
+
+// RegisterDB 注册 database/sql 连接池的统计信息
+func RegisterDB(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
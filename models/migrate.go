@@ -0,0 +1,423 @@
+package models
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"github.com/saisai/gindemo/utils/timeutil"
+)
+
+// 迁移文件命名：<版本号>_<名称>.up.sql / <版本号>_<名称>.down.sql，按版本号顺序执行
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const createSchemaMigrations = "CREATE TABLE IF NOT EXISTS `schema_migrations` (" +
+	"`version` bigint(20) NOT NULL," +
+	"`name` varchar(255) NOT NULL," +
+	"`applied_at` datetime NOT NULL," +
+	"PRIMARY KEY (`version`)" +
+	") ENGINE=InnoDB DEFAULT CHARSET=utf8"
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type SchemaMigration struct {
+	Version   int64     `xorm:"bigint pk"`
+	Name      string    `xorm:"varchar(255) not null"`
+	AppliedAt time.Time `xorm:"DateTime not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrations 返回内嵌的全部迁移，按版本号升序
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, name := range names {
+		base := path.Base(name)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: missing .up.sql/.down.sql suffix", base)
+		}
+		stem := strings.TrimSuffix(base, "."+direction+".sql")
+		parts := strings.SplitN(stem, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s: name must be <version>_<name>", base)
+		}
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %v", base, err)
+		}
+
+		content, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		} else if m.Name != parts[1] {
+			return nil, fmt.Errorf("migration %d: conflicting names %s and %s", version, m.Name, parts[1])
+		}
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func appliedMigrations() (map[int64]SchemaMigration, error) {
+	if _, err := DB().Exec(createSchemaMigrations); err != nil {
+		return nil, err
+	}
+
+	rows := make([]SchemaMigration, 0)
+	if err := DB().Find(&rows); err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// MigrationStatus 返回每个迁移的执行状态
+func MigrationStatus() ([]MigrationState, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		row, ok := applied[m.Version]
+		states = append(states, MigrationState{Migration: m, Applied: ok, AppliedAt: row.AppliedAt})
+	}
+	return states, nil
+}
+
+// PendingMigrations 返回未执行的迁移数，只读取 schema_migrations，不会创建该表，用于就绪检查
+func PendingMigrations(ctx context.Context) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	rows := make([]SchemaMigration, 0)
+	if err := DB().Context(ctx).Cols("version").Find(&rows); err != nil {
+		return 0, err
+	}
+	applied := make(map[int64]bool, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = true
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp 按顺序执行所有未执行的迁移，返回本次执行的迁移数
+func MigrateUp() (int, error) {
+	states, err := MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, state := range states {
+		if state.Applied {
+			continue
+		}
+		if err := runMigration(state.Migration, state.Up, true); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown 回滚最近执行的一个迁移，没有可回滚的迁移时返回 nil
+func MigrateDown() (*Migration, error) {
+	states, err := MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(states) - 1; i >= 0; i-- {
+		state := states[i]
+		if !state.Applied {
+			continue
+		}
+		if state.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing down file", state.Version, state.Name)
+		}
+		if err := runMigration(state.Migration, state.Down, false); err != nil {
+			return nil, err
+		}
+		return &state.Migration, nil
+	}
+	return nil, nil
+}
+
+// uniqueGuard 加唯一索引前检查的列
+type uniqueGuard struct {
+	table   string
+	columns []string
+}
+
+// migrationGuards 执行迁移前的检查。MySQL 的 DDL 会隐式提交，所以在执行任何语句前检查所有要加唯一索引的列，
+// 有重复数据时中止迁移并列出重复的值，由人工处理后重新执行
+var migrationGuards = map[int64][]uniqueGuard{
+	3: {
+		{table: "user", columns: []string{"nickname"}},
+		{table: "user_auths", columns: []string{"user_id", "identify_type"}},
+	},
+}
+
+// duplicateLimit 中止迁移时最多列出的重复值数量
+const duplicateLimit = 20
+
+// checkDuplicates 检查 g 的列是否有重复的值
+func checkDuplicates(sess *xorm.Session, g uniqueGuard) error {
+	cols := "`" + strings.Join(g.columns, "`, `") + "`"
+	rows, err := sess.QuerySliceString(fmt.Sprintf(
+		"SELECT %s, COUNT(*) FROM `%s` GROUP BY %s HAVING COUNT(*) > 1 ORDER BY COUNT(*) DESC LIMIT %d",
+		cols, g.table, cols, duplicateLimit))
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	dups := make([]string, 0, len(rows))
+	for _, row := range rows {
+		n := len(row) - 1
+		dups = append(dups, fmt.Sprintf("(%s) x%s", strings.Join(row[:n], ", "), row[n]))
+	}
+	return fmt.Errorf("duplicate %s.(%s), resolve them and retry: %s", g.table, strings.Join(g.columns, ", "), strings.Join(dups, "; "))
+}
+
+// legacyColumn 旧版本写入的时间列
+type legacyColumn struct {
+	table  string
+	column string
+}
+
+// legacyColumns 旧版本在 db.utc=false 时以本地时间写入的列，update_time 由 0006 从 create_time 复制
+var legacyColumns = []legacyColumn{
+	{table: "user", column: "create_time"},
+	{table: "user", column: "update_time"},
+	{table: "user_auths", column: "latestlogintime"},
+	{table: "user_auths", column: "registertime"},
+}
+
+// legacyZone 旧数据的时区，nil 表示未知：db.utc=true 时为UTC，否则为 db.legacy_time_zone
+var legacyZone *time.Location
+
+// SetLegacyTimeZone 设置旧数据的时区，迁移 0007 按它把时间转换为UTC，在 MigrateUp/MigrateDown 之前调用
+func SetLegacyTimeZone(loc *time.Location) {
+	legacyZone = loc
+}
+
+// migrationHooks 迁移语句之后在同一事务中执行的转换，up 为 false 时是回滚
+var migrationHooks = map[int64]func(sess *xorm.Session, up bool) error{
+	legacyTimesVersion: convertLegacyTimes,
+}
+
+// legacyTimesVersion 转换旧数据时间的迁移
+const legacyTimesVersion = 7
+
+// checkLegacyZone 时区未知且已有用户时返回错误，避免把本地时间当作UTC。
+// 只检查 user.create_time：其他列都在注册后才写入，update_time 在 0006 之前还不存在
+func checkLegacyZone(sess *xorm.Session) error {
+	if legacyZone != nil {
+		return nil
+	}
+	rows, err := sess.QueryString("SELECT 1 FROM `user` WHERE `create_time` IS NOT NULL LIMIT 1")
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	return fmt.Errorf("user.create_time has data written by an older version, set db.legacy_time_zone " +
+		"to the time zone it was written in, or db.utc=true if it is already UTC")
+}
+
+// CheckLegacyTimes 迁移 0007 未执行且旧数据的时区未知时返回错误，不自动迁移时用于拒绝启动
+func CheckLegacyTimes() error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	if _, ok := applied[legacyTimesVersion]; ok {
+		return nil
+	}
+	sess := DB().NewSession()
+	defer sess.Close()
+	return checkLegacyZone(sess)
+}
+
+// convertLegacyTimes 把 legacyColumns 从 legacyZone 转换为UTC，回滚时反向转换
+func convertLegacyTimes(sess *xorm.Session, up bool) error {
+	if err := checkLegacyZone(sess); err != nil || legacyZone == nil || legacyZone == time.UTC {
+		return err
+	}
+	for _, c := range legacyColumns {
+		rows, err := sess.QueryString(fmt.Sprintf(
+			"SELECT `id`, `%s` AS `t` FROM `%s` WHERE `%s` IS NOT NULL", c.column, c.table, c.column))
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if strings.HasPrefix(row["t"], "0000-00-00") {
+				continue
+			}
+			t, err := parseLegacyTime(row["t"], up)
+			if err != nil {
+				return fmt.Errorf("%s.%s of id %s: %v", c.table, c.column, row["id"], err)
+			}
+			if _, err := sess.Exec(fmt.Sprintf("UPDATE `%s` SET `%s` = ? WHERE `id` = ?", c.table, c.column),
+				t.Format(timeutil.DATETIME), row["id"]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseLegacyTime 解析 DATETIME 的值，up 时按 legacyZone 解析并转换为UTC，否则按UTC解析并转换为 legacyZone
+func parseLegacyTime(s string, up bool) (time.Time, error) {
+	from, to := legacyZone, time.UTC
+	if !up {
+		from, to = time.UTC, legacyZone
+	}
+	for _, layout := range []string{timeutil.DATETIME, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, from); err == nil {
+			if layout == time.RFC3339 {
+				// 驱动返回的 RFC 3339 带时区，取其字面时间
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, from)
+			}
+			return t.In(to), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid datetime %q", s)
+}
+
+// runMigration 在一个事务中执行迁移语句并更新 schema_migrations。
+// 注意 MySQL 的 DDL 会隐式提交，包含 DDL 的迁移失败后可能需要手工清理。
+func runMigration(m Migration, content string, up bool) error {
+	sess := DB().NewSession()
+	defer sess.Close()
+
+	if err := sess.Begin(); err != nil {
+		return err
+	}
+
+	if up {
+		for _, g := range migrationGuards[m.Version] {
+			if err := checkDuplicates(sess, g); err != nil {
+				sess.Rollback()
+				return fmt.Errorf("migration %d_%s: %v", m.Version, m.Name, err)
+			}
+		}
+	}
+
+	for _, stmt := range splitStatements(content) {
+		if _, err := sess.Exec(stmt); err != nil {
+			sess.Rollback()
+			return fmt.Errorf("migration %d_%s: %v", m.Version, m.Name, err)
+		}
+	}
+
+	if hook := migrationHooks[m.Version]; hook != nil {
+		if err := hook(sess, up); err != nil {
+			sess.Rollback()
+			return fmt.Errorf("migration %d_%s: %v", m.Version, m.Name, err)
+		}
+	}
+
+	var err error
+	if up {
+		_, err = sess.Insert(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()})
+	} else {
+		_, err = sess.Where("version = ?", m.Version).Delete(new(SchemaMigration))
+	}
+	if err != nil {
+		sess.Rollback()
+		return fmt.Errorf("migration %d_%s: %v", m.Version, m.Name, err)
+	}
+
+	return sess.Commit()
+}
+
+// splitStatements 按行尾的分号拆分sql语句，并去掉 -- 注释行
+func splitStatements(content string) []string {
+	stmts := make([]string, 0)
+	var buf strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmt := strings.TrimSuffix(strings.TrimSpace(buf.String()), ";")
+			stmts = append(stmts, stmt)
+			buf.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(buf.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
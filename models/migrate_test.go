@@ -0,0 +1,32 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/saisai/gindemo/utils/timeutil"
+)
+
+func TestParseLegacyTime(t *testing.T) {
+	t.Cleanup(func() { SetLegacyTimeZone(nil) })
+	SetLegacyTimeZone(time.FixedZone("UTC+8", 8*3600))
+
+	tests := []struct {
+		in   string
+		up   bool
+		want string
+	}{
+		{"2026-01-02 08:00:00", true, "2026-01-02 00:00:00"},
+		{"2026-01-02T08:00:00Z", true, "2026-01-02 00:00:00"}, // 驱动返回 RFC 3339 时取字面时间
+		{"2026-01-01 20:00:00", false, "2026-01-02 04:00:00"},
+	}
+	for _, tt := range tests {
+		got, err := parseLegacyTime(tt.in, tt.up)
+		if err != nil || got.Format(timeutil.DATETIME) != tt.want {
+			t.Errorf("parseLegacyTime(%q, %v) = %v, %v, want %v", tt.in, tt.up, got, err, tt.want)
+		}
+	}
+	if got, err := parseLegacyTime("abc", true); err == nil {
+		t.Errorf("parseLegacyTime(abc) = %v, want error", got)
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `user_auths`;
+DROP TABLE IF EXISTS `user`;
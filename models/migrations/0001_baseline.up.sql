@@ -0,0 +1,22 @@
+-- 基线：与原先手工导出的 usersystem.sql 中 user / user_auths 的表结构保持一致，
+-- 已由 Sync2 建表的库上执行不会有任何变化
+CREATE TABLE IF NOT EXISTS `user` (
+  `id` varchar(24) NOT NULL,
+  `nickname` varchar(100) DEFAULT NULL,
+  `avatar` varchar(100) DEFAULT NULL,
+  `sex` int(11) DEFAULT NULL,
+  `create_time` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE IF NOT EXISTS `user_auths` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` varchar(100) NOT NULL,
+  `identify_type` varchar(50) NOT NULL,
+  `identifier` varchar(50) NOT NULL,
+  `credential` varchar(100) NOT NULL,
+  `latestlogintime` datetime DEFAULT NULL,
+  `state` int(11) DEFAULT NULL,
+  `registertime` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
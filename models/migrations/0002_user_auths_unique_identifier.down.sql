@@ -0,0 +1,4 @@
+-- 备份表 user_auths_dedup_0002 不删除，需要时从中恢复被清理的行
+ALTER TABLE `user_auths`
+  DROP INDEX `UQE_user_auths_identifier`,
+  DROP INDEX `IDX_user_auths_user_id`;
@@ -0,0 +1,21 @@
+-- 同一账号类型下的 identifier 必须唯一，建索引前先清理重复数据，保留最早注册的一条。
+-- 被删除的行先备份到 user_auths_dedup_0002，确认无误后手动删除该表
+CREATE TABLE IF NOT EXISTS `user_auths_dedup_0002` LIKE `user_auths`;
+
+INSERT IGNORE INTO `user_auths_dedup_0002`
+SELECT a.* FROM `user_auths` a
+ WHERE EXISTS (
+   SELECT 1 FROM `user_auths` b
+    WHERE b.`identify_type` = a.`identify_type`
+      AND b.`identifier` = a.`identifier`
+      AND b.`id` < a.`id`);
+
+DELETE a FROM `user_auths` a
+  JOIN `user_auths` b
+    ON a.`identify_type` = b.`identify_type`
+   AND a.`identifier` = b.`identifier`
+   AND a.`id` > b.`id`;
+
+ALTER TABLE `user_auths`
+  ADD UNIQUE INDEX `UQE_user_auths_identifier` (`identify_type`, `identifier`),
+  ADD INDEX `IDX_user_auths_user_id` (`user_id`);
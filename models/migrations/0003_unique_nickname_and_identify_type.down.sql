@@ -0,0 +1,16 @@
+-- 与 up 一样只删除存在的索引，up 中途失败后也可以回滚
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.statistics
+                WHERE table_schema = DATABASE() AND table_name = 'user_auths' AND index_name = 'UQE_user_auths_user_type') > 0,
+  'ALTER TABLE `user_auths` DROP INDEX `UQE_user_auths_user_type`',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.statistics
+                WHERE table_schema = DATABASE() AND table_name = 'user' AND index_name = 'UQE_user_nickname') > 0,
+  'ALTER TABLE `user` DROP INDEX `UQE_user_nickname`',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
@@ -0,0 +1,18 @@
+-- 昵称唯一、同一用户的每种账号类型只能绑定一次，由数据库保证而不是先查后插。
+-- 执行前 migrate.go 会检查两张表的重复数据，有重复时中止并列出。
+-- 两个表的 DDL 各自隐式提交，每个索引只在不存在时添加，中途失败后可以直接重新执行
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.statistics
+                WHERE table_schema = DATABASE() AND table_name = 'user' AND index_name = 'UQE_user_nickname') = 0,
+  'ALTER TABLE `user` ADD UNIQUE INDEX `UQE_user_nickname` (`nickname`)',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.statistics
+                WHERE table_schema = DATABASE() AND table_name = 'user_auths' AND index_name = 'UQE_user_auths_user_type') = 0,
+  'ALTER TABLE `user_auths` ADD UNIQUE INDEX `UQE_user_auths_user_type` (`user_id`, `identify_type`)',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
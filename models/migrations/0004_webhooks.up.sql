@@ -0,0 +1,34 @@
+-- webhook 订阅和投递记录，投递记录同时作为持久化的投递队列：
+-- state 为 pending 或 delivering 且 next_attempt_at 已到的记录等待投递，delivering 的 next_attempt_at 为租约到期时间
+CREATE TABLE IF NOT EXISTS `webhook_subscription` (
+  `id` varchar(24) NOT NULL,
+  `name` varchar(100) NOT NULL DEFAULT '',
+  `url` varchar(500) NOT NULL,
+  `secret` varchar(100) NOT NULL,
+  `events` varchar(500) NOT NULL,
+  `active` tinyint(1) NOT NULL DEFAULT 1,
+  `owner` varchar(100) NOT NULL DEFAULT '',
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE IF NOT EXISTS `webhook_delivery` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
+  `subscription_id` varchar(24) NOT NULL,
+  `event_id` varchar(24) NOT NULL,
+  `event` varchar(50) NOT NULL,
+  `payload` text NOT NULL,
+  `state` varchar(20) NOT NULL,
+  `attempts` int(11) NOT NULL DEFAULT 0,
+  `next_attempt_at` datetime NOT NULL,
+  `response_code` int(11) NOT NULL DEFAULT 0,
+  `last_error` varchar(500) NOT NULL DEFAULT '',
+  `duration_ms` int(11) NOT NULL DEFAULT 0,
+  `redelivery_of` bigint(20) NOT NULL DEFAULT 0,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `IDX_webhook_delivery_due` (`state`, `next_attempt_at`),
+  KEY `IDX_webhook_delivery_subscription` (`subscription_id`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,13 @@
+-- 只有在所有ID仍为24位、账号ID仍为数字时才能回退
+ALTER TABLE `webhook_delivery`
+  MODIFY `subscription_id` varchar(24) NOT NULL,
+  MODIFY `event_id` varchar(24) NOT NULL;
+
+ALTER TABLE `webhook_subscription`
+  MODIFY `id` varchar(24) NOT NULL;
+
+ALTER TABLE `user_auths`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+ALTER TABLE `user`
+  MODIFY `id` varchar(24) NOT NULL;
@@ -0,0 +1,13 @@
+-- ID由 idgen 生成：ULID 为26位，ID列放宽到32位；账号ID不再自增，已有的账号保留原来的数字作为ID
+ALTER TABLE `user`
+  MODIFY `id` varchar(32) NOT NULL;
+
+ALTER TABLE `user_auths`
+  MODIFY `id` varchar(32) NOT NULL;
+
+ALTER TABLE `webhook_subscription`
+  MODIFY `id` varchar(32) NOT NULL;
+
+ALTER TABLE `webhook_delivery`
+  MODIFY `subscription_id` varchar(32) NOT NULL,
+  MODIFY `event_id` varchar(32) NOT NULL;
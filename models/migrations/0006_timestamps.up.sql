@@ -0,0 +1,8 @@
+-- 用户信息的更新时间（新增账号时也会更新），已有用户取注册时间；
+-- 原来注册时写入的 1970-01-01 占位登录时间改为 NULL，表示从未登录
+ALTER TABLE `user`
+  ADD COLUMN `update_time` datetime DEFAULT NULL;
+
+UPDATE `user` SET `update_time` = `create_time` WHERE `update_time` IS NULL;
+
+UPDATE `user_auths` SET `latestlogintime` = NULL WHERE `latestlogintime` <= '1970-01-01 00:00:00';
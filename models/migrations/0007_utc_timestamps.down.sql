@@ -0,0 +1 @@
+-- 把UTC时间转换回 [db] legacy_time_zone 的本地时间，由 migrationHooks 中的 convertLegacyTimes 执行
@@ -0,0 +1,2 @@
+-- 把旧版本以本地时间写入的时间转换为UTC，由 migrationHooks 中的 convertLegacyTimes 按 [db] legacy_time_zone 逐行转换，
+-- 没有 SQL 语句
@@ -1,47 +1,48 @@
 package models
 
 import (
+	"database/sql"
+	"time"
+
 	"github.com/go-xorm/xorm"
-	"github.com/saisai/gindemo/api/msg"
 )
 
 type User struct {
-	Id         string `json:"id" xorm:"varchar(24) pk "`
-	Nickname   string `json:"nickname" xorm:"varchar(100) not null unique"`
-	Avatar     string `json:"avatar" xorm:"varchar(100)"`
-	Sex        int    `json:"sex" xorm:"int"`
-	CreateTime string `json:"createtime" xorm:"DateTime created"`
+	Id         string    `json:"id" xorm:"varchar(32) pk "`
+	Nickname   string    `json:"nickname" xorm:"varchar(100) not null unique"`
+	Avatar     string    `json:"avatar" xorm:"varchar(100)"`
+	Sex        int       `json:"sex" xorm:"int"`
+	CreateTime time.Time `json:"createtime" xorm:"DateTime created"`
+	UpdateTime time.Time `json:"updatetime" xorm:"DateTime updated"`
 }
 
 type UserAuths struct {
-	Id              int    `json:"id" xorm:"int pk autoincr"`
-	UserId          string `json:"user_id" xorm:"varchar(100) not null"`
-	IdentifyType    string `json:"identify_type" xorm:"varchar(50) not null"`
-	Identifier      string `json:"identifier" xorm:"varchar(50) not null"`
-	Credential      string `json:"credential" xorm:"varchar(100) not null"`
-	Latestlogintime string `json:"latestlogintime" xorm:"DateTime"`
-	State           int    `json:"state" xorm:"int"`
-	Registertime    string `json:"registertime" xorm:"DateTime created"`
+	Id              string    `json:"id" xorm:"varchar(32) pk"`
+	UserId          string    `json:"user_id" xorm:"varchar(100) not null"`
+	IdentifyType    string    `json:"identify_type" xorm:"varchar(50) not null unique(identifier)"`
+	Identifier      string    `json:"identifier" xorm:"varchar(50) not null unique(identifier)"`
+	Credential      string    `json:"credential" xorm:"varchar(100) not null"`
+	Latestlogintime time.Time `json:"latestlogintime" xorm:"DateTime"` // 从未登录时为 NULL
+	State           int       `json:"state" xorm:"int"`
+	Registertime    time.Time `json:"registertime" xorm:"DateTime created"`
 }
 
 var (
 	DBEngine *xorm.Engine
 )
 
-var (
-	coreTables []interface{} = []interface{}{
-		new(UserAuths), new(msg.User),
-	}
-)
-
 func DB() *xorm.Engine {
 	return DBEngine
 }
 
-func SyncTables() error {
-	return DB().Sync2(coreTables...)
+// SQLDB 返回 xorm 使用的 *sql.DB，供 utils/db 的原生SQL查询共用连接池
+func SQLDB() *sql.DB {
+	return DBEngine.DB().DB
 }
 
+// InitDB 设置主库，没有调用 InitReplicas 时只读查询也使用主库
 func InitDB(e *xorm.Engine) {
 	DBEngine = e
+	readGroup, _ = xorm.NewEngineGroup(e, []*xorm.Engine{})
+	replicas = nil
 }
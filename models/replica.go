@@ -0,0 +1,211 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/utils/db"
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/go-xorm/xorm"
+)
+
+// 只读查询的从库路由。ReadDB 返回的引擎组按轮询把查询发到可用的从库，后台定期检查每个从库：
+// 连不上、复制停止或延迟超过 maxLag 的从库暂不使用，全部不可用时查询回到主库。
+// 写操作、事务和登录时的凭据校验始终使用 DB()。从库总会有一点延迟，刚写入的数据用 getWithFallback 查询。
+
+type replica struct {
+	name   string
+	engine *xorm.Engine
+	up     atomic.Bool
+	// checked 是否已经检查过，第一次检查的结果总是记录日志
+	checked bool
+}
+
+// replicaSet 实现 xorm.GroupPolicy
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint32
+	maxLag   time.Duration
+	interval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	readGroup *xorm.EngineGroup
+	replicas  *replicaSet
+)
+
+// ReadDB 返回只读查询使用的引擎组，没有配置从库时查询都发到主库
+func ReadDB() *xorm.EngineGroup {
+	return readGroup
+}
+
+// InitReplicas 设置只读查询使用的从库，names 与 engines 一一对应，返回前检查一次从库状态。
+// maxLag 为允许的复制延迟，<= 0 时只检查连通性
+func InitReplicas(names []string, engines []*xorm.Engine, maxLag, interval time.Duration) error {
+	s := &replicaSet{maxLag: maxLag, interval: interval}
+	for i, e := range engines {
+		s.replicas = append(s.replicas, &replica{name: names[i], engine: e})
+	}
+
+	// 主库也放在从库列表中：xorm 只有一个从库时不经过策略直接使用它，
+	// 放入主库后始终由 Slave 选择，没有可用从库时返回主库
+	group, err := xorm.NewEngineGroup(DB(), append(engines, DB()), s)
+	if err != nil {
+		return err
+	}
+	s.check()
+	readGroup = group
+	replicas = s
+	return nil
+}
+
+// StartReplicaCheck 启动后台检查，没有配置从库时什么都不做
+func StartReplicaCheck() error {
+	s := replicas
+	if s == nil || len(s.replicas) == 0 {
+		return nil
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop()
+	return nil
+}
+
+// StopReplicaCheck 停止后台检查
+func StopReplicaCheck(ctx context.Context) error {
+	s := replicas
+	if s == nil || s.stop == nil {
+		return nil
+	}
+	close(s.stop)
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CloseDB 关闭主库和所有从库的连接
+func CloseDB() error {
+	err := DB().Close()
+	if s := replicas; s != nil {
+		for _, r := range s.replicas {
+			if e := r.engine.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// Slave 从上次选择的下一个开始，返回第一个可用的从库，都不可用时返回主库
+func (s *replicaSet) Slave(eg *xorm.EngineGroup) *xorm.Engine {
+	n := len(s.replicas)
+	start := int(s.next.Add(1))
+	for i := 0; i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if r.up.Load() {
+			metrics.DBRead(r.name)
+			return r.engine
+		}
+	}
+	metrics.DBRead("primary")
+	return eg.Master()
+}
+
+func (s *replicaSet) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.check()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// check 检查每个从库，状态变化时记录日志
+func (s *replicaSet) check() {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+		lag, err := s.lag(ctx, r.engine)
+		cancel()
+		if err == nil && s.maxLag > 0 && lag > s.maxLag {
+			err = fmt.Errorf("replication lag %s exceeds %s", lag, s.maxLag)
+		}
+
+		up := err == nil
+		if was := r.up.Swap(up); was != up || !r.checked {
+			if up {
+				log.Info("[db replica] up", "replica", r.name, "lag", lag)
+			} else {
+				log.Warn("[db replica] down, reads go to other replicas or the primary", "replica", r.name, "error", err)
+			}
+		}
+
+		seconds := lag.Seconds()
+		if err != nil && lag == 0 {
+			seconds = -1
+		}
+		metrics.DBReplica(r.name, seconds, up)
+		r.checked = true
+	}
+}
+
+// lag 返回从库的复制延迟，只检查连通性时返回0
+func (s *replicaSet) lag(ctx context.Context, e *xorm.Engine) (time.Duration, error) {
+	if s.maxLag <= 0 {
+		return 0, e.DB().PingContext(ctx)
+	}
+
+	column := "Seconds_Behind_Source"
+	rows, err := db.QueryMaps(ctx, e.DB().DB, "SHOW REPLICA STATUS")
+	if err != nil {
+		// MySQL 8.0.22 之前没有 SHOW REPLICA STATUS
+		column = "Seconds_Behind_Master"
+		rows, err = db.QueryMaps(ctx, e.DB().DB, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, errors.New("replication is not configured on this server")
+	}
+	value := rows[0][column]
+	if value == nil {
+		return 0, errors.New("replication is not running")
+	}
+	seconds, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s %v: %v", column, value, err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// getWithFallback 在 ReadDB 选出的库上执行 fn，没有查到记录且选出的是从库时在主库上再执行一次，
+// 刚写入的数据可能还没有复制到从库。返回最终执行查询的库，后续的关联查询应使用同一个库
+func getWithFallback(ctx context.Context, fn func(sess *xorm.Session) (bool, error)) (bool, *xorm.Engine, error) {
+	e := ReadDB().Slave()
+	has, err := fn(e.Context(ctx))
+	if err != nil || has || e == DB() {
+		return has, e, err
+	}
+
+	metrics.DBRead("fallback")
+	has, err = fn(DB().Context(ctx))
+	return has, DB(), err
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/saisai/gindemo/common"
+	"github.com/saisai/gindemo/idgen"
+	"github.com/saisai/gindemo/utils"
+)
+
+// 用户token的格式为 <公开用户ID>_<会话ID>.<随机串>，会话ID由 idgen 生成，在审计日志和事件中标识一次登录。
+// 旧格式 <用户ID>_<随机串> 的token在过期前仍然有效，会话ID为空；其中是内部用户ID，配置公开ID的密钥后也可以解析。
+// token 中只有随机串是保密的，校验时与缓存中 <用户ID>_token 的值整体比较
+
+// NewToken 为用户生成新的登录token，返回token和会话ID
+func NewToken(userId string) (token, sessionId string) {
+	sessionId = idgen.New()
+	return idgen.Public(userId) + common.SPLIT + sessionId + "." + utils.GetToken(), sessionId
+}
+
+// ParseToken 取出token中的内部用户ID和会话ID，只检查格式，不检查token是否有效
+func ParseToken(token string) (userId, sessionId string, ok bool) {
+	parts := strings.Split(token, common.SPLIT)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	userId, err := idgen.Internal(parts[0])
+	if err != nil {
+		// 配置公开ID的密钥前签发的旧格式token中是内部用户ID
+		if strings.Contains(parts[1], ".") || !idgen.Valid(parts[0]) {
+			return "", "", false
+		}
+		userId = parts[0]
+	}
+	if i := strings.Index(parts[1], "."); i > 0 {
+		sessionId = parts[1][:i]
+	}
+	return userId, sessionId, true
+}
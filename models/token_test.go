@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/saisai/gindemo/idgen"
+)
+
+func TestParseToken(t *testing.T) {
+	const userId = "5f0c1a2b3c4d5e6f7a8b9c0d"
+	type tokenCase struct {
+		token     string
+		userId    string
+		sessionId string
+		ok        bool
+	}
+	t.Cleanup(func() { idgen.SetPublicKey("") })
+
+	for _, key := range []string{"", "test-key"} {
+		if err := idgen.SetPublicKey(key); err != nil {
+			t.Fatal(err)
+		}
+		token, sessionId := NewToken(userId)
+		tests := []tokenCase{
+			{token, userId, sessionId, true},
+			{userId + "_0123456789abcdef", userId, "", true}, // 旧格式
+			{"", "", "", false},
+			{"_abc", "", "", false},
+			{userId, "", "", false},
+			{userId + "_a_b", "", "", false},
+		}
+		if key == "" {
+			tests = append(tests, tokenCase{"not-an-id_0123456789abcdef", "not-an-id", "", true})
+		} else {
+			// 配置密钥后，内部ID只能出现在旧格式中
+			tests = append(tests,
+				tokenCase{userId + "_" + sessionId + ".0123456789abcdef", "", "", false},
+				tokenCase{"not-an-id_0123456789abcdef", "", "", false},
+			)
+		}
+		for _, tt := range tests {
+			gotUser, gotSession, ok := ParseToken(tt.token)
+			if gotUser != tt.userId || gotSession != tt.sessionId || ok != tt.ok {
+				t.Errorf("key %q: ParseToken(%q) = %q, %q, %v, want %q, %q, %v",
+					key, tt.token, gotUser, gotSession, ok, tt.userId, tt.sessionId, tt.ok)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/saisai/gindemo/api/msg"
+	"github.com/saisai/gindemo/utils/db"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/go-xorm/xorm"
+)
+
+// 唯一索引名，见 migrations
+const (
+	indexUserNickname        = "UQE_user_nickname"
+	indexUserAuthsIdentifier = "UQE_user_auths_identifier"
+	indexUserAuthsUserType   = "UQE_user_auths_user_type"
+
+	mysqlErrDupEntry = 1062
+)
+
+// ErrCode 携带msg错误码的错误，事务回调返回它时调用方可直接取得错误码
+type ErrCode int
+
+func (e ErrCode) Error() string {
+	return fmt.Sprintf("error code %d", int(e))
+}
+
+// Transaction 在一个事务中执行fn，fn返回错误或panic时回滚，否则提交。
+// 涉及多张表的写操作都应通过它完成，避免产生只写了一半的数据。
+// 死锁或锁等待超时时整个事务重新执行，fn 中不要有事务以外的副作用。
+func Transaction(ctx context.Context, fn func(sess *xorm.Session) error) error {
+	return db.Retry(ctx, db.DEFAULT_TX_ATTEMPTS, func() error {
+		return transaction(ctx, fn)
+	})
+}
+
+func transaction(ctx context.Context, fn func(sess *xorm.Session) error) (err error) {
+	sess := DB().NewSession().Context(ctx)
+	defer sess.Close()
+
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			sess.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(sess); err != nil {
+		sess.Rollback()
+		return err
+	}
+
+	return sess.Commit()
+}
+
+// errorCode 把事务返回的错误转换成msg错误码
+func errorCode(err error) int {
+	if err == nil {
+		return msg.OK
+	}
+	if code, ok := err.(ErrCode); ok {
+		return int(code)
+	}
+	return msg.ErrServerInternalError
+}
+
+// duplicateKey 判断err是否为唯一索引冲突，并返回冲突的索引名
+func duplicateKey(err error) (string, bool) {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	if !ok || mysqlErr.Number != mysqlErrDupEntry {
+		return "", false
+	}
+
+	// Duplicate entry 'xxx' for key 'UQE_user_nickname'，MySQL 8 为 'user.UQE_user_nickname'
+	message := mysqlErr.Message
+	i := strings.LastIndex(message, "for key '")
+	if i < 0 {
+		return "", true
+	}
+	key := strings.TrimSuffix(message[i+len("for key '"):], "'")
+	if j := strings.LastIndex(key, "."); j >= 0 {
+		key = key[j+1:]
+	}
+	return key, true
+}
+
+// translateDuplicate 把唯一索引冲突转换为对应的ErrCode，identifyType为冲突时正在写入的账号类型
+func translateDuplicate(err error, identifyType string) error {
+	key, ok := duplicateKey(err)
+	if !ok {
+		return err
+	}
+
+	switch key {
+	case indexUserNickname:
+		return ErrCode(msg.ErrNicknameIsExist)
+	case indexUserAuthsUserType:
+		return ErrCode(msg.ErrIdentifyTypeExist)
+	case indexUserAuthsIdentifier:
+		switch identifyType {
+		case "email":
+			return ErrCode(msg.ErrEmailIsExist)
+		case "phone":
+			return ErrCode(msg.ErrPhoneIsExist)
+		}
+		return ErrCode(msg.ErrIdentifierIsExist)
+	}
+	return err
+}
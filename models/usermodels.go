@@ -1,19 +1,26 @@
 package models
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/saisai/gindemo/api/msg"
 	"github.com/saisai/gindemo/common"
+	"github.com/saisai/gindemo/config"
+	"github.com/saisai/gindemo/events"
+	"github.com/saisai/gindemo/idgen"
 
-	"github.com/saisai/gindemo/utils"
 	"github.com/saisai/gindemo/utils/cache"
 	"github.com/saisai/gindemo/utils/captcha"
+	"github.com/saisai/gindemo/utils/log"
+	"github.com/saisai/gindemo/utils/timeutil"
+
+	"github.com/go-xorm/xorm"
 )
 
-func Register(req *msg.RegisterReq) (string, int) {
+func Register(ctx context.Context, req *msg.RegisterReq) (string, int) {
 	if req.Nickname == "" ||
 		req.Credential == "" {
 		return "", msg.ErrInvalidParam
@@ -23,51 +30,81 @@ func Register(req *msg.RegisterReq) (string, int) {
 		return "", msg.ErrInvalidParam
 	}
 
-	userId := utils.GetMongoObjectId()
+	userId := idgen.New()
 
 	user := User{Id: userId, Nickname: req.Nickname, Avatar: req.Avatar, Sex: req.Sex}
-	newUser := new(User)
-	has, err := DB().Where("nickname=?", req.Nickname).Get(newUser)
-	if err != nil {
-		fmt.Println(err.Error())
-		return "", msg.ErrInvalidParam
+
+	auths := make([]*UserAuths, 0)
+	if req.Email != "" {
+		auths = append(auths, &UserAuths{UserId: userId, IdentifyType: "email",
+			Identifier: req.Email, Credential: req.Credential})
 	}
-	if has {
-		return "", msg.ErrNicknameIsExist
+	if req.Phone != "" {
+		auths = append(auths, &UserAuths{UserId: userId, IdentifyType: "phone",
+			Identifier: req.Phone, Credential: req.Credential})
 	}
 
-	affected, err := DB().Insert(user)
+	err := Transaction(ctx, func(sess *xorm.Session) error {
+		if _, err := sess.Insert(&user); err != nil {
+			return translateDuplicate(err, "")
+		}
+		return insertAuths(sess, auths...)
+	})
 	if err != nil {
-		fmt.Println(err.Error())
-		return "", msg.ErrInvalidParam
+		log.Error("register failed", "nickname", req.Nickname, "error", err)
+		return "", errorCode(err)
 	}
 
-	fmt.Println(affected)
-
-	if req.Email != "" {
-		auths := UserAuths{UserId: userId, IdentifyType: "email",
-			Identifier: req.Email, Credential: req.Credential, Latestlogintime: "1970-1-1 0:0:0"}
-		_, err := DB().Insert(auths)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", msg.ErrInvalidParam
-		}
+	identifyTypes := make([]string, 0, len(auths))
+	for _, auth := range auths {
+		identifyTypes = append(identifyTypes, auth.IdentifyType)
 	}
+	InvalidateUserView(userId)
+	events.Publish(ctx, events.UserRegistered{UserId: userId, Nickname: req.Nickname, IdentifyTypes: identifyTypes, At: time.Now()})
 
-	if req.Phone != "" {
-		auths := UserAuths{UserId: userId, IdentifyType: "phone",
-			Identifier: req.Phone, Credential: req.Credential, Latestlogintime: "1970-1-1 0:0:0"}
-		_, err := DB().Insert(auths)
-		if err != nil {
-			fmt.Println(err.Error())
-			return "", msg.ErrInvalidParam
-		}
+	return userId, msg.OK
+}
+
+// loginFailureReason 返回登录失败原因
+func loginFailureReason(code int) string {
+	switch code {
+	case msg.ErrInvalidParam:
+		return events.REASON_INVALID_PARAM
+	case msg.ErrAccountNotExist:
+		return events.REASON_ACCOUNT_NOT_EXIST
+	case msg.ErrPasswordError:
+		return events.REASON_PASSWORD_ERROR
+	case msg.ErrCaptchaError:
+		return events.REASON_CAPTCHA_ERROR
+	case msg.ErrTooManyLoginError:
+		return events.REASON_LOCKED_OUT
 	}
+	return events.REASON_INTERNAL_ERROR
+}
 
-	return userId, msg.OK
+// publishLogin 按登录结果发布 LoginSucceeded 或 LoginFailed
+func publishLogin(ctx context.Context, req *msg.LoginReq, userId string, rsp *msg.LoginRsp) {
+	if rsp.Error_code == msg.OK {
+		_, sessionId, _ := ParseToken(rsp.Token)
+		events.Publish(ctx, events.LoginSucceeded{UserId: userId, IdentifyType: req.Identify_type, SessionId: sessionId,
+			Token: rsp.Token, At: time.Now()})
+		return
+	}
+	events.Publish(ctx, events.LoginFailed{
+		UserId:       userId,
+		IdentifyType: req.Identify_type,
+		Reason:       loginFailureReason(rsp.Error_code),
+		ErrCount:     rsp.ErrCount,
+		At:           time.Now(),
+	})
 }
 
-func Login(req *msg.LoginReq, rsp *msg.LoginRsp) {
+func Login(ctx context.Context, req *msg.LoginReq, rsp *msg.LoginRsp) {
+	var userId string
+	defer func() {
+		publishLogin(ctx, req, userId, rsp)
+	}()
+
 	if req.Identify_type == "" || req.Identifier == "" || req.Credential == "" {
 		rsp.Error_code = msg.ErrInvalidParam
 		return
@@ -75,9 +112,10 @@ func Login(req *msg.LoginReq, rsp *msg.LoginRsp) {
 
 	auth := new(UserAuths)
 
-	has, err := DB().Where("identify_type = ? and identifier = ?", req.Identify_type, req.Identifier).Get(auth)
+	// 凭据总是从主库读取，从库上可能还是修改密码或解绑之前的数据
+	has, err := DB().Context(ctx).Where("identify_type = ? and identifier = ?", req.Identify_type, req.Identifier).Get(auth)
 	if err != nil {
-		fmt.Println(err.Error())
+		log.Error("query user auth failed", "identify_type", req.Identify_type, "error", err)
 		rsp.Error_code = msg.ErrInvalidParam
 		return
 	}
@@ -86,21 +124,21 @@ func Login(req *msg.LoginReq, rsp *msg.LoginRsp) {
 		rsp.Error_code = msg.ErrAccountNotExist
 		return
 	}
+	userId = auth.UserId
 
 	key_login_err := auth.UserId + common.KEY_LOGIN_ERROR_COUNT
 
+	// redis不可用时不能当作没有错误次数，否则会绕过登录错误次数限制
 	var errCount int
-	has, strCount := cache.DoStrGet(key_login_err)
-
-	if has {
-		errCount, err = strconv.Atoi(strCount)
-		if err != nil {
-			fmt.Println(err.Error())
-			return
-		}
+	err = cache.Raw.Get(ctx, key_login_err, &errCount)
+	if err != nil && err != cache.ErrNotFound {
+		log.ErrorCtx(ctx, "get login error count failed", "user_id", auth.UserId, "error", err)
+		rsp.Error_code = msg.ErrServerInternalError
+		return
 	}
 
-	if errCount > 10 {
+	cfg := config.Get()
+	if errCount > cfg.Login.MaxErrors {
 		rsp.Error_code = msg.ErrTooManyLoginError
 		return
 	}
@@ -123,7 +161,7 @@ func Login(req *msg.LoginReq, rsp *msg.LoginRsp) {
 
 	if auth.Credential != req.Credential {
 		errCount = errCount + 1
-		cache.DoStrSet(key_login_err, strconv.Itoa(errCount), common.FIVE_MINUTE)
+		cache.DoStrSetCtx(ctx, key_login_err, strconv.Itoa(errCount), cfg.Login.ErrorWindow)
 		rsp.Error_code = msg.ErrPasswordError
 		rsp.ErrCount = errCount
 		captchaId := captcha.NewLen(4)
@@ -132,8 +170,8 @@ func Login(req *msg.LoginReq, rsp *msg.LoginRsp) {
 		return
 	}
 
-	token := auth.UserId + common.SPLIT + utils.GetToken()
-	has = cache.DoStrSet(auth.UserId+common.KEY_TOKEN, token, common.FIVE_MINUTE)
+	token, _ := NewToken(auth.UserId)
+	has = cache.DoStrSetCtx(ctx, auth.UserId+common.KEY_TOKEN, token, cfg.Token.TTL)
 	//	has = cache.DoExpire(token, common.ONE_MINUTE)
 	if !has {
 		rsp.Error_code = msg.ErrServerInternalError
@@ -145,115 +183,158 @@ func Login(req *msg.LoginReq, rsp *msg.LoginRsp) {
 	}
 	rsp.Token = token
 
-}
-
-func UserInfo(userId string, rsp *msg.InfoRsp) error {
-	user := new(User)
-	has, err := DB().Where("id = ?", userId).Get(user)
+	// 登录时间只用于记录，写入失败不影响登录
+	_, err = DB().Context(ctx).ID(auth.Id).Cols("latestlogintime").Update(&UserAuths{Latestlogintime: timeutil.Now()})
 	if err != nil {
-		return err
-	}
-	if !has {
-		err2 := fmt.Errorf("user not exist!")
-		return err2
+		log.WarnCtx(ctx, "update latest login time failed", "user_id", auth.UserId, "error", err)
 	}
+}
 
-	fmt.Println(user)
-
-	auths := make([]UserAuths, 0)
-	err = DB().Where("user_id=?", userId).Find(&auths)
+func UserInfo(ctx context.Context, userId string, rsp *msg.InfoRsp) error {
+	info, err := GetUserView(ctx, userId)
 	if err != nil {
 		return err
 	}
-
-	fmt.Println(auths)
-
-	rsp.Id = userId
-	rsp.Nickname = user.Nickname
-	rsp.Avatar = user.Avatar
-	rsp.Sex = user.Sex
-	rsp.CreateTime = user.CreateTime
-
-	for _, auth := range auths {
-		if auth.IdentifyType == "email" {
-			rsp.Email = auth.Identifier
-		} else if auth.IdentifyType == "phone" {
-			rsp.Phone = auth.Identifier
-		}
+	if info == nil {
+		err2 := errors.New("user not exist!")
+		return err2
 	}
 
+	rsp.UserInfo = *info
 	return nil
 }
 
-func GetUerInfo(token string, rsp *msg.AuthenticationRsp) (error_code int) {
+func GetUerInfo(ctx context.Context, token string, rsp *msg.AuthenticationRsp) (error_code int) {
 
-	str := strings.Split(token, common.SPLIT)
-	//	fmt.Println(str)
-	if len(str) != 2 {
+	userId, _, ok := ParseToken(token)
+	if !ok {
 		return msg.ErrUnauthorized
 	}
 
-	userId := str[0]
-
-	user := new(User)
-	has, err := DB().Where("id = ?", userId).Get(user)
+	info, err := GetUserView(ctx, userId)
 	if err != nil {
 		return msg.ErrServerInternalError
 	}
-	if !has {
+	if info == nil {
 		//		err2 := fmt.Errorf("user not exist!")
 		return msg.ErrServerInternalError
 	}
 
-	fmt.Println(user)
+	rsp.UserInfo = *info
+	return msg.OK
+}
 
-	auths := make([]UserAuths, 0)
-	err = DB().Where("user_id=?", userId).Find(&auths)
-	if err != nil {
-		return msg.ErrServerInternalError
+func AddIdentifyType(ctx context.Context, req *msg.AddIdentifyTypeReq) int {
+	if req.User_id == "" || req.Identify_type == "" ||
+		req.Identifier == "" || req.Credential == "" {
+		return msg.ErrInvalidParam
 	}
 
-	fmt.Println(auths)
+	auth := &UserAuths{
+		UserId:       req.User_id,
+		IdentifyType: req.Identify_type,
+		Identifier:   req.Identifier,
+		Credential:   req.Credential,
+	}
 
-	rsp.Id = userId
-	rsp.Nickname = user.Nickname
-	rsp.Avatar = user.Avatar
-	rsp.Sex = user.Sex
-	rsp.CreateTime = user.CreateTime
+	err := Transaction(ctx, func(sess *xorm.Session) error {
+		has, err := sess.Where("id = ?", req.User_id).Exist(new(User))
+		if err != nil {
+			return err
+		}
+		if !has {
+			return ErrCode(msg.ErrAccountNotExist)
+		}
+		if err := insertAuths(sess, auth); err != nil {
+			return err
+		}
+		// 账号变化也算作用户信息的更新
+		_, err = sess.ID(req.User_id).Cols("update_time").Update(&User{UpdateTime: timeutil.Now()})
+		return err
+	})
+	if err != nil {
+		log.Error("add identify type failed", "user_id", req.User_id, "identify_type", req.Identify_type, "error", err)
+		return errorCode(err)
+	}
+	InvalidateUserView(req.User_id)
+	events.Publish(ctx, events.IdentityLinked{UserId: req.User_id, IdentifyType: req.Identify_type, At: time.Now()})
+	return msg.OK
+}
 
+// insertAuths 在事务中写入账号，唯一索引冲突会被转换为对应的错误码
+func insertAuths(sess *xorm.Session, auths ...*UserAuths) error {
 	for _, auth := range auths {
-		if auth.IdentifyType == "email" {
-			rsp.Email = auth.Identifier
-		} else if auth.IdentifyType == "phone" {
-			rsp.Phone = auth.Identifier
+		if auth.Id == "" {
+			auth.Id = idgen.New()
+		}
+		if _, err := sess.Insert(auth); err != nil {
+			return translateDuplicate(err, auth.IdentifyType)
 		}
 	}
-
-	return msg.OK
+	return nil
 }
 
-func AddIdentifyType(req *msg.AddIdentifyTypeReq) int {
+// FindUserId 按账号查找用户ID，账号不存在时返回空字符串
+func FindUserId(ctx context.Context, identifyType, identifier string) (string, error) {
 	auth := new(UserAuths)
-	has, err := DB().Where("user_id=? and identify_type=?", req.User_id, req.Identify_type).Get(auth)
-	if err != nil {
-		fmt.Println(err.Error())
-		return msg.ErrServerInternalError
+	has, _, err := getWithFallback(ctx, func(sess *xorm.Session) (bool, error) {
+		return sess.Cols("user_id").Where("identify_type = ? and identifier = ?", identifyType, identifier).Get(auth)
+	})
+	if err != nil || !has {
+		return "", err
 	}
+	return auth.UserId, nil
+}
 
-	if has {
-		return msg.ErrIdentifyTypeExist
+// BatchUserInfo 批量查询用户信息，按 users 表的顺序返回，不存在的用户不在结果中；从从库读取，刚注册的用户可能暂时查不到
+func BatchUserInfo(ctx context.Context, userIds []string) ([]msg.UserInfo, error) {
+	users := make([]User, 0, len(userIds))
+	if err := ReadDB().Context(ctx).In("id", userIds).Find(&users); err != nil {
+		return nil, err
+	}
+	auths := make([]UserAuths, 0)
+	if err := ReadDB().Context(ctx).In("user_id", userIds).Find(&auths); err != nil {
+		return nil, err
 	}
 
-	auth.IdentifyType = req.Identify_type
-	auth.UserId = req.User_id
-	auth.Identifier = req.Identifier
-	auth.Credential = req.Credential
-	auth.Latestlogintime = "1970-1-1 0:0:0"
+	result := make([]msg.UserInfo, len(users))
+	index := make(map[string]*msg.UserInfo, len(users))
+	for i, user := range users {
+		info := &result[i]
+		info.Id = user.Id
+		info.Nickname = user.Nickname
+		info.Avatar = user.Avatar
+		info.Sex = user.Sex
+		info.CreateTime = user.CreateTime
+		info.UpdateTime = user.UpdateTime
+		index[user.Id] = info
+	}
+	for _, auth := range auths {
+		info, ok := index[auth.UserId]
+		if !ok {
+			continue
+		}
+		if auth.IdentifyType == "email" {
+			info.Email = auth.Identifier
+		} else if auth.IdentifyType == "phone" {
+			info.Phone = auth.Identifier
+		}
+	}
+	return result, nil
+}
 
-	_, err = DB().Insert(auth)
-	if err != nil {
-		fmt.Println(err.Error())
-		return msg.ErrServerInternalError
+// ExistingUserIds 返回 userIds 中在数据库里存在的用户ID，查询主库，不会把从库还没有的新用户当作不存在
+func ExistingUserIds(ctx context.Context, userIds []string) (map[string]bool, error) {
+	existing := make(map[string]bool, len(userIds))
+	if len(userIds) == 0 {
+		return existing, nil
 	}
-	return msg.OK
+	users := make([]User, 0, len(userIds))
+	if err := DB().Context(ctx).Cols("id").In("id", userIds).Find(&users); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		existing[user.Id] = true
+	}
+	return existing, nil
 }
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/api/msg"
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/go-xorm/xorm"
+)
+
+// 用户信息（users 和 user_auths 组装后的结果）的进程内读穿缓存，/info 和 /authentication 共用。
+// 同一用户同时未命中时只查询一次数据库；不存在的用户ID也会缓存 NegativeTTL，避免无效ID反复查库。
+// 用户信息或账号变化后调用 InvalidateUserView，其他实例通过 events.SubscribeCluster 收到事件后失效。
+
+// userViewEntry info 为 nil 表示用户不存在
+type userViewEntry struct {
+	info    *msg.UserInfo
+	expires time.Time
+}
+
+// userViewCall 正在进行的数据库查询，同一用户的并发请求等待同一个结果
+type userViewCall struct {
+	done chan struct{}
+	info *msg.UserInfo
+	err  error
+}
+
+type userViewCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	mu      sync.Mutex
+	entries map[string]userViewEntry
+	calls   map[string]*userViewCall
+	// gen 每次失效时递增，查询期间发生过失效的结果不写入缓存
+	gen uint64
+}
+
+var userViews *userViewCache
+
+// InitUserViewCache 启用用户信息缓存，未调用时每次都查询数据库
+func InitUserViewCache(ttl, negativeTTL time.Duration, maxEntries int) {
+	userViews = &userViewCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		entries:     make(map[string]userViewEntry),
+		calls:       make(map[string]*userViewCall),
+	}
+}
+
+// InvalidateUserView 删除本实例缓存的用户信息
+func InvalidateUserView(userId string) {
+	c := userViews
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userId)
+	c.gen++
+}
+
+// GetUserView 返回用户信息，用户不存在时返回 nil, nil
+func GetUserView(ctx context.Context, userId string) (*msg.UserInfo, error) {
+	c := userViews
+	if c == nil {
+		return loadUserView(ctx, userId)
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[userId]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		if e.info == nil {
+			metrics.UserCache("negative_hit")
+			return nil, nil
+		}
+		metrics.UserCache("hit")
+		info := *e.info
+		return &info, nil
+	}
+	metrics.UserCache("miss")
+
+	call, ok := c.calls[userId]
+	if !ok {
+		call = &userViewCall{done: make(chan struct{})}
+		c.calls[userId] = call
+		// 查询不受发起请求取消的影响，等待同一结果的其他请求仍然可以拿到
+		go c.load(log.Detach(ctx), userId, call, c.gen)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil || call.info == nil {
+		return nil, call.err
+	}
+	info := *call.info
+	return &info, nil
+}
+
+func (c *userViewCache) load(ctx context.Context, userId string, call *userViewCall, gen uint64) {
+	call.info, call.err = loadUserView(ctx, userId)
+
+	c.mu.Lock()
+	delete(c.calls, userId)
+	if call.err == nil && gen == c.gen {
+		ttl := c.ttl
+		if call.info == nil {
+			ttl = c.negativeTTL
+		}
+		if ttl > 0 {
+			c.evict()
+			c.entries[userId] = userViewEntry{info: call.info, expires: time.Now().Add(ttl)}
+		}
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// evict 缓存项达到上限时先删除已过期的，仍然不够时删除任意一项，调用方需持有锁
+func (c *userViewCache) evict() {
+	if len(c.entries) < c.maxEntries {
+		return
+	}
+	now := time.Now()
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	for id := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			break
+		}
+		delete(c.entries, id)
+	}
+}
+
+// loadUserView 从数据库查询用户和账号，组装成用户信息，优先读从库
+func loadUserView(ctx context.Context, userId string) (*msg.UserInfo, error) {
+	user := new(User)
+	has, e, err := getWithFallback(ctx, func(sess *xorm.Session) (bool, error) {
+		return sess.Where("id = ?", userId).Get(user)
+	})
+	if err != nil || !has {
+		return nil, err
+	}
+
+	auths := make([]UserAuths, 0)
+	err = e.Context(ctx).Where("user_id=?", userId).Find(&auths)
+	if err != nil {
+		return nil, err
+	}
+
+	info := new(msg.UserInfo)
+	info.Id = userId
+	info.Nickname = user.Nickname
+	info.Avatar = user.Avatar
+	info.Sex = user.Sex
+	info.CreateTime = user.CreateTime
+	info.UpdateTime = user.UpdateTime
+
+	for _, auth := range auths {
+		if auth.IdentifyType == "email" {
+			info.Email = auth.Identifier
+		} else if auth.IdentifyType == "phone" {
+			info.Phone = auth.Identifier
+		}
+	}
+	return info, nil
+}
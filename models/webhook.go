@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// webhook 投递状态
+const (
+	WEBHOOK_PENDING    = "pending"
+	WEBHOOK_DELIVERING = "delivering"
+	WEBHOOK_SUCCEEDED  = "succeeded"
+	WEBHOOK_FAILED     = "failed"
+)
+
+type WebhookSubscription struct {
+	Id        string    `xorm:"varchar(32) pk"`
+	Name      string    `xorm:"varchar(100) not null"`
+	URL       string    `xorm:"'url' varchar(500) not null"`
+	Secret    string    `xorm:"varchar(100) not null"`
+	Events    string    `xorm:"varchar(500) not null"` // 订阅的事件，用 , 分隔，* 表示全部
+	Active    bool      `xorm:"not null"`
+	Owner     string    `xorm:"varchar(100) not null"` // 创建订阅的内部接口调用方
+	CreatedAt time.Time `xorm:"DateTime created"`
+	UpdatedAt time.Time `xorm:"DateTime updated"`
+}
+
+type WebhookDelivery struct {
+	Id             int64     `xorm:"bigint pk autoincr"`
+	SubscriptionId string    `xorm:"varchar(32) not null"`
+	EventId        string    `xorm:"varchar(32) not null"`
+	Event          string    `xorm:"varchar(50) not null"`
+	Payload        string    `xorm:"text not null"`
+	State          string    `xorm:"varchar(20) not null"`
+	Attempts       int       `xorm:"int not null"`
+	NextAttemptAt  time.Time `xorm:"DateTime not null"` // 下一次投递的时间，投递中时为租约到期时间
+	ResponseCode   int       `xorm:"int not null"`
+	LastError      string    `xorm:"varchar(500) not null"`
+	DurationMs     int       `xorm:"int not null"`
+	RedeliveryOf   int64     `xorm:"bigint not null"` // 手动重新投递时为原投递记录的ID
+	CreatedAt      time.Time `xorm:"DateTime created"`
+	UpdatedAt      time.Time `xorm:"DateTime updated"`
+}
+
+func CreateWebhook(ctx context.Context, s *WebhookSubscription) error {
+	_, err := DB().Context(ctx).Insert(s)
+	return err
+}
+
+// GetWebhook 不存在时返回nil
+func GetWebhook(ctx context.Context, id string) (*WebhookSubscription, error) {
+	s := new(WebhookSubscription)
+	has, err := DB().Context(ctx).ID(id).Get(s)
+	if err != nil || !has {
+		return nil, err
+	}
+	return s, nil
+}
+
+func ListWebhooks(ctx context.Context) ([]WebhookSubscription, error) {
+	list := make([]WebhookSubscription, 0)
+	err := DB().Context(ctx).OrderBy("created_at").Find(&list)
+	return list, err
+}
+
+func ActiveWebhooks(ctx context.Context) ([]WebhookSubscription, error) {
+	list := make([]WebhookSubscription, 0)
+	err := DB().Context(ctx).Where("active = ?", true).Find(&list)
+	return list, err
+}
+
+// UpdateWebhook 更新订阅的全部可修改字段，订阅不存在时返回false
+func UpdateWebhook(ctx context.Context, s *WebhookSubscription) (bool, error) {
+	n, err := DB().Context(ctx).ID(s.Id).Cols("name", "url", "secret", "events", "active").Update(s)
+	return n > 0, err
+}
+
+// DeleteWebhook 删除订阅及其投递记录，订阅不存在时返回false
+func DeleteWebhook(ctx context.Context, id string) (bool, error) {
+	var deleted int64
+	err := Transaction(ctx, func(sess *xorm.Session) error {
+		n, err := sess.ID(id).Delete(new(WebhookSubscription))
+		if err != nil {
+			return err
+		}
+		deleted = n
+		_, err = sess.Where("subscription_id = ?", id).Delete(new(WebhookDelivery))
+		return err
+	})
+	return deleted > 0, err
+}
+
+func InsertDeliveries(ctx context.Context, deliveries []*WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	_, err := DB().Context(ctx).Insert(&deliveries)
+	return err
+}
+
+// InsertDelivery 写入一条投递记录，写入后 d.Id 为记录的ID
+func InsertDelivery(ctx context.Context, d *WebhookDelivery) error {
+	_, err := DB().Context(ctx).Insert(d)
+	return err
+}
+
+// GetDelivery 不存在时返回nil
+func GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	d := new(WebhookDelivery)
+	has, err := DB().Context(ctx).ID(id).Get(d)
+	if err != nil || !has {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListDeliveries 按ID倒序返回订阅的投递记录，beforeId 大于0时只返回ID小于它的记录
+func ListDeliveries(ctx context.Context, subscriptionId string, beforeId int64, limit int) ([]WebhookDelivery, error) {
+	list := make([]WebhookDelivery, 0, limit)
+	sess := DB().Context(ctx).Where("subscription_id = ?", subscriptionId)
+	if beforeId > 0 {
+		sess = sess.And("id < ?", beforeId)
+	}
+	err := sess.Desc("id").Limit(limit).Find(&list)
+	return list, err
+}
+
+// DueDeliveries 返回到期需要投递的记录，包括租约已过期的投递中记录
+func DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	list := make([]WebhookDelivery, 0, limit)
+	err := DB().Context(ctx).
+		In("state", WEBHOOK_PENDING, WEBHOOK_DELIVERING).
+		And("next_attempt_at <= ?", dbTime(now)).
+		Asc("next_attempt_at").Limit(limit).Find(&list)
+	return list, err
+}
+
+// ClaimDelivery 把到期的记录标记为投递中，租约到 leaseUntil 为止；领取成功后 next_attempt_at 不再到期，
+// 多个实例同时领取时只有一个成功
+func ClaimDelivery(ctx context.Context, d *WebhookDelivery, now, leaseUntil time.Time) (bool, error) {
+	n, err := DB().Context(ctx).
+		Where("id = ?", d.Id).
+		In("state", WEBHOOK_PENDING, WEBHOOK_DELIVERING).
+		And("next_attempt_at <= ?", dbTime(now)).
+		Cols("state", "next_attempt_at").
+		Update(&WebhookDelivery{State: WEBHOOK_DELIVERING, NextAttemptAt: leaseUntil})
+	if err != nil || n == 0 {
+		return false, err
+	}
+	d.State, d.NextAttemptAt = WEBHOOK_DELIVERING, leaseUntil
+	return true, nil
+}
+
+// FinishDelivery 记录一次投递的结果
+func FinishDelivery(ctx context.Context, d *WebhookDelivery) error {
+	_, err := DB().Context(ctx).ID(d.Id).
+		Cols("state", "attempts", "next_attempt_at", "response_code", "last_error", "duration_ms").
+		Update(d)
+	return err
+}
+
+// PurgeDeliveries 删除 before 之前已结束的投递记录
+func PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	return DB().Context(ctx).
+		In("state", WEBHOOK_SUCCEEDED, WEBHOOK_FAILED).
+		And("updated_at < ?", dbTime(before)).
+		Delete(new(WebhookDelivery))
+}
+
+// dbTime 按xorm写入时间列的方式格式化查询条件中的时间
+func dbTime(t time.Time) string {
+	return t.In(DB().DatabaseTZ).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,320 @@
+// Package provision 下游开通钩子：用户注册或登录后，异步通知配置的下游服务（如 Cydex manager），
+// 失败时按退避重试，不阻塞用户请求。下游返回的token缓存在 <user_id><cache_key> 下。
+package provision
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/utils"
+	"github.com/saisai/gindemo/utils/cache"
+	ss_http "github.com/saisai/gindemo/utils/http"
+	"github.com/saisai/gindemo/utils/log"
+)
+
+const (
+	EVENT_REGISTER = "register"
+	EVENT_LOGIN    = "login"
+)
+
+// Target 一个下游服务
+type Target struct {
+	Name     string
+	URL      string
+	Events   []string // 触发的事件，register | login
+	Timeout  time.Duration
+	CacheKey string // 缓存下游返回token的key后缀，如 _cydex_auth，为空表示不缓存
+	CacheTTL int    // 单位：秒
+	Header   http.Header
+}
+
+// Options 钩子配置
+type Options struct {
+	Workers      int
+	QueueSize    int
+	MaxRetries   int
+	RetryBackoff time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	Targets      []Target
+}
+
+// Payload 发送给下游的请求，authtype/auth 兼容 Cydex manager 的 thirdparty_auth 接口：
+// 登录时为 token 和用户token，注册时为 user_id 和用户ID
+type Payload struct {
+	Event    string `json:"event"`
+	UserId   string `json:"user_id"`
+	AuthType string `json:"authtype"`
+	Auth     string `json:"auth"`
+}
+
+// response 下游的响应，error_code 不为0表示失败
+type response struct {
+	Error_code int    `json:"error_code"`
+	Token      string `json:"token"`
+}
+
+// Status 单个钩子的执行情况
+type Status struct {
+	Name                string    `json:"name"`
+	URL                 string    `json:"url"`
+	Events              []string  `json:"events"`
+	Succeeded           int64     `json:"succeeded"`
+	Failed              int64     `json:"failed"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastFailure         time.Time `json:"last_failure"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+type job struct {
+	ctx     context.Context
+	target  *Target
+	payload Payload
+}
+
+// Dispatcher 钩子的执行队列
+type Dispatcher struct {
+	opts   Options
+	queue  chan job
+	closed bool
+	qmu    sync.RWMutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	client *ss_http.Client
+
+	mu     sync.Mutex
+	status map[string]*Status
+}
+
+var dispatcher *Dispatcher
+
+// NewDispatcher 创建执行队列，client 为nil时使用 utils/http 的默认客户端
+func NewDispatcher(opts Options, client *ss_http.Client) *Dispatcher {
+	if client == nil {
+		client = ss_http.Default()
+	}
+	d := &Dispatcher{
+		opts:   opts,
+		queue:  make(chan job, opts.QueueSize),
+		stop:   make(chan struct{}),
+		client: client,
+		status: make(map[string]*Status),
+	}
+	for _, t := range opts.Targets {
+		d.status[t.Name] = &Status{Name: t.Name, URL: t.URL, Events: t.Events}
+	}
+	return d
+}
+
+// Init 创建默认的执行队列
+func Init(opts Options) {
+	dispatcher = NewDispatcher(opts, nil)
+}
+
+// Start 启动默认执行队列的worker
+func Start() error {
+	if dispatcher == nil {
+		return nil
+	}
+	dispatcher.Start()
+	return nil
+}
+
+// Stop 停止默认执行队列，等待队列中的钩子执行完成，ctx 超时后放弃剩余的钩子
+func Stop(ctx context.Context) error {
+	if dispatcher == nil {
+		return nil
+	}
+	return dispatcher.Stop(ctx)
+}
+
+// Dispatch 把事件交给默认执行队列
+func Dispatch(ctx context.Context, event string, userId string, auth string) {
+	if dispatcher == nil {
+		return
+	}
+	dispatcher.Dispatch(ctx, event, userId, auth)
+}
+
+// Statuses 返回默认执行队列中各钩子的执行情况
+func Statuses() []Status {
+	if dispatcher == nil {
+		return []Status{}
+	}
+	return dispatcher.Statuses()
+}
+
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.opts.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+}
+
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	d.qmu.Lock()
+	d.closed = true
+	close(d.queue)
+	d.qmu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// 中断重试等待，worker 不再发送剩余的钩子，直接记为失败
+		close(d.stop)
+		<-done
+		return fmt.Errorf("provision: %v, pending hooks dropped", ctx.Err())
+	}
+}
+
+// Dispatch 把事件交给订阅了该事件的钩子，不会阻塞；队列已满时钩子直接记为失败。
+// 钩子在请求结束后执行，只沿用请求的trace和请求ID，不受请求取消的影响。
+func (d *Dispatcher) Dispatch(ctx context.Context, event string, userId string, auth string) {
+	payload := Payload{Event: event, UserId: userId, AuthType: "user_id", Auth: userId}
+	if event == EVENT_LOGIN {
+		payload.AuthType, payload.Auth = "token", auth
+	}
+
+	detached := log.Detach(ctx)
+
+	d.qmu.RLock()
+	defer d.qmu.RUnlock()
+
+	for i := range d.opts.Targets {
+		target := &d.opts.Targets[i]
+		if !subscribed(target, event) {
+			continue
+		}
+		if d.closed {
+			d.record(detached, target, event, fmt.Errorf("dispatcher is stopped"))
+			continue
+		}
+		select {
+		case d.queue <- job{ctx: detached, target: target, payload: payload}:
+		default:
+			d.record(detached, target, event, fmt.Errorf("queue is full"))
+		}
+	}
+}
+
+func subscribed(target *Target, event string) bool {
+	for _, e := range target.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+	for j := range d.queue {
+		d.run(j)
+	}
+}
+
+// run 执行一个钩子，失败时按指数退避加随机抖动重试。Stop 超时后不再发送，直接记为失败
+func (d *Dispatcher) run(j job) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if d.stopped() {
+			if err == nil {
+				err = fmt.Errorf("shutting down, not sent")
+			} else {
+				err = fmt.Errorf("shutting down, last error: %v", err)
+			}
+			break
+		}
+
+		err = d.call(j)
+		if err == nil || attempt >= d.opts.MaxRetries {
+			break
+		}
+
+		wait := utils.Backoff(attempt+1, d.opts.RetryBackoff, 0)
+		log.WarnCtx(j.ctx, "[provision] hook failed, retrying", "hook", j.target.Name,
+			"event", j.payload.Event, "user_id", j.payload.UserId, "attempt", attempt+1, "wait", wait.String(), "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-d.stop:
+			timer.Stop()
+		}
+	}
+	d.record(j.ctx, j.target, j.payload.Event, err)
+}
+
+// stopped 判断 Stop 是否已超时
+func (d *Dispatcher) stopped() bool {
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Dispatcher) call(j job) error {
+	ctx, cancel := context.WithTimeout(j.ctx, j.target.Timeout)
+	defer cancel()
+
+	rsp := new(response)
+	if err := d.client.DoJSON(ctx, http.MethodPost, j.target.URL, j.payload, rsp, j.target.Header); err != nil {
+		return err
+	}
+	if rsp.Error_code != 0 {
+		return fmt.Errorf("error code %d", rsp.Error_code)
+	}
+
+	if j.target.CacheKey != "" && rsp.Token != "" {
+		if !cache.DoStrSetCtx(ctx, j.payload.UserId+j.target.CacheKey, rsp.Token, j.target.CacheTTL) {
+			return fmt.Errorf("cache %s token failed", j.target.Name)
+		}
+	}
+	return nil
+}
+
+// record 记录钩子的执行结果
+func (d *Dispatcher) record(ctx context.Context, target *Target, event string, err error) {
+	metrics.Provision(target.Name, event, err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := d.status[target.Name]
+	if err == nil {
+		status.Succeeded++
+		status.ConsecutiveFailures = 0
+		status.LastSuccess = time.Now()
+		return
+	}
+
+	status.Failed++
+	status.ConsecutiveFailures++
+	status.LastFailure = time.Now()
+	status.LastError = err.Error()
+	log.ErrorCtx(ctx, "[provision] hook failed", "hook", target.Name, "event", event, "error", err)
+}
+
+// Statuses 返回各钩子的执行情况
+func (d *Dispatcher) Statuses() []Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := make([]Status, 0, len(d.opts.Targets))
+	for _, t := range d.opts.Targets {
+		result = append(result, *d.status[t.Name])
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// memoryEntry 一个key的限流状态，tokens/last 用于令牌桶，hits 用于滑动窗口
+type memoryEntry struct {
+	tokens  float64
+	last    time.Time
+	hits    []time.Time
+	expires time.Time
+}
+
+// memoryLimiter 进程内限流器，仅对当前实例生效
+type memoryLimiter struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// NewMemory 创建进程内限流器，cleanup 为清理过期key的间隔
+func NewMemory(cleanup time.Duration) Limiter {
+	m := &memoryLimiter{entries: make(map[string]*memoryEntry)}
+	if cleanup > 0 {
+		go m.janitor(cleanup)
+	}
+	return m
+}
+
+func (m *memoryLimiter) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		m.mu.Lock()
+		for key, entry := range m.entries {
+			if now.After(entry.expires) {
+				delete(m.entries, key)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *memoryLimiter) Allow(ctx context.Context, key string, rule *Rule) (*Result, error) {
+	return m.allow(key, rule, time.Now()), nil
+}
+
+// allow 以 now 为当前时间检查额度
+func (m *memoryLimiter) allow(key string, rule *Rule, now time.Time) *Result {
+	key = rule.String() + ":" + key
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || now.After(entry.expires) {
+		entry = &memoryEntry{tokens: float64(rule.Limit), last: now}
+		m.entries[key] = entry
+	}
+	entry.expires = now.Add(rule.Period)
+
+	if rule.Algorithm == SLIDING_WINDOW {
+		return m.slidingWindow(entry, rule, now)
+	}
+	return m.tokenBucket(entry, rule, now)
+}
+
+func (m *memoryLimiter) tokenBucket(entry *memoryEntry, rule *Rule, now time.Time) *Result {
+	// 每纳秒补充的令牌数
+	rate := float64(rule.Limit) / float64(rule.Period)
+	capacity := float64(rule.Limit)
+
+	if elapsed := now.Sub(entry.last); elapsed > 0 {
+		entry.tokens = math.Min(capacity, entry.tokens+float64(elapsed)*rate)
+	}
+	entry.last = now
+
+	result := &Result{Limit: rule.Limit}
+	if entry.tokens >= 1 {
+		entry.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - entry.tokens) / rate))
+	}
+	result.Remaining = int(entry.tokens)
+	result.Reset = time.Duration(math.Ceil((capacity - entry.tokens) / rate))
+	return result
+}
+
+func (m *memoryLimiter) slidingWindow(entry *memoryEntry, rule *Rule, now time.Time) *Result {
+	start := now.Add(-rule.Period)
+	i := 0
+	for i < len(entry.hits) && !entry.hits[i].After(start) {
+		i++
+	}
+	entry.hits = entry.hits[i:]
+
+	result := &Result{Limit: rule.Limit}
+	if len(entry.hits) < rule.Limit {
+		entry.hits = append(entry.hits, now)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = entry.hits[0].Add(rule.Period).Sub(now)
+	}
+	result.Remaining = rule.Limit - len(entry.hits)
+	result.Reset = entry.hits[0].Add(rule.Period).Sub(now)
+	return result
+}
@@ -0,0 +1,133 @@
+// Package ratelimit 请求限流，支持令牌桶和滑动窗口两种算法，redis 后端通过 Lua 脚本
+// 保证多实例间计数的原子性，redis 不可用时退回到进程内限流
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TOKEN_BUCKET   = "token_bucket"
+	SLIDING_WINDOW = "sliding_window"
+
+	KEY_IP         = "ip"
+	KEY_USER       = "user"
+	KEY_IDENTIFIER = "identifier"
+)
+
+// Rule 一条限流规则：每个 key 在 Period 内最多 Limit 次请求。
+// 令牌桶的容量为 Limit，按 Limit/Period 的速度补充，允许短时间的突发；
+// 滑动窗口严格限制任意 Period 内的请求数。
+type Rule struct {
+	Algorithm string
+	Limit     int
+	Period    time.Duration
+	Keys      []string // 限流维度，多个维度组合成一个key，如 ip+identifier
+}
+
+// Result 一次限流检查的结果
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // 被拒绝时，距离下一次允许请求的时间
+	Reset      time.Duration // 距离额度完全恢复的时间
+}
+
+// Limiter 限流器，key 相同的请求共享额度
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule *Rule) (*Result, error)
+}
+
+var (
+	limiter Limiter = NewMemory(time.Minute)
+	rules   atomic.Value
+)
+
+func init() {
+	rules.Store(map[string][]*Rule{})
+}
+
+// Use 设置使用的限流器
+func Use(l Limiter) {
+	limiter = l
+}
+
+// Allow 使用当前限流器检查key是否还有额度
+func Allow(ctx context.Context, key string, rule *Rule) (*Result, error) {
+	return limiter.Allow(ctx, key, rule)
+}
+
+// SetRules 设置各路由分组的限流规则，可在运行中替换
+func SetRules(groups map[string][]*Rule) {
+	rules.Store(groups)
+}
+
+// Rules 返回路由分组的限流规则，没有配置时返回空
+func Rules(group string) []*Rule {
+	return rules.Load().(map[string][]*Rule)[group]
+}
+
+// ParseRules 解析以 , 分隔的多条规则，每条规则的格式为 <algorithm>:<limit>/<period>:<key>[+<key>...]，
+// 如 "token_bucket:20/1m:ip,sliding_window:5/10m:identifier"，空字符串表示不限流
+func ParseRules(s string) ([]*Rule, error) {
+	result := make([]*Rule, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		rule, err := ParseRule(item)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+// ParseRule 解析一条规则，格式见 ParseRules
+func ParseRule(s string) (*Rule, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("ratelimit: rule %q must be <algorithm>:<limit>/<period>:<key>", s)
+	}
+
+	rule := &Rule{Algorithm: strings.TrimSpace(parts[0])}
+	if rule.Algorithm != TOKEN_BUCKET && rule.Algorithm != SLIDING_WINDOW {
+		return nil, fmt.Errorf("ratelimit: rule %q: algorithm must be %s or %s", s, TOKEN_BUCKET, SLIDING_WINDOW)
+	}
+
+	rate := strings.SplitN(parts[1], "/", 2)
+	if len(rate) != 2 {
+		return nil, fmt.Errorf("ratelimit: rule %q: rate must be <limit>/<period>", s)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(rate[0]))
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("ratelimit: rule %q: limit must be a positive integer", s)
+	}
+	period, err := time.ParseDuration(strings.TrimSpace(rate[1]))
+	if err != nil || period < time.Millisecond {
+		return nil, fmt.Errorf("ratelimit: rule %q: period must be a duration such as 1s, 1m or 1h", s)
+	}
+	rule.Limit, rule.Period = limit, period
+
+	for _, key := range strings.Split(parts[2], "+") {
+		key = strings.TrimSpace(key)
+		if key != KEY_IP && key != KEY_USER && key != KEY_IDENTIFIER {
+			return nil, fmt.Errorf("ratelimit: rule %q: key must be %s, %s or %s", s, KEY_IP, KEY_USER, KEY_IDENTIFIER)
+		}
+		rule.Keys = append(rule.Keys, key)
+	}
+	return rule, nil
+}
+
+// String 返回规则的配置格式，也用作计数key的一部分，规则修改后重新计数
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s:%d/%s:%s", r.Algorithm, r.Limit, r.Period, strings.Join(r.Keys, "+"))
+}
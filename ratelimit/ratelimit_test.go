@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *Rule
+	}{
+		{"token_bucket:20/1m:ip", &Rule{TOKEN_BUCKET, 20, time.Minute, []string{KEY_IP}}},
+		{"sliding_window:5/10m:identifier", &Rule{SLIDING_WINDOW, 5, 10 * time.Minute, []string{KEY_IDENTIFIER}}},
+		{" token_bucket : 3 / 1s : ip + user ", &Rule{TOKEN_BUCKET, 3, time.Second, []string{KEY_IP, KEY_USER}}},
+		{"sliding_window:1/1ms:ip+identifier", &Rule{SLIDING_WINDOW, 1, time.Millisecond, []string{KEY_IP, KEY_IDENTIFIER}}},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.in)
+		if err != nil {
+			t.Errorf("ParseRule(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(rule, tt.want) {
+			t.Errorf("ParseRule(%q) = %+v, want %+v", tt.in, rule, tt.want)
+		}
+	}
+}
+
+func TestParseRuleInvalid(t *testing.T) {
+	for _, in := range []string{
+		"", "token_bucket", "token_bucket:20/1m", "token_bucket:20/1m:ip:x", // 段数不对
+		"leaky_bucket:20/1m:ip", ":20/1m:ip", // 算法
+		"token_bucket:20:ip", "token_bucket:/1m:ip", "token_bucket:a/1m:ip", "token_bucket:0/1m:ip", "token_bucket:-1/1m:ip", // 次数
+		"token_bucket:20/:ip", "token_bucket:20/1:ip", "token_bucket:20/1x:ip", "token_bucket:20/1us:ip", "token_bucket:20/-1s:ip", // 周期
+		"token_bucket:20/1m:", "token_bucket:20/1m:host", "token_bucket:20/1m:ip+", "token_bucket:20/1m:ip+host", // 维度
+	} {
+		if rule, err := ParseRule(in); err == nil {
+			t.Errorf("ParseRule(%q) = %+v, want error", in, rule)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(" , token_bucket:20/1m:ip,, sliding_window:5/10m:identifier ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].String() != "token_bucket:20/1m0s:ip" || rules[1].String() != "sliding_window:5/10m0s:identifier" {
+		t.Errorf("ParseRules = %v", rules)
+	}
+	if rules, err := ParseRules(""); err != nil || len(rules) != 0 {
+		t.Errorf("ParseRules(\"\") = %v, %v, want no rules", rules, err)
+	}
+	if _, err := ParseRules("token_bucket:20/1m:ip,bad"); err == nil {
+		t.Error("ParseRules with an invalid rule: want error")
+	}
+}
+
+// step 在 at 时刻请求一次，期望的结果
+type step struct {
+	at         time.Duration
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+func runSteps(t *testing.T, rule *Rule, steps []step) {
+	t.Helper()
+	m := NewMemory(0).(*memoryLimiter)
+	start := time.Now()
+	for i, s := range steps {
+		r := m.allow("k", rule, start.Add(s.at))
+		if r.Allowed != s.allowed || r.Remaining != s.remaining || r.Limit != rule.Limit {
+			t.Errorf("step %d at %v: allowed=%v remaining=%d limit=%d, want allowed=%v remaining=%d limit=%d",
+				i, s.at, r.Allowed, r.Remaining, r.Limit, s.allowed, s.remaining, rule.Limit)
+		}
+		// 令牌桶按浮点数计算，允许一微秒的误差
+		if !near(r.RetryAfter, s.retryAfter) || !near(r.Reset, s.reset) {
+			t.Errorf("step %d at %v: retryAfter=%v reset=%v, want retryAfter=%v reset=%v",
+				i, s.at, r.RetryAfter, r.Reset, s.retryAfter, s.reset)
+		}
+	}
+}
+
+func near(got, want time.Duration) bool {
+	d := got - want
+	return d >= -time.Microsecond && d <= time.Microsecond
+}
+
+func TestMemoryTokenBucket(t *testing.T) {
+	rule := &Rule{Algorithm: TOKEN_BUCKET, Limit: 2, Period: time.Second, Keys: []string{KEY_IP}}
+	runSteps(t, rule, []step{
+		{0, true, 1, 0, 500 * time.Millisecond},
+		{0, true, 0, 0, time.Second},
+		{0, false, 0, 500 * time.Millisecond, time.Second},
+		{250 * time.Millisecond, false, 0, 250 * time.Millisecond, 750 * time.Millisecond},
+		// 多等一微秒，避免浮点误差使令牌数略小于1
+		{500*time.Millisecond + time.Microsecond, true, 0, 0, time.Second},
+		// 超过一个周期没有请求，额度完全恢复，但不会超过 Limit
+		{10 * time.Second, true, 1, 0, 500 * time.Millisecond},
+		{10 * time.Second, true, 0, 0, time.Second},
+		{10 * time.Second, false, 0, 500 * time.Millisecond, time.Second},
+	})
+}
+
+func TestMemorySlidingWindow(t *testing.T) {
+	rule := &Rule{Algorithm: SLIDING_WINDOW, Limit: 2, Period: 10 * time.Second, Keys: []string{KEY_IP}}
+	runSteps(t, rule, []step{
+		{0, true, 1, 0, 10 * time.Second},
+		{time.Second, true, 0, 0, 9 * time.Second},
+		{2 * time.Second, false, 0, 8 * time.Second, 8 * time.Second},
+		{9 * time.Second, false, 0, time.Second, time.Second},
+		// 第一次请求移出窗口
+		{10 * time.Second, true, 0, 0, time.Second},
+		{10*time.Second + 500*time.Millisecond, false, 0, 500 * time.Millisecond, 500 * time.Millisecond},
+		{11 * time.Second, true, 0, 0, 9 * time.Second},
+		// 超过一个周期没有请求，额度完全恢复
+		{30 * time.Second, true, 1, 0, 10 * time.Second},
+	})
+}
+
+func TestMemoryKeys(t *testing.T) {
+	m := NewMemory(0).(*memoryLimiter)
+	rule := &Rule{Algorithm: SLIDING_WINDOW, Limit: 1, Period: time.Minute, Keys: []string{KEY_IP}}
+	now := time.Now()
+	if !m.allow("a", rule, now).Allowed || m.allow("a", rule, now).Allowed {
+		t.Fatal("second request for the same key should be rejected")
+	}
+	if !m.allow("b", rule, now).Allowed {
+		t.Error("keys must not share quota")
+	}
+	// 修改规则后重新计数
+	changed := &Rule{Algorithm: SLIDING_WINDOW, Limit: 2, Period: time.Minute, Keys: []string{KEY_IP}}
+	if !m.allow("a", changed, now).Allowed {
+		t.Error("changed rule must not reuse the old count")
+	}
+}
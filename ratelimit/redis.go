@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/saisai/gindemo/utils/cache"
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const KEY_PREFIX = "ratelimit:"
+
+// tokenBucketScript 令牌桶，桶状态保存在hash中：tokens 剩余令牌数，ts 上次补充的时间（毫秒）
+// ARGV: limit, period(ms), now(ms)
+// 返回: allowed, remaining, retry_after(ms), reset(ms)
+var tokenBucketScript = redis.NewScript(1, `
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local rate = limit / period
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = limit
+	ts = now
+end
+if now > ts then
+	tokens = math.min(limit, tokens + (now - ts) * rate)
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], period)
+return {allowed, math.floor(tokens), retry, math.ceil((limit - tokens) / rate)}
+`)
+
+// slidingWindowScript 滑动窗口，窗口内每次请求是zset中的一个成员，score 为请求时间（毫秒）
+// ARGV: limit, period(ms), now(ms), member
+// 返回: allowed, remaining, retry_after(ms), reset(ms)
+var slidingWindowScript = redis.NewScript(1, `
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - period)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+local retry = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+
+local reset = 0
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + period - now
+end
+if allowed == 0 then
+	retry = reset
+end
+
+redis.call('PEXPIRE', KEYS[1], period)
+return {allowed, limit - count, retry, reset}
+`)
+
+// redisLimiter 基于redis的限流器，多个实例共享额度。
+// redis 出错时使用 fallback 限流，此时额度只在单个实例内生效。
+type redisLimiter struct {
+	fallback Limiter
+}
+
+// NewRedis 创建基于redis的限流器，使用 utils/cache 的redis连接池
+func NewRedis(fallback Limiter) Limiter {
+	return &redisLimiter{fallback: fallback}
+}
+
+func (r *redisLimiter) Allow(ctx context.Context, key string, rule *Rule) (*Result, error) {
+	result, err := r.allow(key, rule)
+	if err != nil {
+		log.WarnCtx(ctx, "[ratelimit] redis unavailable, using in-memory limiter", "error", err)
+		return r.fallback.Allow(ctx, key, rule)
+	}
+	return result, nil
+}
+
+func (r *redisLimiter) allow(key string, rule *Rule) (*Result, error) {
+	conn := cache.Get()
+	if conn == nil {
+		return nil, fmt.Errorf("redis pool is not initialized")
+	}
+	defer conn.Close()
+
+	key = cache.Key(KEY_PREFIX + rule.String() + ":" + key)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	period := int64(rule.Period / time.Millisecond)
+
+	var reply []int64
+	var err error
+	if rule.Algorithm == SLIDING_WINDOW {
+		member := fmt.Sprintf("%d-%d", now, rand.Int63())
+		reply, err = redis.Int64s(slidingWindowScript.Do(conn, key, rule.Limit, period, now, member))
+	} else {
+		reply, err = redis.Int64s(tokenBucketScript.Do(conn, key, rule.Limit, period, now))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 4 {
+		return nil, fmt.Errorf("unexpected script reply %v", reply)
+	}
+
+	return &Result{
+		Allowed:    reply[0] == 1,
+		Limit:      rule.Limit,
+		Remaining:  int(reply[1]),
+		RetryAfter: time.Duration(reply[2]) * time.Millisecond,
+		Reset:      time.Duration(reply[3]) * time.Millisecond,
+	}, nil
+}
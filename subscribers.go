@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+
+	"github.com/saisai/gindemo/events"
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/models"
+	"github.com/saisai/gindemo/provision"
+	"github.com/saisai/gindemo/utils/log"
+	"github.com/saisai/gindemo/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 领域事件的订阅者：指标、下游开通钩子、webhook、用户信息缓存和审计日志。
+// 开通钩子和 webhook 自己有异步队列，这里同步交给它们，webhook 的投递记录在请求返回前写入数据库。
+
+// identifyTypeLabel 限制指标中 identify_type 的取值，避免任意输入产生大量时间序列
+func identifyTypeLabel(identifyType string) string {
+	switch identifyType {
+	case "email", "phone", "qq":
+		return identifyType
+	}
+	return "other"
+}
+
+func subscribeEvents() {
+	events.Subscribe(events.LOGIN_SUCCEEDED, func(ctx context.Context, e events.Event) {
+		metrics.Login(identifyTypeLabel(e.(events.LoginSucceeded).IdentifyType), "")
+	})
+	events.Subscribe(events.LOGIN_FAILED, func(ctx context.Context, e events.Event) {
+		ev := e.(events.LoginFailed)
+		metrics.Login(identifyTypeLabel(ev.IdentifyType), ev.Reason)
+	})
+
+	events.Subscribe(events.USER_REGISTERED, func(ctx context.Context, e events.Event) {
+		ev := e.(events.UserRegistered)
+		provision.Dispatch(ctx, provision.EVENT_REGISTER, ev.UserId, "")
+		webhook.Publish(ctx, webhook.EVENT_USER_REGISTERED, ev.UserId, gin.H{"nickname": ev.Nickname})
+	})
+	events.Subscribe(events.LOGIN_SUCCEEDED, func(ctx context.Context, e events.Event) {
+		ev := e.(events.LoginSucceeded)
+		provision.Dispatch(ctx, provision.EVENT_LOGIN, ev.UserId, ev.Token)
+		webhook.Publish(ctx, webhook.EVENT_USER_LOGGED_IN, ev.UserId, gin.H{"identify_type": ev.IdentifyType})
+	})
+	events.Subscribe(events.IDENTITY_LINKED, func(ctx context.Context, e events.Event) {
+		ev := e.(events.IdentityLinked)
+		webhook.Publish(ctx, webhook.EVENT_USER_IDENTITY_LINKED, ev.UserId, gin.H{"identify_type": ev.IdentifyType})
+	})
+
+	// 发布事件的实例已经在 models 中失效了自己的缓存，这里处理其他实例广播的事件
+	events.SubscribeCluster(events.USER_REGISTERED, func(ctx context.Context, e events.Event) {
+		models.InvalidateUserView(e.(events.UserRegistered).UserId)
+	})
+	events.SubscribeCluster(events.IDENTITY_LINKED, func(ctx context.Context, e events.Event) {
+		models.InvalidateUserView(e.(events.IdentityLinked).UserId)
+	})
+
+	for _, name := range []string{events.USER_REGISTERED, events.LOGIN_SUCCEEDED, events.LOGIN_FAILED,
+		events.IDENTITY_LINKED, events.LOGGED_OUT} {
+		events.SubscribeAsync(name, audit)
+	}
+}
+
+// audit 审计日志，不记录token等敏感信息；event_id 与 webhook 投递的事件ID相同
+func audit(ctx context.Context, e events.Event) {
+	id := events.EventId(ctx)
+	switch ev := e.(type) {
+	case events.UserRegistered:
+		log.InfoCtx(ctx, "[audit] user registered", "event_id", id, "user_id", ev.UserId, "identify_types", ev.IdentifyTypes)
+	case events.LoginSucceeded:
+		log.InfoCtx(ctx, "[audit] login succeeded", "event_id", id, "user_id", ev.UserId, "identify_type", ev.IdentifyType,
+			"session_id", ev.SessionId)
+	case events.LoginFailed:
+		log.InfoCtx(ctx, "[audit] login failed", "event_id", id, "user_id", ev.UserId, "identify_type", ev.IdentifyType,
+			"reason", ev.Reason, "err_count", ev.ErrCount)
+	case events.IdentityLinked:
+		log.InfoCtx(ctx, "[audit] identity linked", "event_id", id, "user_id", ev.UserId, "identify_type", ev.IdentifyType)
+	case events.LoggedOut:
+		log.InfoCtx(ctx, "[audit] logged out", "event_id", id, "user_id", ev.UserId, "session_id", ev.SessionId)
+	}
+}
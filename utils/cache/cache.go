@@ -0,0 +1,483 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/saisai/gindemo/utils"
+	"github.com/saisai/gindemo/utils/log"
+)
+
+const (
+	BACKEND_REDIS  = "redis"
+	BACKEND_MEMORY = "memory"
+)
+
+var (
+	// ErrNil 表示 key 或 field 不存在
+	ErrNil = errors.New("cache: nil")
+	// ErrWrongType 表示对已存在的 key 执行了类型不匹配的操作
+	ErrWrongType = errors.New("cache: operation against a key holding the wrong kind of value")
+	// ErrInvalidExpire 表示 Set/SetNX 的过期时间不是正数，与 redis SETEX 一致不允许写入永不过期的key
+	ErrInvalidExpire = errors.New("cache: invalid expire time")
+
+	backend Cache
+)
+
+// Cache 缓存后端接口，Do* 系列函数均通过它访问实际存储。
+// expire 的单位为秒，Set/SetNX 的 expire 必须大于0，读取不存在的 key 或 field 时返回 ErrNil。
+// ctx 用于链路追踪，每条命令在 ctx 中的span下生成一个子span。
+type Cache interface {
+	Ping(ctx context.Context) error
+	Close() error
+
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, expire int) error
+	SetNX(ctx context.Context, key string, value []byte, expire int) (bool, error)
+	Del(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	Expire(ctx context.Context, key string, expire int) (bool, error)
+	// TTL 返回剩余生存时间（秒），key 不存在返回 -2，未设置过期时间返回 -1
+	TTL(ctx context.Context, key string) (int, error)
+	// Scan 按 opts 分批遍历key并交给 fn，fn 返回错误时停止遍历并返回该错误。
+	// 不会像 KEYS 一样阻塞redis；遍历期间新增或删除的key可能返回也可能不返回，同一个key可能返回多次。
+	Scan(ctx context.Context, opts ScanOptions, fn func(keys []string) error) error
+	// Unlink 删除多个key，返回实际删除的数量
+	Unlink(ctx context.Context, keys ...string) (int64, error)
+	// ExpireMany 设置多个key的过期时间，返回设置成功（key 存在）的数量
+	ExpireMany(ctx context.Context, keys []string, expire int) (int64, error)
+
+	HSet(ctx context.Context, key string, field string, value []byte) error
+	HGet(ctx context.Context, key string, field string) ([]byte, error)
+	HDel(ctx context.Context, key string, field string) error
+	HKeys(ctx context.Context, key string) ([]string, error)
+	HVals(ctx context.Context, key string) ([][]byte, error)
+	HLen(ctx context.Context, key string) (int64, error)
+
+	RPush(ctx context.Context, key string, value []byte) (int64, error)
+	LPop(ctx context.Context, key string) ([]byte, error)
+
+	ZAdd(ctx context.Context, key string, score float64, member []byte) (int, error)
+	ZRange(ctx context.Context, key string, start int, stop int) ([][]byte, error)
+
+	// Pipeline 把 fn 中加入的命令在一个连接上一次发送，按顺序返回每条命令的结果，见 Pipe。
+	// atomic 为 true 时使用 MULTI/EXEC，命令之间不会插入其它客户端的命令。
+	// 任意一条命令出错时返回该错误，redis 不会回滚 MULTI 中已经执行的命令。
+	Pipeline(ctx context.Context, atomic bool, fn func(p Pipe)) ([]int64, error)
+}
+
+const (
+	TYPE_STRING = "string"
+	TYPE_HASH   = "hash"
+	TYPE_LIST   = "list"
+	TYPE_ZSET   = "zset"
+)
+
+// batchSize Unlink、ExpireMany 每批发送的key数量，Scan 默认的 Count
+const batchSize = 100
+
+// ScanOptions Scan 的过滤条件，key 都是去掉前缀之后的
+type ScanOptions struct {
+	Match string // redis 的 glob 模式（*、?、[...]、\ 转义），默认 *
+	Type  string // TYPE_* 之一，默认不过滤
+	Count int    // 每次 SCAN 的提示数量，每批返回的key数量不固定，默认 100
+}
+
+func (o ScanOptions) withDefaults() ScanOptions {
+	if o.Match == "" {
+		o.Match = "*"
+	}
+	if o.Count <= 0 {
+		o.Count = batchSize
+	}
+	return o
+}
+
+// Pipe 批量发送的写命令，结果统一为整数：
+// Set 为1；SetNX 写入为1，key 已存在为0；Del、HDel 为删除的数量；Expire 设置成功为1，key 不存在为0；
+// HSet、ZAdd 为新增的数量；RPush 为追加后的列表长度
+type Pipe interface {
+	Set(key string, value []byte, expire int)
+	SetNX(key string, value []byte, expire int)
+	Del(key string)
+	Expire(key string, expire int)
+	HSet(key string, field string, value []byte)
+	HDel(key string, field string)
+	RPush(key string, value []byte)
+	ZAdd(key string, score float64, member []byte)
+}
+
+// Use 设置 Do* 系列函数使用的缓存后端
+func Use(c Cache) {
+	backend = c
+}
+
+// Backend 返回当前使用的缓存后端
+func Backend() Cache {
+	if backend == nil {
+		log.Error("Please set cache backend first!")
+	}
+	return backend
+}
+
+// InitMemory 使用进程内缓存作为后端，适用于单节点部署和测试
+func InitMemory(cleanup_interval int) {
+	Use(NewMemory(time.Duration(cleanup_interval) * time.Second))
+}
+
+func PingCtx(ctx context.Context) error {
+	return Backend().Ping(ctx)
+}
+
+func Close() {
+	log.Info("[cache close]")
+	err := Backend().Close()
+	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+}
+
+// Do* 系列函数是 Client 的简单封装：错误只记录日志并转为 bool，无法区分 key 不存在和redis不可用。
+// 新代码直接使用 JSON、Raw 等 Client。
+
+// failed 记录 key 不存在以外的错误，返回是否出错
+func failed(err error) bool {
+	if err != nil && err != ErrNotFound {
+		utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+	}
+	return err != nil
+}
+
+func DoMapSetCtx(ctx context.Context, key string, obj map[int]map[int]string, expire int) {
+	failed(JSON.Set(ctx, key, obj, expire))
+}
+
+func DoMapGetCtx(ctx context.Context, key string) (obj map[int]map[int]string) {
+	failed(JSON.Get(ctx, key, &obj))
+	return obj
+}
+
+func DoSetCtx(ctx context.Context, key string, obj interface{}, expire int) bool {
+	return !failed(JSON.Set(ctx, key, obj, expire))
+}
+
+func DoSetNxCtx(ctx context.Context, key string, expire int) bool {
+	ok, err := Raw.SetNX(ctx, key, "1", expire)
+	return !failed(err) && ok
+}
+
+// DoHSetCtx 写入字段并重置整个 key 的过期时间，两条命令在同一个事务中执行
+func DoHSetCtx(ctx context.Context, key string, field string, obj interface{}, expire int) bool {
+	return !failed(JSON.HSet(ctx, key, field, obj, expire))
+}
+
+func DoHDelCtx(ctx context.Context, key string, field string) bool {
+	return !failed(JSON.HDel(ctx, key, field))
+}
+
+func DoHGetCtx(ctx context.Context, key string, field string, obj interface{}) bool {
+	return !failed(JSON.HGet(ctx, key, field, obj))
+}
+
+// 设置key的过期时间
+func DoExpireCtx(ctx context.Context, key string, expire int) bool {
+	ok, err := JSON.Expire(ctx, key, expire)
+	return err == nil && ok
+}
+
+// DoTTLCtx 返回key的剩余生存时间，单位：秒
+func DoTTLCtx(ctx context.Context, key string) int {
+	ttl, err := JSON.TTL(ctx, key)
+	if failed(err) {
+		return -2
+	}
+	return ttl
+}
+
+func DoDelCtx(ctx context.Context, key string) bool {
+	return !failed(JSON.Del(ctx, key))
+}
+
+// DoGetCtx obj:结构体指针 返回值 true：取到值 false：未取到值
+func DoGetCtx(ctx context.Context, key string, obj interface{}) bool {
+	return !failed(JSON.Get(ctx, key, obj))
+}
+
+func DoStrSetCtx(ctx context.Context, key string, obj string, expire int) bool {
+	return !failed(SetString(ctx, key, obj, expire))
+}
+
+func DoStrGetCtx(ctx context.Context, key string) (ret bool, obj string) {
+	obj, err := GetString(ctx, key)
+	if failed(err) {
+		return false, ""
+	}
+	return true, obj
+}
+
+// DoKeysCtx 返回匹配 pattern 的所有key
+//
+// Deprecated: 一次取回全部key，key 很多时占用大量内存，使用 Scan 分批处理
+func DoKeysCtx(ctx context.Context, pattern string) (ret bool, keys []string) {
+	err := Scan(ctx, ScanOptions{Match: pattern}, func(batch []string) error {
+		keys = append(keys, batch...)
+		return nil
+	})
+	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+	if err != nil || len(keys) == 0 {
+		return false, nil
+	}
+
+	return true, keys
+}
+
+func DoHkeysCtx(ctx context.Context, key string) []string {
+	ret, err := Backend().HKeys(ctx, key)
+	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+
+	return ret
+}
+
+func DoHValsCtx(ctx context.Context, key string) (bool, []interface{}) {
+	value, err := Backend().HVals(ctx, key)
+	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+	if err != nil || len(value) == 0 {
+		return false, nil
+	}
+
+	return decodeAll(value)
+}
+
+func DoHLenCtx(ctx context.Context, key string) int64 {
+	ret, err := Backend().HLen(ctx, key)
+	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+
+	return ret
+}
+
+// DoRPushCtx 追加到列表末尾，expire 只在列表新建时设置，之后的追加不会延长整个列表的过期时间
+func DoRPushCtx(ctx context.Context, key string, obj interface{}, expire int) bool {
+	_, err := JSON.RPush(ctx, key, obj, expire)
+	return !failed(err)
+}
+
+func DoLPopCtx(ctx context.Context, key string, obj interface{}) bool {
+	return !failed(JSON.LPop(ctx, key, obj))
+}
+
+// 共享锁
+// -------------------------------------------------------------------
+// lockStart 开始一个分布式锁,retLock:是否锁成功 ，尝试n次，每次间隔100毫秒
+// cntTry:尝试次数 redisKeyEx：锁的超时时间，单位：秒，该值必须大于1秒
+//
+// Deprecated: 锁没有持有者标识，任何人都可以释放，使用 lock.Acquire
+func LockStartCtx(ctx context.Context, redisKey string, redisKeyEx int, cntTry int) (retLock bool) {
+	retLock = false
+	for i := 0; i < cntTry; i++ {
+		retLock = DoSetNxCtx(ctx, redisKey, redisKeyEx)
+		if retLock == true {
+			break
+		} else {
+			// 休眠200毫秒
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	return retLock
+}
+
+// lockEnd 结束一个分布式锁
+//
+// Deprecated: 不检查持有者，可能删除别人的锁，使用 lock.Lock 的 Release
+func LockEndCtx(ctx context.Context, redisKey string) {
+	DoDelCtx(ctx, redisKey)
+}
+
+// lockHeart 锁的心跳,锁的超时时间很短，一旦没有心跳，锁就自动解锁
+// redisKeyEx：每次心跳时会重置key的超时时间，用来保持锁定状态，该值必须大于1秒
+// expire:心跳超时时间，单位：秒，如果忘记关闭心跳，超时后心跳结束
+//
+// Deprecated: 阻塞调用方且无法取消，lock.Acquire 获取的锁会在后台自动续期
+func LockHeartCtx(ctx context.Context, redisKey string, redisKeyEx int, expire float64) {
+	start := time.Now()
+	for {
+		ret := DoExpireCtx(ctx, redisKey, redisKeyEx)
+		if ret == false {
+			break
+		}
+		time.Sleep(1 * time.Second)
+		// 如果超过心跳超时时间，则心跳退出
+		if time.Since(start).Seconds() > expire {
+			break
+		}
+	}
+}
+
+func DoZAddCtx(ctx context.Context, key string, score float64, obj interface{}) (int, bool) {
+	ret, err := JSON.ZAdd(ctx, key, score, obj)
+	if failed(err) {
+		return -1, false
+	}
+	return ret, true
+}
+
+func DoZRangeCtx(ctx context.Context, key string, start int, stop int) (bool, []interface{}) {
+	value, err := Backend().ZRange(ctx, key, start, stop)
+	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+	if err != nil || len(value) == 0 {
+		return false, nil
+	}
+
+	return decodeAll(value)
+}
+
+func DoExistsCtx(ctx context.Context, key string) bool {
+	exists, err := JSON.Exists(ctx, key)
+	return !failed(err) && exists
+}
+
+func decodeAll(value [][]byte) (bool, []interface{}) {
+	obj := make([]interface{}, 0)
+	for _, v := range value {
+		var f interface{}
+		err := json.Unmarshal(v, &f)
+		if err != nil {
+			return false, nil
+		}
+		obj = append(obj, f)
+	}
+	return true, obj
+}
+
+// -------------------------------------------------------------------
+// 不带 ctx 的旧函数，保留给已有的调用方，使用 context.Background()
+
+// Deprecated: 使用 PingCtx
+func Ping() error {
+	return PingCtx(context.Background())
+}
+
+// Deprecated: 使用 DoMapSetCtx
+func DoMapSet(key string, obj map[int]map[int]string, expire int) {
+	DoMapSetCtx(context.Background(), key, obj, expire)
+}
+
+// Deprecated: 使用 DoMapGetCtx
+func DoMapGet(key string) (obj map[int]map[int]string) {
+	return DoMapGetCtx(context.Background(), key)
+}
+
+// Deprecated: 使用 DoSetCtx
+func DoSet(key string, obj interface{}, expire int) bool {
+	return DoSetCtx(context.Background(), key, obj, expire)
+}
+
+// Deprecated: 使用 DoSetNxCtx
+func DoSetNx(key string, expire int) bool {
+	return DoSetNxCtx(context.Background(), key, expire)
+}
+
+// Deprecated: 使用 DoHSetCtx
+func DoHSet(key string, field string, obj interface{}, expire int) bool {
+	return DoHSetCtx(context.Background(), key, field, obj, expire)
+}
+
+// Deprecated: 使用 DoHDelCtx
+func DoHDel(key string, field string) bool {
+	return DoHDelCtx(context.Background(), key, field)
+}
+
+// Deprecated: 使用 DoHGetCtx
+func DoHGet(key string, field string, obj interface{}) bool {
+	return DoHGetCtx(context.Background(), key, field, obj)
+}
+
+// Deprecated: 使用 DoExpireCtx
+func DoExpire(key string, expire int) bool {
+	return DoExpireCtx(context.Background(), key, expire)
+}
+
+// Deprecated: 使用 DoTTLCtx
+func DoTTL(key string) int {
+	return DoTTLCtx(context.Background(), key)
+}
+
+// Deprecated: 使用 DoDelCtx
+func DoDel(key string) bool {
+	return DoDelCtx(context.Background(), key)
+}
+
+// Deprecated: 使用 DoGetCtx
+func DoGet(key string, obj interface{}) bool {
+	return DoGetCtx(context.Background(), key, obj)
+}
+
+// Deprecated: 使用 DoStrSetCtx
+func DoStrSet(key string, obj string, expire int) bool {
+	return DoStrSetCtx(context.Background(), key, obj, expire)
+}
+
+// Deprecated: 使用 DoStrGetCtx
+func DoStrGet(key string) (ret bool, obj string) {
+	return DoStrGetCtx(context.Background(), key)
+}
+
+// Deprecated: 使用 Scan 分批处理
+func DoKeys(key string) (ret bool, keys []string) {
+	return DoKeysCtx(context.Background(), key)
+}
+
+// Deprecated: 使用 DoHkeysCtx
+func DoHkeys(key string) []string {
+	return DoHkeysCtx(context.Background(), key)
+}
+
+// Deprecated: 使用 DoHValsCtx
+func DoHVals(key string) (bool, []interface{}) {
+	return DoHValsCtx(context.Background(), key)
+}
+
+// Deprecated: 使用 DoHLenCtx
+func DoHLen(key string) int64 {
+	return DoHLenCtx(context.Background(), key)
+}
+
+// Deprecated: 使用 DoRPushCtx
+func DoRPush(key string, obj interface{}, expire int) bool {
+	return DoRPushCtx(context.Background(), key, obj, expire)
+}
+
+// Deprecated: 使用 DoLPopCtx
+func DoLPop(key string, obj interface{}) bool {
+	return DoLPopCtx(context.Background(), key, obj)
+}
+
+// Deprecated: 使用 lock.Acquire
+func LockStart(redisKey string, redisKeyEx int, cntTry int) (retLock bool) {
+	return LockStartCtx(context.Background(), redisKey, redisKeyEx, cntTry)
+}
+
+// Deprecated: 使用 lock.Lock 的 Release
+func LockEnd(redisKey string) {
+	LockEndCtx(context.Background(), redisKey)
+}
+
+// Deprecated: lock.Acquire 获取的锁会在后台自动续期
+func LockHeart(redisKey string, redisKeyEx int, expire float64) {
+	LockHeartCtx(context.Background(), redisKey, redisKeyEx, expire)
+}
+
+// Deprecated: 使用 DoZAddCtx
+func DoZAdd(key string, score float64, obj interface{}) (int, bool) {
+	return DoZAddCtx(context.Background(), key, score, obj)
+}
+
+// Deprecated: 使用 DoZRangeCtx
+func DoZRange(key string, start int, stop int) (bool, []interface{}) {
+	return DoZRangeCtx(context.Background(), key, start, stop)
+}
+
+// Deprecated: 使用 DoExistsCtx
+func DoExists(key string) bool {
+	return DoExistsCtx(context.Background(), key)
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+)
+
+// ErrNotFound key 或 field 不存在，与 ErrNil 是同一个错误；其它错误表示redis不可用或数据无法解码
+var ErrNotFound = ErrNil
+
+var (
+	// Raw 原样读写字符串和整数，不会像 JSON 那样给字符串加上引号
+	Raw = NewClient(RawCodec)
+	// JSON 使用json编码，与 Do* 系列函数写入的数据兼容
+	JSON = NewClient(JSONCodec)
+)
+
+// Client 使用指定编码方式访问当前缓存后端，所有方法都返回错误。
+// expire 的单位为秒，读取不存在的 key 或 field 时返回 ErrNotFound。
+type Client struct {
+	codec Codec
+}
+
+// NewClient 创建使用 codec 编码的客户端，后端在每次调用时取 Backend()，可以在 Use 之前创建
+func NewClient(codec Codec) *Client {
+	return &Client{codec: codec}
+}
+
+// Codec 返回客户端的编码方式
+func (c *Client) Codec() Codec {
+	return c.codec
+}
+
+func (c *Client) encode(key string, v interface{}) ([]byte, error) {
+	value, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cache: encode %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func (c *Client) decode(key string, value []byte, v interface{}) error {
+	if err := c.codec.Unmarshal(value, v); err != nil {
+		return fmt.Errorf("cache: decode %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get 读取 key 并解码到 v，v 为指针
+func (c *Client) Get(ctx context.Context, key string, v interface{}) error {
+	value, err := Backend().Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	return c.decode(key, value, v)
+}
+
+// Set 写入 key 并设置过期时间
+func (c *Client) Set(ctx context.Context, key string, v interface{}, expire int) error {
+	value, err := c.encode(key, v)
+	if err != nil {
+		return err
+	}
+	return Backend().Set(ctx, key, value, expire)
+}
+
+// SetNX key 不存在时写入并设置过期时间，写入和过期时间是一条命令，不会留下没有过期时间的key
+func (c *Client) SetNX(ctx context.Context, key string, v interface{}, expire int) (bool, error) {
+	value, err := c.encode(key, v)
+	if err != nil {
+		return false, err
+	}
+	return Backend().SetNX(ctx, key, value, expire)
+}
+
+func (c *Client) Del(ctx context.Context, key string) error {
+	return Backend().Del(ctx, key)
+}
+
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	return Backend().Exists(ctx, key)
+}
+
+// Expire 重置 key 的过期时间，key 不存在时返回 false
+func (c *Client) Expire(ctx context.Context, key string, expire int) (bool, error) {
+	return Backend().Expire(ctx, key, expire)
+}
+
+// TTL 返回剩余生存时间（秒），key 不存在返回 -2，未设置过期时间返回 -1
+func (c *Client) TTL(ctx context.Context, key string) (int, error) {
+	return Backend().TTL(ctx, key)
+}
+
+// HGet 读取 hash 的字段并解码到 v
+func (c *Client) HGet(ctx context.Context, key string, field string, v interface{}) error {
+	value, err := Backend().HGet(ctx, key, field)
+	if err != nil {
+		return err
+	}
+	return c.decode(key, value, v)
+}
+
+// HSet 写入 hash 的字段，并在同一个事务中把整个 key 的过期时间重置为 expire
+func (c *Client) HSet(ctx context.Context, key string, field string, v interface{}, expire int) error {
+	value, err := c.encode(key, v)
+	if err != nil {
+		return err
+	}
+	_, err = Backend().Pipeline(ctx, true, func(p Pipe) {
+		p.HSet(key, field, value)
+		p.Expire(key, expire)
+	})
+	return err
+}
+
+func (c *Client) HDel(ctx context.Context, key string, field string) error {
+	return Backend().HDel(ctx, key, field)
+}
+
+// RPush 追加到列表末尾并返回列表长度，expire 只在列表新建时设置
+func (c *Client) RPush(ctx context.Context, key string, v interface{}, expire int) (int64, error) {
+	value, err := c.encode(key, v)
+	if err != nil {
+		return 0, err
+	}
+	n, err := Backend().RPush(ctx, key, value)
+	if err != nil || n > 1 {
+		return n, err
+	}
+	_, err = Backend().Expire(ctx, key, expire)
+	return n, err
+}
+
+// LPop 取出列表的第一个元素并解码到 v，列表为空时返回 ErrNotFound
+func (c *Client) LPop(ctx context.Context, key string, v interface{}) error {
+	value, err := Backend().LPop(ctx, key)
+	if err != nil {
+		return err
+	}
+	return c.decode(key, value, v)
+}
+
+// ZAdd 添加有序集合的成员，返回新增的成员数
+func (c *Client) ZAdd(ctx context.Context, key string, score float64, v interface{}) (int, error) {
+	value, err := c.encode(key, v)
+	if err != nil {
+		return 0, err
+	}
+	return Backend().ZAdd(ctx, key, score, value)
+}
+
+// ZRange 按score升序读取 [start, stop] 范围内的成员，每个成员解码后交给 fn
+func (c *Client) ZRange(ctx context.Context, key string, start int, stop int, fn func(decode func(v interface{}) error) error) error {
+	values, err := Backend().ZRange(ctx, key, start, stop)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		value := value
+		if err := fn(func(v interface{}) error { return c.decode(key, value, v) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetString 使用 Raw 读取字符串
+func GetString(ctx context.Context, key string) (string, error) {
+	var s string
+	err := Raw.Get(ctx, key, &s)
+	return s, err
+}
+
+// SetString 使用 Raw 写入字符串
+func SetString(ctx context.Context, key string, s string, expire int) error {
+	return Raw.Set(ctx, key, s, expire)
+}
+
+// Scan 分批遍历匹配的key，见 Cache.Scan
+func Scan(ctx context.Context, opts ScanOptions, fn func(keys []string) error) error {
+	return Backend().Scan(ctx, opts, fn)
+}
+
+// UnlinkKeys 批量删除key，返回实际删除的数量
+func UnlinkKeys(ctx context.Context, keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	return Backend().Unlink(ctx, keys...)
+}
+
+// ExpireKeys 批量设置过期时间，返回 key 存在并设置成功的数量
+func ExpireKeys(ctx context.Context, keys []string, expire int) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	return Backend().ExpireMany(ctx, keys, expire)
+}
@@ -0,0 +1,471 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// redis cluster 模式：启动时通过 CLUSTER SLOTS 取得每个slot所在的master，每个master一个连接池。
+// 单条命令按第一个key所在的slot路由，收到 MOVED 时刷新slot映射后重试，收到 ASK 时发送 ASKING 后到目标节点重试。
+// 多key命令和Lua脚本的key需要使用相同的hash tag，如 lock:{key} 和 lock:{key}:fence。
+
+const (
+	clusterSlots = 16384
+	// clusterRedirects 一条命令最多跟随的重定向次数
+	clusterRedirects = 5
+)
+
+type cluster struct {
+	opts *Options
+
+	mu    sync.RWMutex
+	slots [clusterSlots]string // slot 所在master的地址
+	pools map[string]*redis.Pool
+
+	refreshMu   sync.Mutex
+	refreshedAt time.Time
+}
+
+func newCluster(o *Options) (*cluster, error) {
+	c := &cluster{opts: o, pools: make(map[string]*redis.Pool)}
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// pool 返回节点的连接池，不存在时创建，调用方不能持有 mu
+func (c *cluster) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	p := c.pools[addr]
+	c.mu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p = c.pools[addr]; p == nil {
+		p = c.opts.newPool(func() (redis.Conn, error) { return c.opts.dial(addr, c.opts.ReadTimeout) }, ping)
+		c.pools[addr] = p
+	}
+	return p
+}
+
+// nodes 返回已知的节点地址，没有时返回配置的地址
+func (c *cluster) nodes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	addrs := make([]string, 0, len(c.pools)+len(c.opts.Addrs))
+	for addr := range c.pools {
+		addrs = append(addrs, addr)
+	}
+	return append(addrs, c.opts.Addrs...)
+}
+
+// masters 返回负责slot的所有master地址
+func (c *cluster) masters() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	addrs := make([]string, 0)
+	for _, addr := range c.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// refresh 从任意一个可用节点重新读取slot映射，1秒内只刷新一次
+func (c *cluster) refresh() error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if time.Since(c.refreshedAt) < time.Second {
+		return nil
+	}
+
+	var lastErr error
+	for _, addr := range c.nodes() {
+		slots, err := c.loadSlots(addr)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", addr, err)
+			continue
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		c.refreshedAt = time.Now()
+		return nil
+	}
+	return fmt.Errorf("load cluster slots: %v", lastErr)
+}
+
+// loadSlots 执行 CLUSTER SLOTS，每一项为 [start, end, [host, port, ...], 副本...]
+func (c *cluster) loadSlots(addr string) (slots [clusterSlots]string, err error) {
+	conn, err := c.opts.dial(addr, c.opts.ConnectTimeout)
+	if err != nil {
+		return slots, err
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return slots, err
+	}
+	covered := 0
+	for _, r := range ranges {
+		fields, err := redis.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return slots, fmt.Errorf("unexpected CLUSTER SLOTS entry %v", r)
+		}
+		start, _ := redis.Int(fields[0], nil)
+		end, _ := redis.Int(fields[1], nil)
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 || start < 0 || end >= clusterSlots || start > end {
+			return slots, fmt.Errorf("unexpected CLUSTER SLOTS entry %v", r)
+		}
+		host, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		// 空的host表示与被查询的节点相同
+		if host == "" {
+			host, _, _ = net.SplitHostPort(addr)
+		}
+		node := net.JoinHostPort(host, strconv.Itoa(port))
+		for i := start; i <= end; i++ {
+			slots[i] = node
+		}
+		covered += end - start + 1
+	}
+	if covered == 0 {
+		return slots, errors.New("no slots assigned")
+	}
+	return slots, nil
+}
+
+// addr 返回key所在的master，key 为空时返回任意一个节点
+func (c *cluster) addr(key string, hasKey bool) string {
+	if hasKey {
+		c.mu.RLock()
+		addr := c.slots[slot(key)]
+		c.mu.RUnlock()
+		if addr != "" {
+			return addr
+		}
+	}
+	if masters := c.masters(); len(masters) > 0 {
+		return masters[0]
+	}
+	return c.opts.Addrs[0]
+}
+
+func (c *cluster) Get() redis.Conn {
+	return &clusterConn{c: c}
+}
+
+// dialAny 建立一个不属于连接池的连接，用于订阅，cluster 中 PUBLISH 会广播到所有节点
+func (c *cluster) dialAny() (redis.Conn, error) {
+	var lastErr error
+	for _, addr := range c.masters() {
+		conn, err := c.opts.dial(addr, 0)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no cluster node available")
+	}
+	return nil, lastErr
+}
+
+// eachMaster 依次在每个master上执行 fn，遇到错误时停止并返回
+func (c *cluster) eachMaster(fn func(conn redis.Conn) error) error {
+	for _, addr := range c.masters() {
+		conn := c.pool(addr).Get()
+		err := fn(conn)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+func (c *cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.pools {
+		p.Close()
+	}
+	return nil
+}
+
+func (c *cluster) ActiveCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	n := 0
+	for _, p := range c.pools {
+		n += p.ActiveCount()
+	}
+	return n
+}
+
+func (c *cluster) IdleCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	n := 0
+	for _, p := range c.pools {
+		n += p.IdleCount()
+	}
+	return n
+}
+
+// clusterConn cluster 模式下 Get 返回的连接。
+// Do 每次按key从对应节点的连接池取连接并处理重定向；
+// Send 的命令先缓存，在 Flush、Receive 或 Do 时按第一个带key的命令绑定到一个节点的连接，之后都在该连接上执行，
+// 因此 MULTI/EXEC 和订阅可以正常使用，但其中的key需要在同一个slot。
+// 绑定的连接在收到第一个回复前遇到 MOVED 时，刷新slot映射后到新节点重新发送全部命令，只重试一次。
+type clusterConn struct {
+	c        *cluster
+	pending  []redisCmd
+	bound    redis.Conn
+	sent     []redisCmd // 已在绑定的连接上发送的命令，MOVED 时重新发送
+	received int        // 绑定后已读取的回复数
+	retried  bool
+	err      error
+}
+
+func (cc *clusterConn) Close() error {
+	if cc.bound != nil {
+		return cc.bound.Close()
+	}
+	return nil
+}
+
+func (cc *clusterConn) Err() error {
+	if cc.bound != nil {
+		return cc.bound.Err()
+	}
+	return cc.err
+}
+
+// bind 按缓存的命令选择节点并发送它们
+func (cc *clusterConn) bind() error {
+	key, hasKey := "", false
+	for _, cmd := range cc.pending {
+		if key, hasKey = commandKey(cmd.name, cmd.args); hasKey {
+			break
+		}
+	}
+	cc.sent, cc.pending = cc.pending, nil
+	return cc.bindTo(cc.c.addr(key, hasKey))
+}
+
+// bindTo 绑定到 addr 的连接并发送 sent 中的命令
+func (cc *clusterConn) bindTo(addr string) error {
+	cc.bound = cc.c.pool(addr).Get()
+	cc.received = 0
+	for _, cmd := range cc.sent {
+		if err := cc.bound.Send(cmd.name, cmd.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moved 判断 err 是否为绑定后第一个回复的 MOVED，是则刷新slot映射并绑定到新节点重新发送，
+// 返回是否可以重试。连接池的连接关闭时会读完剩余的回复
+func (cc *clusterConn) moved(err error) bool {
+	rerr, ok := err.(redis.Error)
+	if !ok || cc.retried || cc.received > 0 || !strings.HasPrefix(string(rerr), "MOVED ") {
+		return false
+	}
+	cc.retried = true
+	if err := cc.c.refresh(); err != nil {
+		log.Warn("[redis cluster] refresh slots failed", "error", err)
+	}
+	cc.bound.Close()
+	return cc.bindTo(redirectAddr(string(rerr))) == nil
+}
+
+func (cc *clusterConn) Send(cmd string, args ...interface{}) error {
+	if cc.bound != nil {
+		cc.sent = append(cc.sent, redisCmd{name: cmd, args: args})
+		return cc.bound.Send(cmd, args...)
+	}
+	cc.pending = append(cc.pending, redisCmd{name: cmd, args: args})
+	return nil
+}
+
+func (cc *clusterConn) Flush() error {
+	if cc.bound == nil {
+		if len(cc.pending) == 0 {
+			return nil
+		}
+		if err := cc.bind(); err != nil {
+			return err
+		}
+	}
+	return cc.bound.Flush()
+}
+
+func (cc *clusterConn) Receive() (interface{}, error) {
+	if cc.bound == nil {
+		if err := cc.bind(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := cc.bound.Receive()
+	if cc.moved(err) {
+		if err := cc.bound.Flush(); err != nil {
+			return nil, err
+		}
+		reply, err = cc.bound.Receive()
+	}
+	cc.received++
+	return reply, err
+}
+
+func (cc *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cc.bound == nil && len(cc.pending) > 0 {
+		if err := cc.bind(); err != nil {
+			return nil, err
+		}
+	}
+	if cc.bound != nil {
+		// MULTI 中排队的命令遇到 MOVED 时 EXEC 会放弃整个事务，可以安全地重新发送
+		reply, err := cc.bound.Do(cmd, args...)
+		if cc.moved(err) {
+			reply, err = cc.bound.Do(cmd, args...)
+		}
+		// Do 读完了所有回复
+		cc.sent, cc.received = nil, 0
+		return reply, err
+	}
+	if cmd == "" {
+		return nil, nil
+	}
+
+	key, hasKey := commandKey(cmd, args)
+	addr := cc.c.addr(key, hasKey)
+	asking := false
+	for i := 0; ; i++ {
+		conn := cc.c.pool(addr).Get()
+		if asking {
+			conn.Do("ASKING")
+		}
+		reply, err := conn.Do(cmd, args...)
+		cc.err = conn.Err()
+		conn.Close()
+
+		rerr, ok := err.(redis.Error)
+		if !ok || i >= clusterRedirects {
+			return reply, err
+		}
+		msg := string(rerr)
+		switch {
+		case strings.HasPrefix(msg, "MOVED "):
+			// slot 已经迁移，刷新映射后直接到新节点重试
+			if err := cc.c.refresh(); err != nil {
+				log.Warn("[redis cluster] refresh slots failed", "error", err)
+			}
+			addr, asking = redirectAddr(msg), false
+		case strings.HasPrefix(msg, "ASK "):
+			addr, asking = redirectAddr(msg), true
+		case strings.HasPrefix(msg, "TRYAGAIN"), strings.HasPrefix(msg, "CLUSTERDOWN"):
+			time.Sleep(100 * time.Millisecond)
+		default:
+			return reply, err
+		}
+	}
+}
+
+// redirectAddr 取出 MOVED/ASK 错误中的目标地址，格式为 "MOVED <slot> <host:port>"
+func redirectAddr(msg string) string {
+	fields := strings.Fields(msg)
+	return fields[len(fields)-1]
+}
+
+// keylessCommands 没有key或在任意节点执行的命令
+var keylessCommands = map[string]bool{
+	"PING": true, "INFO": true, "ROLE": true, "AUTH": true, "SELECT": true, "ASKING": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true, "SCRIPT": true, "CLUSTER": true,
+	"PUBLISH": true, "SUBSCRIBE": true, "PSUBSCRIBE": true, "UNSUBSCRIBE": true, "PUNSUBSCRIBE": true,
+	"KEYS": true, "SCAN": true, "FLUSHDB": true, "DBSIZE": true,
+}
+
+// commandKey 返回命令的第一个key，EVAL/EVALSHA 的key在 numkeys 之后
+func commandKey(cmd string, args []interface{}) (string, bool) {
+	cmd = strings.ToUpper(cmd)
+	if keylessCommands[cmd] || len(args) == 0 {
+		return "", false
+	}
+	if cmd == "EVAL" || cmd == "EVALSHA" {
+		if len(args) < 3 || intArg(args[1]) == 0 {
+			return "", false
+		}
+		args = args[2:]
+	}
+	switch k := args[0].(type) {
+	case string:
+		return k, true
+	case []byte:
+		return string(k), true
+	}
+	return fmt.Sprint(args[0]), true
+}
+
+func intArg(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
+
+// slot 计算key所在的slot，key 中有非空的 {hash tag} 时只使用其中的内容
+func slot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 redis cluster 使用的 CRC16-CCITT（XMODEM）
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
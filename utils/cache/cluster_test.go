@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint16
+	}{
+		{"", 0},
+		{"123456789", 0x31C3},
+		{"foo", 0xAF96},
+	}
+	for _, tt := range tests {
+		if got := crc16(tt.in); got != tt.want {
+			t.Errorf("crc16(%q) = %#04x, want %#04x", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"123456789", 0x31C3},
+		{"{user1000}.following", slot("user1000")},
+		{"{user1000}.followers", slot("user1000")},
+		{"lock:{key}:fence", slot("key")},
+		{"foo{}{bar}", int(crc16("foo{}{bar}")) % clusterSlots}, // 空的hash tag使用整个key
+		{"foo{{bar}}zap", slot("{bar")},
+		{"foo{bar}{zap}", slot("bar")},
+		{"foo{bar", int(crc16("foo{bar")) % clusterSlots},
+	}
+	for _, tt := range tests {
+		if got := slot(tt.key); got != tt.want {
+			t.Errorf("slot(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestCommandKey(t *testing.T) {
+	tests := []struct {
+		cmd    string
+		args   []interface{}
+		key    string
+		hasKey bool
+	}{
+		{"GET", []interface{}{"k"}, "k", true},
+		{"get", []interface{}{[]byte("k")}, "k", true},
+		{"SETEX", []interface{}{"k", 60, "v"}, "k", true},
+		{"INCR", []interface{}{42}, "42", true},
+		{"GET", nil, "", false},
+		{"PING", nil, "", false},
+		{"SCAN", []interface{}{"0", "MATCH", "k*"}, "", false},
+		{"multi", nil, "", false},
+		{"EVAL", []interface{}{"return 1", 1, "k", "arg"}, "k", true},
+		{"EVAL", []interface{}{"return 1", "2", "k1", "k2"}, "k1", true},
+		{"EVAL", []interface{}{"return 1", int64(1), []byte("k")}, "k", true},
+		{"evalsha", []interface{}{"sha", 1, "k"}, "k", true},
+		{"EVAL", []interface{}{"return 1", 0}, "", false},
+		{"EVAL", []interface{}{"return 1", 0, "arg"}, "", false},
+		{"EVALSHA", []interface{}{"sha", 1}, "", false},
+		{"EVAL", []interface{}{"return 1"}, "", false},
+	}
+	for _, tt := range tests {
+		key, hasKey := commandKey(tt.cmd, tt.args)
+		if key != tt.key || hasKey != tt.hasKey {
+			t.Errorf("commandKey(%s, %v) = %q, %v, want %q, %v", tt.cmd, tt.args, key, hasKey, tt.key, tt.hasKey)
+		}
+	}
+}
+
+// fakeNode 只支持测试用到的命令的 cluster 节点，所有slot都在 owner 记录的节点上，
+// 其他节点对带key的命令返回 MOVED
+type fakeNode struct {
+	ln       net.Listener
+	owner    atomic.Value // string
+	executed int32        // 执行成功的带key命令数
+	wg       sync.WaitGroup
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNode{ln: ln}
+	n.owner.Store(n.addr())
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			n.wg.Add(1)
+			go func() {
+				defer n.wg.Done()
+				n.serve(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		n.wg.Wait()
+	})
+	return n
+}
+
+func (n *fakeNode) addr() string {
+	return n.ln.Addr().String()
+}
+
+func (n *fakeNode) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	inMulti, aborted := false, false
+	queued := make([]string, 0)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		owner := n.owner.Load().(string)
+		var reply string
+		switch cmd := strings.ToUpper(args[0]); cmd {
+		case "PING":
+			reply = "+PONG\r\n"
+		case "CLUSTER":
+			host, port, _ := net.SplitHostPort(owner)
+			reply = fmt.Sprintf("*1\r\n*3\r\n:0\r\n:%d\r\n*2\r\n$%d\r\n%s\r\n:%s\r\n", clusterSlots-1, len(host), host, port)
+		case "MULTI":
+			inMulti, aborted, queued = true, false, queued[:0]
+			reply = "+OK\r\n"
+		case "DISCARD":
+			inMulti = false
+			reply = "+OK\r\n"
+		case "EXEC":
+			if aborted {
+				reply = "-EXECABORT Transaction discarded because of previous errors.\r\n"
+			} else {
+				atomic.AddInt32(&n.executed, int32(len(queued)))
+				reply = fmt.Sprintf("*%d\r\n%s", len(queued), strings.Join(queued, ""))
+			}
+			inMulti = false
+		default:
+			result := ":1\r\n"
+			if cmd == "SET" || cmd == "SETEX" {
+				result = "+OK\r\n"
+			}
+			switch {
+			case owner != n.addr():
+				aborted = aborted || inMulti
+				reply = fmt.Sprintf("-MOVED %d %s\r\n", slot(args[1]), owner)
+			case inMulti:
+				queued = append(queued, result)
+				reply = "+QUEUED\r\n"
+			default:
+				atomic.AddInt32(&n.executed, 1)
+				reply = result
+			}
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand 读取一条 RESP 数组格式的命令
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+	args := make([]string, count)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func newTestCluster(t *testing.T, addr string) (*cluster, *redisCache) {
+	t.Helper()
+	c, err := newCluster(&Options{Addrs: []string{addr}, ConnectTimeout: time.Second, ReadTimeout: time.Second, WriteTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, &redisCache{pool: c}
+}
+
+func TestClusterPipelineMoved(t *testing.T) {
+	ctx := context.Background()
+	for _, multi := range []bool{true, false} {
+		t.Run(fmt.Sprintf("atomic=%v", multi), func(t *testing.T) {
+			from, to := newFakeNode(t), newFakeNode(t)
+			c, r := newTestCluster(t, from.addr())
+
+			// slot 迁移到 to，允许立即刷新映射
+			from.owner.Store(to.addr())
+			to.owner.Store(to.addr())
+			c.refreshMu.Lock()
+			c.refreshedAt = time.Time{}
+			c.refreshMu.Unlock()
+
+			results, err := r.Pipeline(ctx, multi, func(p Pipe) {
+				p.Set("k", []byte("v"), 60)
+				p.Expire("k", 60)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, []int64{1, 1}) {
+				t.Errorf("results = %v, want [1 1]", results)
+			}
+			if n, m := atomic.LoadInt32(&from.executed), atomic.LoadInt32(&to.executed); n != 0 || m != 2 {
+				t.Errorf("executed on old node %d, new node %d, want 0 and 2", n, m)
+			}
+			if addr := c.addr("k", true); addr != to.addr() {
+				t.Errorf("slot of k = %s after MOVED, want %s", addr, to.addr())
+			}
+		})
+	}
+}
+
+func TestClusterPipelineMovedOnce(t *testing.T) {
+	a, b := newFakeNode(t), newFakeNode(t)
+	_, r := newTestCluster(t, a.addr())
+
+	// 两个节点互相重定向，只重试一次
+	a.owner.Store(b.addr())
+	b.owner.Store(a.addr())
+	_, err := r.Pipeline(context.Background(), true, func(p Pipe) { p.Set("k", []byte("v"), 60) })
+	if err == nil || !strings.HasPrefix(err.Error(), "MOVED ") {
+		t.Errorf("error = %v, want MOVED", err)
+	}
+	if n, m := atomic.LoadInt32(&a.executed), atomic.LoadInt32(&b.executed); n != 0 || m != 0 {
+		t.Errorf("executed %d and %d commands, want none", n, m)
+	}
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Codec 缓存值的编码方式
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// RawCodec 原样保存字符串，支持 string、[]byte 和整数，读取到 *string、*[]byte、*int、*int64
+	RawCodec Codec = rawCodec{}
+	// JSONCodec json编码，Do* 系列函数使用的编码方式
+	JSONCodec Codec = jsonCodec{}
+	// MsgpackCodec msgpack编码，比json更紧凑
+	MsgpackCodec Codec = msgpackCodec{}
+	// GobCodec gob编码，只能由Go程序读取，接口类型的字段需要先 gob.Register
+	GobCodec Codec = gobCodec{}
+)
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case int:
+		return []byte(strconv.Itoa(v)), nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	}
+	return nil, fmt.Errorf("cache: raw codec cannot marshal %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *string:
+		*v = string(data)
+		return nil
+	case *[]byte:
+		*v = append((*v)[:0], data...)
+		return nil
+	case *int:
+		n, err := strconv.Atoi(string(data))
+		if err != nil {
+			return err
+		}
+		*v = n
+		return nil
+	case *int64:
+		n, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return err
+		}
+		*v = n
+		return nil
+	}
+	return fmt.Errorf("cache: raw codec cannot unmarshal into %T", v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// msgpackHandle 解码到 interface{} 时使用 map[string]interface{} 和 string，与json一致
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := new(codec.MsgpackHandle)
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}()
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v)
+	return data, err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
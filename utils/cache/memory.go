@@ -0,0 +1,664 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryItem 进程内缓存的一个key，同一时刻只使用其中一种数据结构
+type memoryItem struct {
+	str    []byte
+	hash   map[string][]byte
+	list   [][]byte
+	zset   map[string]float64
+	expire time.Time
+}
+
+func (it *memoryItem) expired(now time.Time) bool {
+	return !it.expire.IsZero() && now.After(it.expire)
+}
+
+// memoryCache 进程内缓存后端，按过期时间惰性淘汰，并由后台协程定期清理
+type memoryCache struct {
+	mu    sync.Mutex
+	items map[string]*memoryItem
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// NewMemory 创建进程内缓存，cleanup为后台清理过期key的间隔，<=0 时只做惰性淘汰
+func NewMemory(cleanup time.Duration) Cache {
+	m := &memoryCache{
+		items: make(map[string]*memoryItem),
+		stop:  make(chan struct{}),
+	}
+	if cleanup > 0 {
+		go m.janitor(cleanup)
+	}
+	return m
+}
+
+func (m *memoryCache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			m.mu.Lock()
+			for k, it := range m.items {
+				if it.expired(now) {
+					delete(m.items, k)
+				}
+			}
+			m.mu.Unlock()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// lookup 取得未过期的key，调用方需持有锁
+func (m *memoryCache) lookup(key string) *memoryItem {
+	it, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+	if it.expired(time.Now()) {
+		delete(m.items, key)
+		return nil
+	}
+	return it
+}
+
+func expireAt(expire int) time.Time {
+	return time.Now().Add(time.Duration(expire) * time.Second)
+}
+
+// copyBytes 复制 b，空值也返回非nil的切片，str 为nil表示不是字符串类型
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+func (m *memoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryCache) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	return nil
+}
+
+func (m *memoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it := m.lookup(key)
+	if it == nil {
+		return nil, ErrNil
+	}
+	if it.str == nil {
+		return nil, ErrWrongType
+	}
+	return copyBytes(it.str), nil
+}
+
+func (m *memoryCache) Set(ctx context.Context, key string, value []byte, expire int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.set(key, value, expire)
+}
+
+// set 与 redis SETEX 一致，expire <= 0 时返回 ErrInvalidExpire
+func (m *memoryCache) set(key string, value []byte, expire int) error {
+	if expire <= 0 {
+		return ErrInvalidExpire
+	}
+	m.items[key] = &memoryItem{str: copyBytes(value), expire: expireAt(expire)}
+	return nil
+}
+
+func (m *memoryCache) SetNX(ctx context.Context, key string, value []byte, expire int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.setNX(key, value, expire)
+}
+
+func (m *memoryCache) setNX(key string, value []byte, expire int) (bool, error) {
+	if expire <= 0 {
+		return false, ErrInvalidExpire
+	}
+	if m.lookup(key) != nil {
+		return false, nil
+	}
+	return true, m.set(key, value, expire)
+}
+
+func (m *memoryCache) Del(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.del(key)
+	return nil
+}
+
+func (m *memoryCache) del(key string) bool {
+	if m.lookup(key) == nil {
+		return false
+	}
+	delete(m.items, key)
+	return true
+}
+
+func (m *memoryCache) Exists(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lookup(key) != nil, nil
+}
+
+func (m *memoryCache) Expire(ctx context.Context, key string, expire int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.expire(key, expire), nil
+}
+
+func (m *memoryCache) expire(key string, expire int) bool {
+	it := m.lookup(key)
+	if it == nil {
+		return false
+	}
+	if expire <= 0 {
+		delete(m.items, key)
+		return true
+	}
+	it.expire = expireAt(expire)
+	return true
+}
+
+func (m *memoryCache) TTL(ctx context.Context, key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it := m.lookup(key)
+	if it == nil {
+		return -2, nil
+	}
+	if it.expire.IsZero() {
+		return -1, nil
+	}
+	// 与redis一致四舍五入到秒
+	return int(time.Until(it.expire).Round(time.Second) / time.Second), nil
+}
+
+// typ 返回key的数据类型，与 redis TYPE 命令的结果一致
+func (it *memoryItem) typ() string {
+	switch {
+	case it.hash != nil:
+		return TYPE_HASH
+	case it.list != nil:
+		return TYPE_LIST
+	case it.zset != nil:
+		return TYPE_ZSET
+	}
+	return TYPE_STRING
+}
+
+// Scan 先在锁内取出所有匹配的key并排序，再分批调用 fn，fn 中可以修改缓存
+func (m *memoryCache) Scan(ctx context.Context, opts ScanOptions, fn func(keys []string) error) error {
+	opts = opts.withDefaults()
+
+	m.mu.Lock()
+	keys := make([]string, 0)
+	now := time.Now()
+	for k, it := range m.items {
+		if it.expired(now) || (opts.Type != "" && it.typ() != opts.Type) {
+			continue
+		}
+		if matchGlob(opts.Match, k) {
+			keys = append(keys, k)
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(keys)
+
+	for len(keys) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := opts.Count
+		if n > len(keys) {
+			n = len(keys)
+		}
+		if err := fn(keys[:n:n]); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// matchGlob 按 redis 的 stringmatch 规则匹配：* 匹配任意字符（包括 /），? 匹配一个字符，
+// [abc]、[^abc]、[a-z] 匹配字符集合，\ 转义下一个字符。与 path.Match 不同，不合法的模式不会报错
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var matched bool
+			matched, pattern = matchClass(pattern[1:], s[0])
+			if !matched {
+				return false
+			}
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass 匹配 [ 之后的字符集合，返回是否匹配和 ] 之后剩余的模式，缺少 ] 时集合延续到模式结尾
+func matchClass(pattern string, c byte) (bool, string) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			pattern = pattern[1:]
+			if pattern[0] == c {
+				matched = true
+			}
+		case len(pattern) >= 3 && pattern[1] == '-':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= c && c <= hi {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case pattern[0] == c:
+			matched = true
+		}
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return matched != not, pattern
+}
+
+func (m *memoryCache) Unlink(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, k := range keys {
+		if m.del(k) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memoryCache) ExpireMany(ctx context.Context, keys []string, expire int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, k := range keys {
+		if m.expire(k, expire) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// hash 取得或创建hash类型的key，调用方需持有锁
+func (m *memoryCache) hash(key string, create bool) (*memoryItem, error) {
+	it := m.lookup(key)
+	if it == nil {
+		if !create {
+			return nil, nil
+		}
+		it = &memoryItem{hash: make(map[string][]byte)}
+		m.items[key] = it
+	}
+	if it.hash == nil {
+		return nil, ErrWrongType
+	}
+	return it, nil
+}
+
+func (m *memoryCache) HSet(ctx context.Context, key string, field string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.hset(key, field, value)
+	return err
+}
+
+// hset 返回新增的字段数
+func (m *memoryCache) hset(key string, field string, value []byte) (int64, error) {
+	it, err := m.hash(key, true)
+	if err != nil {
+		return 0, err
+	}
+	_, exists := it.hash[field]
+	it.hash[field] = copyBytes(value)
+	if exists {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (m *memoryCache) HGet(ctx context.Context, key string, field string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, err := m.hash(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if it == nil {
+		return nil, ErrNil
+	}
+	value, ok := it.hash[field]
+	if !ok {
+		return nil, ErrNil
+	}
+	return copyBytes(value), nil
+}
+
+func (m *memoryCache) HDel(ctx context.Context, key string, field string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.hdel(key, field)
+	return err
+}
+
+// hdel 返回删除的字段数
+func (m *memoryCache) hdel(key string, field string) (int64, error) {
+	it, err := m.hash(key, false)
+	if err != nil || it == nil {
+		return 0, err
+	}
+	if _, ok := it.hash[field]; !ok {
+		return 0, nil
+	}
+	delete(it.hash, field)
+	if len(it.hash) == 0 {
+		delete(m.items, key)
+	}
+	return 1, nil
+}
+
+func (m *memoryCache) HKeys(ctx context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, err := m.hash(key, false)
+	if err != nil || it == nil {
+		return []string{}, err
+	}
+	keys := make([]string, 0, len(it.hash))
+	for field := range it.hash {
+		keys = append(keys, field)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memoryCache) HVals(ctx context.Context, key string) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, err := m.hash(key, false)
+	if err != nil || it == nil {
+		return [][]byte{}, err
+	}
+	fields := make([]string, 0, len(it.hash))
+	for field := range it.hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	values := make([][]byte, 0, len(fields))
+	for _, field := range fields {
+		values = append(values, copyBytes(it.hash[field]))
+	}
+	return values, nil
+}
+
+func (m *memoryCache) HLen(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, err := m.hash(key, false)
+	if err != nil || it == nil {
+		return 0, err
+	}
+	return int64(len(it.hash)), nil
+}
+
+func (m *memoryCache) RPush(ctx context.Context, key string, value []byte) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rpush(key, value)
+}
+
+func (m *memoryCache) rpush(key string, value []byte) (int64, error) {
+	it := m.lookup(key)
+	if it == nil {
+		it = &memoryItem{list: make([][]byte, 0)}
+		m.items[key] = it
+	}
+	if it.list == nil {
+		return 0, ErrWrongType
+	}
+	it.list = append(it.list, copyBytes(value))
+	return int64(len(it.list)), nil
+}
+
+func (m *memoryCache) LPop(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it := m.lookup(key)
+	if it == nil {
+		return nil, ErrNil
+	}
+	if it.list == nil {
+		return nil, ErrWrongType
+	}
+	value := it.list[0]
+	it.list = it.list[1:]
+	if len(it.list) == 0 {
+		delete(m.items, key)
+	}
+	return value, nil
+}
+
+func (m *memoryCache) ZAdd(ctx context.Context, key string, score float64, member []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.zadd(key, score, member)
+}
+
+func (m *memoryCache) zadd(key string, score float64, member []byte) (int, error) {
+	it := m.lookup(key)
+	if it == nil {
+		it = &memoryItem{zset: make(map[string]float64)}
+		m.items[key] = it
+	}
+	if it.zset == nil {
+		return 0, ErrWrongType
+	}
+	_, exists := it.zset[string(member)]
+	it.zset[string(member)] = score
+	if exists {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (m *memoryCache) ZRange(ctx context.Context, key string, start int, stop int) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it := m.lookup(key)
+	if it == nil {
+		return [][]byte{}, nil
+	}
+	if it.zset == nil {
+		return nil, ErrWrongType
+	}
+
+	members := make([]string, 0, len(it.zset))
+	for member := range it.zset {
+		members = append(members, member)
+	}
+	// 与redis一致：按score升序，score相同按成员字典序
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := it.zset[members[i]], it.zset[members[j]]
+		if si != sj {
+			return si < sj
+		}
+		return members[i] < members[j]
+	})
+
+	n := len(members)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	values := make([][]byte, 0)
+	for i := start; i <= stop; i++ {
+		values = append(values, []byte(members[i]))
+	}
+	return values, nil
+}
+
+// memoryPipe 进程内缓存的批量命令，在同一次加锁中执行，总是原子的
+type memoryPipe struct {
+	m   *memoryCache
+	ops []func() (int64, error)
+}
+
+func (p *memoryPipe) add(op func() (int64, error)) {
+	p.ops = append(p.ops, op)
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *memoryPipe) Set(key string, value []byte, expire int) {
+	p.add(func() (int64, error) { return 1, p.m.set(key, value, expire) })
+}
+
+func (p *memoryPipe) SetNX(key string, value []byte, expire int) {
+	p.add(func() (int64, error) {
+		ok, err := p.m.setNX(key, value, expire)
+		return boolInt(ok), err
+	})
+}
+
+func (p *memoryPipe) Del(key string) {
+	p.add(func() (int64, error) { return boolInt(p.m.del(key)), nil })
+}
+
+func (p *memoryPipe) Expire(key string, expire int) {
+	p.add(func() (int64, error) { return boolInt(p.m.expire(key, expire)), nil })
+}
+
+func (p *memoryPipe) HSet(key string, field string, value []byte) {
+	p.add(func() (int64, error) { return p.m.hset(key, field, value) })
+}
+
+func (p *memoryPipe) HDel(key string, field string) {
+	p.add(func() (int64, error) { return p.m.hdel(key, field) })
+}
+
+func (p *memoryPipe) RPush(key string, value []byte) {
+	p.add(func() (int64, error) { return p.m.rpush(key, value) })
+}
+
+func (p *memoryPipe) ZAdd(key string, score float64, member []byte) {
+	p.add(func() (int64, error) {
+		n, err := p.m.zadd(key, score, member)
+		return int64(n), err
+	})
+}
+
+func (m *memoryCache) Pipeline(ctx context.Context, atomic bool, fn func(p Pipe)) ([]int64, error) {
+	p := &memoryPipe{m: m}
+	fn(p)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]int64, len(p.ops))
+	for i, op := range p.ops {
+		n, err := op()
+		if err != nil {
+			return nil, err
+		}
+		results[i] = n
+	}
+	return results, nil
+}
@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestMemory(t *testing.T) *memoryCache {
+	m := NewMemory(0).(*memoryCache)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// expireNow 把key的过期时间改到过去，避免测试中等待
+func (m *memoryCache) expireNow(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key].expire = time.Now().Add(-time.Millisecond)
+}
+
+func TestMemoryTTL(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		setup   func(m *memoryCache)
+		ttl     int
+		exists  bool
+		wantErr error
+	}{
+		{"missing", func(m *memoryCache) {}, -2, false, ErrNil},
+		{"with expire", func(m *memoryCache) { m.Set(ctx, "k", []byte("v"), 60) }, 60, true, nil},
+		{"empty string", func(m *memoryCache) { m.Set(ctx, "k", []byte(""), 60) }, 60, true, nil},
+		{"expired", func(m *memoryCache) {
+			m.Set(ctx, "k", []byte("v"), 60)
+			m.expireNow("k")
+		}, -2, false, ErrNil},
+		{"hash without expire", func(m *memoryCache) { m.HSet(ctx, "k", "f", []byte("v")) }, -1, true, ErrWrongType},
+		{"expire refreshed", func(m *memoryCache) {
+			m.Set(ctx, "k", []byte("v"), 10)
+			m.Expire(ctx, "k", 120)
+		}, 120, true, nil},
+		{"expire <= 0 deletes", func(m *memoryCache) {
+			m.Set(ctx, "k", []byte("v"), 10)
+			m.Expire(ctx, "k", 0)
+		}, -2, false, ErrNil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMemory(t)
+			tt.setup(m)
+			ttl, err := m.TTL(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ttl != tt.ttl {
+				t.Errorf("TTL = %d, want %d", ttl, tt.ttl)
+			}
+			if exists, _ := m.Exists(ctx, "k"); exists != tt.exists {
+				t.Errorf("Exists = %v, want %v", exists, tt.exists)
+			}
+			if _, err := m.Get(ctx, "k"); err != tt.wantErr {
+				t.Errorf("Get error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemorySetInvalidExpire(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t)
+	for _, expire := range []int{0, -1} {
+		if err := m.Set(ctx, "k", []byte("v"), expire); err != ErrInvalidExpire {
+			t.Errorf("Set(expire=%d) error = %v, want ErrInvalidExpire", expire, err)
+		}
+		if _, err := m.SetNX(ctx, "k", []byte("v"), expire); err != ErrInvalidExpire {
+			t.Errorf("SetNX(expire=%d) error = %v, want ErrInvalidExpire", expire, err)
+		}
+	}
+	if exists, _ := m.Exists(ctx, "k"); exists {
+		t.Error("key written with invalid expire")
+	}
+	_, err := m.Pipeline(ctx, true, func(p Pipe) { p.Set("k", []byte("v"), 0) })
+	if err != ErrInvalidExpire {
+		t.Errorf("Pipeline Set error = %v, want ErrInvalidExpire", err)
+	}
+}
+
+func TestMemoryEmptyString(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t)
+	m.Set(ctx, "set", []byte(""), 60)
+	m.SetNX(ctx, "setnx", nil, 60)
+	m.Pipeline(ctx, true, func(p Pipe) { p.Set("pipe", []byte{}, 60) })
+	for _, key := range []string{"set", "setnx", "pipe"} {
+		value, err := m.Get(ctx, key)
+		if err != nil || len(value) != 0 {
+			t.Errorf("Get(%q) = %q, %v, want empty string", key, value, err)
+		}
+	}
+	var got []string
+	m.Scan(ctx, ScanOptions{Type: TYPE_STRING}, func(keys []string) error {
+		got = append(got, keys...)
+		return nil
+	})
+	if len(got) != 3 {
+		t.Errorf("Scan type string = %v, want 3 keys", got)
+	}
+}
+
+func TestMemorySetNX(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		setup func(m *memoryCache)
+		ok    bool
+		value string
+	}{
+		{"missing", func(m *memoryCache) {}, true, "new"},
+		{"exists", func(m *memoryCache) { m.Set(ctx, "k", []byte("old"), 60) }, false, "old"},
+		{"expired", func(m *memoryCache) {
+			m.Set(ctx, "k", []byte("old"), 60)
+			m.expireNow("k")
+		}, true, "new"},
+		{"deleted", func(m *memoryCache) {
+			m.Set(ctx, "k", []byte("old"), 60)
+			m.Del(ctx, "k")
+		}, true, "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMemory(t)
+			tt.setup(m)
+			ok, err := m.SetNX(ctx, "k", []byte("new"), 60)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Errorf("SetNX = %v, want %v", ok, tt.ok)
+			}
+			value, err := m.Get(ctx, "k")
+			if err != nil || string(value) != tt.value {
+				t.Errorf("Get = %q, %v, want %q", value, err, tt.value)
+			}
+		})
+	}
+}
+
+func TestMemoryScan(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemory(t)
+	for _, k := range []string{"user:1", "user:2", "user:10", "user/a/b", "session:1", "a*b", "a?b", "axb"} {
+		m.Set(ctx, k, []byte("v"), 60)
+	}
+	m.HSet(ctx, "user:h", "f", []byte("v"))
+	m.RPush(ctx, "user:l", []byte("v"))
+	m.ZAdd(ctx, "user:z", 1, []byte("v"))
+	m.Set(ctx, "user:expired", []byte("v"), 60)
+	m.expireNow("user:expired")
+
+	tests := []struct {
+		name string
+		opts ScanOptions
+		want []string
+	}{
+		{"all", ScanOptions{}, []string{"a*b", "a?b", "axb", "session:1", "user/a/b", "user:1", "user:10", "user:2", "user:h", "user:l", "user:z"}},
+		{"prefix", ScanOptions{Match: "user:*"}, []string{"user:1", "user:10", "user:2", "user:h", "user:l", "user:z"}},
+		{"star matches slash", ScanOptions{Match: "user*b"}, []string{"user/a/b"}},
+		{"question mark", ScanOptions{Match: "user:?"}, []string{"user:1", "user:2", "user:h", "user:l", "user:z"}},
+		{"class", ScanOptions{Match: "user:[12]"}, []string{"user:1", "user:2"}},
+		{"negated class", ScanOptions{Match: "user:[^0-9]"}, []string{"user:h", "user:l", "user:z"}},
+		{"range", ScanOptions{Match: "user:[a-i]"}, []string{"user:h"}},
+		{"escaped star", ScanOptions{Match: `a\*b`}, []string{"a*b"}},
+		{"escaped question mark", ScanOptions{Match: `a\?b`}, []string{"a?b"}},
+		{"unclosed class", ScanOptions{Match: "user:[12"}, []string{"user:1", "user:2"}},
+		{"type string", ScanOptions{Match: "user:*", Type: TYPE_STRING}, []string{"user:1", "user:10", "user:2"}},
+		{"type hash", ScanOptions{Match: "user:*", Type: TYPE_HASH}, []string{"user:h"}},
+		{"type list", ScanOptions{Type: TYPE_LIST}, []string{"user:l"}},
+		{"type zset", ScanOptions{Type: TYPE_ZSET}, []string{"user:z"}},
+		{"no match", ScanOptions{Match: "none:*"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Count = 2
+			var got []string
+			err := m.Scan(ctx, tt.opts, func(keys []string) error {
+				if len(keys) > 2 {
+					t.Errorf("batch of %d keys, want at most 2", len(keys))
+				}
+				got = append(got, keys...)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Scan(%+v) = %v, want %v", tt.opts, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,332 +1,644 @@
 package cache
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	//	"fmt"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/tracing"
 	"github.com/saisai/gindemo/utils"
+	"github.com/saisai/gindemo/utils/log"
 
-	clog "github.com/cihub/seelog"
 	"github.com/garyburd/redigo/redis"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	MODE_STANDALONE = "standalone"
+	MODE_SENTINEL   = "sentinel"
+	MODE_CLUSTER    = "cluster"
+)
+
+// Options redis连接配置
+type Options struct {
+	Mode             string   // standalone | sentinel | cluster，默认 standalone
+	URL              string   // standalone 的 redis:// 或 rediss://（TLS）地址
+	Addrs            []string // sentinel 为各sentinel的地址，cluster 为用于发现集群的部分节点地址
+	MasterName       string   // sentinel 监控的master名称
+	SentinelPassword string   // 连接sentinel的密码
+
+	Username  string // ACL用户名
+	Password  string // 设置后覆盖 URL 中的密码
+	KeyPrefix string // 所有key的前缀，用于多个服务共用一个redis，代替按DB编号区分
+
+	TLS       bool        // 使用TLS连接，standalone 的 URL 为 rediss:// 时也使用TLS
+	TLSConfig *tls.Config // 为空时使用系统根证书
+
+	MaxIdle        int           // 每个节点的最大空闲连接数
+	MaxActive      int           // 每个节点的最大连接数，0 表示不限制
+	Wait           bool          // 达到 MaxActive 时等待空闲连接，否则立即返回错误
+	IdleTimeout    time.Duration // 空闲连接的关闭时间
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration // 0 表示不超时，需大于jobs的阻塞读取时间
+	WriteTimeout   time.Duration
+}
+
+// connPool 连接池，cluster 模式下 Get 返回按key路由到各节点的连接
+type connPool interface {
+	Get() redis.Conn
+	Close() error
+	ActiveCount() int
+	IdleCount() int
+}
+
 var (
-	pool *redis.Pool
+	pool      connPool
+	options   Options
+	keyPrefix string
 )
 
-func newPool(url, password string, max_idle, idle_timeout int, db int) *redis.Pool {
-	timeout := time.Duration(idle_timeout) * time.Second
+// dialOptions 建立连接的公共参数，readTimeout 单独传入，订阅等长时间阻塞的连接不设置读超时
+func (o *Options) dialOptions(readTimeout time.Duration) []redis.DialOption {
+	opts := []redis.DialOption{
+		redis.DialConnectTimeout(o.ConnectTimeout),
+		redis.DialReadTimeout(readTimeout),
+		redis.DialWriteTimeout(o.WriteTimeout),
+	}
+	if o.TLSConfig != nil {
+		opts = append(opts, redis.DialTLSConfig(o.TLSConfig))
+	}
+	return opts
+}
+
+// dial 连接一个节点并认证，addr 为 host:port，standalone 为空时使用 URL
+func (o *Options) dial(addr string, readTimeout time.Duration) (redis.Conn, error) {
+	var c redis.Conn
+	var err error
+	if addr == "" {
+		opts := o.dialOptions(readTimeout)
+		if o.TLS {
+			opts = append(opts, redis.DialUseTLS(true))
+		}
+		c, err = redis.DialURL(o.URL, opts...)
+	} else {
+		c, err = redis.Dial("tcp", addr, append(o.dialOptions(readTimeout), redis.DialUseTLS(o.TLS))...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// ACL 用户需要 AUTH username password，只有密码时兼容 requirepass
+	switch {
+	case o.Username != "":
+		_, err = c.Do("AUTH", o.Username, o.Password)
+	case o.Password != "":
+		_, err = c.Do("AUTH", o.Password)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (o *Options) newPool(dial func() (redis.Conn, error), test func(c redis.Conn, t time.Time) error) *redis.Pool {
 	return &redis.Pool{
-		MaxIdle:     max_idle,
-		IdleTimeout: timeout,
+		MaxIdle:     o.MaxIdle,
+		MaxActive:   o.MaxActive,
+		Wait:        o.Wait,
+		IdleTimeout: o.IdleTimeout,
 		Dial: func() (redis.Conn, error) {
-			c, err := redis.DialURL(url)
+			c, err := dial()
 			if err != nil {
 				return nil, err
-
 			}
-			c.Do("SELECT", db)
-
-			if password != "" {
-				if _, err := c.Do("AUTH", password); err != nil {
-					c.Close()
-					return nil, err
-
-				}
-			}
-			clog.Info("[redis pool open]")
-			return c, err
-
+			log.Info("[redis pool open]")
+			return c, nil
 		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			if time.Since(t) < time.Minute {
-				return nil
+		TestOnBorrow: test,
+	}
+}
 
-			}
-			_, err := c.Do("PING")
-			return err
+// ping 空闲超过1分钟的连接在使用前检查是否可用
+func ping(c redis.Conn, t time.Time) error {
+	if time.Since(t) < time.Minute {
+		return nil
+	}
+	_, err := c.Do("PING")
+	return err
+}
 
-		},
+// Init 初始化redis连接池，并将其设为缓存后端
+func Init(opts Options) error {
+	var p connPool
+	switch opts.Mode {
+	case MODE_SENTINEL:
+		p = newSentinelPool(&opts)
+	case MODE_CLUSTER:
+		c, err := newCluster(&opts)
+		if err != nil {
+			return err
+		}
+		p = c
+	default:
+		p = opts.newPool(func() (redis.Conn, error) { return opts.dial("", opts.ReadTimeout) }, ping)
 	}
+
+	pool, options, keyPrefix = p, opts, opts.KeyPrefix
+	Use(&redisCache{pool: p, prefix: opts.KeyPrefix})
+	return nil
 }
 
-func Init(url, password string, max_idle, idle_timeout int, db int) {
-	pool = newPool(url, password, max_idle, idle_timeout, db)
+// Key 给key加上配置的前缀，直接使用 Get 返回的连接时需要自己调用
+func Key(key string) string {
+	return keyPrefix + key
 }
 
+// Get 从连接池取得一个redis连接，仅在redis后端下可用。
+// cluster 模式下单条命令按key路由到对应节点；Send 的多条命令在同一个节点上执行，key 需要使用相同的hash tag
 func Get() redis.Conn {
 	if pool == nil {
-		clog.Critical("Please set cache pool first!")
+		log.Error("Please set cache pool first!")
 		return nil
 	}
 	return pool.Get()
 }
 
-func Ping() error {
-	conn := Get()
-	defer conn.Close()
-	_, err := conn.Do("PING")
-	return err
+// Dial 建立一个不属于连接池、没有读超时的连接，用于 SUBSCRIBE 等长时间阻塞的命令，使用后需要 Close。
+// sentinel 模式下连接到当前的master，cluster 模式下连接到任意一个节点
+func Dial() (redis.Conn, error) {
+	switch {
+	case pool == nil:
+		return nil, fmt.Errorf("redis pool is not initialized")
+	case options.Mode == MODE_SENTINEL:
+		return dialMaster(&options, 0)
+	case options.Mode == MODE_CLUSTER:
+		return pool.(*cluster).dialAny()
+	}
+	return options.dial("", 0)
 }
 
-func Close() {
-	clog.Info("[redis pool close]")
-	pool.Close()
+// PoolActiveCount 返回redis连接池中的活跃连接数，未使用redis后端时返回0
+func PoolActiveCount() int {
+	if pool == nil {
+		return 0
+	}
+	return pool.ActiveCount()
 }
 
-func DoMapSet(key string, obj map[int]map[int]string, expire int) {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	value, _ := json.Marshal(obj)
-	// 存入redis
-	_, err := redisConn.Do("SETEX", key, expire, value)
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-
+// PoolIdleCount 返回redis连接池中的空闲连接数，未使用redis后端时返回0
+func PoolIdleCount() int {
+	if pool == nil {
+		return 0
+	}
+	return pool.IdleCount()
 }
-func DoMapGet(key string) (obj map[int]map[int]string) {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	value, err := redis.Bytes(redisConn.Do("GET", key))
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
 
-	// 将json解析成map类型
-	errShal := json.Unmarshal(value, &obj)
-	utils.CheckErr(errShal, utils.CHECK_FLAG_LOGONLY)
+// redisCache 基于redigo连接池的缓存后端，key 在这里加上前缀
+type redisCache struct {
+	pool   connPool
+	prefix string
+}
 
-	return obj
+func (r *redisCache) key(key string) string {
+	return r.prefix + key
 }
 
-func DoSet(key string, obj interface{}, expire int) bool {
-	redisConn := Get()
-	defer redisConn.Close()
+func (r *redisCache) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	_, span := tracing.Tracer().Start(ctx, "redis "+cmd,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName(cmd)),
+	)
 
-	value, _ := json.Marshal(obj)
-	// 存入redis
-	_, err := redisConn.Do("SETEX", key, expire, value)
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-	if err != nil {
-		return false
+	start := time.Now()
+	conn := r.pool.Get()
+	defer conn.Close()
+	reply, err := conn.Do(cmd, args...)
+	failed := err
+	if err == redis.ErrNil {
+		failed = nil
 	}
+	metrics.CacheCommand(cmd, time.Since(start), failed)
+	tracing.End(span, failed)
+	return reply, err
+}
 
-	return true
+func (r *redisCache) Ping(ctx context.Context) error {
+	_, err := r.do(ctx, "PING")
+	return err
 }
 
-func DoSetNx(key string, expire int) bool {
-	redisConn := Get()
-	defer redisConn.Close()
+func (r *redisCache) Close() error {
+	log.Info("[redis pool close]")
+	return r.pool.Close()
+}
 
-	retSetNx, errSetNx := redis.Int(redisConn.Do("SETNX", key, "1"))
-	utils.CheckErr(errSetNx, utils.CHECK_FLAG_LOGONLY)
-	if errSetNx != nil {
-		return false
+func (r *redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := redis.Bytes(r.do(ctx, "GET", r.key(key)))
+	if err == redis.ErrNil {
+		return nil, ErrNil
 	}
+	return value, err
+}
 
-	if retSetNx == 0 {
-		//		fmt.Println("DoSetNx false")
-		return false
+func (r *redisCache) Set(ctx context.Context, key string, value []byte, expire int) error {
+	if expire <= 0 {
+		return ErrInvalidExpire
 	}
+	_, err := r.do(ctx, "SETEX", r.key(key), expire, value)
+	return err
+}
 
-	_, err2 := redisConn.Do("EXPIRE", key, expire)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return false
+func (r *redisCache) SetNX(ctx context.Context, key string, value []byte, expire int) (bool, error) {
+	if expire <= 0 {
+		return false, ErrInvalidExpire
 	}
+	_, err := redis.String(r.do(ctx, "SET", r.key(key), value, "EX", expire, "NX"))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-	//	fmt.Println("DoSetNx true")
-
-	return true
+func (r *redisCache) Del(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", r.key(key))
+	return err
 }
 
-func DoHSet(key string, field string, obj interface{}, expire int) bool {
-	redisConn := Get()
-	defer redisConn.Close()
+func (r *redisCache) Exists(ctx context.Context, key string) (bool, error) {
+	return redis.Bool(r.do(ctx, "EXISTS", r.key(key)))
+}
 
-	value, _ := json.Marshal(obj)
-	// 存入redis
-	_, err := redisConn.Do("HSET", key, field, value)
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-	if err != nil {
-		return false
-	}
-	_, err2 := redisConn.Do("EXPIRE", key, expire)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return false
-	}
+func (r *redisCache) Expire(ctx context.Context, key string, expire int) (bool, error) {
+	return redis.Bool(r.do(ctx, "EXPIRE", r.key(key), expire))
+}
 
-	return true
+func (r *redisCache) TTL(ctx context.Context, key string) (int, error) {
+	return redis.Int(r.do(ctx, "TTL", r.key(key)))
 }
 
-func DoHDel(key string, field string) bool {
-	redisConn := Get()
-	defer redisConn.Close()
+// Scan 用 SCAN 游标遍历带前缀的key，返回的key去掉了前缀，cluster 模式下依次遍历每个master。
+// 前缀中的通配符会被转义；类型过滤在客户端用 TYPE 完成，不依赖 redis 6 的 SCAN TYPE。
+func (r *redisCache) Scan(ctx context.Context, opts ScanOptions, fn func(keys []string) error) error {
+	opts = opts.withDefaults()
+	return r.eachMaster(ctx, "SCAN", func(conn redis.Conn) error {
+		cursor := "0"
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", escapeGlob(r.prefix)+opts.Match, "COUNT", opts.Count))
+			if err == nil && len(reply) != 2 {
+				err = fmt.Errorf("unexpected SCAN reply %v", reply)
+			}
+			if err != nil {
+				return err
+			}
+			cursor, _ = redis.String(reply[0], nil)
+			keys, err := redis.Strings(reply[1], nil)
+			if err != nil {
+				return err
+			}
+			if opts.Type != "" {
+				if keys, err = filterType(conn, keys, opts.Type); err != nil {
+					return err
+				}
+			}
+			for i := range keys {
+				keys[i] = strings.TrimPrefix(keys[i], r.prefix)
+			}
+			if len(keys) > 0 {
+				if err := fn(keys); err != nil {
+					return err
+				}
+			}
+			if cursor == "0" {
+				return nil
+			}
+		}
+	})
+}
 
-	_, err := redisConn.Do("HDEL", key, field)
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-	if err != nil {
-		return false
+// escapeGlob 转义 SCAN MATCH 中的通配符，使前缀按字面匹配
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
 	}
-
-	return true
+	return b.String()
 }
 
-func DoHGet(key string, field string, obj interface{}) bool {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	ret, err1 := redisConn.Do("HGET", key, field)
-	if ret == nil {
-		return false
+// filterType 在同一个连接上批量查询 TYPE，只保留类型为 typ 的key
+func filterType(conn redis.Conn, keys []string, typ string) ([]string, error) {
+	for _, k := range keys {
+		if err := conn.Send("TYPE", k); err != nil {
+			return nil, err
+		}
 	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	matched := keys[:0]
+	for _, k := range keys {
+		t, err := redis.String(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		if t == typ {
+			matched = append(matched, k)
+		}
+	}
+	return matched, nil
+}
 
-	value, err2 := redis.Bytes(ret, err1)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return false
+// batches 把key加上前缀后分成每批最多 batchSize 个，cluster 模式下同一批的key属于同一个slot
+func (r *redisCache) batches(keys []string) [][]interface{} {
+	groups := make(map[int][]interface{})
+	order := make([]int, 0)
+	_, isCluster := r.pool.(*cluster)
+	for _, k := range keys {
+		s := 0
+		if isCluster {
+			s = slot(r.key(k))
+		}
+		if _, ok := groups[s]; !ok {
+			order = append(order, s)
+		}
+		groups[s] = append(groups[s], r.key(k))
 	}
 
-	if value == nil {
-		return false
+	var batches [][]interface{}
+	for _, s := range order {
+		g := groups[s]
+		for len(g) > batchSize {
+			batches = append(batches, g[:batchSize])
+			g = g[batchSize:]
+		}
+		batches = append(batches, g)
 	}
+	return batches
+}
 
-	err3 := json.Unmarshal(value, obj)
-	utils.CheckErr(err3, utils.CHECK_FLAG_LOGONLY)
-	if err3 != nil {
-		return false
+// Unlink 分批执行 UNLINK，key 在redis后台释放，不会因为大key阻塞
+func (r *redisCache) Unlink(ctx context.Context, keys ...string) (int64, error) {
+	var n int64
+	for _, batch := range r.batches(keys) {
+		deleted, err := redis.Int64(r.do(ctx, "UNLINK", batch...))
+		n += deleted
+		if err != nil {
+			return n, err
+		}
 	}
+	return n, nil
+}
 
-	return true
+// ExpireMany 分批在一个连接上发送 EXPIRE，返回设置成功的数量
+func (r *redisCache) ExpireMany(ctx context.Context, keys []string, expire int) (int64, error) {
+	var n int64
+	for _, batch := range r.batches(keys) {
+		cmds := make([]redisCmd, 0, len(batch))
+		for _, k := range batch {
+			cmds = append(cmds, redisCmd{name: "EXPIRE", args: []interface{}{k, expire}})
+		}
+		_, span := tracing.Tracer().Start(ctx, "redis PIPELINE",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName("PIPELINE")),
+		)
+		start := time.Now()
+		conn := r.pool.Get()
+		replies, err := r.pipeline(conn, false, cmds)
+		conn.Close()
+		metrics.CacheCommand("PIPELINE", time.Since(start), err)
+		tracing.End(span, err)
+		if err != nil {
+			return n, err
+		}
+		for _, reply := range replies {
+			if e, ok := reply.(redis.Error); ok {
+				return n, e
+			}
+			if v, _ := redis.Int64(reply, nil); v == 1 {
+				n++
+			}
+		}
+	}
+	return n, nil
 }
 
-// 设置key的过期时间
-func DoExpire(key string, expire int) bool {
-	redisConn := Get()
-	defer redisConn.Close()
+// eachMaster 在每个master的连接上执行 fn，非 cluster 模式下只有一个
+func (r *redisCache) eachMaster(ctx context.Context, cmd string, fn func(conn redis.Conn) error) error {
+	_, span := tracing.Tracer().Start(ctx, "redis "+cmd,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName(cmd)),
+	)
 
-	ret, err := redisConn.Do("EXPIRE", key, expire)
-	//	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-	if err != nil {
-		return false
-	}
-	value, _ := redis.Int(ret, err)
-	if value == 1 {
-		return true
+	start := time.Now()
+	var err error
+	if c, ok := r.pool.(*cluster); ok {
+		err = c.eachMaster(fn)
 	} else {
-		return false
+		conn := r.pool.Get()
+		err = fn(conn)
+		conn.Close()
 	}
+	metrics.CacheCommand(cmd, time.Since(start), err)
+	tracing.End(span, err)
+	return err
 }
 
-func DoDel(key string) bool {
-	redisConn := Get()
-	defer redisConn.Close()
+func (r *redisCache) HSet(ctx context.Context, key string, field string, value []byte) error {
+	_, err := r.do(ctx, "HSET", r.key(key), field, value)
+	return err
+}
 
-	_, err := redisConn.Do("DEL", key)
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-	if err != nil {
-		return false
+func (r *redisCache) HGet(ctx context.Context, key string, field string) ([]byte, error) {
+	value, err := redis.Bytes(r.do(ctx, "HGET", r.key(key), field))
+	if err == redis.ErrNil {
+		return nil, ErrNil
 	}
+	return value, err
+}
 
-	return true
+func (r *redisCache) HDel(ctx context.Context, key string, field string) error {
+	_, err := r.do(ctx, "HDEL", r.key(key), field)
+	return err
 }
 
-// DoGet obj:结构体指针 返回值 true：取到值 false：未取到值
-func DoGet(key string, obj interface{}) bool {
-	redisConn := Get()
-	defer redisConn.Close()
+func (r *redisCache) HKeys(ctx context.Context, key string) ([]string, error) {
+	return redis.Strings(r.do(ctx, "HKEYS", r.key(key)))
+}
 
-	ret, err1 := redisConn.Do("GET", key)
-	if ret == nil {
-		return false
-	}
+func (r *redisCache) HVals(ctx context.Context, key string) ([][]byte, error) {
+	return redis.ByteSlices(r.do(ctx, "HVALS", r.key(key)))
+}
 
-	value, err2 := redis.Bytes(ret, err1)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return false
-	}
+func (r *redisCache) HLen(ctx context.Context, key string) (int64, error) {
+	return redis.Int64(r.do(ctx, "HLEN", r.key(key)))
+}
 
-	err3 := json.Unmarshal(value, obj)
-	utils.CheckErr(err3, utils.CHECK_FLAG_LOGONLY)
-	if err3 != nil {
-		return false
+func (r *redisCache) RPush(ctx context.Context, key string, value []byte) (int64, error) {
+	return redis.Int64(r.do(ctx, "RPUSH", r.key(key), value))
+}
+
+func (r *redisCache) LPop(ctx context.Context, key string) ([]byte, error) {
+	value, err := redis.Bytes(r.do(ctx, "LPOP", r.key(key)))
+	if err == redis.ErrNil {
+		return nil, ErrNil
 	}
+	return value, err
+}
 
-	return true
+func (r *redisCache) ZAdd(ctx context.Context, key string, score float64, member []byte) (int, error) {
+	return redis.Int(r.do(ctx, "ZADD", r.key(key), score, member))
 }
 
-func DoFlushDb() bool {
-	redisConn := Get()
-	defer redisConn.Close()
+func (r *redisCache) ZRange(ctx context.Context, key string, start int, stop int) ([][]byte, error) {
+	return redis.ByteSlices(r.do(ctx, "ZRANGE", r.key(key), start, stop))
+}
 
-	_, _ = redisConn.Do("FLUSHDB")
-	return true
+// redisPipe 缓存待发送的命令，Pipeline 中一次发送
+type redisPipe struct {
+	prefix string
+	cmds   []redisCmd
 }
 
-func DoStrSet(key string, obj string, expire int) bool {
-	redisConn := Get()
-	defer redisConn.Close()
+type redisCmd struct {
+	name string
+	args []interface{}
+}
 
-	// 存入redis
-	ret, err := redisConn.Do("SETEX", key, expire, obj)
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-	if ret == false {
-		return false
-	} else {
-		return true
-	}
+// add 第一个参数为key，加上前缀
+func (p *redisPipe) add(name string, key string, args ...interface{}) {
+	p.cmds = append(p.cmds, redisCmd{name: name, args: append([]interface{}{p.prefix + key}, args...)})
+}
 
+func (p *redisPipe) Set(key string, value []byte, expire int) {
+	p.add("SETEX", key, expire, value)
 }
 
-func DoStrGet(key string) (ret bool, obj string) {
-	redisConn := Get()
-	defer redisConn.Close()
+func (p *redisPipe) SetNX(key string, value []byte, expire int) {
+	p.add("SET", key, value, "EX", expire, "NX")
+}
 
-	retGet, err1 := redisConn.Do("get", key)
-	if retGet == nil {
-		return false, ""
-	}
+func (p *redisPipe) Del(key string) {
+	p.add("DEL", key)
+}
 
-	if err1 == nil {
-		value, err2 := redis.String(retGet, err1)
-		utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-		if err2 == nil {
-			return true, value
-		} else {
-			return false, ""
-		}
-	} else {
-		return false, ""
-	}
+func (p *redisPipe) Expire(key string, expire int) {
+	p.add("EXPIRE", key, expire)
+}
 
+func (p *redisPipe) HSet(key string, field string, value []byte) {
+	p.add("HSET", key, field, value)
 }
 
-func DoKeys(key string) (ret bool, keys []string) {
+func (p *redisPipe) HDel(key string, field string) {
+	p.add("HDEL", key, field)
+}
 
-	redisConn := Get()
-	defer redisConn.Close()
+func (p *redisPipe) RPush(key string, value []byte) {
+	p.add("RPUSH", key, value)
+}
 
-	retGet, err1 := redisConn.Do("keys", key)
-	if retGet == nil {
-		return false, nil
+func (p *redisPipe) ZAdd(key string, score float64, member []byte) {
+	p.add("ZADD", key, score, member)
+}
+
+// pipeResult 把命令的返回值转为整数：整数原样返回，OK 为1，nil（SET NX 未写入）为0
+func pipeResult(reply interface{}) (int64, error) {
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string, []byte:
+		return 1, nil
+	case nil:
+		return 0, nil
+	case redis.Error:
+		return 0, v
 	}
+	return 0, fmt.Errorf("cache: unexpected reply type %T", reply)
+}
 
-	if err1 == nil {
-		value, err2 := redis.Strings(retGet, err1)
-		utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-		if err2 == nil {
-			return true, value
-		} else {
-			return false, nil
+func (r *redisCache) Pipeline(ctx context.Context, atomic bool, fn func(p Pipe)) ([]int64, error) {
+	p := &redisPipe{prefix: r.prefix}
+	fn(p)
+	if len(p.cmds) == 0 {
+		return []int64{}, nil
+	}
+
+	op := "PIPELINE"
+	if atomic {
+		op = "MULTI"
+	}
+	_, span := tracing.Tracer().Start(ctx, "redis "+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName(op)),
+	)
+
+	start := time.Now()
+	conn := r.pool.Get()
+	defer conn.Close()
+	replies, err := r.pipeline(conn, atomic, p.cmds)
+	metrics.CacheCommand(op, time.Since(start), err)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]int64, len(replies))
+	for i, reply := range replies {
+		if results[i], err = pipeResult(reply); err != nil {
+			return nil, err
 		}
-	} else {
-		return false, nil
 	}
+	return results, nil
+}
 
+func (r *redisCache) pipeline(conn redis.Conn, atomic bool, cmds []redisCmd) ([]interface{}, error) {
+	if atomic {
+		conn.Send("MULTI")
+	}
+	for _, cmd := range cmds {
+		if err := conn.Send(cmd.name, cmd.args...); err != nil {
+			return nil, err
+		}
+	}
+	if atomic {
+		// EXEC 前排队的命令有错误时，Do 返回该错误，redis 会放弃整个事务
+		return redis.Values(conn.Do("EXEC"))
+	}
+
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		// 单条命令的错误放在结果中，读完所有回复后再返回
+		reply, err := conn.Receive()
+		if e, ok := err.(redis.Error); ok {
+			reply = e
+		} else if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
 }
 
+// 以下函数直接使用调用方持有的redis连接，仅在redis后端下可用，key 不会自动加上前缀，需要时使用 Key
+
 func DoStrHSetConn(key string, field string, value string, conn redis.Conn) {
 
 	// 存入redis
@@ -363,54 +675,6 @@ func DoHDelConn(key string, field string, conn redis.Conn) bool {
 	return true
 }
 
-func DoHkeys(key string) []string {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	ret, err := redis.Strings(redisConn.Do("hkeys", key))
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-
-	return ret
-}
-
-func DoHVals(key string) (bool, []interface{}) {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	ret, err1 := redisConn.Do("HVals", key)
-	if ret == nil {
-		return false, nil
-	}
-
-	value, err2 := redis.ByteSlices(ret, err1)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return false, nil
-	}
-
-	obj := make([]interface{}, 0)
-	for _, v := range value {
-		var f interface{}
-		err3 := json.Unmarshal(v, &f)
-		if err3 != nil {
-			return false, nil
-		}
-		obj = append(obj, f)
-	}
-
-	return true, obj
-}
-
-func DoHLen(key string) int64 {
-	redisConn := Get()
-	defer redisConn.Close()
-	// 存入redis
-	ret, err := redis.Int64(redisConn.Do("hlen", key))
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-
-	return ret
-}
-
 func DoSetConn(key string, obj interface{}, expire int, conn redis.Conn) bool {
 
 	value, _ := json.Marshal(obj)
@@ -445,164 +709,3 @@ func DoGetConn(key string, obj interface{}, conn redis.Conn) bool {
 
 	return true
 }
-
-func DoRPush(key string, obj interface{}, expire int) bool {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	value, _ := json.Marshal(obj)
-	// 存入redis
-	_, err := redisConn.Do("RPUSH", key, value)
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-	if err != nil {
-		return false
-	}
-	_, err2 := redisConn.Do("EXPIRE", key, expire)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return false
-	}
-
-	return true
-}
-
-func DoLPop(key string, obj interface{}) bool {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	ret, err1 := redisConn.Do("LPop", key)
-	if ret == nil {
-		return false
-	}
-
-	value, err2 := redis.Bytes(ret, err1)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return false
-	}
-
-	if value == nil {
-		return false
-	}
-
-	err3 := json.Unmarshal(value, obj)
-	utils.CheckErr(err3, utils.CHECK_FLAG_LOGONLY)
-	if err3 != nil {
-		return false
-	}
-
-	return true
-}
-
-// 共享锁
-// -------------------------------------------------------------------
-// lockStart 开始一个分布式锁,retLock:是否锁成功 ，尝试n次，每次间隔100毫秒
-// cntTry:尝试次数 redisKeyEx：锁的超时时间，单位：秒，该值必须大于1秒
-func LockStart(redisKey string, redisKeyEx int, cntTry int) (retLock bool) {
-	retLock = false
-	for i := 0; i < cntTry; i++ {
-		retLock = DoSetNx(redisKey, redisKeyEx)
-		if retLock == true {
-			break
-		} else {
-			// 休眠200毫秒
-			time.Sleep(500 * time.Millisecond)
-		}
-	}
-
-	return retLock
-}
-
-// lockEnd 结束一个分布式锁
-func LockEnd(redisKey string) {
-	DoDel(redisKey)
-}
-
-// lockHeart 锁的心跳,锁的超时时间很短，一旦没有心跳，锁就自动解锁
-// redisKeyEx：每次心跳时会重置key的超时时间，用来保持锁定状态，该值必须大于1秒
-// expire:心跳超时时间，单位：秒，如果忘记关闭心跳，超时后心跳结束
-func LockHeart(redisKey string, redisKeyEx int, expire float64) {
-	nowT := utils.GetNowUTC2()
-	for {
-		ret := DoExpire(redisKey, redisKeyEx)
-		if ret == false {
-			break
-		}
-		time.Sleep(1 * time.Second)
-		// 如果超过心跳超时时间，则心跳退出
-		diff := utils.DtDiff(nowT, utils.GetNowUTC2())
-		if diff.Seconds() > expire {
-			break
-		}
-	}
-}
-
-func DoZAdd(key string, score float64, obj interface{}) (int, bool) {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	value, _ := json.Marshal(obj)
-	// 存入redis
-	ret, err1 := redisConn.Do("ZAdd", key, score, value)
-	utils.CheckErr(err1, utils.CHECK_FLAG_LOGONLY)
-	if err1 != nil {
-		return -1, false
-	}
-
-	ret2, err2 := redis.Int(ret, err1)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return -1, false
-	}
-
-	return ret2, true
-}
-
-func DoZRange(key string, start int, stop int) (bool, []interface{}) {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	ret, err1 := redisConn.Do("ZRange", key, start, stop)
-	if ret == nil {
-		return false, nil
-	}
-
-	value, err2 := redis.ByteSlices(ret, err1)
-	utils.CheckErr(err2, utils.CHECK_FLAG_LOGONLY)
-	if err2 != nil {
-		return false, nil
-	}
-
-	obj := make([]interface{}, 0)
-	for _, v := range value {
-		var f interface{}
-		err3 := json.Unmarshal(v, &f)
-		if err3 != nil {
-			return false, nil
-		}
-		obj = append(obj, f)
-	}
-
-	//	err3 := json.Unmarshal(value, obj)
-	//	utils.CheckErr(err3, utils.CHECK_FLAG_LOGONLY)
-	//	if err3 != nil {
-	//		return false
-	//	}
-
-	return true, obj
-}
-
-func DoExists(key string) bool {
-	redisConn := Get()
-	defer redisConn.Close()
-
-	exists, err := redis.Bool(redisConn.Do("EXISTS", key))
-	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-	if err != nil {
-		return false
-	}
-
-	return exists
-}
-
-// -------------------------------------------------------------------
@@ -0,0 +1,35 @@
+package cache
+
+import "testing"
+
+func TestEscapeGlob(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+		match  []string // 加上 * 后应该匹配的key
+		other  []string // 不应该匹配的key
+	}{
+		{"", "", []string{"a", ""}, nil},
+		{"svc:", "svc:", []string{"svc:a"}, []string{"svcx"}},
+		{"a*b:", `a\*b:`, []string{"a*b:k"}, []string{"axb:k", "ab:k"}},
+		{"a?:", `a\?:`, []string{"a?:k"}, []string{"ax:k"}},
+		{"[ab]:", `\[ab\]:`, []string{"[ab]:k"}, []string{"a:k", "b:k"}},
+		{`a\b:`, `a\\b:`, []string{`a\b:k`}, []string{"ab:k"}},
+	}
+	for _, tt := range tests {
+		got := escapeGlob(tt.prefix)
+		if got != tt.want {
+			t.Errorf("escapeGlob(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+		for _, key := range tt.match {
+			if !matchGlob(got+"*", key) {
+				t.Errorf("%q does not match %q", got+"*", key)
+			}
+		}
+		for _, key := range tt.other {
+			if matchGlob(got+"*", key) {
+				t.Errorf("%q matches %q", got+"*", key)
+			}
+		}
+	}
+}
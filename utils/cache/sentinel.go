@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// sentinel 模式：每次建立连接时向sentinel查询当前的master，故障切换后新建的连接会连到新的master。
+// 已有的连接在借出前检查角色，不再是master的连接被丢弃。
+
+// masterAddr 依次询问各sentinel，返回第一个应答的master地址
+func masterAddr(o *Options) (string, error) {
+	var lastErr error
+	for _, addr := range o.Addrs {
+		opts := append(o.dialOptions(o.ConnectTimeout), redis.DialUseTLS(o.TLS), redis.DialPassword(o.SentinelPassword))
+		c, err := redis.Dial("tcp", addr, opts...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reply, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", o.MasterName))
+		c.Close()
+		if err == nil && len(reply) != 2 {
+			err = fmt.Errorf("unexpected reply %v", reply)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("sentinel %s: %v", addr, err)
+			continue
+		}
+		return net.JoinHostPort(reply[0], reply[1]), nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no sentinel configured")
+	}
+	return "", fmt.Errorf("get master %q: %v", o.MasterName, lastErr)
+}
+
+// isMaster 检查连接的节点当前是否为master
+func isMaster(c redis.Conn) error {
+	role, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(role) == 0 {
+		return fmt.Errorf("empty ROLE reply")
+	}
+	if name, _ := redis.String(role[0], nil); name != "master" {
+		return fmt.Errorf("node role is %q, not master", name)
+	}
+	return nil
+}
+
+// dialMaster 连接到sentinel报告的master，sentinel尚未完成切换时连接到的可能仍是旧master，检查角色后返回
+func dialMaster(o *Options, readTimeout time.Duration) (redis.Conn, error) {
+	addr, err := masterAddr(o)
+	if err != nil {
+		return nil, err
+	}
+	c, err := o.dial(addr, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := isMaster(c); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("%s: %v", addr, err)
+	}
+	return c, nil
+}
+
+func newSentinelPool(o *Options) *redis.Pool {
+	return o.newPool(
+		func() (redis.Conn, error) {
+			c, err := dialMaster(o, o.ReadTimeout)
+			if err != nil {
+				log.Warn("[redis sentinel] dial master failed", "master", o.MasterName, "error", err)
+			}
+			return c, err
+		},
+		// 空闲超过1秒的连接检查角色，故障切换后尽快丢弃连到旧master的连接
+		func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Second {
+				return nil
+			}
+			return isMaster(c)
+		},
+	)
+}
@@ -1,15 +1,25 @@
 package captcha
 
 import (
+	"context"
+
 	"github.com/dchest/captcha"
+	"github.com/saisai/gindemo/metrics"
 	"github.com/saisai/gindemo/utils"
 	"github.com/saisai/gindemo/utils/cache"
 )
 
+// KEY_PREFIX 验证码key的前缀，cache cleanup 据此识别验证码
+const KEY_PREFIX = "captcha:"
+
 var (
 	SR *StoreRedis
 )
 
+func key(id string) string {
+	return KEY_PREFIX + id
+}
+
 type StoreRedis struct {
 }
 type ImgBytes struct {
@@ -19,11 +29,11 @@ type ImgBytes struct {
 func (s *StoreRedis) Set(id string, digits []byte) {
 	obj := new(ImgBytes)
 	obj.Img = digits
-	cache.DoSet(id, obj, utils.TIME_MINUTE_FIVE)
+	cache.DoSetCtx(context.Background(), key(id), obj, utils.TIME_MINUTE_FIVE)
 }
 func (s *StoreRedis) Get(id string, clear bool) (digits []byte) {
 	obj := new(ImgBytes)
-	_ = cache.DoGet(id, obj)
+	_ = cache.DoGetCtx(context.Background(), key(id), obj)
 	return obj.Img
 }
 
@@ -34,13 +44,14 @@ func InitCaptcha() {
 
 func NewLen(length int) (id string) {
 	captchaId := captcha.NewLen(length)
+	metrics.CaptchaIssued()
 	return captchaId
 }
 
-func VerifyString(id string, digits string) bool {
+func VerifyString(ctx context.Context, id string, digits string) bool {
 	ret := captcha.VerifyString(id, digits)
 	// 验证一次以后就失效
-	cache.DoDel(id)
+	cache.DoDelCtx(ctx, key(id))
 	return ret
 }
 
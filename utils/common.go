@@ -4,6 +4,7 @@ const (
 	REPORT_ID_CYDEX_DAY  = "001" //CYDEX 按天统计报表
 	REPORT_ID_CYDEX_HOUR = "002" //CYDEX 按小时统计报表
 
+	// Deprecated: redis cluster 只有 DB 0，不再按DB编号区分服务，使用 [redis] key_prefix
 	REDIS_DB_STATISTICS_USER_INFO_DT = 1
 	REDIS_DB_STATISTICS_CYDEX_DAY    = 2
 	REDIS_DB_STATISTICS_CYDEX_HOUR   = 3
@@ -37,8 +38,12 @@ const (
 	//时区字母缩写 MST
 
 	// 日期格式 YYYY-MM-DD
+	//
+	// Deprecated: 使用 timeutil.DATE
 	DATE_FORMAT_SHORT = "2006-01-02"
 	// 时间格式 YYYY-MM-DD HH:MM:SS
+	//
+	// Deprecated: 使用 timeutil.DATETIME
 	DATE_FORMAT_LONG = "2006-01-02 15:04:05"
 )
 
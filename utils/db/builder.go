@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MAX_PLACEHOLDERS MySQL 一条预处理语句最多的参数个数
+const MAX_PLACEHOLDERS = 65535
+
+// Placeholders 返回 n 个以逗号分隔的 ?
+func Placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
+// Builder 拼接SQL和参数，参数始终通过 ? 传递，不会拼进SQL文本。
+//
+//	query, args := db.SQL("SELECT id FROM user WHERE sex = ?", 1).
+//		Append(" AND ").In("id", ids).Build()
+type Builder struct {
+	sb   strings.Builder
+	args []interface{}
+	err  error
+}
+
+// SQL 以 query 开始一条语句
+func SQL(query string, args ...interface{}) *Builder {
+	return new(Builder).Append(query, args...)
+}
+
+// Append 追加一段SQL和它的参数，SQL 中的每个 ? 都算作一个占位符
+func (b *Builder) Append(query string, args ...interface{}) *Builder {
+	if n := strings.Count(query, "?"); n != len(args) && b.err == nil {
+		b.err = fmt.Errorf("db: %q has %d placeholders but %d args", query, n, len(args))
+	}
+	b.sb.WriteString(query)
+	b.args = append(b.args, args...)
+	return b
+}
+
+// In 追加 column IN (?,?,...)，values 为任意类型的切片；切片为空时追加 1 = 0，不匹配任何行
+func (b *Builder) In(column string, values interface{}) *Builder {
+	v := reflect.ValueOf(values)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		if b.err == nil {
+			b.err = fmt.Errorf("db: IN values for %s must be a slice, got %T", column, values)
+		}
+		return b
+	}
+	if v.Len() == 0 {
+		b.sb.WriteString("1 = 0")
+		return b
+	}
+
+	b.sb.WriteString(column)
+	b.sb.WriteString(" IN (")
+	b.sb.WriteString(Placeholders(v.Len()))
+	b.sb.WriteString(")")
+	for i := 0; i < v.Len(); i++ {
+		b.args = append(b.args, v.Index(i).Interface())
+	}
+	return b
+}
+
+// String 返回目前拼接的SQL
+func (b *Builder) String() string {
+	return b.sb.String()
+}
+
+// Args 返回目前的参数
+func (b *Builder) Args() []interface{} {
+	return b.args
+}
+
+// Err 返回拼接过程中的错误，例如占位符与参数个数不一致
+func (b *Builder) Err() error {
+	return b.err
+}
+
+// Build 返回SQL和参数，调用方需检查 Err；QueryBuilt、ExecBuilt 会直接返回拼接错误
+func (b *Builder) Build() (string, []interface{}) {
+	return b.String(), b.args
+}
+
+// QueryBuilt 执行 b 拼接的查询，见 Query
+func QueryBuilt[T any](ctx context.Context, q Querier, b *Builder) ([]T, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return Query[T](ctx, q, b.String(), b.args...)
+}
+
+// ExecBuilt 执行 b 拼接的语句
+func ExecBuilt(ctx context.Context, q Querier, b *Builder) (int64, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	result, err := Exec(ctx, q, b.String(), b.args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// BatchInsert 多行 INSERT，超过 BatchSize 行或参数个数上限时拆成多条语句
+type BatchInsert struct {
+	table     string
+	columns   []string
+	rows      [][]interface{}
+	updates   []string
+	err       error
+	BatchSize int // 每条语句最多的行数，默认 500
+}
+
+// NewBatchInsert 创建向 table 的 columns 插入数据的批量语句
+func NewBatchInsert(table string, columns ...string) *BatchInsert {
+	return &BatchInsert{table: table, columns: columns, BatchSize: 500}
+}
+
+// Row 添加一行，values 与 columns 一一对应
+func (bi *BatchInsert) Row(values ...interface{}) *BatchInsert {
+	if len(values) != len(bi.columns) && bi.err == nil {
+		bi.err = fmt.Errorf("db: insert into %s: row has %d values, expected %d", bi.table, len(values), len(bi.columns))
+	}
+	bi.rows = append(bi.rows, values)
+	return bi
+}
+
+// OnDuplicateUpdate 唯一索引冲突时用新值更新 columns，不指定时冲突返回错误
+func (bi *BatchInsert) OnDuplicateUpdate(columns ...string) *BatchInsert {
+	bi.updates = append(bi.updates, columns...)
+	return bi
+}
+
+// Len 返回已添加的行数
+func (bi *BatchInsert) Len() int {
+	return len(bi.rows)
+}
+
+// Builders 按批拆分后的语句，没有行时返回空
+func (bi *BatchInsert) Builders() ([]*Builder, error) {
+	if bi.err != nil {
+		return nil, bi.err
+	}
+	if len(bi.columns) == 0 {
+		return nil, fmt.Errorf("db: insert into %s: no columns", bi.table)
+	}
+
+	size := bi.BatchSize
+	if size <= 0 {
+		size = 500
+	}
+	if max := MAX_PLACEHOLDERS / len(bi.columns); size > max {
+		size = max
+	}
+
+	cols := make([]string, len(bi.columns))
+	for i, c := range bi.columns {
+		cols[i] = quote(c)
+	}
+	head := "INSERT INTO " + quote(bi.table) + " (" + strings.Join(cols, ", ") + ") VALUES "
+	row := "(" + Placeholders(len(bi.columns)) + ")"
+	tail := ""
+	if len(bi.updates) > 0 {
+		sets := make([]string, len(bi.updates))
+		for i, c := range bi.updates {
+			sets[i] = quote(c) + " = VALUES(" + quote(c) + ")"
+		}
+		tail = " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+
+	var builders []*Builder
+	for start := 0; start < len(bi.rows); start += size {
+		end := start + size
+		if end > len(bi.rows) {
+			end = len(bi.rows)
+		}
+		b := new(Builder)
+		b.sb.WriteString(head)
+		for i, values := range bi.rows[start:end] {
+			if i > 0 {
+				b.sb.WriteString(", ")
+			}
+			b.sb.WriteString(row)
+			b.args = append(b.args, values...)
+		}
+		b.sb.WriteString(tail)
+		builders = append(builders, b)
+	}
+	return builders, nil
+}
+
+// Exec 依次执行每一批，返回影响的总行数；需要全部成功或全部失败时在事务中执行（见 WithTx）
+func (bi *BatchInsert) Exec(ctx context.Context, q Querier) (int64, error) {
+	builders, err := bi.Builders()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, b := range builders {
+		n, err := ExecBuilt(ctx, q, b)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// quote 用反引号括起表名和列名，db.table 分别括起
+func quote(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = "`" + strings.ReplaceAll(p, "`", "``") + "`"
+	}
+	return strings.Join(parts, ".")
+}
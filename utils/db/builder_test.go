@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilderPlaceholders(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     *Builder
+		query string
+		args  []interface{}
+		err   bool
+	}{
+		{"match", SQL("a = ?", 1).Append(" AND b = ?", 2), "a = ? AND b = ?", []interface{}{1, 2}, false},
+		{"no placeholders", SQL("SELECT 1"), "SELECT 1", nil, false},
+		{"too few args", SQL("a = ? AND b = ?", 1), "a = ? AND b = ?", []interface{}{1}, true},
+		{"too many args", SQL("a = 1", 1), "a = 1", []interface{}{1}, true},
+		{"later append", SQL("a = ?", 1).Append(" AND b = ?"), "a = ? AND b = ?", []interface{}{1}, true},
+		{"in", SQL("SELECT id FROM user WHERE ").In("id", []int64{1, 2, 3}), "SELECT id FROM user WHERE id IN (?,?,?)", []interface{}{int64(1), int64(2), int64(3)}, false},
+		{"in array", SQL("").In("id", [2]string{"a", "b"}), "id IN (?,?)", []interface{}{"a", "b"}, false},
+		{"in empty", SQL("SELECT id FROM user WHERE ").In("id", []int{}), "SELECT id FROM user WHERE 1 = 0", nil, false},
+		{"in nil slice", SQL("").In("id", []string(nil)).Append(" AND a = ?", 1), "1 = 0 AND a = ?", []interface{}{1}, false},
+		{"in not a slice", SQL("").In("id", 1), "", nil, true},
+	}
+	for _, tt := range tests {
+		query, args := tt.b.Build()
+		if query != tt.query || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: Build = %q, %v, want %q, %v", tt.name, query, args, tt.query, tt.args)
+		}
+		if (tt.b.Err() != nil) != tt.err {
+			t.Errorf("%s: Err = %v, want error %v", tt.name, tt.b.Err(), tt.err)
+		}
+	}
+}
+
+func TestBuiltErrorNotExecuted(t *testing.T) {
+	f, db := newFakeDB(t)
+	ctx := context.Background()
+	b := SQL("UPDATE user SET a = ? WHERE id = ?", 1)
+	if _, err := ExecBuilt(ctx, db, b); err == nil || err != b.Err() {
+		t.Errorf("ExecBuilt error = %v, want %v", err, b.Err())
+	}
+	if _, err := QueryBuilt[int](ctx, db, b); err == nil || err != b.Err() {
+		t.Errorf("QueryBuilt error = %v, want %v", err, b.Err())
+	}
+	if len(f.prepared) != 0 || len(f.execs) != 0 {
+		t.Errorf("statement with mismatched placeholders was sent: %v", f.prepared)
+	}
+}
+
+func TestBatchInsert(t *testing.T) {
+	bi := NewBatchInsert("db.user", "id", "name").OnDuplicateUpdate("name")
+	bi.BatchSize = 2
+	for i := 0; i < 5; i++ {
+		bi.Row(i, "n")
+	}
+	builders, err := bi.Builders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"INSERT INTO `db`.`user` (`id`, `name`) VALUES (?,?), (?,?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`)",
+		"INSERT INTO `db`.`user` (`id`, `name`) VALUES (?,?), (?,?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`)",
+		"INSERT INTO `db`.`user` (`id`, `name`) VALUES (?,?) ON DUPLICATE KEY UPDATE `name` = VALUES(`name`)",
+	}
+	if len(builders) != len(want) {
+		t.Fatalf("got %d statements, want %d", len(builders), len(want))
+	}
+	for i, b := range builders {
+		if b.String() != want[i] || len(b.Args()) != strings.Count(want[i], "?") {
+			t.Errorf("statement %d = %q with %d args", i, b.String(), len(b.Args()))
+		}
+	}
+
+	if _, err := NewBatchInsert("user", "id", "name").Row(1).Builders(); err == nil {
+		t.Error("row with wrong number of values: want error")
+	}
+	if _, err := NewBatchInsert("user").Row().Builders(); err == nil {
+		t.Error("no columns: want error")
+	}
+	if builders, err := NewBatchInsert("user", "id").Builders(); err != nil || len(builders) != 0 {
+		t.Errorf("no rows = %d statements, %v, want none", len(builders), err)
+	}
+}
+
+func TestBatchInsertMaxPlaceholders(t *testing.T) {
+	const columns = 7
+	perStatement := MAX_PLACEHOLDERS / columns
+	tests := []struct {
+		batchSize int
+		rows      int
+		sizes     []int
+	}{
+		{0, 1200, []int{500, 500, 200}}, // 默认 500 行
+		{100000, perStatement*2 + 1, []int{perStatement, perStatement, 1}},
+		{perStatement, perStatement, []int{perStatement}},
+		{perStatement + 1, perStatement + 1, []int{perStatement, 1}},
+	}
+	for _, tt := range tests {
+		bi := NewBatchInsert("t", "a", "b", "c", "d", "e", "f", "g")
+		bi.BatchSize = tt.batchSize
+		row := make([]interface{}, columns)
+		for i := 0; i < tt.rows; i++ {
+			bi.Row(row...)
+		}
+		builders, err := bi.Builders()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes := make([]int, len(builders))
+		for i, b := range builders {
+			if len(b.Args()) > MAX_PLACEHOLDERS {
+				t.Errorf("batch size %d: statement %d has %d args", tt.batchSize, i, len(b.Args()))
+			}
+			sizes[i] = len(b.Args()) / columns
+		}
+		if !reflect.DeepEqual(sizes, tt.sizes) {
+			t.Errorf("batch size %d, %d rows: statements of %v rows, want %v", tt.batchSize, tt.rows, sizes, tt.sizes)
+		}
+	}
+}
+
+func TestBatchInsertExec(t *testing.T) {
+	f, db := newFakeDB(t)
+	bi := NewBatchInsert("user", "id")
+	bi.BatchSize = 2
+	bi.Row(1).Row(2).Row(3)
+	n, err := bi.Exec(context.Background(), db)
+	if err != nil || n != 2 {
+		t.Errorf("Exec = %d, %v, want 2 (one per statement from the fake driver)", n, err)
+	}
+	if len(f.execs) != 2 || len(f.execs[0]) != 2 || len(f.execs[1]) != 1 {
+		t.Errorf("executed %v", f.execs)
+	}
+}
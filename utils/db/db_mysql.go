@@ -2,12 +2,11 @@ package db
 
 import (
 	"database/sql"
-	"fmt"
 	"time"
 
 	"github.com/saisai/gindemo/utils"
+	"github.com/saisai/gindemo/utils/log"
 
-	clog "github.com/cihub/seelog"
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -16,7 +15,7 @@ func OpenDB(url string, maxLT int, maxOC int, maxIC int) (DBmysql *sql.DB) {
 
 	DBmysql, err = sql.Open("mysql", url)
 	if err != nil {
-		clog.Critical(err.Error())
+		log.Error("open db", "error", err)
 		panic(err)
 	}
 	utils.CheckErr(err, utils.CHECK_FLAG_EXIT)
@@ -25,7 +24,7 @@ func OpenDB(url string, maxLT int, maxOC int, maxIC int) (DBmysql *sql.DB) {
 	DBmysql.SetMaxOpenConns(maxOC)
 	DBmysql.SetMaxIdleConns(maxIC)
 
-	clog.Info("[db opened]")
+	log.Info("[db opened]")
 
 	//	clog.Infof("[db opened] url:'%s'", url)
 	//	clog.Infof("[db opened] max_life_time:'%d'", maxLT)
@@ -38,12 +37,12 @@ func OpenDB(url string, maxLT int, maxOC int, maxIC int) (DBmysql *sql.DB) {
 
 func CloseDB(DBmysql *sql.DB) {
 	DBmysql.Close()
-	clog.Info("[db closed] mysql")
+	log.Info("[db closed] mysql")
 }
 
 //func DoQuery(DBmysql *sql.DB, sql string, args ...interface{}) (results map[int]map[int]string, err error) {
 
-//	clog.Trace("[sql]: ", sql+" args:"+utils.Args2Str(args...))
+//	log.Debug("[sql]", "sql", sql, "args", utils.Args2Str(args...))
 
 //	rows, err := DBmysql.Query(sql, args...)
 //	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
@@ -77,17 +76,24 @@ func CloseDB(DBmysql *sql.DB) {
 
 //}
 
+// DoQuery 每一行的各列都转为字符串，NULL 为空字符串
+//
+// Deprecated: 无法区分 NULL 和空字符串，使用 Query 扫描到结构体或 QueryMaps
 func DoQuery(DBmysql *sql.DB, sql string, args ...interface{}) (results [][]string, err error) {
 
-	clog.Trace("[sql]: ", sql+" args:"+utils.Args2Str(args...))
+	log.Debug("[sql]", "sql", sql, "args", utils.Args2Str(args...))
 
 	rows, err := DBmysql.Query(sql, args...)
 	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	cols, _ := rows.Columns()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
 	values := make([][]byte, len(cols))
 	scans := make([]interface{}, len(cols))
 	for i := range values {
@@ -95,19 +101,21 @@ func DoQuery(DBmysql *sql.DB, sql string, args ...interface{}) (results [][]stri
 	}
 	results = make([][]string, 0)
 
-	i := 0
 	for rows.Next() {
-
-		err = rows.Scan(scans...)
-		utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
-		row := make([]string, 0)
+		if err = rows.Scan(scans...); err != nil {
+			utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+			return nil, err
+		}
+		row := make([]string, 0, len(values))
 		for _, v := range values { //每行数据是放在values里面，现在把它挪到row里
 			row = append(row, string(v))
 		}
 		results = append(results, row) //装入结果集中
-		i++
 	}
-	rows.Close()
+	if err = rows.Err(); err != nil {
+		utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
+		return nil, err
+	}
 
 	return results, nil
 
@@ -115,7 +123,7 @@ func DoQuery(DBmysql *sql.DB, sql string, args ...interface{}) (results [][]stri
 
 func DoExec(DBmysql *sql.DB, sql string, args ...interface{}) (bool, error) {
 
-	clog.Trace("[sql]: ", sql+" args:"+utils.Args2Str(args...))
+	log.Debug("[sql]", "sql", sql, "args", utils.Args2Str(args...))
 
 	_, err := DBmysql.Exec(sql, args...)
 	utils.CheckErr(err, utils.CHECK_FLAG_LOGONLY)
@@ -127,6 +135,8 @@ func DoExec(DBmysql *sql.DB, sql string, args ...interface{}) (bool, error) {
 }
 
 // DoExecBatch 开启事务，执行批处理
+//
+// Deprecated: 遇到死锁不会重试，使用 WithTx
 func DoExecBatch(DBmysql *sql.DB, sqls []string, args [][]interface{}) (bool, error) {
 	tx, errBegin := DBmysql.Begin()
 	utils.CheckErr(errBegin, utils.CHECK_FLAG_LOGONLY)
@@ -135,13 +145,13 @@ func DoExecBatch(DBmysql *sql.DB, sqls []string, args [][]interface{}) (bool, er
 	}
 	var errExec error
 	for idx, sql := range sqls {
-		clog.Trace("[sql]: ", sql+" args:"+utils.Args2Str(args[idx]...))
+		log.Debug("[sql]", "sql", sql, "args", utils.Args2Str(args[idx]...))
 		_, errExec = tx.Exec(sql, args[idx]...)
 		utils.CheckErr(errExec, utils.CHECK_FLAG_LOGONLY)
 		if errExec != nil {
 			errRollback := tx.Rollback()
 			utils.CheckErr(errRollback, utils.CHECK_FLAG_LOGONLY)
-			clog.Error("[sql]:" + sql)
+			log.Error("[sql] exec failed, rolled back", "sql", sql, "error", errExec)
 			return false, errExec
 		}
 	}
@@ -157,17 +167,8 @@ func DoExecBatch(DBmysql *sql.DB, sqls []string, args [][]interface{}) (bool, er
 }
 
 // SqlColon 返回 ?,?,?......
+//
+// Deprecated: 使用 Placeholders，或用 Builder.In 同时生成占位符和参数
 func SqlColon(cnt int) string {
-	if cnt == 0 {
-		return ""
-	}
-	sql := ""
-	for i := 0; i < cnt; i++ {
-		sql = sql + `?,`
-	}
-	if utils.Substring(sql, len(sql)-1, len(sql)) == "," {
-		sql = utils.Substring(sql, 0, len(sql)-1)
-	}
-	fmt.Println("SqlColon:" + sql)
-	return sql
+	return Placeholders(cnt)
 }
@@ -1,5 +1,8 @@
 package db
 
+// OpenDB 的参考值。
+//
+// Deprecated: 服务使用的连接池在配置文件 [db] 的 max_open_conns、max_idle_conns、conn_max_lifetime 中设置
 const (
 	DEFAULT_MAX_LIFE_TIME  = 300
 	DEFAULT_MAX_OPEN_CONNS = 1000
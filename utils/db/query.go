@@ -0,0 +1,234 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/saisai/gindemo/utils/log"
+)
+
+// 带 ctx 的原生SQL查询，结果扫描到结构体、单列的基本类型或 map。
+// 可与 xorm 共用连接池：Querier 可以是 models.DB().DB().DB、*sql.Tx、*sql.Conn 或 StmtCache。
+
+// Querier 可以执行查询的对象
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// ErrNoRows Get 没有查询到记录
+var ErrNoRows = sql.ErrNoRows
+
+// Query 执行查询并把每一行扫描为 T。
+// T 为结构体时按列名匹配字段：字段的 db 标签，没有标签时为字段名的 snake_case（与 xorm 默认的映射一致），
+// 嵌入的结构体字段展开匹配，标签为 "-" 的字段忽略，没有对应字段的列返回错误。
+// T 不是结构体（或为 time.Time、实现了 sql.Scanner 的类型）时查询只能返回一列。
+// NULL 扫描到指针字段时为 nil，扫描到非指针字段时为零值；DATETIME 可以扫描到 time.Time 和 string，
+// DSN 没有 parseTime=true 时按 UTC 解析。
+func Query[T any](ctx context.Context, q Querier, query string, args ...interface{}) ([]T, error) {
+	results := make([]T, 0)
+	err := each(ctx, q, query, args, func(rows *sql.Rows, cols []string) error {
+		var v T
+		if err := scanRow(rows, cols, reflect.ValueOf(&v).Elem()); err != nil {
+			return err
+		}
+		results = append(results, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Get 执行查询并把第一行扫描为 T，没有记录时返回 ErrNoRows
+func Get[T any](ctx context.Context, q Querier, query string, args ...interface{}) (T, error) {
+	var v T
+	found := false
+	err := each(ctx, q, query, args, func(rows *sql.Rows, cols []string) error {
+		if found {
+			return nil
+		}
+		found = true
+		return scanRow(rows, cols, reflect.ValueOf(&v).Elem())
+	})
+	if err == nil && !found {
+		err = ErrNoRows
+	}
+	return v, err
+}
+
+// QueryMaps 执行查询，每一行为列名到值的 map；值为 nil（NULL）、int64、float64、bool、string 或 time.Time，
+// 驱动返回的 []byte 转为 string
+func QueryMaps(ctx context.Context, q Querier, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	results := make([]map[string]interface{}, 0)
+	err := each(ctx, q, query, args, func(rows *sql.Rows, cols []string) error {
+		values := make([]interface{}, len(cols))
+		scans := make([]interface{}, len(cols))
+		for i := range values {
+			scans[i] = &values[i]
+		}
+		if err := rows.Scan(scans...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+		results = append(results, row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Exec 执行不返回结果集的语句
+func Exec(ctx context.Context, q Querier, query string, args ...interface{}) (sql.Result, error) {
+	log.DebugCtx(ctx, "[sql]", "sql", query, "args", args)
+	result, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("db: exec: %w", err)
+	}
+	return result, nil
+}
+
+// each 逐行调用 fn，最后检查 rows.Err
+func each(ctx context.Context, q Querier, query string, args []interface{}, fn func(rows *sql.Rows, cols []string) error) error {
+	log.DebugCtx(ctx, "[sql]", "sql", query, "args", args)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("db: query: %w", err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("db: columns: %w", err)
+	}
+	for rows.Next() {
+		if err := fn(rows, cols); err != nil {
+			return fmt.Errorf("db: scan: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("db: rows: %w", err)
+	}
+	return nil
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(timeZero)
+)
+
+// isScalar 是否把整个值作为一列扫描
+func isScalar(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() != reflect.Struct || t == timeType || reflect.PtrTo(t).Implements(scannerType)
+}
+
+func scanRow(rows *sql.Rows, cols []string, v reflect.Value) error {
+	if isScalar(v.Type()) {
+		if len(cols) != 1 {
+			return fmt.Errorf("%d columns returned, %s can only hold one", len(cols), v.Type())
+		}
+		return rows.Scan(&field{v})
+	}
+
+	fields := structFields(v.Type())
+	scans := make([]interface{}, len(cols))
+	for i, col := range cols {
+		index, ok := fields[strings.ToLower(col)]
+		if !ok {
+			return fmt.Errorf("column %q has no matching field in %s", col, v.Type())
+		}
+		scans[i] = &field{fieldByIndex(v, index)}
+	}
+	return rows.Scan(scans...)
+}
+
+// fieldByIndex 取得字段，途中的嵌入结构体指针为 nil 时创建
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// fieldCache 结构体类型 -> 小写列名 -> 字段索引
+var fieldCache sync.Map
+
+func structFields(t reflect.Type) map[string][]int {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	collectFields(t, nil, fields)
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func collectFields(t reflect.Type, parent []int, fields map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("db")
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		index := append(append([]int{}, parent...), i)
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct && !isScalar(ft) {
+			collectFields(ft, index, fields)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = snakeCase(f.Name)
+		}
+		// 外层的字段优先于嵌入结构体中的同名字段
+		key := strings.ToLower(name)
+		if old, ok := fields[key]; !ok || len(index) < len(old) {
+			fields[key] = index
+		}
+	}
+}
+
+// snakeCase 与 xorm SnakeMapper 相同：CreateTime -> create_time，UserID -> user_i_d
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		if c >= 'A' && c <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c += 'a' - 'A'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"xorm.io/core"
+)
+
+// fakeDB 测试用的驱动：查询返回 rows 中为该SQL设置的结果，记录 Prepare 和 Exec 的次数
+type fakeDB struct {
+	mu       sync.Mutex
+	rows     map[string]*fakeResult
+	prepared map[string]int
+	execs    [][]driver.Value
+}
+
+type fakeResult struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	f := &fakeDB{rows: make(map[string]*fakeResult), prepared: make(map[string]int)}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+	return f, db
+}
+
+func (f *fakeDB) result(query string, columns []string, values ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rows[query] = &fakeResult{columns: columns, values: values}
+}
+
+func (f *fakeDB) Connect(ctx context.Context) (driver.Conn, error) { return &fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                            { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.prepared[query]++
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.execs = append(s.db.execs, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	r, ok := s.db.rows[s.query]
+	if !ok {
+		return nil, errors.New("unexpected query " + s.query)
+	}
+	return &fakeRows{result: r}, nil
+}
+
+type fakeRows struct {
+	result *fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.values) {
+		return io.EOF
+	}
+	copy(dest, r.result.values[r.next])
+	r.next++
+	return nil
+}
+
+type base struct {
+	Id         int64
+	CreateTime time.Time
+}
+
+type nullUser struct {
+	base
+	Name     string
+	Nick     *string
+	Age      int
+	Score    *float64
+	Birthday *time.Time
+	Remark   string `db:"memo"`
+	Ignored  string `db:"-"`
+}
+
+func TestQueryNull(t *testing.T) {
+	f, db := newFakeDB(t)
+	ctx := context.Background()
+	cols := []string{"id", "create_time", "name", "nick", "age", "score", "birthday", "memo"}
+	f.result("users", cols,
+		[]driver.Value{int64(1), nil, nil, nil, nil, nil, nil, nil},
+		[]driver.Value{int64(2), []byte("2026-01-02 03:04:05"), []byte("n"), []byte("k"), int64(30), 1.5, []byte("0000-00-00 00:00:00"), "m"},
+	)
+
+	users, err := Query[nullUser](ctx, db, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("got %d rows, want 2", len(users))
+	}
+	if want := (nullUser{base: base{Id: 1}}); !reflect.DeepEqual(users[0], want) {
+		t.Errorf("NULL row = %+v, want zero values and nil pointers", users[0])
+	}
+
+	u := users[1]
+	if u.Id != 2 || !u.CreateTime.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)) || u.Name != "n" || u.Age != 30 || u.Remark != "m" {
+		t.Errorf("row = %+v", u)
+	}
+	if u.Nick == nil || *u.Nick != "k" || u.Score == nil || *u.Score != 1.5 {
+		t.Errorf("pointer fields = %v, %v", u.Nick, u.Score)
+	}
+	if u.Birthday == nil || !u.Birthday.IsZero() {
+		t.Errorf("zero DATETIME into *time.Time = %v, want pointer to zero time", u.Birthday)
+	}
+}
+
+func TestQueryScalarNull(t *testing.T) {
+	f, db := newFakeDB(t)
+	ctx := context.Background()
+	f.result("one", []string{"n"}, []driver.Value{nil}, []driver.Value{int64(7)})
+	f.result("two", []string{"a", "b"}, []driver.Value{int64(1), int64(2)})
+
+	ints, err := Query[int](ctx, db, "one")
+	if err != nil || !reflect.DeepEqual(ints, []int{0, 7}) {
+		t.Errorf("Query[int] = %v, %v, want [0 7]", ints, err)
+	}
+	ptrs, err := Query[*int](ctx, db, "one")
+	if err != nil || len(ptrs) != 2 || ptrs[0] != nil || ptrs[1] == nil || *ptrs[1] != 7 {
+		t.Errorf("Query[*int] = %v, %v, want [nil 7]", ptrs, err)
+	}
+	var null sql.NullInt64
+	if null, err = Get[sql.NullInt64](ctx, db, "one"); err != nil || null.Valid {
+		t.Errorf("Get[sql.NullInt64] = %+v, %v, want invalid", null, err)
+	}
+	if _, err := Query[int](ctx, db, "two"); err == nil {
+		t.Error("two columns into int: want error")
+	}
+	if _, err := Query[struct{ A int }](ctx, db, "two"); err == nil {
+		t.Error("column without field: want error")
+	}
+	f.result("empty", []string{"n"})
+	if _, err := Get[int](ctx, db, "empty"); err != ErrNoRows {
+		t.Errorf("Get without rows error = %v, want ErrNoRows", err)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	mapper := core.SnakeMapper{}
+	for _, name := range []string{"Id", "UserId", "CreateTime", "UserID", "ID", "URL", "HTMLParser", "A", "Name2", "Ab_cd", "X9Y"} {
+		if got, want := snakeCase(name), mapper.Obj2Table(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, xorm SnakeMapper = %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var timeZero time.Time
+
+// DATETIME_LAYOUT MySQL DATETIME 的文本格式，DSN 没有 parseTime=true 时驱动返回这个格式
+const DATETIME_LAYOUT = "2006-01-02 15:04:05"
+
+// field 把一列扫描到任意类型的值，处理 NULL 和 DATETIME
+type field struct {
+	v reflect.Value
+}
+
+func (f *field) Scan(src interface{}) error {
+	v := f.v
+	if src == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if s, ok := v.Addr().Interface().(sql.Scanner); ok {
+		return s.Scan(src)
+	}
+	return assign(v, src)
+}
+
+// assign 把驱动返回的值（int64、float64、bool、[]byte、string、time.Time）转换后写入 v
+func assign(v reflect.Value, src interface{}) error {
+	if v.Type() == timeType {
+		t, err := toTime(src)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	if v.Kind() == reflect.Interface {
+		if b, ok := src.([]byte); ok {
+			src = string(b)
+		}
+		v.Set(reflect.ValueOf(src))
+		return nil
+	}
+
+	if t, ok := src.(time.Time); ok {
+		if v.Kind() == reflect.String {
+			v.SetString(t.Format(DATETIME_LAYOUT))
+			return nil
+		}
+		return fmt.Errorf("cannot assign time to %s", v.Type())
+	}
+
+	var s string
+	switch src := src.(type) {
+	case []byte:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), src...))
+			return nil
+		}
+		s = string(src)
+	case string:
+		s = src
+	case int64:
+		s = strconv.FormatInt(src, 10)
+	case float64:
+		s = strconv.FormatFloat(src, 'g', -1, 64)
+	case bool:
+		s = strconv.FormatBool(src)
+	default:
+		return fmt.Errorf("unsupported source type %T", src)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("cannot assign %T to %s", src, v.Type())
+		}
+		v.SetBytes([]byte(s))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("convert %q to %s: %v", s, v.Type(), err)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("convert %q to %s: %v", s, v.Type(), err)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("convert %q to %s: %v", s, v.Type(), err)
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("convert %q to %s: %v", s, v.Type(), err)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("cannot assign %T to %s", src, v.Type())
+	}
+	return nil
+}
+
+// toTime 解析 DATETIME，文本格式按 UTC 解析，0000-00-00 00:00:00 为零值
+func toTime(src interface{}) (time.Time, error) {
+	var s string
+	switch src := src.(type) {
+	case time.Time:
+		return src, nil
+	case []byte:
+		s = string(src)
+	case string:
+		s = src
+	default:
+		return timeZero, fmt.Errorf("cannot assign %T to time.Time", src)
+	}
+	if s == "" || s[0] == '0' && (s == "0000-00-00" || len(s) >= 19 && s[:19] == "0000-00-00 00:00:00") {
+		return timeZero, nil
+	}
+	for _, layout := range []string{DATETIME_LAYOUT + ".999999999", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return timeZero, fmt.Errorf("cannot parse %q as DATETIME", s)
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestToTime(t *testing.T) {
+	local := time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("UTC+8", 8*3600))
+	tests := []struct {
+		src  interface{}
+		want time.Time
+	}{
+		{"0000-00-00 00:00:00", timeZero},
+		{[]byte("0000-00-00 00:00:00"), timeZero},
+		{"0000-00-00 00:00:00.000000", timeZero},
+		{"0000-00-00", timeZero},
+		{"", timeZero},
+		{"2026-01-02 03:04:05", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{[]byte("2026-01-02 03:04:05.123456"), time.Date(2026, 1, 2, 3, 4, 5, 123456000, time.UTC)},
+		{"2026-01-02", time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{local, local},
+	}
+	for _, tt := range tests {
+		got, err := toTime(tt.src)
+		if err != nil {
+			t.Errorf("toTime(%v) error = %v", tt.src, err)
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location() != tt.want.Location() {
+			t.Errorf("toTime(%v) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+
+	for _, src := range []interface{}{"abc", "2026-13-01", "0000-00-00 00:00:01", int64(1)} {
+		if got, err := toTime(src); err == nil {
+			t.Errorf("toTime(%v) = %v, want error", src, got)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// StmtCache 缓存预处理语句，同一条SQL只 Prepare 一次，超过上限时关闭最久未使用的。
+// 实现了 Querier，可以直接传给 Query、Get、Exec；在事务中使用时通过 Tx 取得绑定到事务的语句。
+type StmtCache struct {
+	db  *sql.DB
+	max int
+
+	mu    sync.Mutex
+	lru   *list.List // 元素为 *cachedStmt，最近使用的在前
+	stmts map[string]*list.Element
+}
+
+type cachedStmt struct {
+	query string
+	stmt  *sql.Stmt
+	// refs 正在 Query/Exec 的调用数，被淘汰的语句在 refs 归零后关闭
+	refs    int
+	evicted bool
+}
+
+// NewStmtCache 创建最多缓存 max 条语句的缓存，max <= 0 时为 100
+func NewStmtCache(db *sql.DB, max int) *StmtCache {
+	if max <= 0 {
+		max = 100
+	}
+	return &StmtCache{
+		db:    db,
+		max:   max,
+		lru:   list.New(),
+		stmts: make(map[string]*list.Element),
+	}
+}
+
+// acquire 返回 query 的预处理语句并增加引用，用完后调用 release
+func (c *StmtCache) acquire(ctx context.Context, query string) (*cachedStmt, error) {
+	c.mu.Lock()
+	if e, ok := c.stmts[query]; ok {
+		c.lru.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.refs++
+		c.mu.Unlock()
+		return cs, nil
+	}
+	c.mu.Unlock()
+
+	// Prepare 期间不持有锁，并发准备同一条SQL时保留先放入的
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.stmts[query]; ok {
+		stmt.Close()
+		c.lru.MoveToFront(e)
+		cs := e.Value.(*cachedStmt)
+		cs.refs++
+		return cs, nil
+	}
+	cs := &cachedStmt{query: query, stmt: stmt, refs: 1}
+	c.stmts[query] = c.lru.PushFront(cs)
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Remove(c.lru.Back()).(*cachedStmt)
+		delete(c.stmts, oldest.query)
+		oldest.evicted = true
+		if oldest.refs == 0 {
+			oldest.stmt.Close()
+		}
+	}
+	return cs, nil
+}
+
+// release 减少引用，已被淘汰且没有引用的语句关闭。
+// 关闭后仍在读取的 Rows 不受影响，database/sql 在它们结束后才真正释放语句
+func (c *StmtCache) release(cs *cachedStmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cs.refs--
+	if cs.evicted && cs.refs == 0 {
+		cs.stmt.Close()
+	}
+}
+
+func (c *StmtCache) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	cs, err := c.acquire(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(cs)
+	return cs.stmt.QueryContext(ctx, args...)
+}
+
+func (c *StmtCache) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	cs, err := c.acquire(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(cs)
+	return cs.stmt.ExecContext(ctx, args...)
+}
+
+// Tx 返回在事务 tx 中使用缓存语句的 Querier
+func (c *StmtCache) Tx(tx *sql.Tx) Querier {
+	return &txStmts{cache: c, tx: tx}
+}
+
+// Len 返回缓存的语句数
+func (c *StmtCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Close 关闭所有缓存的语句，正在使用的语句在使用结束后关闭
+func (c *StmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		cs := e.Value.(*cachedStmt)
+		cs.evicted = true
+		if cs.refs > 0 {
+			continue
+		}
+		if err := cs.stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.lru.Init()
+	c.stmts = make(map[string]*list.Element)
+	return firstErr
+}
+
+// txStmts 把缓存的语句绑定到事务上执行
+type txStmts struct {
+	cache *StmtCache
+	tx    *sql.Tx
+}
+
+func (t *txStmts) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	cs, err := t.cache.acquire(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer t.cache.release(cs)
+	return t.tx.StmtContext(ctx, cs.stmt).QueryContext(ctx, args...)
+}
+
+func (t *txStmts) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	cs, err := t.cache.acquire(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer t.cache.release(cs)
+	return t.tx.StmtContext(ctx, cs.stmt).ExecContext(ctx, args...)
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+// queryStmt 用语句执行一次查询，语句已关闭时返回错误
+func queryStmt(ctx context.Context, stmt *sql.Stmt) error {
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+func TestStmtCacheReuse(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.result("a", []string{"n"}, []driver.Value{int64(1)})
+	c := NewStmtCache(db, 2)
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if n, err := Get[int](ctx, c, "a"); err != nil || n != 1 {
+			t.Fatalf("Get = %d, %v", n, err)
+		}
+	}
+	if f.prepared["a"] != 1 || c.Len() != 1 {
+		t.Errorf("prepared %d times, %d cached, want 1 and 1", f.prepared["a"], c.Len())
+	}
+}
+
+func TestStmtCacheEvictInUse(t *testing.T) {
+	f, db := newFakeDB(t)
+	for _, q := range []string{"a", "b", "c"} {
+		f.result(q, []string{"n"}, []driver.Value{int64(1)})
+	}
+	c := NewStmtCache(db, 1)
+	defer c.Close()
+	ctx := context.Background()
+
+	inUse, err := c.acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 淘汰正在使用的 a，使用结束前不能关闭
+	if _, err := Get[int](ctx, c, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 1 || !inUse.evicted {
+		t.Fatalf("cached %d, a evicted %v, want 1 and true", c.Len(), inUse.evicted)
+	}
+	if err := queryStmt(ctx, inUse.stmt); err != nil {
+		t.Errorf("evicted statement in use was closed: %v", err)
+	}
+
+	c.release(inUse)
+	if err := queryStmt(ctx, inUse.stmt); err == nil {
+		t.Error("evicted statement still open after release")
+	}
+
+	// 没有在使用的语句淘汰时直接关闭
+	b := c.stmts["b"].Value.(*cachedStmt)
+	if _, err := Get[int](ctx, c, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := queryStmt(ctx, b.stmt); err == nil {
+		t.Error("evicted idle statement still open")
+	}
+
+	// 再次使用被淘汰的SQL时重新 Prepare
+	if _, err := Get[int](ctx, c, "a"); err != nil || f.prepared["a"] != 2 {
+		t.Errorf("Get a after eviction = %v, prepared %d times, want 2", err, f.prepared["a"])
+	}
+}
+
+func TestStmtCacheCloseInUse(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.result("a", []string{"n"}, []driver.Value{int64(1)})
+	c := NewStmtCache(db, 2)
+	ctx := context.Background()
+
+	inUse, err := c.acquire(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("cached %d after Close", c.Len())
+	}
+	if err := queryStmt(ctx, inUse.stmt); err != nil {
+		t.Errorf("statement in use was closed by Close: %v", err)
+	}
+	c.release(inUse)
+	if err := queryStmt(ctx, inUse.stmt); err == nil {
+		t.Error("statement still open after release")
+	}
+}
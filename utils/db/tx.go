@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/saisai/gindemo/utils/log"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+
+	// DEFAULT_TX_ATTEMPTS WithTx 遇到死锁时最多执行的次数
+	DEFAULT_TX_ATTEMPTS = 3
+)
+
+// IsRetryable 判断是否为死锁或锁等待超时，这两种错误重新执行整个事务通常可以成功
+func IsRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+}
+
+// Retry 执行 fn，返回 IsRetryable 的错误时等待一小段随机时间后重试，最多执行 attempts 次。
+// fn 必须可以重复执行：每次都重新开始事务，不依赖上一次执行留下的状态。
+func Retry(ctx context.Context, attempts int, fn func() error) error {
+	if attempts <= 0 {
+		attempts = DEFAULT_TX_ATTEMPTS
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			// 10ms、20ms、40ms... 加上随机抖动，避免冲突的事务同时重试再次死锁
+			backoff := time.Duration(10<<uint(i-1)) * time.Millisecond
+			backoff += time.Duration(rand.Int63n(int64(backoff)))
+			log.WarnCtx(ctx, "[sql] transaction retry", "attempt", i+1, "backoff", backoff, "error", err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err = fn(); err == nil || !IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// WithTx 在事务中执行 fn，fn 返回错误或 panic 时回滚，否则提交；死锁或锁等待超时时整个事务重试，见 Retry
+func WithTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	return Retry(ctx, DEFAULT_TX_ATTEMPTS, func() error {
+		return runTx(ctx, db, opts, fn)
+	})
+}
+
+func runTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("db: begin: %w", err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -2,72 +2,367 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
-	"net"
-	// "crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/saisai/gindemo/tracing"
+	"github.com/saisai/gindemo/utils/log"
 )
 
-// NOTE: inherit from http.DefaultTransport, and skip server cert verify
-var defaultTransport = &http.Transport{
-	Proxy: http.ProxyFromEnvironment,
-	DialContext: (&net.Dialer{
+// Options 出站http客户端配置
+type Options struct {
+	Timeout            time.Duration // 单次请求的超时，包括读取响应body
+	CAFile             string        // 额外信任的CA证书（PEM），为空时只使用系统CA
+	CertFile           string        // mTLS 客户端证书，与 KeyFile 同时配置时生效
+	KeyFile            string
+	InsecureSkipVerify bool // 跳过服务端证书校验，仅用于测试环境
+
+	MaxRetries      int           // 幂等请求失败后的最大重试次数
+	RetryBackoff    time.Duration // 第一次重试前的等待时间，之后每次翻倍，并加入随机抖动
+	RetryMaxBackoff time.Duration
+
+	BreakerFailures int           // 同一主机连续失败多少次后熔断，0 表示不熔断
+	BreakerCooldown time.Duration // 熔断后多久放行探测请求
+
+	// AllowAddr 不为nil时只允许连接返回true的IP，在域名解析之后、建立连接之前检查，
+	// 不受DNS重绑定影响。设置后不使用环境变量中的代理，否则检查的是代理的地址
+	AllowAddr func(ip net.IP) bool
+}
+
+// DefaultOptions 返回默认配置
+func DefaultOptions() Options {
+	return Options{
+		Timeout:         5 * time.Second,
+		MaxRetries:      2,
+		RetryBackoff:    100 * time.Millisecond,
+		RetryMaxBackoff: 2 * time.Second,
+		BreakerFailures: 5,
+		BreakerCooldown: 30 * time.Second,
+	}
+}
+
+// StatusError 响应的状态码不是 2xx
+type StatusError struct {
+	Method string
+	URL    string
+	Code   int
+	Body   []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http: %s %s: unexpected status %d", e.Method, e.URL, e.Code)
+}
+
+// Client 出站http客户端：默认校验服务端证书，幂等请求失败时按指数退避重试，
+// 每个目标主机有独立的熔断器。可在多个goroutine中共用。
+type Client struct {
+	client *http.Client
+	opts   Options
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+var defaultClient, _ = NewClient(DefaultOptions())
+
+// Init 按配置替换 CallAPI 等函数使用的默认客户端
+func Init(opts Options) error {
+	c, err := NewClient(opts)
+	if err != nil {
+		return err
+	}
+	defaultClient = c
+	return nil
+}
+
+// Default 返回默认客户端
+func Default() *Client {
+	return defaultClient
+}
+
+// NewClient 创建客户端
+func NewClient(opts Options) (*Client, error) {
+	tlsConfig, err := newTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
-		DualStack: true,
-	}).DialContext,
-	MaxIdleConns:          100,
-	IdleConnTimeout:       90 * time.Second,
-	TLSHandshakeTimeout:   10 * time.Second,
-	ExpectContinueTimeout: 1 * time.Second,
-	TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+	}
+	proxy := http.ProxyFromEnvironment
+	if opts.AllowAddr != nil {
+		dialer.Control = allowControl(opts.AllowAddr)
+		proxy = nil
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+
+	return &Client{
+		client: &http.Client{
+			Transport: tracing.Transport(transport),
+			Timeout:   opts.Timeout,
+		},
+		opts:     opts,
+		breakers: make(map[string]*breaker),
+	}, nil
 }
 
-type readCloser struct {
-	io.Reader
+// allowControl 在连接前检查目标IP
+func allowControl(allow func(ip net.IP) bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || !allow(ip) {
+			return fmt.Errorf("http: connection to %s is not allowed", address)
+		}
+		return nil
+	}
 }
 
-func (r readCloser) Close() error {
-	return nil
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("http: read ca file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("http: no certificate found in %s", opts.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("http: load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (c *Client) breaker(host string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[host]
+	if !ok {
+		b = &breaker{maxFailures: c.opts.BreakerFailures, cooldown: c.opts.BreakerCooldown}
+		c.breakers[host] = b
+	}
+	return b
 }
 
-func CallAPI(method, url string, content []byte, header http.Header, timeout time.Duration) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, nil)
+// idempotent 判断请求是否可以安全重试，非幂等的请求可以通过 Idempotency-Key 请求头声明可重试
+func idempotent(method string, header http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return header.Get("Idempotency-Key") != ""
+}
+
+// retryable 判断失败是否值得重试：证书校验以外的网络错误、429 和 502/503/504
+func retryable(rsp *http.Response, err error) bool {
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		return !errors.As(err, &certErr)
+	}
+	switch rsp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff 返回第 attempt 次重试前的等待时间：指数退避加全抖动，服务端给出 Retry-After 时优先使用
+func (c *Client) backoff(attempt int, rsp *http.Response) time.Duration {
+	if rsp != nil {
+		if seconds, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			d := time.Duration(seconds) * time.Second
+			if d > c.opts.RetryMaxBackoff {
+				d = c.opts.RetryMaxBackoff
+			}
+			return d
+		}
+	}
+
+	d := c.opts.RetryBackoff << uint(attempt)
+	if d <= 0 || d > c.opts.RetryMaxBackoff {
+		d = c.opts.RetryMaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// Do 发送请求。幂等请求在网络错误或 429/502/503/504 时重试，ctx 取消后立即返回；
+// 目标主机熔断时返回 ErrCircuitOpen。调用方负责关闭返回的 Body。
+func (c *Client) Do(ctx context.Context, method, url string, content []byte, header http.Header) (*http.Response, error) {
+	if header == nil {
+		header = http.Header{}
+	}
+	retries := 0
+	if idempotent(method, header) {
+		retries = c.opts.MaxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		rsp, err := c.do(ctx, method, url, content, header)
+		if err == ErrCircuitOpen || attempt >= retries || !retryable(rsp, err) || ctx.Err() != nil {
+			return rsp, err
+		}
+
+		wait := c.backoff(attempt, rsp)
+		if rsp != nil {
+			io.Copy(ioutil.Discard, rsp.Body)
+			rsp.Body.Close()
+		}
+		log.WarnCtx(ctx, "[http] retrying request", "method", method, "url", url,
+			"attempt", attempt+1, "wait", wait.String(), "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, url string, content []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	if header != nil {
-		req.Header = header
+	req.Header = header.Clone()
+	if len(content) > 0 {
+		req.ContentLength = int64(len(content))
+		req.Body = ioutil.NopCloser(bytes.NewReader(content))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(content)), nil
+		}
 	}
-	req.ContentLength = int64(len(content))
-	if req.ContentLength > 0 {
-		req.Body = readCloser{bytes.NewReader(content)}
+
+	b := c.breaker(req.URL.Host)
+	if !b.allow() {
+		return nil, ErrCircuitOpen
 	}
 
-	client := &http.Client{Transport: defaultTransport}
-	// client := new(http.Client)
-	client.Timeout = timeout
-	return client.Do(req)
+	rsp, err := c.client.Do(req)
+	// 调用方主动取消不算作目标主机的失败
+	if err != nil && ctx.Err() != nil {
+		b.release()
+		return nil, err
+	}
+	b.done(err == nil && rsp.StatusCode < http.StatusInternalServerError)
+	return rsp, err
 }
 
-func CallJSONAPI(method, url string, data interface{}, header http.Header, timeout time.Duration) (*http.Response, error) {
+// DoJSON 以JSON格式发送 req（为nil时不带body），状态码为 2xx 时把响应解析到 rsp（为nil时忽略响应body），
+// 否则返回 *StatusError
+func (c *Client) DoJSON(ctx context.Context, method, url string, req interface{}, rsp interface{}, header http.Header) error {
 	var content []byte
-	var err error
-	if data != nil {
-		content, err = json.Marshal(data)
+	if header == nil {
+		header = http.Header{}
+	} else {
+		header = header.Clone()
+	}
+	if req != nil {
+		var err error
+		content, err = json.Marshal(req)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if header == nil {
-			header = http.Header{}
+		header.Set("Content-Type", "application/json")
+	}
+	header.Set("Accept", "application/json")
+
+	ret, err := c.Do(ctx, method, url, content, header)
+	if err != nil {
+		return err
+	}
+	body, err := ResponseBody(ret)
+	if err != nil {
+		return err
+	}
+
+	if ret.StatusCode < 200 || ret.StatusCode > 299 {
+		return &StatusError{Method: method, URL: url, Code: ret.StatusCode, Body: body}
+	}
+	if rsp == nil || len(body) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, rsp); err != nil {
+		return fmt.Errorf("http: %s %s: decode response: %v", method, url, err)
+	}
+	return nil
+}
+
+// CallAPI 使用默认客户端发送请求，timeout 大于0时作为整个调用（包括重试）的超时
+func CallAPI(ctx context.Context, method, url string, content []byte, header http.Header, timeout time.Duration) (*http.Response, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		rsp, err := defaultClient.Do(ctx, method, url, content, header)
+		if err != nil {
+			cancel()
+			return nil, err
 		}
-		header.Set("ContentType", "application/json")
+		// 读取完body后才能取消ctx
+		rsp.Body = cancelReadCloser{ReadCloser: rsp.Body, cancel: cancel}
+		return rsp, nil
 	}
-	return CallAPI(method, url, content, header, timeout)
+	return defaultClient.Do(ctx, method, url, content, header)
+}
+
+// CallJSONAPI 使用默认客户端发送JSON请求并解析JSON响应，见 Client.DoJSON
+func CallJSONAPI(ctx context.Context, method, url string, req interface{}, rsp interface{}, header http.Header) error {
+	return defaultClient.DoJSON(ctx, method, url, req, rsp, header)
+}
+
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r cancelReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
 }
 
 func ResponseBody(rsp *http.Response) ([]byte, error) {
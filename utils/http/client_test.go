@@ -0,0 +1,252 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, opts Options) *Client {
+	t.Helper()
+	c, err := NewClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// status 按顺序返回 codes 中的状态码，用完后一直返回最后一个
+func status(hits *int32, header http.Header, codes ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(hits, 1))
+		if n > len(codes) {
+			n = len(codes)
+		}
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(codes[n-1])
+	}
+}
+
+func get(c *Client, ctx context.Context, url string) (int, error) {
+	rsp, err := c.Do(ctx, http.MethodGet, url, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	ResponseBody(rsp)
+	return rsp.StatusCode, nil
+}
+
+func TestRetryable(t *testing.T) {
+	certErr := fmt.Errorf("dial: %w", &tls.CertificateVerificationError{Err: errors.New("unknown authority")})
+	tests := []struct {
+		code int
+		err  error
+		want bool
+	}{
+		{http.StatusOK, nil, false},
+		{http.StatusBadRequest, nil, false},
+		{http.StatusNotFound, nil, false},
+		{http.StatusInternalServerError, nil, false},
+		{http.StatusNotImplemented, nil, false},
+		{http.StatusTooManyRequests, nil, true},
+		{http.StatusBadGateway, nil, true},
+		{http.StatusServiceUnavailable, nil, true},
+		{http.StatusGatewayTimeout, nil, true},
+		{0, errors.New("connection refused"), true},
+		{0, certErr, false},
+	}
+	for _, tt := range tests {
+		var rsp *http.Response
+		if tt.err == nil {
+			rsp = &http.Response{StatusCode: tt.code}
+		}
+		if got := retryable(rsp, tt.err); got != tt.want {
+			t.Errorf("retryable(%d, %v) = %v, want %v", tt.code, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := newTestClient(t, Options{RetryBackoff: 100 * time.Millisecond, RetryMaxBackoff: 2 * time.Second})
+	retryAfter := func(v string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {v}}}
+	}
+	tests := []struct {
+		name     string
+		attempt  int
+		rsp      *http.Response
+		min, max time.Duration
+	}{
+		{"first retry", 0, nil, 1, 100 * time.Millisecond},
+		{"doubles", 2, nil, 1, 400 * time.Millisecond},
+		{"capped", 10, nil, 1, 2 * time.Second},
+		{"shift overflow", 70, nil, 1, 2 * time.Second},
+		{"retry after", 0, retryAfter("1"), time.Second, time.Second},
+		{"retry after capped", 0, retryAfter("3600"), 2 * time.Second, 2 * time.Second},
+		{"retry after zero", 0, retryAfter("0"), 1, 100 * time.Millisecond},
+		{"retry after date", 0, retryAfter("Wed, 21 Oct 2026 07:28:00 GMT"), 1, 100 * time.Millisecond},
+		{"no retry after", 0, &http.Response{Header: http.Header{}}, 1, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := c.backoff(tt.attempt, tt.rsp); d < tt.min || d > tt.max {
+				t.Errorf("%s: backoff = %v, want between %v and %v", tt.name, d, tt.min, tt.max)
+				break
+			}
+		}
+	}
+}
+
+func TestDoRetry(t *testing.T) {
+	opts := Options{Timeout: 5 * time.Second, MaxRetries: 2, RetryBackoff: time.Millisecond, RetryMaxBackoff: 10 * time.Millisecond}
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		codes  []int
+		code   int
+		hits   int32
+	}{
+		{"recovers", http.MethodGet, nil, []int{503, 502, 200}, 200, 3},
+		{"gives up", http.MethodGet, nil, []int{503}, 503, 3},
+		{"not retryable", http.MethodGet, nil, []int{500, 200}, 500, 1},
+		{"post not retried", http.MethodPost, nil, []int{503, 200}, 503, 1},
+		{"post with idempotency key", http.MethodPost, http.Header{"Idempotency-Key": {"k"}}, []int{503, 200}, 200, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			srv := httptest.NewServer(status(&hits, nil, tt.codes...))
+			defer srv.Close()
+
+			rsp, err := newTestClient(t, opts).Do(context.Background(), tt.method, srv.URL, nil, tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ResponseBody(rsp)
+			if n := atomic.LoadInt32(&hits); rsp.StatusCode != tt.code || n != tt.hits {
+				t.Errorf("status = %d after %d requests, want %d after %d", rsp.StatusCode, n, tt.code, tt.hits)
+			}
+		})
+	}
+}
+
+func TestDoRetryAfterCapped(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(status(&hits, http.Header{"Retry-After": {"3600"}}, 429, 200))
+	defer srv.Close()
+
+	c := newTestClient(t, Options{Timeout: 5 * time.Second, MaxRetries: 1, RetryBackoff: time.Millisecond, RetryMaxBackoff: 20 * time.Millisecond})
+	start := time.Now()
+	code, err := get(c, context.Background(), srv.URL)
+	if n := atomic.LoadInt32(&hits); err != nil || code != 200 || n != 2 {
+		t.Fatalf("status = %d, %v after %d requests, want 200 after 2", code, err, n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %v, Retry-After must be capped by RetryMaxBackoff", elapsed)
+	}
+}
+
+func TestBreakerHalfOpenSingleProbe(t *testing.T) {
+	var hits int32
+	var healthy int32
+	entered := make(chan struct{}, 1)
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-unblock
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, Options{Timeout: 5 * time.Second, BreakerFailures: 2, BreakerCooldown: 50 * time.Millisecond})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if code, err := get(c, ctx, srv.URL); err != nil || code != 500 {
+			t.Fatalf("request %d = %d, %v, want 500", i, code, err)
+		}
+	}
+	if _, err := get(c, ctx, srv.URL); err != ErrCircuitOpen {
+		t.Fatalf("after 2 failures error = %v, want ErrCircuitOpen", err)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("open breaker sent a request: %d hits", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&healthy, 1)
+	probe := make(chan error, 1)
+	go func() {
+		_, err := get(c, ctx, srv.URL)
+		probe <- err
+	}()
+	<-entered
+
+	// 探测请求未完成时，其他请求仍被拒绝
+	if _, err := get(c, ctx, srv.URL); err != ErrCircuitOpen {
+		t.Errorf("second request while probing error = %v, want ErrCircuitOpen", err)
+	}
+	close(unblock)
+	if err := <-probe; err != nil {
+		t.Fatalf("probe error = %v", err)
+	}
+
+	// 探测成功后关闭
+	for i := 0; i < 3; i++ {
+		if code, err := get(c, ctx, srv.URL); err != nil || code != 200 {
+			t.Errorf("request after probe = %d, %v, want 200", code, err)
+		}
+	}
+}
+
+func TestBreakerReleaseOnCancel(t *testing.T) {
+	var fail int32 = 1
+	entered := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("X-Block") != "" {
+			entered <- struct{}{}
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, Options{Timeout: 5 * time.Second, BreakerFailures: 1, BreakerCooldown: 20 * time.Millisecond})
+	if code, _ := get(c, context.Background(), srv.URL); code != 502 {
+		t.Fatalf("status = %d, want 502", code)
+	}
+	time.Sleep(30 * time.Millisecond)
+	atomic.StoreInt32(&fail, 0)
+
+	// 半开状态的探测请求被调用方取消，不计入结果，也不占用探测名额
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-entered
+		cancel()
+	}()
+	_, err := c.Do(ctx, http.MethodGet, srv.URL, nil, http.Header{"X-Block": {"1"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled probe error = %v, want context.Canceled", err)
+	}
+	if code, err := get(c, context.Background(), srv.URL); err != nil || code != 200 {
+		t.Errorf("request after canceled probe = %d, %v, want 200", code, err)
+	}
+}
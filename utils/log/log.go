@@ -0,0 +1,223 @@
+// Package log 结构化日志，基于 log/slog，支持JSON/文本格式、按大小滚动的日志文件、
+// 请求ID以及凭证类字段的自动脱敏
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	OUTPUT_STDOUT = "stdout"
+	OUTPUT_FILE   = "file"
+
+	FORMAT_JSON = "json"
+	FORMAT_TEXT = "text"
+
+	REDACTED = "[REDACTED]"
+)
+
+// Options 日志配置，File* 仅在 Output 为 file 时生效
+type Options struct {
+	Level      string
+	Format     string
+	Output     string
+	File       string
+	MaxSize    int // 单个日志文件的最大大小，单位MB
+	MaxBackups int // 保留的历史日志文件数
+	MaxAge     int // 历史日志文件的保留天数
+}
+
+type ctxKey struct{}
+
+var (
+	level  = new(slog.LevelVar)
+	logger atomic.Value
+	closer io.Closer
+)
+
+func init() {
+	logger.Store(newLogger(os.Stdout, FORMAT_JSON))
+}
+
+// Init 按配置初始化日志，可重复调用，调用后旧的日志文件会被关闭
+func Init(opts Options) error {
+	if err := SetLevel(opts.Level); err != nil {
+		return err
+	}
+
+	var w io.Writer
+	var c io.Closer
+	switch opts.Output {
+	case "", OUTPUT_STDOUT:
+		w = os.Stdout
+	case OUTPUT_FILE:
+		if opts.File == "" {
+			return fmt.Errorf("log: file is required when output is file")
+		}
+		lj := &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    opts.MaxSize,
+			MaxBackups: opts.MaxBackups,
+			MaxAge:     opts.MaxAge,
+			LocalTime:  false,
+		}
+		w, c = lj, lj
+	default:
+		return fmt.Errorf("log: unknown output %q", opts.Output)
+	}
+
+	logger.Store(newLogger(w, opts.Format))
+	if closer != nil {
+		closer.Close()
+	}
+	closer = c
+	return nil
+}
+
+// Close 关闭日志文件
+func Close() error {
+	if closer == nil {
+		return nil
+	}
+	return closer.Close()
+}
+
+// SetLevel 设置日志级别：debug|info|warn|error
+func SetLevel(name string) error {
+	var l slog.Level
+	switch strings.ToLower(name) {
+	case "debug":
+		l = slog.LevelDebug
+	case "", "info":
+		l = slog.LevelInfo
+	case "warn":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		return fmt.Errorf("log: unknown level %q", name)
+	}
+	level.Set(l)
+	return nil
+}
+
+func newLogger(w io.Writer, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+	if format == FORMAT_TEXT {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+// L 返回全局logger
+func L() *slog.Logger {
+	return logger.Load().(*slog.Logger)
+}
+
+// WithRequestID 返回携带请求ID的context
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, requestID)
+}
+
+// RequestID 返回context中的请求ID
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Detach 返回不受ctx取消和超时影响的context，只保留请求ID和span，用于请求结束后继续执行的后台任务
+func Detach(ctx context.Context) context.Context {
+	detached := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+	if id := RequestID(ctx); id != "" {
+		detached = WithRequestID(detached, id)
+	}
+	return detached
+}
+
+// Ctx 返回带有请求ID和 trace_id/span_id 字段的logger
+func Ctx(ctx context.Context) *slog.Logger {
+	lg := L()
+	if ctx == nil {
+		return lg
+	}
+	if id := RequestID(ctx); id != "" {
+		lg = lg.With("request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		lg = lg.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+	return lg
+}
+
+// output 以调用方的位置记录日志，skip 为需要跳过的调用层数
+func output(ctx context.Context, skip int, l slog.Level, msg string, args ...interface{}) {
+	lg := Ctx(ctx)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !lg.Enabled(ctx, l) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(skip, pcs[:])
+	r := slog.NewRecord(time.Now(), l, msg, pcs[0])
+	r.Add(args...)
+	lg.Handler().Handle(ctx, r)
+}
+
+func Debug(msg string, args ...interface{}) { output(nil, 3, slog.LevelDebug, msg, args...) }
+func Info(msg string, args ...interface{})  { output(nil, 3, slog.LevelInfo, msg, args...) }
+func Warn(msg string, args ...interface{})  { output(nil, 3, slog.LevelWarn, msg, args...) }
+func Error(msg string, args ...interface{}) { output(nil, 3, slog.LevelError, msg, args...) }
+
+func Debugf(format string, args ...interface{}) {
+	output(nil, 3, slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+func Infof(format string, args ...interface{}) {
+	output(nil, 3, slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+func Warnf(format string, args ...interface{}) {
+	output(nil, 3, slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+func Errorf(format string, args ...interface{}) {
+	output(nil, 3, slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+// Fatal 记录错误日志后退出进程
+func Fatal(msg string, args ...interface{}) {
+	output(nil, 3, slog.LevelError, msg, args...)
+	Close()
+	os.Exit(1)
+}
+
+// DebugCtx 等函数会在日志中带上context中的请求ID
+func DebugCtx(ctx context.Context, msg string, args ...interface{}) {
+	output(ctx, 3, slog.LevelDebug, msg, args...)
+}
+func InfoCtx(ctx context.Context, msg string, args ...interface{}) {
+	output(ctx, 3, slog.LevelInfo, msg, args...)
+}
+func WarnCtx(ctx context.Context, msg string, args ...interface{}) {
+	output(ctx, 3, slog.LevelWarn, msg, args...)
+}
+func ErrorCtx(ctx context.Context, msg string, args ...interface{}) {
+	output(ctx, 3, slog.LevelError, msg, args...)
+}
@@ -0,0 +1,83 @@
+package log
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// sensitiveKeys 字段名（不区分大小写）包含这些词时，日志中的值会被替换为 [REDACTED]
+var sensitiveKeys = []string{
+	"credential",
+	"password",
+	"passwd",
+	"token",
+	"secret",
+	"authorization",
+	"cookie",
+	"captcha_value",
+}
+
+// IsSensitive 判断字段名是否为凭证类字段
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if IsSensitive(a.Key) {
+		return slog.String(a.Key, REDACTED)
+	}
+	return a
+}
+
+// RedactJSON 返回脱敏后的JSON，凭证类字段的值会被替换，无法解析时只返回长度信息
+func RedactJSON(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "<non-json body>"
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return "<non-json body>"
+	}
+	return string(out)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if IsSensitive(k) {
+				value[k] = REDACTED
+			} else {
+				value[k] = redactValue(item)
+			}
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+		return value
+	}
+	return v
+}
+
+// RedactURL 去掉URL中的密码，如 redis://:password@host:6379
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
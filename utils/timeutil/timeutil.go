@@ -0,0 +1,189 @@
+// Package timeutil 时间的存储和展示：数据库中的时间一律为UTC的 time.Time，接口返回时转换到调用方的时区，
+// JSON 中为 RFC 3339 格式。调用方的时区可以是 IANA 名称（Asia/Shanghai）或相对UTC的偏移（8、+05:30、UTC-3）。
+// 取代 utils 中基于字符串的日期函数
+package timeutil
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// 内置时区数据库，容器镜像中没有 /usr/share/zoneinfo 时也能解析 IANA 名称
+	_ "time/tzdata"
+)
+
+const (
+	// DATE 日期格式 yyyy-mm-dd
+	DATE = "2006-01-02"
+	// DATETIME MySQL DATETIME 的格式 yyyy-mm-dd hh:mm:ss
+	DATETIME = "2006-01-02 15:04:05"
+
+	// 相对UTC的偏移范围，-12:00 到 +14:00
+	MIN_OFFSET = -12 * 3600
+	MAX_OFFSET = 14 * 3600
+)
+
+// ErrInvalidZone 既不是 IANA 时区名也不是合法的偏移
+var ErrInvalidZone = errors.New("timeutil: invalid time zone")
+
+// zones 缓存解析过的时区，LoadLocation 每次都会读取时区数据
+var zones sync.Map
+
+// Now 返回当前的UTC时间，精确到秒，与 DATETIME 列保存的值一致
+func Now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// ParseZone 解析时区，为空时返回UTC。支持：
+//
+//	Asia/Shanghai、UTC    IANA 时区名
+//	8、-5、+14            整数小时
+//	+08:00、-0530、+0800  小时和分钟
+//	UTC+8、GMT-03:30      带 UTC/GMT 前缀的偏移
+func ParseZone(s string) (*time.Location, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := zones.Load(s); ok {
+		return loc.(*time.Location), nil
+	}
+
+	var loc *time.Location
+	if offset, ok := parseOffset(s); ok {
+		if offset < MIN_OFFSET || offset > MAX_OFFSET {
+			return nil, ErrInvalidZone
+		}
+		loc = time.FixedZone(offsetName(offset), offset)
+	} else {
+		l, err := time.LoadLocation(s)
+		if err != nil || s == "Local" {
+			return nil, ErrInvalidZone
+		}
+		loc = l
+	}
+	zones.Store(s, loc)
+	return loc, nil
+}
+
+// parseOffset 解析相对UTC的偏移，返回秒数
+func parseOffset(s string) (int, bool) {
+	upper := strings.ToUpper(s)
+	for _, prefix := range []string{"UTC", "GMT"} {
+		if strings.HasPrefix(upper, prefix) && len(s) > len(prefix) {
+			s = s[len(prefix):]
+			break
+		}
+	}
+
+	sign := 1
+	switch {
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	case strings.HasPrefix(s, "-"):
+		sign, s = -1, s[1:]
+	}
+
+	var hh, mm string
+	switch {
+	case strings.Contains(s, ":"):
+		parts := strings.SplitN(s, ":", 2)
+		hh, mm = parts[0], parts[1]
+		if len(mm) != 2 {
+			return 0, false
+		}
+	case len(s) == 4:
+		hh, mm = s[:2], s[2:]
+	case len(s) == 1 || len(s) == 2:
+		hh, mm = s, "0"
+	default:
+		return 0, false
+	}
+	if hh == "" || len(hh) > 2 || !digits(hh) || !digits(mm) {
+		return 0, false
+	}
+	h, _ := strconv.Atoi(hh)
+	m, _ := strconv.Atoi(mm)
+	if m > 59 {
+		return 0, false
+	}
+	return sign * (h*3600 + m*60), true
+}
+
+// digits 判断 s 是否只包含数字，strconv.Atoi 还接受 + 和 - 号
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// offsetName 固定偏移时区的名称，如 UTC+08:00
+func offsetName(offset int) string {
+	if offset == 0 {
+		return "UTC"
+	}
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return "UTC" + sign + pad2(offset/3600) + ":" + pad2(offset%3600/60)
+}
+
+func pad2(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// In 把 t 转换到时区 loc，零值保持不变
+func In(t time.Time, loc *time.Location) time.Time {
+	if t.IsZero() || loc == nil {
+		return t
+	}
+	return t.In(loc)
+}
+
+// Format 按 RFC 3339 格式化 t 在时区 loc 中的时间，零值返回空字符串
+func Format(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return ""
+	}
+	return In(t, loc).Format(time.RFC3339)
+}
+
+// ParseDateTime 解析 yyyy-mm-dd hh:mm:ss 或 yyyy-mm-dd，按UTC解释
+func ParseDateTime(s string) (time.Time, error) {
+	layout := DATETIME
+	if len(s) == len(DATE) {
+		layout = DATE
+	}
+	return time.ParseInLocation(layout, s, time.UTC)
+}
+
+// FormatDateTime 返回 t 的UTC时间，格式为 yyyy-mm-dd hh:mm:ss
+func FormatDateTime(t time.Time) string {
+	return t.UTC().Format(DATETIME)
+}
+
+// FormatDate 返回 t 的UTC日期，格式为 yyyy-mm-dd
+func FormatDate(t time.Time) string {
+	return t.UTC().Format(DATE)
+}
+
+// StartOfDay 返回 t 在时区 loc 中当天的零点
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// StartOfMonth 返回 t 在时区 loc 中当月第一天的零点，下月第一天为 StartOfMonth(t, loc).AddDate(0, 1, 0)
+func StartOfMonth(t time.Time, loc *time.Location) time.Time {
+	y, m, _ := t.In(loc).Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+}
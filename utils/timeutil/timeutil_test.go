@@ -0,0 +1,97 @@
+package timeutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseZone(t *testing.T) {
+	tests := []struct {
+		in     string
+		name   string
+		offset int
+	}{
+		{"", "UTC", 0},
+		{"  ", "UTC", 0},
+		{"UTC", "UTC", 0},
+		{"0", "UTC", 0},
+		{"8", "UTC+08:00", 8 * 3600},
+		{"+8", "UTC+08:00", 8 * 3600},
+		{"-5", "UTC-05:00", -5 * 3600},
+		{"+14", "UTC+14:00", 14 * 3600},
+		{"-12", "UTC-12:00", -12 * 3600},
+		{"+05:30", "UTC+05:30", 5*3600 + 30*60},
+		{"-0330", "UTC-03:30", -(3*3600 + 30*60)},
+		{"+0800", "UTC+08:00", 8 * 3600},
+		{"UTC+8", "UTC+08:00", 8 * 3600},
+		{"utc-3", "UTC-03:00", -3 * 3600},
+		{"gmt-03:30", "UTC-03:30", -(3*3600 + 30*60)},
+		{"GMT+05:45", "UTC+05:45", 5*3600 + 45*60},
+		{"Asia/Shanghai", "Asia/Shanghai", 8 * 3600},
+		{"America/New_York", "America/New_York", -5 * 3600}, // 1月为标准时间
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			loc, err := ParseZone(tt.in)
+			if err != nil {
+				t.Fatalf("ParseZone(%q) error = %v", tt.in, err)
+			}
+			if loc.String() != tt.name {
+				t.Errorf("ParseZone(%q) = %s, want %s", tt.in, loc, tt.name)
+			}
+			if _, offset := time.Date(2026, 1, 1, 0, 0, 0, 0, loc).Zone(); offset != tt.offset {
+				t.Errorf("ParseZone(%q) offset = %d, want %d", tt.in, offset, tt.offset)
+			}
+		})
+	}
+}
+
+func TestParseZoneInvalid(t *testing.T) {
+	for _, in := range []string{
+		"15", "+15", "-13", "+14:30", "-12:30", // 超出 -12:00 到 +14:00
+		"+8:60", "+08:5", "+123", "+08:00:00", "8.5", "+", "UTC+", "++8", "+-8", "+8:+5",
+		"Local", "Mars/Base", "abc",
+	} {
+		if loc, err := ParseZone(in); err != ErrInvalidZone {
+			t.Errorf("ParseZone(%q) = %v, %v, want ErrInvalidZone", in, loc, err)
+		}
+	}
+}
+
+func TestParseOffset(t *testing.T) {
+	tests := []struct {
+		in     string
+		offset int
+		ok     bool
+	}{
+		{"0", 0, true},
+		{"8", 8 * 3600, true},
+		{"08", 8 * 3600, true},
+		{"-08", -8 * 3600, true},
+		{"+5:30", 5*3600 + 30*60, true},
+		{"0530", 5*3600 + 30*60, true},
+		{"UTC0", 0, true},
+		{"GMT+1", 3600, true},
+		{"99", 99 * 3600, true}, // 范围由 ParseZone 检查
+		{"", 0, false},
+		{"UTC", 0, false},
+		{"+", 0, false},
+		{"123", 0, false},
+		{"12345", 0, false},
+		{"+1:5", 0, false},
+		{"+1:60", 0, false},
+		{":30", 0, false},
+		{"+-1", 0, false},
+		{"++1", 0, false},
+		{"+1:+5", 0, false},
+		{"+1:-5", 0, false},
+		{"a", 0, false},
+		{"Asia/Shanghai", 0, false},
+	}
+	for _, tt := range tests {
+		offset, ok := parseOffset(tt.in)
+		if ok != tt.ok || (ok && offset != tt.offset) {
+			t.Errorf("parseOffset(%q) = %d, %v, want %d, %v", tt.in, offset, ok, tt.offset, tt.ok)
+		}
+	}
+}
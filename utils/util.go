@@ -12,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	mRand "math/rand"
 	"net"
 	"os"
@@ -22,10 +23,12 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
+
+	"github.com/saisai/gindemo/utils/log"
 
 	"github.com/dchest/pbkdf2"
 
-	clog "github.com/cihub/seelog"
 	"github.com/denisbrodbeck/machineid"
 	"github.com/jaypipes/ghw"
 	"github.com/satori/go.uuid"
@@ -89,22 +92,18 @@ func GetHumanSize(v uint64) string {
 // CheckErr 错误处理函数，程序中错误分2种，一种需要终止程序，一种仅仅是记录错误日志 flag:  1:exit  2:log only
 func CheckErr(err error, flag int) {
 
-	var path string
-
 	if err != nil {
 		_, file, line, _ := runtime.Caller(1)
-		path = " -- " + file + ":" + strconv.Itoa(line)
+		caller := file + ":" + strconv.Itoa(line)
 
 		switch flag {
 		case CHECK_FLAG_EXIT:
-			clog.Critical(err.Error() + path)
-			clog.Critical(StackTrace(false))
+			log.Error(err.Error(), "caller", caller, "stack", StackTrace(false))
 			panic(err)
 		case CHECK_FLAG_LOGONLY:
-			clog.Critical(err.Error() + path)
-			clog.Critical(StackTrace(false))
+			log.Error(err.Error(), "caller", caller, "stack", StackTrace(false))
 		default:
-			clog.Info(err.Error() + path)
+			log.Info(err.Error(), "caller", caller)
 		}
 	}
 
@@ -138,7 +137,7 @@ func FileDel(filename string) bool {
 	if err == nil {
 		return true
 	} else {
-		clog.Error(err)
+		log.Error(err.Error())
 		return false
 	}
 }
@@ -149,7 +148,7 @@ func ObjToStr(obj interface{}) string {
 	}
 	b, err := json.Marshal(obj)
 	if err != nil {
-		clog.Error(err)
+		log.Error(err.Error())
 		return ""
 	}
 	return (string(b))
@@ -286,6 +285,8 @@ func Wrap(str string) string {
 }
 
 // UnWrap 去掉string外面的双引号（从redis中取出数据时会有） eg. "dddd" -> dddd
+//
+// Deprecated: 引号来自json编码的字符串，使用 cache.GetString/cache.SetString 原样读写
 func UnWrap(str string) string {
 	rs := []rune(str)
 	quote := []rune(`"`)
@@ -312,6 +313,8 @@ func UnWrap(str string) string {
 }
 
 // 返回两个时间戳之间的间隔，单位：秒  ret = time2 - time1
+//
+// Deprecated: 使用 time.Time.Sub
 func TimeDiff(time1 int64, time2 int64) int64 {
 	return time2 - time1
 }
@@ -390,6 +393,8 @@ func FileExist(fileNameWithPath string) bool {
 }
 
 // AddOneDay 日期加一天 yyyy-mm-dd
+//
+// Deprecated: 使用 timeutil.ParseDateTime 和 time.Time.AddDate
 func AddOneDay(dt string) (ret string) {
 
 	tm, _ := time.Parse(DATE_FORMAT_SHORT, dt)
@@ -399,6 +404,8 @@ func AddOneDay(dt string) (ret string) {
 }
 
 // AddDays 日期加n天 yyyy-mm-dd
+//
+// Deprecated: 使用 timeutil.ParseDateTime 和 time.Time.AddDate
 func AddDays(dt string, n int) (ret string) {
 
 	tm, _ := time.Parse(DATE_FORMAT_SHORT, dt)
@@ -408,6 +415,8 @@ func AddDays(dt string, n int) (ret string) {
 }
 
 // AddOneMonth 日期加一个月 yyyy-mm-dd
+//
+// Deprecated: 使用 timeutil.ParseDateTime 和 time.Time.AddDate
 func AddOneMonth(dt string) (ret string) {
 
 	tm, _ := time.Parse(DATE_FORMAT_SHORT, dt)
@@ -417,6 +426,8 @@ func AddOneMonth(dt string) (ret string) {
 }
 
 // AddMonths 日期加n个月 yyyy-mm-dd
+//
+// Deprecated: 使用 timeutil.ParseDateTime 和 time.Time.AddDate
 func AddMonths(dt string, n int) (ret string) {
 
 	tm, _ := time.Parse(DATE_FORMAT_SHORT, dt)
@@ -426,6 +437,8 @@ func AddMonths(dt string, n int) (ret string) {
 }
 
 // AddOneHour 时间加一小时 yyyy-mm-dd hh:mm:ss
+//
+// Deprecated: 使用 timeutil.ParseDateTime 和 time.Time.Add
 func AddOneHour(dt string) (ret string) {
 	tm, _ := time.Parse(DATE_FORMAT_LONG, dt)
 	ta := tm.Add(60 * time.Minute)
@@ -434,6 +447,8 @@ func AddOneHour(dt string) (ret string) {
 }
 
 // AddMinute 时间加若干分钟 yyyy-mm-dd hh:mm:ss
+//
+// Deprecated: 使用 timeutil.ParseDateTime 和 time.Time.Add
 func AddMinute(dt string, cnt int) (ret string) {
 	tm, _ := time.Parse(DATE_FORMAT_LONG, dt)
 	ta := tm.Add(time.Duration(cnt) * time.Minute)
@@ -442,6 +457,8 @@ func AddMinute(dt string, cnt int) (ret string) {
 }
 
 // AddSecond 时间加若干秒钟 yyyy-mm-dd hh:mm:ss
+//
+// Deprecated: 使用 timeutil.ParseDateTime 和 time.Time.Add
 func AddSecond(dt string, cnt int) (ret string) {
 	tm, _ := time.Parse(DATE_FORMAT_LONG, dt)
 	ta := tm.Add(time.Duration(cnt) * time.Second)
@@ -456,6 +473,8 @@ func AddSecond(dt string, cnt int) (ret string) {
 // period：时间长度，单位分钟
 // threadCnt：线程数
 // threadId：线程序号
+//
+// Deprecated: 使用 timeutil.ParseDateTime 和 time.Time.Add
 func GetTimeStr(workDtStart string, period int, threadCnt int, threadId int) (ret string) {
 	totalSec := period * 60
 	secPerThead := totalSec / threadCnt
@@ -467,28 +486,38 @@ func GetTimeStr(workDtStart string, period int, threadCnt int, threadId int) (re
 }
 
 // Time2StrL long time -> yyyy-mm-dd hh:mm:ss
+//
+// Deprecated: 使用 timeutil.FormatDateTime，接口中的时间使用 timeutil.Format
 func Time2StrL(t time.Time) string {
 	return t.Format(DATE_FORMAT_LONG)
 }
 
 // Time2StrS short time -> yyyy-mm-dd
+//
+// Deprecated: 使用 timeutil.FormatDate
 func Time2StrS(t time.Time) string {
 	return t.Format(DATE_FORMAT_SHORT)
 }
 
 // TimeStamp2StrL long time -> yyyy-mm-dd hh:mm:ss
+//
+// Deprecated: 使用 timeutil.FormatDateTime(time.Unix(ts, 0))
 func TimeStamp2StrL(ts int64) string {
 	t := time.Unix(ts, 0).UTC()
 	return t.Format(DATE_FORMAT_LONG)
 }
 
 // TimeStamp2StrS short time -> yyyy-mm-dd
+//
+// Deprecated: 使用 timeutil.FormatDate(time.Unix(ts, 0))
 func TimeStamp2StrS(ts int64) string {
 	t := time.Unix(ts, 0).UTC()
 	return t.Format(DATE_FORMAT_SHORT)
 }
 
 // Str2TimeL yyyy-mm-dd hh:mm:ss -> time
+//
+// Deprecated: 使用 timeutil.ParseDateTime，它返回解析错误
 func Str2TimeL(s string) time.Time {
 	loc, _ := time.LoadLocation("UTC")
 	t, err := time.ParseInLocation(DATE_FORMAT_LONG, s, loc)
@@ -497,6 +526,8 @@ func Str2TimeL(s string) time.Time {
 }
 
 // Str2TimeS yyyy-mm-dd -> time
+//
+// Deprecated: 使用 timeutil.ParseDateTime，它返回解析错误
 func Str2TimeS(s string) time.Time {
 	loc, _ := time.LoadLocation("UTC")
 	t, err := time.ParseInLocation(DATE_FORMAT_SHORT, s, loc)
@@ -505,6 +536,8 @@ func Str2TimeS(s string) time.Time {
 }
 
 // Str2TimeStampL yyyy-mm-dd hh:mm:ss -> timeStamp
+//
+// Deprecated: 使用 timeutil.ParseDateTime
 func Str2TimeStampL(s string) int64 {
 	loc, _ := time.LoadLocation("UTC")
 	t, err := time.ParseInLocation(DATE_FORMAT_LONG, s, loc)
@@ -513,6 +546,8 @@ func Str2TimeStampL(s string) int64 {
 }
 
 // Str2TimeStampS yyyy-mm-dd -> timeStamp
+//
+// Deprecated: 使用 timeutil.ParseDateTime
 func Str2TimeStampS(s string) int64 {
 	loc, _ := time.LoadLocation("UTC")
 	t, err := time.ParseInLocation(DATE_FORMAT_SHORT, s, loc)
@@ -520,6 +555,7 @@ func Str2TimeStampS(s string) int64 {
 	return t.Unix()
 }
 
+// Deprecated: 使用 timeutil.ParseDateTime
 func IsDate(s string) bool {
 	var err error
 	loc, _ := time.LoadLocation("UTC")
@@ -536,20 +572,30 @@ func IsDate(s string) bool {
 }
 
 // GetNow 取得当前日期时间
+//
+// Deprecated: 使用 timeutil.Now，数据库和接口中的时间都是 time.Time
 func GetNow(format string) string {
 	return time.Now().Format(format)
 }
+
+// Deprecated: 使用 timeutil.Now，数据库和接口中的时间都是 time.Time
 func GetNowUTC(format string) string {
 	return time.Now().UTC().Format(format)
 }
+
+// Deprecated: 使用 timeutil.Now，数据库和接口中的时间都是 time.Time
 func GetNowUTC2() string {
 	return time.Now().UTC().Format(DATE_FORMAT_LONG)
 }
+
+// Deprecated: 使用 time.Now().Unix()
 func GetNowUTC2Num() int64 {
 	return Str2TimeStampL(GetNowUTC2())
 }
 
 // 取得本月最后一天
+//
+// Deprecated: 使用 timeutil.StartOfMonth(t, loc).AddDate(0, 1, -1)
 func GetLastDayOfMonth(date string) string {
 	now := Str2TimeL(date)
 	loc, _ := time.LoadLocation("UTC")
@@ -562,6 +608,8 @@ func GetLastDayOfMonth(date string) string {
 }
 
 // 取得本月第一天 yyyy-mm-dd hh:mm:ss
+//
+// Deprecated: 使用 timeutil.StartOfMonth
 func GetFirstDayOfMonth(date string) string {
 	now := Str2TimeL(date)
 	loc, _ := time.LoadLocation("UTC")
@@ -572,6 +620,8 @@ func GetFirstDayOfMonth(date string) string {
 }
 
 // 取得下月第一天 yyyy-mm-dd hh:mm:ss
+//
+// Deprecated: 使用 timeutil.StartOfMonth(t, loc).AddDate(0, 1, 0)
 func GetFirstDayOfNextMonth(date string) string {
 	dayNext := AddOneMonth(Substring(date, 0, 10)) + " 00:00:00"
 	day := GetFirstDayOfMonth(dayNext)
@@ -588,12 +638,45 @@ func RandomInt64(iMax int64) int64 {
 	return mRand.Int63n(iMax)
 }
 
+// Backoff 第 attempt 次失败（从1开始）后的重试等待时间：base 每次翻倍，不超过 max（<=0 表示不限），
+// 在 [d/2, d] 之间随机，避免多个失败的任务同时重试
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	wait := base
+	for i := 1; i < attempt && wait < math.MaxInt64/2 && (max <= 0 || wait < max); i++ {
+		wait *= 2
+	}
+	if max > 0 && wait > max {
+		wait = max
+	}
+	return wait/2 + time.Duration(mRand.Int63n(int64(wait/2)+1))
+}
+
+// Truncate 截取 s 的前 n 个字节，不会从多字节的 UTF-8 字符中间截断
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
 // GetMongoObjectId 取得mongo objectid，24位
+//
+// Deprecated: 使用 idgen.New，生成器由配置 [id] generator 选择
 func GetMongoObjectId() string {
 	ret := bson.NewObjectId()
 	return ret.Hex()
 }
 
+// IsMongoObjectId 判断是否为 GetMongoObjectId 生成的24位十六进制字符串
+//
+// Deprecated: 使用 idgen.Valid，它同时接受 ulid 和 snowflake 格式的ID
+func IsMongoObjectId(s string) bool {
+	return bson.IsObjectIdHex(s)
+}
+
 // Substring 取得子字符串  substring(yyyy-mm-dd hh:mm:ss,0,13) -> yyyy-mm-dd hh
 func Substring(source string, start int, end int) string {
 	var r = []rune(source)
@@ -629,6 +712,8 @@ func Args2Str(args ...interface{}) (ret string) {
 }
 
 // DtDiff 计算两个时间差 ret = timeB - timeA ,参数：yyyy-mm-dd hh:mm:ss 返回值：time.Duration
+//
+// Deprecated: 使用 time.Time.Sub
 func DtDiff(timeA string, timeB string) (ret time.Duration) {
 	dtA, _ := time.Parse(DATE_FORMAT_LONG, timeA)
 	dtB, _ := time.Parse(DATE_FORMAT_LONG, timeB)
@@ -646,8 +731,7 @@ func WaitEndSignal() {
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigs
-		fmt.Println()
-		fmt.Println(sig)
+		log.Info("received signal", "signal", sig.String())
 		done <- true
 	}()
 	<-done
@@ -720,6 +804,8 @@ func DtPatch(dt string) (ret bool, dtNew string) {
 }
 
 // DtCheck 判断是否是合法日期 yyyy-mm-dd
+//
+// Deprecated: 使用 timeutil.ParseDateTime
 func DtCheck(dt string) bool {
 	loc, _ := time.LoadLocation("UTC")
 	_, err := time.ParseInLocation(DATE_FORMAT_SHORT, dt, loc)
@@ -877,7 +963,7 @@ func NvlInt(value *int) int {
 func GetMachineId() string {
 	id, err := machineid.ID()
 	if err != nil {
-		fmt.Println(err.Error())
+		log.Error("get machine id", "error", err)
 		return ""
 	}
 	return id
@@ -907,7 +993,7 @@ func getHardwareStr() string {
 
 	memory, err := ghw.Memory()
 	if err != nil {
-		fmt.Printf("Error getting memory info: %v", err)
+		log.Error("get memory info", "error", err)
 	}
 
 	memoryStr := fmt.Sprintf("%v\n", memory)
@@ -930,7 +1016,7 @@ func getHardwareStr() string {
 
 	//	cpu, err := ghw.CPU()
 	//	if err != nil {
-	//		fmt.Printf("Error getting CPU info: %v", err)
+	//		log.Error("get CPU info", "error", err)
 	//	}
 
 	//	//	fmt.Printf("%v\n", cpu)
@@ -981,7 +1067,7 @@ func getHardwareStr() string {
 
 	block, err := ghw.Block()
 	if err != nil {
-		fmt.Printf("Error getting block storage info: %v", err)
+		log.Error("get block storage info", "error", err)
 	}
 
 	//	fmt.Printf("%v\n", block)
@@ -1008,7 +1094,7 @@ func getHardwareStr() string {
 
 	topology, err := ghw.Topology()
 	if err != nil {
-		fmt.Printf("Error getting topology info: %v", err)
+		log.Error("get topology info", "error", err)
 	}
 
 	//	fmt.Printf("%v\n", topology)
@@ -1034,7 +1120,7 @@ func getHardwareStr() string {
 
 	net, err := ghw.Network()
 	if err != nil {
-		fmt.Printf("Error getting network info: %v", err)
+		log.Error("get network info", "error", err)
 	}
 
 	//	//	fmt.Printf("%v\n", net)
@@ -1071,7 +1157,7 @@ func getHardwareStr() string {
 
 	//	pci, err := ghw.PCI()
 	//	if err != nil {
-	//		fmt.Printf("Error getting PCI info: %v", err)
+	//		log.Error("get PCI info", "error", err)
 	//	}
 
 	//	for _, devClass := range pci.Classes {
@@ -1098,7 +1184,7 @@ func getHardwareStr() string {
 
 	pci, err := ghw.PCI()
 	if err != nil {
-		fmt.Printf("Error getting PCI info: %v", err)
+		log.Error("get PCI info", "error", err)
 	}
 
 	addr := "0000:00:00.0"
@@ -1109,7 +1195,7 @@ func getHardwareStr() string {
 
 	deviceInfo := pci.GetDevice(addr)
 	if deviceInfo == nil {
-		fmt.Printf("could not retrieve PCI device information for %s\n", addr)
+		log.Error("retrieve PCI device information", "addr", addr)
 	}
 
 	vendor := deviceInfo.Vendor
@@ -1156,7 +1242,7 @@ func getHardwareStr() string {
 
 	gpu, err := ghw.GPU()
 	if err != nil {
-		fmt.Printf("Error getting GPU info: %v", err)
+		log.Error("get GPU info", "error", err)
 	}
 
 	//	fmt.Printf("%v\n", gpu)
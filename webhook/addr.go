@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 订阅地址不能指向本机和内网，否则能管理订阅的调用方可以让服务去请求内网的其他服务（SSRF）。
+// 订阅时检查地址，投递时在建立连接前再检查一次解析出的IP，域名在订阅后改为解析到内网地址也会被拒绝
+
+// resolveTimeout 订阅时解析域名的超时
+const resolveTimeout = 2 * time.Second
+
+// deniedNets IsPrivate 等方法没有覆盖的保留地址段
+var deniedNets = mustParseNets("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15")
+
+// allowedNets 允许投递的内网地址段，见 SetAllowedNets
+var allowedNets []*net.IPNet
+
+func mustParseNets(cidrs ...string) []*net.IPNet {
+	nets, err := ParseNets(strings.Join(cidrs, ","))
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// ParseNets 解析用 , 分隔的CIDR列表
+func ParseNets(cidrs string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0)
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// SetAllowedNets 设置允许投递的内网地址段（用 , 分隔的CIDR），为空时回环、链路本地、私有等地址都不允许
+func SetAllowedNets(cidrs string) error {
+	nets, err := ParseNets(cidrs)
+	if err != nil {
+		return err
+	}
+	allowedNets = nets
+	return nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAddr 判断是否允许向 ip 投递
+func AllowedAddr(ip net.IP) bool {
+	if contains(allowedNets, ip) {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || contains(deniedNets, ip))
+}
+
+// ValidURL 检查订阅的地址：必须是 http(s)，主机名解析出的所有IP都必须允许投递
+func ValidURL(ctx context.Context, s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("url %q must be an http(s) url", s)
+	}
+
+	host := u.Hostname()
+	ips := make([]net.IP, 0)
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return fmt.Errorf("url %q: %v", s, err)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !AllowedAddr(ip) {
+			return fmt.Errorf("url %q: address %s is not allowed", s, ip)
+		}
+	}
+	return nil
+}
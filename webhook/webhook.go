@@ -0,0 +1,447 @@
+// Package webhook 用户生命周期事件的出站webhook：事件发生时为每个匹配的订阅写入一条投递记录，
+// 后台worker从 webhook_delivery 表中领取到期的记录投递，失败后按指数退避重试。
+// 投递记录即是持久化的队列，服务重启或多个实例同时运行都不会丢失或重复领取。
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saisai/gindemo/events"
+	"github.com/saisai/gindemo/idgen"
+	"github.com/saisai/gindemo/metrics"
+	"github.com/saisai/gindemo/models"
+	"github.com/saisai/gindemo/utils"
+	ss_http "github.com/saisai/gindemo/utils/http"
+	"github.com/saisai/gindemo/utils/log"
+	"github.com/saisai/gindemo/utils/sign"
+)
+
+const (
+	EVENT_USER_REGISTERED      = "user.registered"
+	EVENT_USER_LOGGED_IN       = "user.logged_in"
+	EVENT_USER_IDENTITY_LINKED = "user.identity_linked"
+
+	// ALL_EVENTS 订阅全部事件
+	ALL_EVENTS = "*"
+
+	HEADER_EVENT     = "X-Webhook-Event"
+	HEADER_EVENT_ID  = "X-Webhook-Id"
+	HEADER_DELIVERY  = "X-Webhook-Delivery"
+	HEADER_SIGNATURE = "X-Webhook-Signature" // t=<unix秒>,v1=<hex(hmac_sha256(secret, "<t>.<body>"))>
+
+	// subscriptionTTL 进程内缓存有效订阅的时间，其他实例修改订阅后最多延迟这么久生效
+	subscriptionTTL = 10 * time.Second
+)
+
+// Events 可以订阅的事件
+var Events = []string{
+	EVENT_USER_REGISTERED,
+	EVENT_USER_LOGGED_IN,
+	EVENT_USER_IDENTITY_LINKED,
+}
+
+// Options 投递配置
+type Options struct {
+	Workers         int
+	PollInterval    time.Duration // 查询到期投递记录的间隔
+	BatchSize       int           // 每次最多领取的记录数
+	MaxAttempts     int           // 包括第一次投递在内的最大尝试次数
+	RetryBackoff    time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	RetryMaxBackoff time.Duration
+	Timeout         time.Duration // 单次投递的超时
+	Retention       time.Duration // 已结束的投递记录的保留时间
+}
+
+// Event 投递给订阅方的请求body
+type Event struct {
+	Id        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	UserId    string      `json:"user_id"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// Dispatcher 写入并投递webhook
+type Dispatcher struct {
+	opts   Options
+	client *ss_http.Client
+	jobs   chan *models.WebhookDelivery
+	wake   chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	subs     []models.WebhookSubscription
+	loadedAt time.Time
+}
+
+var dispatcher *Dispatcher
+
+// NewDispatcher client 为nil时使用 utils/http 的默认客户端
+func NewDispatcher(opts Options, client *ss_http.Client) *Dispatcher {
+	if client == nil {
+		client = ss_http.Default()
+	}
+	return &Dispatcher{
+		opts:   opts,
+		client: client,
+		jobs:   make(chan *models.WebhookDelivery),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Init 创建默认的Dispatcher，client 应当用 AllowedAddr 限制可以连接的地址
+func Init(opts Options, client *ss_http.Client) {
+	dispatcher = NewDispatcher(opts, client)
+}
+
+// Start 启动默认Dispatcher的投递worker
+func Start() error {
+	if dispatcher == nil {
+		return nil
+	}
+	dispatcher.Start()
+	return nil
+}
+
+// Stop 停止默认Dispatcher，等待进行中的投递完成；未完成的记录在租约到期后由其他实例或重启后继续投递
+func Stop(ctx context.Context) error {
+	if dispatcher == nil {
+		return nil
+	}
+	return dispatcher.Stop(ctx)
+}
+
+// Publish 使用默认Dispatcher发布事件
+func Publish(ctx context.Context, event string, userId string, data interface{}) {
+	if dispatcher == nil {
+		return
+	}
+	dispatcher.Publish(ctx, event, userId, data)
+}
+
+// Redeliver 使用默认Dispatcher重新投递，见 Dispatcher.Redeliver
+func Redeliver(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	if dispatcher == nil {
+		return nil, fmt.Errorf("webhook: disabled")
+	}
+	return dispatcher.Redeliver(ctx, id)
+}
+
+// Invalidate 订阅修改后清空默认Dispatcher的订阅缓存
+func Invalidate() {
+	if dispatcher != nil {
+		dispatcher.Invalidate()
+	}
+}
+
+// ValidEvents 检查订阅的事件列表
+func ValidEvents(events []string) error {
+	if len(events) == 0 {
+		return fmt.Errorf("events is required")
+	}
+	for _, e := range events {
+		if e == ALL_EVENTS {
+			continue
+		}
+		valid := false
+		for _, known := range Events {
+			if e == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	return nil
+}
+
+// Subscribed 判断订阅的事件列表（用 , 分隔）是否包含 event
+func Subscribed(events string, event string) bool {
+	for _, e := range strings.Split(events, ",") {
+		if e == ALL_EVENTS || e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) Start() {
+	d.wg.Add(1)
+	go d.poller()
+	for i := 0; i < d.opts.Workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+}
+
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	close(d.stop)
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook: %v, deliveries in progress will be retried after their lease expires", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) Invalidate() {
+	d.mu.Lock()
+	d.subs = nil
+	d.mu.Unlock()
+}
+
+// subscriptions 返回有效的订阅，缓存 subscriptionTTL
+func (d *Dispatcher) subscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.subs != nil && time.Since(d.loadedAt) < subscriptionTTL {
+		return d.subs, nil
+	}
+	subs, err := models.ActiveWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.subs, d.loadedAt = subs, time.Now()
+	return subs, nil
+}
+
+// Publish 为订阅了该事件的每个订阅写入一条投递记录，写入失败只记录日志，不影响调用方
+func (d *Dispatcher) Publish(ctx context.Context, event string, userId string, data interface{}) {
+	subs, err := d.subscriptions(ctx)
+	if err != nil {
+		log.ErrorCtx(ctx, "[webhook] load subscriptions failed", "event", event, "error", err)
+		return
+	}
+
+	now := time.Now()
+	// 由领域事件触发时与审计日志使用同一个事件ID
+	eventId := events.EventId(ctx)
+	if eventId == "" {
+		eventId = idgen.New()
+	}
+	payload, err := json.Marshal(Event{
+		Id:        eventId,
+		Event:     event,
+		CreatedAt: now.UTC(),
+		UserId:    userId,
+		Data:      data,
+	})
+	if err != nil {
+		log.ErrorCtx(ctx, "[webhook] encode event failed", "event", event, "error", err)
+		return
+	}
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	for _, sub := range subs {
+		if !Subscribed(sub.Events, event) {
+			continue
+		}
+		deliveries = append(deliveries, &models.WebhookDelivery{
+			SubscriptionId: sub.Id,
+			EventId:        eventId,
+			Event:          event,
+			Payload:        string(payload),
+			State:          models.WEBHOOK_PENDING,
+			NextAttemptAt:  now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+
+	if err := models.InsertDeliveries(ctx, deliveries); err != nil {
+		log.ErrorCtx(ctx, "[webhook] enqueue failed", "event", event, "event_id", eventId, "error", err)
+		return
+	}
+	d.notify()
+}
+
+// Redeliver 复制一条投递记录重新投递，原记录保持不变；记录不存在时返回nil
+func (d *Dispatcher) Redeliver(ctx context.Context, id int64) (*models.WebhookDelivery, error) {
+	orig, err := models.GetDelivery(ctx, id)
+	if err != nil || orig == nil {
+		return nil, err
+	}
+
+	delivery := &models.WebhookDelivery{
+		SubscriptionId: orig.SubscriptionId,
+		EventId:        orig.EventId,
+		Event:          orig.Event,
+		Payload:        orig.Payload,
+		State:          models.WEBHOOK_PENDING,
+		NextAttemptAt:  time.Now(),
+		RedeliveryOf:   orig.Id,
+	}
+	if err := models.InsertDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	d.notify()
+	return delivery, nil
+}
+
+// notify 有新的投递记录时提前唤醒poller
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) poller() {
+	defer d.wg.Done()
+	defer close(d.jobs)
+
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		case <-purge.C:
+			d.purge()
+			continue
+		}
+		d.poll()
+	}
+}
+
+// poll 领取到期的投递记录交给worker，领取后在租约期内其他实例不会重复投递
+func (d *Dispatcher) poll() {
+	ctx := context.Background()
+	now := time.Now()
+	due, err := models.DueDeliveries(ctx, now, d.opts.BatchSize)
+	if err != nil {
+		log.Error("[webhook] query due deliveries failed", "error", err)
+		return
+	}
+
+	lease := now.Add(2*d.opts.Timeout + d.opts.PollInterval)
+	for i := range due {
+		delivery := &due[i]
+		ok, err := models.ClaimDelivery(ctx, delivery, now, lease)
+		if err != nil {
+			log.Error("[webhook] claim delivery failed", "delivery_id", delivery.Id, "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		select {
+		case d.jobs <- delivery:
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) purge() {
+	if d.opts.Retention <= 0 {
+		return
+	}
+	n, err := models.PurgeDeliveries(context.Background(), time.Now().Add(-d.opts.Retention))
+	if err != nil {
+		log.Error("[webhook] purge deliveries failed", "error", err)
+		return
+	}
+	if n > 0 {
+		log.Info("[webhook] purged deliveries", "count", n)
+	}
+}
+
+func (d *Dispatcher) worker() {
+	defer d.wg.Done()
+	for delivery := range d.jobs {
+		d.deliver(delivery)
+	}
+}
+
+// deliver 投递一条记录并保存结果：2xx 为成功，否则按指数退避重新排队，达到最大次数后标记为失败
+func (d *Dispatcher) deliver(delivery *models.WebhookDelivery) {
+	ctx := context.Background()
+	start := time.Now()
+	code, err := d.send(ctx, delivery)
+
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.DurationMs = int(time.Since(start) / time.Millisecond)
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.State = models.WEBHOOK_SUCCEEDED
+	case delivery.Attempts >= d.opts.MaxAttempts:
+		delivery.State = models.WEBHOOK_FAILED
+	default:
+		delivery.State = models.WEBHOOK_PENDING
+		delivery.NextAttemptAt = time.Now().Add(utils.Backoff(delivery.Attempts, d.opts.RetryBackoff, d.opts.RetryMaxBackoff))
+	}
+	if err != nil {
+		delivery.LastError = utils.Truncate(err.Error(), 500)
+		log.Warn("[webhook] delivery failed", "delivery_id", delivery.Id, "subscription_id", delivery.SubscriptionId,
+			"event", delivery.Event, "attempts", delivery.Attempts, "state", delivery.State, "error", err)
+	}
+	metrics.WebhookDelivery(delivery.Event, delivery.State)
+
+	if err := models.FinishDelivery(ctx, delivery); err != nil {
+		log.Error("[webhook] save delivery result failed", "delivery_id", delivery.Id, "error", err)
+	}
+}
+
+// send 发送带签名的请求，返回响应状态码
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	sub, err := models.GetWebhook(ctx, delivery.SubscriptionId)
+	if err != nil {
+		return 0, err
+	}
+	if sub == nil || !sub.Active {
+		return 0, fmt.Errorf("subscription %s is deleted or inactive", delivery.SubscriptionId)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(HEADER_EVENT, delivery.Event)
+	header.Set(HEADER_EVENT_ID, delivery.EventId)
+	header.Set(HEADER_DELIVERY, strconv.FormatInt(delivery.Id, 10))
+	header.Set(HEADER_SIGNATURE, "t="+timestamp+",v1="+sign.Sign(sub.Secret, timestamp+"."+delivery.Payload))
+
+	rsp, err := d.client.Do(ctx, http.MethodPost, sub.URL, []byte(delivery.Payload), header)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(rsp.Body, 64<<10))
+	rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return rsp.StatusCode, fmt.Errorf("unexpected status %d", rsp.StatusCode)
+	}
+	return rsp.StatusCode, nil
+}